@@ -0,0 +1,14 @@
+/*
+Tesla-ble-bridge is a server that owns a BLE connection to a vehicle and relays it to remote
+clients over an authenticated TCP channel. This allows a host that's within Bluetooth range of a
+vehicle (such as a Raspberry Pi in a garage) to share its radio with tesla-control or other
+applications running elsewhere on the network.
+
+The bridge and its clients must share a secret, which is read from the file given by -secret-file
+or from the TESLA_BLE_BRIDGE_SECRET environment variable. Clients select the bridge using the
+tesla-control -ble-bridge flag or the TESLA_BLE_BRIDGE environment variable.
+
+The bridge never sees client private keys; commands remain end-to-end authenticated between the
+client and the vehicle.
+*/
+package main
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/cli"
+	"github.com/teslamotors/vehicle-command/pkg/connector/ble"
+	"github.com/teslamotors/vehicle-command/pkg/connector/bridge"
+)
+
+const defaultAddress = "localhost:8390"
+
+const (
+	EnvListen  = "TESLA_BLE_BRIDGE_LISTEN"
+	EnvVerbose = "TESLA_VERBOSE"
+)
+
+const nonLocalhostWarning = `
+Anyone who knows the bridge secret can use this host's BLE radio to send messages to the vehicle,
+including requests to add new keys. Use a long, randomly generated secret and restrict network
+access to the bridge.`
+
+var (
+	listenAddress string
+	verbose       bool
+)
+
+func init() {
+	flag.StringVar(&listenAddress, "listen", defaultAddress, "TCP `address` (host:port) to listen on")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
+}
+
+func Usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [OPTION...]\n", os.Args[0])
+	fmt.Fprintf(out, "\nA server that relays a BLE connection to a Tesla vehicle over the network\n")
+	fmt.Fprintln(out, nonLocalhostWarning)
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Options:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	config, err := cli.NewConfig(cli.FlagVIN)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	flag.Usage = Usage
+	flag.StringVar(&config.BLEBridgeSecretFile, "secret-file", "", "A `file` containing the secret shared with clients. Defaults to $TESLA_BLE_BRIDGE_SECRET_FILE.")
+	config.RegisterCommandLineFlags()
+	flag.Parse()
+	readFromEnvironment()
+	config.ReadFromEnvironment()
+	if config.BLEBridgeSecretFile == "" {
+		config.BLEBridgeSecretFile = os.Getenv(cli.EnvTeslaBLEBridgeSecretFile)
+	}
+
+	if verbose {
+		log.SetLevel(log.LevelDebug)
+	}
+
+	if config.VIN == "" {
+		err = fmt.Errorf("no VIN provided")
+		return
+	}
+
+	var secret []byte
+	if secret, err = config.BLEBridgeSecret(); err != nil {
+		return
+	}
+
+	if host, _, splitErr := net.SplitHostPort(listenAddress); splitErr != nil || host != "localhost" {
+		fmt.Fprintln(os.Stderr, nonLocalhostWarning)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	log.Info("Connecting to vehicle over BLE...")
+	var conn *ble.Connection
+	if conn, err = ble.NewConnection(ctx, config.VIN); err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var server *bridge.Server
+	if server, err = bridge.NewServer(conn, secret); err != nil {
+		return
+	}
+
+	var listener net.Listener
+	if listener, err = net.Listen("tcp", listenAddress); err != nil {
+		return
+	}
+	log.Info("Listening on %s", listener.Addr())
+
+	if err = server.Serve(ctx, listener); err == context.Canceled {
+		err = nil
+	}
+}
+
+// readFromEnvironment applies configuration from environment variables.
+// Values are not overwritten.
+func readFromEnvironment() {
+	if listenAddress == defaultAddress {
+		if address, ok := os.LookupEnv(EnvListen); ok {
+			listenAddress = address
+		}
+	}
+
+	if !verbose {
+		if value, ok := os.LookupEnv(EnvVerbose); ok {
+			verbose = value != "false" && value != "0"
+		}
+	}
+}
@@ -0,0 +1,22 @@
+/*
+Tesla-ble-daemon holds a BLE connection to a vehicle and shares it with local processes over a
+Unix domain socket.
+
+Only one process can use the host's BLE adapter at a time, and each new BLE connection requires
+scanning for and connecting to the vehicle. Running tesla-ble-daemon allows several tools to use
+the vehicle concurrently and avoids repeating the connection setup on every tesla-control
+invocation. Point tesla-control at the daemon using the -ble-socket flag or the TESLA_BLE_SOCKET
+environment variable, which the daemon also uses to determine where to create its socket.
+
+If the daemon is given a private key (using the -key-file or -key-name flags, or the corresponding
+environment variables), it establishes sessions with the vehicle's security controller and
+infotainment system when it starts and keeps them for as long as it runs. Clients can then send
+authenticated commands without a key of their own and without a handshake; the daemon signs their
+commands with its key. Any process that can open the socket can use the key, so the socket is only
+accessible to the user running the daemon.
+
+Without a private key, clients authenticate to the vehicle with their own keys. Use the
+-session-cache flag or the TESLA_CACHE_FILE environment variable with tesla-control to reuse
+authenticated sessions between invocations.
+*/
+package main
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/cli"
+	"github.com/teslamotors/vehicle-command/pkg/connector/ble"
+	"github.com/teslamotors/vehicle-command/pkg/connector/mux"
+)
+
+const EnvVerbose = "TESLA_VERBOSE"
+
+var (
+	socketPath string
+	verbose    bool
+)
+
+func init() {
+	flag.StringVar(&socketPath, "socket", "", "Create the Unix domain socket at `path`. Defaults to $TESLA_BLE_SOCKET.")
+	flag.BoolVar(&verbose, "verbose", false, "Enable verbose logging")
+}
+
+func Usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [OPTION...]\n", os.Args[0])
+	fmt.Fprintf(out, "\nA daemon that shares a BLE connection to a Tesla vehicle with local processes\n\n")
+	fmt.Fprintln(out, "Options:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	config, err := cli.NewConfig(cli.FlagVIN | cli.FlagPrivateKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %s\n", err)
+		os.Exit(1)
+	}
+
+	defer func() {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	flag.Usage = Usage
+	config.RegisterCommandLineFlags()
+	flag.Parse()
+	readFromEnvironment()
+	config.ReadFromEnvironment()
+
+	if verbose {
+		log.SetLevel(log.LevelDebug)
+	}
+
+	if config.VIN == "" {
+		err = fmt.Errorf("no VIN provided")
+		return
+	}
+	if socketPath == "" {
+		err = fmt.Errorf("no socket path provided")
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	// Without a private key, the daemon relays commands that clients authorize themselves.
+	skey, err := config.PrivateKey()
+	if err == cli.ErrNoKeySpecified {
+		err = nil
+	} else if err != nil {
+		return
+	}
+
+	var listener net.Listener
+	if listener, err = mux.Listen(socketPath); err != nil {
+		return
+	}
+	// Remove the socket on exit so that clients fail fast instead of waiting for a stale socket.
+	defer os.Remove(socketPath)
+
+	log.Info("Connecting to vehicle over BLE...")
+	var conn *ble.Connection
+	if conn, err = ble.NewConnection(ctx, config.VIN); err != nil {
+		listener.Close()
+		return
+	}
+	defer conn.Close()
+
+	server := mux.NewServer(conn)
+	if skey != nil {
+		log.Info("Holding sessions with public key %02x", skey.PublicBytes())
+		server.HoldSessions(skey)
+	}
+	log.Info("Listening on %s", socketPath)
+	if err = server.Serve(ctx, listener); err == context.Canceled {
+		err = nil
+	}
+}
+
+// readFromEnvironment applies configuration from environment variables.
+// Values are not overwritten.
+func readFromEnvironment() {
+	if socketPath == "" {
+		socketPath = os.Getenv(cli.EnvTeslaBLESocket)
+	}
+
+	if !verbose {
+		if value, ok := os.LookupEnv(EnvVerbose); ok {
+			verbose = value != "false" && value != "0"
+		}
+	}
+}
@@ -2,45 +2,40 @@ package main
 
 import (
 	"context"
+	"crypto/ecdh"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/teslamotors/vehicle-command/pkg/account"
 	"github.com/teslamotors/vehicle-command/pkg/cli"
+	"github.com/teslamotors/vehicle-command/pkg/command"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/carserver"
 	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
 	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
 	"github.com/teslamotors/vehicle-command/pkg/vehicle"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 )
 
-var (
-	ErrCommandLineArgs = errors.New("invalid command line arguments")
-	ErrInvalidTime     = errors.New("invalid time")
-	dayNamesBitMask    = map[string]int32{
-		"SUN":       1,
-		"SUNDAY":    1,
-		"MON":       2,
-		"MONDAY":    2,
-		"TUES":      4,
-		"TUESDAY":   4,
-		"WED":       8,
-		"WEDNESDAY": 8,
-		"THURS":     16,
-		"THURSDAY":  16,
-		"FRI":       32,
-		"FRIDAY":    32,
-		"SAT":       64,
-		"SATURDAY":  64,
-		"ALL":       127,
-		"WEEKDAYS":  62,
-	}
-)
+var ErrCommandLineArgs = errors.New("invalid command line arguments")
+
+var errPairingTimedOut = errors.New("timed out waiting for key to be paired")
+
+// defaultTimeBudget is used by timeBudget for contexts without a deadline. It matches the default
+// -command-timeout.
+const defaultTimeBudget = 5 * time.Second
+
+// wakeTimeout bounds how long prepareDomain spends waking the vehicle and starting a session. It's
+// set to -connect-timeout, since waking the vehicle takes longer than most commands.
+var wakeTimeout = 20 * time.Second
 
 type Argument struct {
 	name string
@@ -59,49 +54,6 @@ type Command struct {
 	domain           protocol.Domain
 }
 
-func GetDegree(degStr string) (float32, error) {
-	deg, err := strconv.ParseFloat(degStr, 32)
-	if err != nil {
-		return 0.0, err
-	}
-	if deg < -180 || deg > 180 {
-		return 0.0, errors.New("latitude and longitude must both be in the range [-180, 180]")
-	}
-	return float32(deg), nil
-}
-
-func GetDays(days string) (int32, error) {
-	var mask int32
-	for _, d := range strings.Split(days, ",") {
-		if v, ok := dayNamesBitMask[strings.TrimSpace(strings.ToUpper(d))]; ok {
-			mask |= v
-		} else {
-			return 0, fmt.Errorf("unrecognized day name: %v", d)
-		}
-	}
-	return mask, nil
-}
-
-func MinutesAfterMidnight(hoursAndMinutes string) (int32, error) {
-	components := strings.Split(hoursAndMinutes, ":")
-	if len(components) != 2 {
-		return 0, fmt.Errorf("%w: expected HH:MM", ErrInvalidTime)
-	}
-	hours, err := strconv.Atoi(components[0])
-	if err != nil {
-		return 0, fmt.Errorf("%w: %s", ErrInvalidTime, err)
-	}
-	minutes, err := strconv.Atoi(components[1])
-	if err != nil {
-		return 0, fmt.Errorf("%w: %s", ErrInvalidTime, err)
-	}
-
-	if hours > 23 || hours < 0 || minutes > 59 || minutes < 0 {
-		return 0, fmt.Errorf("%w: hours or minutes outside valid range", ErrInvalidTime)
-	}
-	return int32(60*hours + minutes), nil
-}
-
 // configureAndVerifyFlags verifies that c contains all the information required to execute a command.
 func configureFlags(c *cli.Config, commandName string, forceBLE bool) error {
 	info, ok := commands[commandName]
@@ -112,7 +64,7 @@ func configureFlags(c *cli.Config, commandName string, forceBLE bool) error {
 	if info.domain != protocol.DomainNone {
 		c.Domains = cli.DomainList{info.domain}
 	}
-	bleWake := forceBLE && commandName == "wake"
+	bleWake := (forceBLE || c.UsesBLERelay()) && commandName == "wake"
 	if bleWake || info.requiresAuth {
 		// Wake commands are special. When sending a wake command over the Internet, infotainment
 		// cannot authenticate the command because it's asleep. When sending the command over BLE,
@@ -138,7 +90,9 @@ func configureFlags(c *cli.Config, commandName string, forceBLE bool) error {
 	}
 
 	// Verify all required parameters are present.
-	havePrivateKey := !(c.KeyringKeyName == "" && c.KeyFilename == "")
+	// A tesla-ble-daemon may authorize commands with its own key. That's checked again after
+	// connecting.
+	havePrivateKey := !(c.KeyringKeyName == "" && c.KeyFilename == "") || c.BLESocket != ""
 	haveOAuth := !(c.KeyringTokenName == "" && c.TokenFilename == "")
 	haveVIN := c.VIN != ""
 	_, err := checkReadiness(commandName, havePrivateKey, haveOAuth, haveVIN)
@@ -201,7 +155,17 @@ func execute(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, a
 			keywords[argInfo.name] = args[index]
 			index++
 		}
-		err = info.handler(ctx, acct, car, keywords)
+		budget := timeBudget(ctx)
+		var prepared bool
+		if prepared, err = prepareDomain(ctx, car, info); err == nil {
+			if prepared {
+				// Waking the vehicle may have used up ctx, so the command gets a fresh budget.
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(context.WithoutCancel(ctx), budget)
+				defer cancel()
+			}
+			err = info.handler(ctx, acct, car, keywords)
+		}
 	}
 
 	// Print command-specific help
@@ -211,6 +175,24 @@ func execute(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, a
 	return err
 }
 
+// prepareDomain starts a session with infotainment if the command needs one and the initial
+// connection skipped it because the vehicle was asleep. This happens in the interactive shell,
+// which connects before it knows what commands will be sent.
+//
+// Starting the session may involve waking the vehicle, so it's subject to wakeTimeout rather than
+// ctx's deadline. prepareDomain returns true if it started a session.
+func prepareDomain(ctx context.Context, car *vehicle.Vehicle, info *Command) (bool, error) {
+	if car == nil || !info.requiresAuth || info.domain != protocol.DomainInfotainment || !car.PrivateKeyAvailable() {
+		return false, nil
+	}
+	if _, ok := car.SessionHealth(protocol.DomainInfotainment); ok {
+		return false, nil
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), wakeTimeout)
+	defer cancel()
+	return true, car.StartSessionSleepAware(ctx, []protocol.Domain{protocol.DomainInfotainment}, reportWake)
+}
+
 func (c *Command) Usage(name string) {
 	fmt.Printf("Usage: %s", name)
 	maxLength := 0
@@ -243,134 +225,370 @@ func (c *Command) Usage(name string) {
 }
 
 var commands = map[string]*Command{
-	"unlock": &Command{
-		help:             "Unlock vehicle",
+	"add-key": &Command{
+		help:             "Add PUBLIC_KEY to vehicle whitelist with ROLE and FORM_FACTOR",
+		requiresAuth:     true,
+		requiresFleetAPI: false,
+		args: []Argument{
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
+			Argument{name: "FORM_FACTOR", help: "One of: nfc_card, ios_device, android_device, cloud_key"},
+		},
+		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
+			role, formFactor, err := roleAndFormFactor(args)
+			if err != nil {
+				return err
+			}
+			publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+			if err != nil {
+				return fmt.Errorf("invalid public key: %s", err)
+			}
+			return car.AddKeyWithRole(ctx, publicKey, role, formFactor)
+		},
+	},
+	"add-key-request": &Command{
+		help:             "Request NFC-card approval for a enrolling PUBLIC_KEY with ROLE and FORM_FACTOR",
+		requiresAuth:     false,
+		requiresFleetAPI: false,
+		args: []Argument{
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
+			Argument{name: "FORM_FACTOR", help: "One of: nfc_card, ios_device, android_device, cloud_key"},
+		},
+		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
+			role, formFactor, err := roleAndFormFactor(args)
+			if err != nil {
+				return err
+			}
+			publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+			if err != nil {
+				return fmt.Errorf("invalid public key: %s", err)
+			}
+			if err := car.SendAddKeyRequestWithRole(ctx, publicKey, role, formFactor); err != nil {
+				return err
+			}
+			fmt.Printf("Sent add-key request to %s. Confirm by tapping NFC card on center console.\n", car.VIN())
+			return nil
+		},
+	},
+	"pair": &Command{
+		help: "Request pairing PUBLIC_KEY with ROLE and FORM_FACTOR over BLE, then wait until the request is " +
+			"approved by tapping an NFC card on the center console. Exits with status 3 if the vehicle rejects the " +
+			"request and 4 if it isn't approved within TIMEOUT.",
+		domain:           protocol.DomainVCSEC,
+		requiresAuth:     false,
+		requiresFleetAPI: false,
+		args: []Argument{
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
+			Argument{name: "FORM_FACTOR", help: "One of: nfc_card, ios_device, android_device, cloud_key"},
+		},
+		optional: []Argument{
+			Argument{name: "TIMEOUT", help: "Time to wait for approval (e.g., 5m). Defaults to 2m."},
+			Argument{name: "NAME", help: "Human-readable name to register for the key once it's paired (requires OAuth token and -hybrid)"},
+		},
+		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
+			role, formFactor, err := roleAndFormFactor(args)
+			if err != nil {
+				return err
+			}
+			publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+			if err != nil {
+				return fmt.Errorf("invalid public key: %s", err)
+			}
+			timeout := 2 * time.Minute
+			if timeoutStr, ok := args["TIMEOUT"]; ok && timeoutStr != "-" {
+				if timeout, err = time.ParseDuration(timeoutStr); err != nil || timeout <= 0 {
+					return fmt.Errorf("%w: invalid TIMEOUT", ErrCommandLineArgs)
+				}
+			}
+			name, setName := args["NAME"]
+			if setName && acct == nil {
+				// Pairing requires BLE, and -ble disables OAuth.
+				return errors.New("setting NAME requires an OAuth token (use -hybrid instead of -ble)")
+			}
+			// Registering the name gets the time allowed for the command, which pairing would
+			// otherwise use up.
+			nameTimeout := timeBudget(ctx)
+			// Walking to the vehicle takes longer than the command timeout allows.
+			pairCtx, stop := signal.NotifyContext(context.WithoutCancel(ctx), os.Interrupt)
+			defer stop()
+			pairCtx, cancel := context.WithTimeout(pairCtx, timeout)
+			defer cancel()
+			err = car.PairKey(pairCtx, publicKey, role, formFactor, func(p vehicle.PairingProgress) {
+				switch p.Stage {
+				case vehicle.PairingRequestSent:
+					fmt.Printf("Sent add-key request to %s. Confirm by tapping NFC card on center console.\n", car.VIN())
+				case vehicle.PairingWaiting:
+					if p.Err != nil {
+						writeErr("Error checking pairing status: %s", p.Err)
+					}
+				case vehicle.PairingEnrolled:
+					fmt.Printf("Key paired after %s.\n", p.Elapsed.Round(time.Second))
+				}
+			})
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("%w after %s", errPairingTimedOut, timeout)
+			}
+			if err != nil {
+				return err
+			}
+			if setName {
+				nameCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), nameTimeout)
+				defer cancel()
+				if err := acct.UpdateKey(nameCtx, publicKey, name); err != nil {
+					return err
+				}
+				recordKeyName(publicKey, name, car.VIN())
+			}
+			return nil
+		},
+	},
+	"remove-key": &Command{
+		help:             "Remove PUBLIC_KEY from vehicle whitelist",
 		requiresAuth:     true,
 		requiresFleetAPI: false,
+		args: []Argument{
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key), or 'slot:N' to remove the key in slot N (see list-keys)"},
+		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.Unlock(ctx)
+			if slot, ok, err := slotArg(args["PUBLIC_KEY"]); ok {
+				if err != nil {
+					return err
+				}
+				return car.RemoveKeyInSlot(ctx, slot)
+			}
+			publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+			if err != nil {
+				return fmt.Errorf("invalid public key: %s", err)
+			}
+			return car.RemoveKey(ctx, publicKey)
 		},
 	},
-	"lock": &Command{
-		help:             "Lock vehicle",
+	"add-impermanent-key": &Command{
+		help:             "Add PUBLIC_KEY to vehicle whitelist as an impermanent key with ROLE and FORM_FACTOR",
+		domain:           protocol.DomainVCSEC,
 		requiresAuth:     true,
 		requiresFleetAPI: false,
+		args: []Argument{
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
+			Argument{name: "FORM_FACTOR", help: "One of: nfc_card, ios_device, android_device, cloud_key"},
+		},
+		optional: []Argument{
+			Argument{name: "REMOVE_EXISTING", help: "'remove-existing' to remove all other impermanent keys"},
+		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.Lock(ctx)
+			role, formFactor, err := roleAndFormFactor(args)
+			if err != nil {
+				return err
+			}
+			publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+			if err != nil {
+				return fmt.Errorf("invalid public key: %s", err)
+			}
+			switch args["REMOVE_EXISTING"] {
+			case "":
+				return car.AddImpermanentKey(ctx, publicKey, role, formFactor)
+			case "remove-existing":
+				return car.AddImpermanentKeyAndRemoveExisting(ctx, publicKey, role, formFactor)
+			}
+			return fmt.Errorf("%w: REMOVE_EXISTING must be 'remove-existing'", ErrCommandLineArgs)
 		},
 	},
-	"drive": &Command{
-		help:             "Remote start vehicle",
+	"remove-impermanent-keys": &Command{
+		help:             "Remove all impermanent keys from vehicle whitelist",
+		domain:           protocol.DomainVCSEC,
 		requiresAuth:     true,
 		requiresFleetAPI: false,
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.RemoteDrive(ctx)
+			return car.RemoveAllImpermanentKeys(ctx)
 		},
 	},
-	"climate-on": &Command{
-		help:             "Turn on climate control",
+	"add-key-permissions": &Command{
+		help:             "Grant the permissions of ROLE to PUBLIC_KEY, which must already be on the vehicle whitelist",
+		domain:           protocol.DomainVCSEC,
 		requiresAuth:     true,
 		requiresFleetAPI: false,
+		args: []Argument{
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
+		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.ClimateOn(ctx)
+			role, publicKey, err := roleAndPublicKey(args)
+			if err != nil {
+				return err
+			}
+			return car.AddKeyPermissions(ctx, publicKey, role)
 		},
 	},
-	"climate-off": &Command{
-		help:             "Turn off climate control",
+	"remove-key-permissions": &Command{
+		help:             "Revoke the permissions of ROLE from PUBLIC_KEY without removing it from the vehicle whitelist",
+		domain:           protocol.DomainVCSEC,
 		requiresAuth:     true,
 		requiresFleetAPI: false,
+		args: []Argument{
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
+		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.ClimateOff(ctx)
+			role, publicKey, err := roleAndPublicKey(args)
+			if err != nil {
+				return err
+			}
+			return car.RemoveKeyPermissions(ctx, publicKey, role)
 		},
 	},
-	"climate-set-temp": &Command{
-		help:             "Set temperature (Celsius)",
+	"update-key": &Command{
+		help:             "Change the ROLE of PUBLIC_KEY, optionally limiting how long the vehicle accepts it",
+		domain:           protocol.DomainVCSEC,
 		requiresAuth:     true,
 		requiresFleetAPI: false,
 		args: []Argument{
-			Argument{name: "TEMP", help: "Desired temperature (e.g., 70f or 21c; defaults to Celsius)"},
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
+		},
+		optional: []Argument{
+			Argument{name: "DURATION", help: "Remove PUBLIC_KEY after DURATION (e.g., 2h or 72h)"},
 		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			var degrees float32
-			var unit string
-			if _, err := fmt.Sscanf(args["TEMP"], "%f%s", &degrees, &unit); err != nil {
-				return fmt.Errorf("failed to parse temperature: format as 22C or 72F")
+			role, publicKey, err := roleAndPublicKey(args)
+			if err != nil {
+				return err
 			}
-			if unit == "F" || unit == "f" {
-				degrees = (degrees - 32.0) * 5.0 / 9.0
-			} else if unit != "C" && unit != "c" {
-				return fmt.Errorf("temperature units must be C or F")
+			var activeFor time.Duration
+			if durationStr, ok := args["DURATION"]; ok {
+				if activeFor, err = time.ParseDuration(durationStr); err != nil || activeFor <= 0 {
+					return fmt.Errorf("%w: invalid DURATION", ErrCommandLineArgs)
+				}
 			}
-			return car.ChangeClimateTemp(ctx, degrees, degrees)
+			return car.UpdateKeyAndPermissions(ctx, publicKey, role, activeFor)
 		},
 	},
-	"add-key": &Command{
-		help:             "Add PUBLIC_KEY to vehicle whitelist with ROLE and FORM_FACTOR",
+	"replace-key": &Command{
+		help:             "Atomically replace OLD_KEY on vehicle whitelist with PUBLIC_KEY, which is given ROLE",
+		domain:           protocol.DomainVCSEC,
 		requiresAuth:     true,
 		requiresFleetAPI: false,
 		args: []Argument{
+			Argument{name: "OLD_KEY", help: "file containing public key to replace, or 'slot:N' to replace the key in slot N"},
 			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
 			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
-			Argument{name: "FORM_FACTOR", help: "One of: nfc_card, ios_device, android_device, cloud_key"},
+		},
+		optional: []Argument{
+			Argument{name: "IMPERMANENT", help: "'impermanent' to add PUBLIC_KEY as an impermanent key"},
 		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			role, ok := keys.Role_value["ROLE_"+strings.ToUpper(args["ROLE"])]
-			if !ok {
-				return fmt.Errorf("%w: invalid ROLE", ErrCommandLineArgs)
+			role, publicKey, err := roleAndPublicKey(args)
+			if err != nil {
+				return err
 			}
-			formFactor, ok := vcsec.KeyFormFactor_value["KEY_FORM_FACTOR_"+strings.ToUpper(args["FORM_FACTOR"])]
-			if !ok {
-				return fmt.Errorf("%w: unrecognized FORM_FACTOR", ErrCommandLineArgs)
+			var impermanent bool
+			switch args["IMPERMANENT"] {
+			case "":
+			case "impermanent":
+				impermanent = true
+			default:
+				return fmt.Errorf("%w: IMPERMANENT must be 'impermanent'", ErrCommandLineArgs)
 			}
-			publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+			if slot, ok, err := slotArg(args["OLD_KEY"]); ok {
+				if err != nil {
+					return err
+				}
+				return car.ReplaceKeyInSlot(ctx, slot, publicKey, role, impermanent)
+			}
+			oldKey, err := protocol.LoadPublicKey(args["OLD_KEY"])
 			if err != nil {
 				return fmt.Errorf("invalid public key: %s", err)
 			}
-			return car.AddKeyWithRole(ctx, publicKey, keys.Role(role), vcsec.KeyFormFactor(formFactor))
+			return car.ReplaceKey(ctx, oldKey, publicKey, role, impermanent)
 		},
 	},
-	"add-key-request": &Command{
-		help:             "Request NFC-card approval for a enrolling PUBLIC_KEY with ROLE and FORM_FACTOR",
-		requiresAuth:     false,
+	"share-key": &Command{
+		help: "Generate a guest key with ROLE that expires after DURATION, enroll it, and save it to " +
+			"BUNDLE_FILE encrypted with a printed passcode. The guest imports the bundle using " +
+			"tesla-keygen. Grants are recorded in a ledger ($" + envKeyLedger + ") for revoke and expire-sweep.",
+		domain:           protocol.DomainVCSEC,
+		requiresAuth:     true,
 		requiresFleetAPI: false,
 		args: []Argument{
-			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
-			Argument{name: "ROLE", help: "One of: owner, driver, fm (fleet manager), vehicle_monitor, charging_manager"},
-			Argument{name: "FORM_FACTOR", help: "One of: nfc_card, ios_device, android_device, cloud_key"},
+			Argument{name: "ROLE", help: "One of: driver, charging_manager, vehicle_monitor"},
+			Argument{name: "DURATION", help: "How long the key remains valid (e.g., 72h)"},
+			Argument{name: "BUNDLE_FILE", help: "File to write the encrypted key to. Must not already exist."},
+		},
+		optional: []Argument{
+			Argument{name: "NAME", help: "Label for the grant, which can be passed to revoke ('-' for none)"},
+			Argument{name: "IMPERMANENT", help: "'impermanent' to enroll the key as an impermanent key"},
 		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			role, ok := keys.Role_value["ROLE_"+strings.ToUpper(args["ROLE"])]
-			if !ok {
-				return fmt.Errorf("%w: invalid ROLE", ErrCommandLineArgs)
+			role, err := parseRole(args)
+			if err != nil {
+				return err
 			}
-			formFactor, ok := vcsec.KeyFormFactor_value["KEY_FORM_FACTOR_"+strings.ToUpper(args["FORM_FACTOR"])]
-			if !ok {
-				return fmt.Errorf("%w: unrecognized FORM_FACTOR", ErrCommandLineArgs)
+			if role == keys.Role_ROLE_OWNER {
+				return fmt.Errorf("%w: guest keys can't have ROLE owner", ErrCommandLineArgs)
 			}
-			publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+			activeFor, err := time.ParseDuration(args["DURATION"])
+			if err != nil || activeFor <= 0 {
+				return fmt.Errorf("%w: invalid DURATION", ErrCommandLineArgs)
+			}
+			var impermanent bool
+			switch args["IMPERMANENT"] {
+			case "":
+			case "impermanent":
+				impermanent = true
+			default:
+				return fmt.Errorf("%w: expected 'impermanent' but got '%s'", ErrCommandLineArgs, args["IMPERMANENT"])
+			}
+			ledger, err := openLedger()
 			if err != nil {
-				return fmt.Errorf("invalid public key: %s", err)
+				return err
 			}
-			if err := car.SendAddKeyRequestWithRole(ctx, publicKey, keys.Role(role), vcsec.KeyFormFactor(formFactor)); err != nil {
+			name := args["NAME"]
+			if name == "-" {
+				name = ""
+			}
+			passcode, err := shareKey(ctx, car, ledger, role, activeFor, args["BUNDLE_FILE"], name, impermanent)
+			if err != nil {
 				return err
 			}
-			fmt.Printf("Sent add-key request to %s. Confirm by tapping NFC card on center console.\n", car.VIN())
+			fmt.Printf("Saved guest key to %s. Send the guest this passcode separately:\n\n\t%s\n\n", args["BUNDLE_FILE"], passcode)
+			fmt.Printf("The guest can import the key using:\n\n\ttesla-keygen -key-name NAME import %s\n", args["BUNDLE_FILE"])
 			return nil
 		},
 	},
-	"remove-key": &Command{
-		help:             "Remove PUBLIC_KEY from vehicle whitelist",
+	"revoke": &Command{
+		help: "Revoke guest KEY issued by share-key, then remove every lapsed guest key in the ledger " +
+			"from its vehicle (see expire-sweep)",
+		domain:           protocol.DomainVCSEC,
 		requiresAuth:     true,
 		requiresFleetAPI: false,
 		args: []Argument{
-			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "KEY", help: "NAME given to share-key, key fingerprint (see list-keys), or hex-encoded public key"},
 		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+			ledger, err := openLedger()
 			if err != nil {
-				return fmt.Errorf("invalid public key: %s", err)
+				return err
 			}
-			return car.RemoveKey(ctx, publicKey)
+			if _, err := ledger.Revoke(args["KEY"], time.Now()); err != nil {
+				return err
+			}
+			return sweepGuestKeys(ctx, car, ledger)
+		},
+	},
+	"expire-sweep": &Command{
+		help: "Remove every expired or revoked guest key in the ledger from its vehicle. Vehicles " +
+			"other than VIN can only be reached with an OAuth token; their keys are retried on the next sweep.",
+		domain:           protocol.DomainVCSEC,
+		requiresAuth:     true,
+		requiresFleetAPI: false,
+		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
+			ledger, err := openLedger()
+			if err != nil {
+				return err
+			}
+			return sweepGuestKeys(ctx, car, ledger)
 		},
 	},
 	"rename-key": &Command{
@@ -386,7 +604,15 @@ var commands = map[string]*Command{
 			if err != nil {
 				return fmt.Errorf("invalid public key: %s", err)
 			}
-			return acct.UpdateKey(ctx, publicKey, args["NAME"])
+			if err := acct.UpdateKey(ctx, publicKey, args["NAME"]); err != nil {
+				return err
+			}
+			vin := ""
+			if car != nil {
+				vin = car.VIN()
+			}
+			recordKeyName(publicKey, args["NAME"], vin)
+			return nil
 		},
 	},
 	"get": &Command{
@@ -438,292 +664,74 @@ var commands = map[string]*Command{
 		},
 	},
 	"list-keys": &Command{
-		help:             "List public keys enrolled on vehicle",
+		help: "List public keys enrolled on vehicle. With 'audit', compare them to the names registered " +
+			"by rename-key, pair, and share-key and flag unnamed, duplicate, or stale keys.",
 		requiresAuth:     false,
 		requiresFleetAPI: false,
+		optional: []Argument{
+			Argument{name: "AUDIT", help: "'audit' to check keys against locally recorded key names"},
+		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			summary, err := car.KeySummary(ctx)
+			audit := false
+			switch args["AUDIT"] {
+			case "":
+			case "audit":
+				audit = true
+			default:
+				return fmt.Errorf("%w: AUDIT must be 'audit'", ErrCommandLineArgs)
+			}
+			entries, err := car.ListKeys(ctx)
 			if err != nil {
-				return err
+				writeErr("Error fetching keys: %s", err)
+				if len(entries) == 0 {
+					return err
+				}
 			}
-			slot := uint32(0)
-			var details *vcsec.WhitelistEntryInfo
-			for mask := summary.GetSlotMask(); mask > 0; mask >>= 1 {
-				if mask&1 == 1 {
-					details, err = car.KeyInfoBySlot(ctx, slot)
-					if err != nil {
-						writeErr("Error fetching slot %d: %s", slot, err)
-						if errors.Is(err, context.DeadlineExceeded) {
-							return err
-						}
-					}
-					if details != nil {
-						fmt.Printf("%02x\t%s\t%s\n", details.GetPublicKey().GetPublicKeyRaw(), details.GetKeyRole(), details.GetMetadataForKey().GetKeyFormFactor())
+			if !audit {
+				// New columns are appended so that scripts parsing the original three columns
+				// keep working.
+				for _, entry := range entries {
+					own := ""
+					if entry.IsOwnKey {
+						own = "\t(this key)"
 					}
+					fmt.Printf("%02x\t%s\t%s\t%d\t%s%s\n", entry.PublicKey, entry.Role, entry.FormFactor, entry.Slot, entry.Fingerprint, own)
+				}
+				return nil
+			}
+			inventory, err := openKeyNames()
+			if err != nil {
+				return err
+			}
+			ledger, err := openLedger()
+			if err != nil {
+				return err
+			}
+			for _, result := range vehicle.AuditKeys(entries, inventory.forVehicle(car.VIN(), ledger)) {
+				slot := "-"
+				if result.Entry != nil {
+					slot = strconv.Itoa(int(result.Entry.Slot))
 				}
-				slot++
+				name := result.Name
+				if name == "" {
+					name = "(unnamed)"
+				}
+				var issues []string
+				for _, issue := range result.Issues {
+					issues = append(issues, string(issue))
+				}
+				fmt.Printf("%s\t%s\t%s\t%s\n", slot, result.PublicKey, name, strings.Join(issues, ","))
 			}
 			return nil
 		},
 	},
-	"honk": &Command{
-		help:             "Honk horn",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.HonkHorn(ctx)
-		},
-	},
-	"ping": &Command{
-		help:             "Ping vehicle",
-		requiresAuth:     true,
+	"session-info": &Command{
+		help:             "Retrieve session info for PUBLIC_KEY from DOMAIN",
+		requiresAuth:     false,
 		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.Ping(ctx)
-		},
-	},
-	"flash-lights": &Command{
-		help:         "Flash lights",
-		requiresAuth: true,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.FlashLights(ctx)
-		},
-	},
-	"charging-set-limit": &Command{
-		help:             "Set charge limit to PERCENT",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "PERCENT", help: "Charging limit"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			limit, err := strconv.Atoi(args["PERCENT"])
-			if err != nil {
-				return fmt.Errorf("error parsing PERCENT")
-			}
-			return car.ChangeChargeLimit(ctx, int32(limit))
-		},
-	},
-	"charging-set-amps": &Command{
-		help:             "Set charge current to AMPS",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "AMPS", help: "Charging current"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			limit, err := strconv.Atoi(args["AMPS"])
-			if err != nil {
-				return fmt.Errorf("error parsing AMPS")
-			}
-			return car.SetChargingAmps(ctx, int32(limit))
-		},
-	},
-	"charging-start": &Command{
-		help:             "Start charging",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.ChargeStart(ctx)
-		},
-	},
-	"charging-stop": &Command{
-		help:             "Stop charging",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.ChargeStop(ctx)
-		},
-	},
-	"charging-schedule": &Command{
-		help:             "Schedule charging to MINS minutes after midnight and enable daily scheduling",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "MINS", help: "Time after midnight in minutes"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			minutesAfterMidnight, err := strconv.Atoi(args["MINS"])
-			if err != nil {
-				return fmt.Errorf("error parsing minutes")
-			}
-			// Convert minutes to a time.Duration
-			chargingTime := time.Duration(minutesAfterMidnight) * time.Minute
-			return car.ScheduleCharging(ctx, true, chargingTime)
-		},
-	},
-	"charging-schedule-cancel": &Command{
-		help:             "Cancel scheduled charge start",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.ScheduleCharging(ctx, false, 0*time.Hour)
-		},
-	},
-	"media-set-volume": &Command{
-		help:             "Set volume",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "VOLUME", help: "Set volume (0.0-10.0"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			volume, err := strconv.ParseFloat(args["VOLUME"], 32)
-			if err != nil {
-				return fmt.Errorf("failed to parse volume")
-			}
-			return car.SetVolume(ctx, float32(volume))
-		},
-	},
-	"media-toggle-playback": &Command{
-		help:             "Toggle between play/pause",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args:             []Argument{},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.ToggleMediaPlayback(ctx)
-		},
-	},
-	"software-update-start": &Command{
-		help:             "Start software update after DELAY",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{
-				name: "DELAY",
-				help: "Time to wait before starting update. Examples: 2h, 10m.",
-			},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			delay, err := time.ParseDuration(args["DELAY"])
-			if err != nil {
-				return fmt.Errorf("error parsing DELAY. Valid times are <n><unit>, where <n> is a number (decimals are allowed) and <unit> is 's, 'm', or 'h'")
-				// ...or 'ns'/'µs' if that's your cup of tea.
-			}
-			return car.ScheduleSoftwareUpdate(ctx, delay)
-		},
-	},
-	"software-update-cancel": &Command{
-		help:             "Cancel a pending software update",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.CancelSoftwareUpdate(ctx)
-		},
-	},
-	"sentry-mode": &Command{
-		help:             "Set sentry mode to STATE ('on' or 'off')",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "STATE", help: "'on' or 'off'"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			var state bool
-			switch args["STATE"] {
-			case "on":
-				state = true
-			case "off":
-				state = false
-			default:
-				return fmt.Errorf("sentry mode state must be 'on' or 'off'")
-			}
-			return car.SetSentryMode(ctx, state)
-		},
-	},
-	"wake": &Command{
-		help:             "Wake up vehicle",
-		requiresAuth:     false,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.Wakeup(ctx)
-		},
-	},
-	"tonneau-open": &Command{
-		help:             "Open Cybertruck tonneau.",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.OpenTonneau(ctx)
-		},
-	},
-	"tonneau-close": &Command{
-		help:             "Close Cybertruck tonneau.",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.CloseTonneau(ctx)
-		},
-	},
-	"tonneau-stop": &Command{
-		help:             "Stop moving Cybertruck tonneau.",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.StopTonneau(ctx)
-		},
-	},
-	"trunk-open": &Command{
-		help:             "Open vehicle trunk. Note that trunk-close only works on certain vehicle types.",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.OpenTrunk(ctx)
-		},
-	},
-	"trunk-move": &Command{
-		help:             "Toggle trunk open/closed. Closing is only available on certain vehicle types.",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.ActuateTrunk(ctx)
-		},
-	},
-	"trunk-close": &Command{
-		help:             "Closes vehicle trunk. Only available on certain vehicle types.",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.CloseTrunk(ctx)
-		},
-	},
-	"frunk-open": &Command{
-		help:             "Open vehicle frunk. Note that there's no frunk-close command!",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.OpenFrunk(ctx)
-		},
-	},
-	"charge-port-open": &Command{
-		help:             "Open charge port",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.OpenChargePort(ctx)
-		},
-	},
-	"charge-port-close": &Command{
-		help:             "Close charge port",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.CloseChargePort(ctx)
-		},
-	},
-	"autosecure-modelx": &Command{
-		help: "Close falcon-wing doors and lock vehicle. Model X only.",
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.AutoSecureVehicle(ctx)
-		},
-	},
-	"session-info": &Command{
-		help:             "Retrieve session info for PUBLIC_KEY from DOMAIN",
-		requiresAuth:     false,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
-			Argument{name: "DOMAIN", help: "'vcsec' or 'infotainment'"},
+		args: []Argument{
+			Argument{name: "PUBLIC_KEY", help: "file containing public key (or corresponding private key)"},
+			Argument{name: "DOMAIN", help: "'vcsec' or 'infotainment'"},
 		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
 			// See SeatPosition definition for controlling backrest heaters (limited models).
@@ -747,65 +755,6 @@ var commands = map[string]*Command{
 			return nil
 		},
 	},
-	"seat-heater": &Command{
-		help:             "Set seat heater at POSITION to LEVEL",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "SEAT", help: "<front|2nd-row|3rd-row>-<left|center|right> (e.g., 2nd-row-left)"},
-			Argument{name: "LEVEL", help: "off, low, medium, or high"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			// See SeatPosition definition for controlling backrest heaters (limited models).
-			seats := map[string]vehicle.SeatPosition{
-				"front-left":     vehicle.SeatFrontLeft,
-				"front-right":    vehicle.SeatFrontRight,
-				"2nd-row-left":   vehicle.SeatSecondRowLeft,
-				"2nd-row-center": vehicle.SeatSecondRowCenter,
-				"2nd-row-right":  vehicle.SeatSecondRowRight,
-				"3rd-row-left":   vehicle.SeatThirdRowLeft,
-				"3rd-row-right":  vehicle.SeatThirdRowRight,
-			}
-			position, ok := seats[args["SEAT"]]
-			if !ok {
-				return fmt.Errorf("invalid seat position")
-			}
-			levels := map[string]vehicle.Level{
-				"off":    vehicle.LevelOff,
-				"low":    vehicle.LevelLow,
-				"medium": vehicle.LevelMed,
-				"high":   vehicle.LevelHigh,
-			}
-			level, ok := levels[args["LEVEL"]]
-			if !ok {
-				return fmt.Errorf("invalid seat heater level")
-			}
-			spec := map[vehicle.SeatPosition]vehicle.Level{
-				position: level,
-			}
-			return car.SetSeatHeater(ctx, spec)
-		},
-	},
-	"steering-wheel-heater": &Command{
-		help:             "Set steering wheel mode to STATE ('on' or 'off')",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "STATE", help: "'on' or 'off'"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			var state bool
-			switch args["STATE"] {
-			case "on":
-				state = true
-			case "off":
-				state = false
-			default:
-				return fmt.Errorf("steering wheel state must be 'on' or 'off'")
-			}
-			return car.SetSteeringWheelHeater(ctx, state)
-		},
-	},
 	"product-info": &Command{
 		help:             "Print JSON product info",
 		requiresAuth:     false,
@@ -819,50 +768,6 @@ var commands = map[string]*Command{
 			return nil
 		},
 	},
-	"auto-seat-and-climate": &Command{
-		help:             "Turn on automatic seat heating and HVAC",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "POSITIONS", help: "'L' (left), 'R' (right), or 'LR'"},
-		},
-		optional: []Argument{
-			Argument{name: "STATE", help: "'on' (default) or 'off'"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			var positions []vehicle.SeatPosition
-			if strings.Contains(args["POSITIONS"], "L") {
-				positions = append(positions, vehicle.SeatFrontLeft)
-			}
-			if strings.Contains(args["POSITIONS"], "R") {
-				positions = append(positions, vehicle.SeatFrontRight)
-			}
-			if len(positions) != len(args["POSITIONS"]) {
-				return fmt.Errorf("invalid seat position")
-			}
-			enabled := true
-			if state, ok := args["STATE"]; ok && strings.ToUpper(state) == "OFF" {
-				enabled = false
-			}
-			return car.AutoSeatAndClimate(ctx, positions, enabled)
-		},
-	},
-	"windows-vent": &Command{
-		help:             "Vent all windows",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.VentWindows(ctx)
-		},
-	},
-	"windows-close": &Command{
-		help:             "Close all windows",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.CloseWindows(ctx)
-		},
-	},
 	"body-controller-state": &Command{
 		help:             "Fetch limited vehicle state information. Works over BLE when infotainment is asleep.",
 		domain:           protocol.DomainVCSEC,
@@ -883,221 +788,221 @@ var commands = map[string]*Command{
 			return nil
 		},
 	},
-	"erase-guest-data": &Command{
-		help:             "Erase Guest Mode user data",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			return car.EraseGuestData(ctx)
-		},
-	},
-	"charging-schedule-add": &Command{
-		help:             "Schedule charge for DAYS START_TIME-END_TIME at LATITUDE LONGITUDE. The END_TIME may be on the following day.",
-		requiresAuth:     true,
+	"watch": &Command{
+		help: "Poll body controller state every INTERVAL and print changes as JSON lines until interrupted or " +
+			"DURATION elapses. Works over BLE when infotainment is asleep.",
+		domain:           protocol.DomainVCSEC,
+		requiresAuth:     false,
 		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "DAYS", help: "Comma-separated list of any of Sun, Mon, Tues, Wed, Thurs, Fri, Sat OR all OR weekdays"},
-			Argument{name: "TIME", help: "Time interval to charge (24-hour clock). Examples: '22:00-6:00', '-6:00', '20:32-"},
-			Argument{name: "LATITUDE", help: "Latitude of charging site"},
-			Argument{name: "LONGITUDE", help: "Longitude of charging site"},
-		},
 		optional: []Argument{
-			Argument{name: "REPEAT", help: "Set to 'once' or omit to repeat weekly"},
-			Argument{name: "ID", help: "The ID of the charge schedule to modify. Not required for new schedules."},
-			Argument{name: "ENABLED", help: "Whether the charge schedule is enabled. Expects 'true' or 'false'. Defaults to true."},
+			Argument{name: "INTERVAL", help: "Time between polls (e.g., 500ms or 5s). Defaults to 1s."},
+			Argument{name: "DURATION", help: "Stop watching after DURATION (e.g., 10m)"},
 		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			var err error
-			schedule := vehicle.ChargeSchedule{
-				Id:      uint64(time.Now().Unix()),
-				Enabled: true,
-			}
-
-			if enabledStr, ok := args["ENABLED"]; ok {
-				schedule.Enabled = enabledStr == "true"
-			}
-
-			schedule.DaysOfWeek, err = GetDays(args["DAYS"])
-			if err != nil {
-				return err
-			}
-
-			r := strings.Split(args["TIME"], "-")
-			if len(r) != 2 {
-				return errors.New("invalid time range")
-			}
-
-			if r[0] != "" {
-				schedule.StartTime, err = MinutesAfterMidnight(r[0])
-				schedule.StartEnabled = true
-				if err != nil {
-					return err
+			interval := time.Second
+			if intervalStr, ok := args["INTERVAL"]; ok {
+				var err error
+				if interval, err = time.ParseDuration(intervalStr); err != nil || interval <= 0 {
+					return fmt.Errorf("%w: invalid INTERVAL", ErrCommandLineArgs)
 				}
 			}
-
-			if r[1] != "" {
-				schedule.EndTime, err = MinutesAfterMidnight(r[1])
-				schedule.EndEnabled = true
+			// Watch until interrupted rather than until the command timeout expires.
+			ctx, stop := signal.NotifyContext(context.WithoutCancel(ctx), os.Interrupt)
+			defer stop()
+			if durationStr, ok := args["DURATION"]; ok {
+				duration, err := time.ParseDuration(durationStr)
 				if err != nil {
+					return fmt.Errorf("%w: invalid DURATION", ErrCommandLineArgs)
+				}
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, duration)
+				defer cancel()
+			}
+			encoder := json.NewEncoder(os.Stdout)
+			for update := range car.Watch(ctx, interval) {
+				line := struct {
+					vehicle.StatusChange
+					Error string `json:"error,omitempty"`
+				}{StatusChange: update}
+				if update.Err != nil {
+					line.Error = update.Err.Error()
+				}
+				if err := encoder.Encode(&line); err != nil {
 					return err
 				}
 			}
-
-			schedule.Latitude, err = GetDegree(args["LATITUDE"])
-			if err != nil {
-				return err
-			}
-
-			schedule.Longitude, err = GetDegree(args["LONGITUDE"])
-			if err != nil {
-				return err
-			}
-
-			if repeatPolicy, ok := args["REPEAT"]; ok && repeatPolicy == "once" {
-				schedule.OneTime = true
-			}
-
-			if err := car.AddChargeSchedule(ctx, &schedule); err != nil {
-				return err
-			}
-			fmt.Printf("%d\n", schedule.Id)
 			return nil
 		},
 	},
-	"charging-schedule-remove": {
-		help:             "Removes charging schedule of TYPE [ID]",
+	"action": &Command{
+		help: "Send an action to DOMAIN ('vcsec' or 'infotainment') and print the response. The action is " +
+			"read from JSON or standard input, and must be the protojson encoding of a vcsec.UnsignedMessage or " +
+			"carserver.VehicleAction, respectively. Useful for actions that don't have a dedicated command.",
 		requiresAuth:     true,
 		requiresFleetAPI: false,
 		args: []Argument{
-			Argument{name: "TYPE", help: "home|work|other|id"},
+			Argument{name: "DOMAIN", help: "'vcsec' or 'infotainment'"},
 		},
 		optional: []Argument{
-			Argument{name: "ID", help: "numeric ID of schedule to remove when TYPE set to id"},
+			Argument{name: "JSON", help: "action encoded as protojson, e.g. '{\"mediaNextTrack\": {}}'"},
 		},
 		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			var home, work, other bool
-			switch strings.ToUpper(args["TYPE"]) {
-			case "ID":
-				if idStr, ok := args["ID"]; ok {
-					id, err := strconv.ParseUint(idStr, 10, 64)
-					if err != nil {
-						return errors.New("expected numeric ID")
-					}
-					return car.RemoveChargeSchedule(ctx, id)
-				} else {
-					return errors.New("missing schedule ID")
+			var jsonBytes []byte
+			if text, ok := args["JSON"]; ok {
+				jsonBytes = []byte(text)
+			} else {
+				var err error
+				if jsonBytes, err = io.ReadAll(os.Stdin); err != nil {
+					return err
+				}
+			}
+			var response proto.Message
+			switch cli.DomainsByName[strings.ToUpper(args["DOMAIN"])] {
+			case protocol.DomainVCSEC:
+				var message vcsec.UnsignedMessage
+				if err := protojson.Unmarshal(jsonBytes, &message); err != nil {
+					return fmt.Errorf("%w: invalid action: %s", ErrCommandLineArgs, err)
+				}
+				fromVCSEC, err := car.ExecuteVCSECAction(ctx, &message)
+				if err != nil {
+					return err
+				}
+				response = fromVCSEC
+			case protocol.DomainInfotainment:
+				var action carserver.VehicleAction
+				if err := protojson.Unmarshal(jsonBytes, &action); err != nil {
+					return fmt.Errorf("%w: invalid action: %s", ErrCommandLineArgs, err)
+				}
+				carResponse, err := car.ExecuteAction(ctx, &carserver.Action_VehicleAction{VehicleAction: &action})
+				if err != nil {
+					return err
 				}
-			case "HOME":
-				home = true
-			case "WORK":
-				work = true
-			case "OTHER":
-				other = true
+				response = carResponse
 			default:
-				return errors.New("TYPE must be home|work|other|id")
+				return fmt.Errorf("%w: unknown domain '%s'", ErrCommandLineArgs, args["DOMAIN"])
 			}
-			return car.BatchRemoveChargeSchedules(ctx, home, work, other)
+			options := protojson.MarshalOptions{
+				Indent:            "\t",
+				UseEnumNumbers:    false,
+				EmitUnpopulated:   false,
+				EmitDefaultValues: true,
+			}
+			fmt.Println(options.Format(response))
+			return nil
 		},
 	},
-	"precondition-schedule-add": &Command{
-		help:             "Schedule precondition for DAYS TIME at LATITUDE LONGITUDE.",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "DAYS", help: "Comma-separated list of any of Sun, Mon, Tues, Wed, Thurs, Fri, Sat OR all OR weekdays"},
-			Argument{name: "TIME", help: "Time to precondition by. Example: '22:00'"},
-			Argument{name: "LATITUDE", help: "Latitude of location to precondition at."},
-			Argument{name: "LONGITUDE", help: "Longitude of location to precondition at."},
-		},
-		optional: []Argument{
-			Argument{name: "REPEAT", help: "Set to 'once' or omit to repeat weekly"},
-			Argument{name: "ID", help: "The ID of the precondition schedule to modify. Not required for new schedules."},
-			Argument{name: "ENABLED", help: "Whether the precondition schedule is enabled. Expects 'true' or 'false'. Defaults to true."},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			var err error
-			schedule := vehicle.PreconditionSchedule{
-				Id:      uint64(time.Now().Unix()),
-				Enabled: true,
-			}
-
-			if enabledStr, ok := args["ENABLED"]; ok {
-				schedule.Enabled = enabledStr == "true"
-			}
+}
 
-			if idStr, ok := args["ID"]; ok {
-				id, err := strconv.ParseUint(idStr, 10, 64)
-				if err != nil {
-					return errors.New("expected numeric ID")
-				}
-				schedule.Id = id
-			}
+// parseRole parses the ROLE command-line argument.
+func parseRole(args map[string]string) (keys.Role, error) {
+	role, ok := keys.Role_value["ROLE_"+strings.ToUpper(args["ROLE"])]
+	if !ok {
+		return 0, fmt.Errorf("%w: invalid ROLE", ErrCommandLineArgs)
+	}
+	return keys.Role(role), nil
+}
 
-			schedule.DaysOfWeek, err = GetDays(args["DAYS"])
-			if err != nil {
-				return err
-			}
+// roleAndFormFactor parses the ROLE and FORM_FACTOR command-line arguments.
+func roleAndFormFactor(args map[string]string) (keys.Role, vcsec.KeyFormFactor, error) {
+	role, err := parseRole(args)
+	if err != nil {
+		return 0, 0, err
+	}
+	formFactor, ok := vcsec.KeyFormFactor_value["KEY_FORM_FACTOR_"+strings.ToUpper(args["FORM_FACTOR"])]
+	if !ok {
+		return 0, 0, fmt.Errorf("%w: unrecognized FORM_FACTOR", ErrCommandLineArgs)
+	}
+	return role, vcsec.KeyFormFactor(formFactor), nil
+}
 
-			if timeStr, ok := args["TIME"]; ok {
-				schedule.PreconditionTime, err = MinutesAfterMidnight(timeStr)
-			} else {
-				return errors.New("expected TIME")
-			}
+// roleAndPublicKey parses the ROLE and PUBLIC_KEY command-line arguments.
+func roleAndPublicKey(args map[string]string) (keys.Role, *ecdh.PublicKey, error) {
+	role, err := parseRole(args)
+	if err != nil {
+		return 0, nil, err
+	}
+	publicKey, err := protocol.LoadPublicKey(args["PUBLIC_KEY"])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid public key: %s", err)
+	}
+	return role, publicKey, nil
+}
 
-			schedule.Latitude, err = GetDegree(args["LATITUDE"])
-			if err != nil {
-				return err
-			}
+// timeBudget returns the time remaining before ctx expires. Handlers that wait on the user call
+// it before doing so, to give follow-up requests the same budget as the command itself.
+func timeBudget(ctx context.Context) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline)
+	}
+	return defaultTimeBudget
+}
 
-			schedule.Longitude, err = GetDegree(args["LONGITUDE"])
-			if err != nil {
-				return err
-			}
+// slotArg parses a command-line argument of the form "slot:N". Returns false if arg doesn't have
+// the "slot:" prefix.
+func slotArg(arg string) (uint32, bool, error) {
+	slotStr, ok := strings.CutPrefix(arg, "slot:")
+	if !ok {
+		return 0, false, nil
+	}
+	slot, err := strconv.ParseUint(slotStr, 10, 32)
+	if err != nil {
+		return 0, true, fmt.Errorf("%w: invalid slot %q", ErrCommandLineArgs, slotStr)
+	}
+	return uint32(slot), true, nil
+}
 
-			if repeatPolicy, ok := args["REPEAT"]; ok && repeatPolicy == "once" {
-				schedule.OneTime = true
-			}
+func init() {
+	for _, c := range command.All() {
+		commands[c.Name] = fromRegistry(c)
+	}
+}
 
-			if err := car.AddPreconditionSchedule(ctx, &schedule); err != nil {
-				return err
-			}
-			fmt.Printf("%d\n", schedule.Id)
-			return nil
-		},
-	},
-	"precondition-schedule-remove": {
-		help:             "Removes precondition schedule of TYPE [ID]",
-		requiresAuth:     true,
-		requiresFleetAPI: false,
-		args: []Argument{
-			Argument{name: "TYPE", help: "home|work|other|id"},
-		},
-		optional: []Argument{
-			Argument{name: "ID", help: "numeric ID of schedule to remove when TYPE set to id"},
-		},
-		handler: func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
-			var home, work, other bool
-			switch strings.ToUpper(args["TYPE"]) {
-			case "ID":
-				if idStr, ok := args["ID"]; ok {
-					id, err := strconv.ParseUint(idStr, 10, 64)
-					if err != nil {
-						return errors.New("expected numeric ID")
-					}
-					return car.RemoveChargeSchedule(ctx, id)
-				} else {
-					return errors.New("missing schedule ID")
-				}
-			case "HOME":
-				home = true
-			case "WORK":
-				work = true
-			case "OTHER":
-				other = true
-			default:
-				return errors.New("TYPE must be home|work|other|id")
+// fromRegistry converts a command from the shared registry into a tesla-control Command. Positional
+// arguments use the uppercase parameter names.
+func fromRegistry(c *command.Command) *Command {
+	info := &Command{
+		help:         c.Help,
+		requiresAuth: c.RequiresAuth,
+		domain:       c.Domain,
+	}
+	for _, p := range c.Params {
+		help := p.Help
+		if p.Type == command.TypeEnum {
+			help = fmt.Sprintf("%s. One of: %s", strings.TrimSuffix(help, "."), strings.Join(p.Choices(), ", "))
+		}
+		arg := Argument{name: strings.ToUpper(p.Name), help: help}
+		if p.Required {
+			info.args = append(info.args, arg)
+		} else {
+			info.optional = append(info.optional, arg)
+		}
+	}
+	if len(info.optional) > 1 {
+		info.help += " Use '-' to skip an optional argument."
+	}
+	info.handler = func(ctx context.Context, acct *account.Account, car *vehicle.Vehicle, args map[string]string) error {
+		values := make(map[string]string)
+		for _, p := range c.Params {
+			if value, ok := args[strings.ToUpper(p.Name)]; ok {
+				values[p.Name] = value
 			}
-			return car.BatchRemovePreconditionSchedules(ctx, home, work, other)
-		},
-	},
+		}
+		parsed, err := c.ParseStrings(values)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrCommandLineArgs, err)
+		}
+		result, err := c.Handler(ctx, car, parsed)
+		var paramErr *command.ParamError
+		if errors.As(err, &paramErr) {
+			return fmt.Errorf("%w: %s", ErrCommandLineArgs, err)
+		}
+		if err != nil {
+			return err
+		}
+		if result != nil {
+			fmt.Println(result)
+		}
+		return nil
+	}
+	return info
 }
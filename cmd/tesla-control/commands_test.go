@@ -1,64 +1,60 @@
 package main
 
 import (
-	"errors"
-	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/teslamotors/vehicle-command/pkg/cli"
+	"github.com/teslamotors/vehicle-command/pkg/command"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
 )
 
-func TestMinutesAfterMidnight(t *testing.T) {
-	type params struct {
-		str     string
-		minutes int32
-		err     error
-	}
-	testCases := []params{
-		{str: "3:03", minutes: 183},
-		{str: "0:00", minutes: 0},
-		{str: "", err: ErrInvalidTime},
-		{str: "3:", err: ErrInvalidTime},
-		{str: ":40", err: ErrInvalidTime},
-		{str: "3:40pm", err: ErrInvalidTime},
-		{str: "25:40", err: ErrInvalidTime},
-		{str: "23:40", minutes: 23*60 + 40},
-		{str: "23:60", err: ErrInvalidTime},
-		{str: "23:-01", err: ErrInvalidTime},
-		{str: "24:00", err: ErrInvalidTime},
-		{str: "-2:00", err: ErrInvalidTime},
-	}
-	for _, test := range testCases {
-		minutes, err := MinutesAfterMidnight(test.str)
-		if !errors.Is(err, test.err) {
-			t.Errorf("expected '%s' to result in error %s, but got %s", test.str, test.err, err)
-		} else if test.minutes != minutes {
-			t.Errorf("expected MinutesAfterMidnight('%s') = %d, but got %d", test.str, test.minutes, minutes)
+func TestRegistryCommands(t *testing.T) {
+	for _, c := range command.All() {
+		info, ok := commands[c.Name]
+		if !ok {
+			t.Errorf("command %s missing from tesla-control", c.Name)
+			continue
+		}
+		if info.requiresAuth != c.RequiresAuth || info.domain != c.Domain {
+			t.Errorf("command %s has inconsistent requirements", c.Name)
+		}
+		if len(info.args)+len(info.optional) != len(c.Params) {
+			t.Errorf("command %s has %d arguments, expected %d", c.Name, len(info.args)+len(info.optional), len(c.Params))
+			continue
+		}
+		for i, arg := range append(info.args, info.optional...) {
+			if arg.name != strings.ToUpper(c.Params[i].Name) {
+				t.Errorf("command %s argument %d is %s, expected %s", c.Name, i, arg.name, strings.ToUpper(c.Params[i].Name))
+			}
 		}
 	}
 }
 
-func TestGetDays(t *testing.T) {
-	type params struct {
-		str   string
-		mask  int32
-		isErr bool
+func TestConfigureFlagsBLERelay(t *testing.T) {
+	config := &cli.Config{
+		VIN:           "vin",
+		KeyFilename:   "key.pem",
+		TokenFilename: "token",
+		BLESocket:     "ble.sock",
 	}
-	testCases := []params{
-		{str: "SUN", mask: 1},
-		{str: "SUN, WED", mask: 1 + 8},
-		{str: "SUN, WEDnesday", mask: 1 + 8},
-		{str: "sUN,wEd", mask: 1 + 8},
-		{str: "all", mask: 127},
-		{str: "sun,all", mask: 127},
-		{str: "mon,tues,wed,thurs", mask: 2 + 4 + 8 + 16},
-		{str: "marketday", isErr: true},
-		{str: "sun mon", isErr: true},
+	if err := configureFlags(config, "wake", false); err != nil {
+		t.Fatal(err)
 	}
-	for _, test := range testCases {
-		mask, err := GetDays(test.str)
-		if (err != nil) != test.isErr {
-			t.Errorf("day string '%s' gave unexpected err = %s", test.str, err)
-		} else if mask != test.mask {
-			t.Errorf("day string '%s' gave mask %s instead of %s", test.str, strconv.FormatInt(int64(mask), 2), strconv.FormatInt(int64(test.mask), 2))
-		}
+	if config.Flags&cli.FlagOAuth == 0 {
+		t.Error("BLE relay disabled OAuth")
+	}
+	if config.Flags&cli.FlagPrivateKey == 0 {
+		t.Error("Wake over BLE relay doesn't load private key")
+	}
+}
+
+func TestConfigureFlagsBLEWake(t *testing.T) {
+	config := &cli.Config{VIN: "vin", KeyFilename: "key.pem"}
+	if err := configureFlags(config, "wake", true); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Domains) != 1 || config.Domains[0] != protocol.DomainVCSEC {
+		t.Errorf("Expected BLE wake to only handshake with VCSEC, got %s", config.Domains)
 	}
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/keyshare"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+// envKeyLedger overrides the location of the guest key ledger.
+const envKeyLedger = "TESLA_KEY_LEDGER"
+
+// connectOther connects to a vehicle other than the one selected on the command line. It's set
+// when an OAuth token is available, which allows commands such as expire-sweep to reach every
+// vehicle in the guest key ledger.
+var connectOther keyshare.Dialer
+
+// configFile returns the path of a tesla-control data file, which can be overridden by the
+// environment variable env, and creates its parent directory if needed.
+func configFile(env, name string) (string, error) {
+	filename := os.Getenv(env)
+	if filename == "" {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return "", fmt.Errorf("couldn't locate %s (set %s): %w", name, env, err)
+		}
+		filename = filepath.Join(configDir, "tesla-control", name)
+	}
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// openLedger loads the guest key ledger, creating its parent directory if needed.
+func openLedger() (*keyshare.Ledger, error) {
+	filename, err := configFile(envKeyLedger, "guest-keys.json")
+	if err != nil {
+		return nil, err
+	}
+	return keyshare.LoadLedger(filename)
+}
+
+// shareKey generates a guest key, enrolls it on car, and writes it to an encrypted bundle. The
+// grant is recorded in ledger, and the bundle's passcode is returned.
+func shareKey(ctx context.Context, car *vehicle.Vehicle, ledger *keyshare.Ledger, role keys.Role, activeFor time.Duration, filename, name string, impermanent bool) (string, error) {
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	publicKey, err := ecdh.P256().NewPublicKey(skey.PublicBytes())
+	if err != nil {
+		return "", err
+	}
+	passcode, err := keyshare.NewPasscode()
+	if err != nil {
+		return "", err
+	}
+	issued := time.Now()
+	expires := issued.Add(activeFor)
+
+	// Write the bundle before enrolling the key so that a bad filename doesn't leave an
+	// inaccessible key on the vehicle.
+	bundle, err := keyshare.Seal(skey, passcode, car.VIN(), role.String(), expires)
+	if err != nil {
+		return "", err
+	}
+	if err := bundle.WriteFile(filename); err != nil {
+		return "", err
+	}
+
+	// Likewise, record the grant before enrolling the key so that expire-sweep can always find it.
+	grant := ledger.Add(car.VIN(), publicKey, role.String(), name, issued, expires)
+	if err := ledger.Save(); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("failed to record guest key in ledger: %w", err)
+	}
+
+	formFactor := vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY
+	if impermanent {
+		err = car.AddImpermanentKey(ctx, publicKey, role, formFactor)
+	} else {
+		err = car.AddKeyWithRole(ctx, publicKey, role, formFactor)
+	}
+	if err != nil {
+		os.Remove(filename)
+		if protocol.MayHaveSucceeded(err) {
+			// The guest can't use the key without the bundle, so have expire-sweep remove it.
+			grant.Expires = time.Now().Truncate(time.Second)
+		} else {
+			ledger.Remove(grant)
+		}
+		return "", errors.Join(err, ledger.Save())
+	}
+	if err := car.UpdateKeyAndPermissions(ctx, publicKey, role, activeFor); err != nil {
+		writeErr("Vehicle didn't accept key expiration (%s). The key will remain enrolled until removed by revoke or expire-sweep.", err)
+	}
+	return passcode, nil
+}
+
+// sweepGuestKeys removes lapsed guest keys from every vehicle in ledger and saves the result.
+// Vehicles other than car are reached using connectOther.
+func sweepGuestKeys(ctx context.Context, car *vehicle.Vehicle, ledger *keyshare.Ledger) error {
+	var opened []*vehicle.Vehicle
+	defer func() {
+		for _, other := range opened {
+			other.Disconnect()
+		}
+	}()
+	dial := func(ctx context.Context, vin string) (*vehicle.Vehicle, error) {
+		if vin == car.VIN() {
+			return car, nil
+		}
+		if connectOther == nil {
+			return nil, fmt.Errorf("vehicle isn't reachable over BLE; retry with -vin %s or with an OAuth token", vin)
+		}
+		other, err := connectOther(ctx, vin)
+		if err != nil {
+			return nil, err
+		}
+		opened = append(opened, other)
+		return other, nil
+	}
+
+	// Each vehicle gets the time allowed for the command, since waking and connecting to several
+	// vehicles would otherwise use up ctx.
+	budget := timeBudget(ctx)
+	removed, err := ledger.Sweep(context.WithoutCancel(ctx), time.Now(), budget, dial)
+	for _, grant := range removed {
+		label := grant.Fingerprint()
+		if grant.Name != "" {
+			label = fmt.Sprintf("%s (%s)", label, grant.Name)
+		}
+		fmt.Printf("Removed guest key %s from %s\n", label, grant.VIN)
+	}
+	return errors.Join(err, ledger.Save())
+}
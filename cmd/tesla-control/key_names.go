@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/teslamotors/vehicle-command/pkg/keyshare"
+)
+
+// envKeyNames overrides the location of the key name inventory.
+const envKeyNames = "TESLA_KEY_NAMES"
+
+// keyNames is a local inventory of the names that rename-key and pair register for public keys.
+// The Fleet API doesn't document a way to read key names back, so list-keys audit checks the
+// vehicle's keychain against this inventory instead.
+type keyNames struct {
+	Keys     []keyName `json:"keys"`
+	filename string
+}
+
+type keyName struct {
+	// PublicKey is the hex-encoded uncompressed public key.
+	PublicKey string `json:"public_key"`
+	Name      string `json:"name"`
+	// VIN is the vehicle the key was paired with, if known.
+	VIN string `json:"vin,omitempty"`
+}
+
+// openKeyNames loads the key name inventory, creating its parent directory if needed.
+func openKeyNames() (*keyNames, error) {
+	filename, err := configFile(envKeyNames, "key-names.json")
+	if err != nil {
+		return nil, err
+	}
+	inventory := keyNames{filename: filename}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &inventory, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &inventory); err != nil {
+		return nil, fmt.Errorf("invalid key name inventory %s: %w", filename, err)
+	}
+	return &inventory, nil
+}
+
+func (k *keyNames) save() error {
+	data, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(k.filename, append(data, '\n'), 0600)
+}
+
+// set records that publicKey is named name. The vin may be empty if it isn't known.
+func (k *keyNames) set(publicKey *ecdh.PublicKey, name, vin string) {
+	encoded := hex.EncodeToString(publicKey.Bytes())
+	for i := range k.Keys {
+		if k.Keys[i].PublicKey == encoded {
+			k.Keys[i].Name = name
+			if vin != "" {
+				k.Keys[i].VIN = vin
+			}
+			return
+		}
+	}
+	k.Keys = append(k.Keys, keyName{PublicKey: encoded, Name: name, VIN: vin})
+}
+
+// forVehicle returns the names of keys that may be enrolled on vin, indexed by hex-encoded public
+// key, for use with vehicle.AuditKeys. Guest keys recorded in ledger are included as well.
+func (k *keyNames) forVehicle(vin string, ledger *keyshare.Ledger) map[string]string {
+	names := make(map[string]string)
+	for _, key := range k.Keys {
+		if key.VIN == "" || key.VIN == vin {
+			names[strings.ToLower(key.PublicKey)] = key.Name
+		}
+	}
+	if ledger != nil {
+		for _, grant := range ledger.Grants {
+			if grant.VIN != vin || grant.Removed != nil {
+				continue
+			}
+			name := grant.Name
+			if name == "" {
+				name = "guest key " + grant.Fingerprint()
+			}
+			names[grant.PublicKey] = name
+		}
+	}
+	return names
+}
+
+// recordKeyName adds a name registered with the Fleet API to the local inventory. The name has
+// already been registered, so failures are reported but not returned.
+func recordKeyName(publicKey *ecdh.PublicKey, name, vin string) {
+	inventory, err := openKeyNames()
+	if err == nil {
+		inventory.set(publicKey, name, vin)
+		err = inventory.save()
+	}
+	if err != nil {
+		writeErr("Registered key name but couldn't record it for list-keys audit: %s", err)
+	}
+}
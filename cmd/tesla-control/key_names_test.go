@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/keyshare"
+)
+
+func newTestPublicKey(t *testing.T) *ecdh.PublicKey {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.PublicKey()
+}
+
+func TestKeyNames(t *testing.T) {
+	t.Setenv(envKeyNames, filepath.Join(t.TempDir(), "key-names.json"))
+	const vin, otherVIN = "5YJ30123456789ABC", "5YJ3ZYXWVUTSRQPON"
+	phone, laptop, otherCar, guest := newTestPublicKey(t), newTestPublicKey(t), newTestPublicKey(t), newTestPublicKey(t)
+
+	inventory, err := openKeyNames()
+	if err != nil {
+		t.Fatal(err)
+	}
+	inventory.set(phone, "Old phone", vin)
+	inventory.set(phone, "Phone", "")
+	inventory.set(laptop, "Laptop", "")
+	inventory.set(otherCar, "Other car", otherVIN)
+	if err := inventory.save(); err != nil {
+		t.Fatal(err)
+	}
+	if inventory, err = openKeyNames(); err != nil {
+		t.Fatal(err)
+	}
+
+	ledger, err := keyshare.LoadLedger(filepath.Join(t.TempDir(), "guest-keys.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ledger.Add(vin, guest, "ROLE_DRIVER", "", now, now.Add(time.Hour))
+
+	names := inventory.forVehicle(vin, ledger)
+	expected := map[string]string{
+		hex.EncodeToString(phone.Bytes()):  "Phone",
+		hex.EncodeToString(laptop.Bytes()): "Laptop",
+		hex.EncodeToString(guest.Bytes()):  "guest key " + ledger.Grants[0].Fingerprint(),
+	}
+	if len(names) != len(expected) {
+		t.Fatalf("Expected %v but got %v", expected, names)
+	}
+	for publicKey, name := range expected {
+		if names[publicKey] != name {
+			t.Errorf("Expected name %q but got %q", name, names[publicKey])
+		}
+	}
+}
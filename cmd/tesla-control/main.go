@@ -56,12 +56,35 @@ func Usage() {
 	}
 }
 
+// Exit codes that let scripts distinguish why the pair command failed. Other failures exit with
+// status 1.
+const (
+	exitPairingRejected = 3
+	exitPairingTimedOut = 4
+)
+
+// reportWake tells the user why a command is taking longer than usual.
+func reportWake(progress vehicle.WakeProgress) {
+	switch progress.Stage {
+	case vehicle.WakeRequested:
+		writeErr("Vehicle is asleep. Waking it up...")
+	case vehicle.WakeCompleted:
+		writeErr("Vehicle woke up after %s.", progress.Elapsed.Round(100*time.Millisecond))
+	}
+}
+
 func runCommand(acct *account.Account, car *vehicle.Vehicle, args []string, timeout time.Duration) int {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	if err := execute(ctx, acct, car, args); err != nil {
-		if protocol.MayHaveSucceeded(err) {
+		if errors.Is(err, vehicle.ErrPairingRejected) {
+			writeErr("Vehicle rejected pairing request: %s", err)
+			return exitPairingRejected
+		} else if errors.Is(err, errPairingTimedOut) {
+			writeErr("%s", err)
+			return exitPairingTimedOut
+		} else if protocol.MayHaveSucceeded(err) {
 			writeErr("Couldn't verify success: %s", err)
 		} else if errors.Is(err, protocol.ErrNoSession) {
 			writeErr("You must provide a private key with -key-name or -key-file to execute this command")
@@ -107,6 +130,8 @@ func main() {
 		forceBLE       bool
 		commandTimeout time.Duration
 		connTimeout    time.Duration
+		recordFile     string
+		verifyTimeout  time.Duration
 	)
 	config, err := cli.NewConfig(cli.FlagAll)
 	if err != nil {
@@ -118,8 +143,11 @@ func main() {
 	flag.BoolVar(&forceBLE, "ble", false, "Force BLE connection even if OAuth environment variables are defined")
 	flag.DurationVar(&commandTimeout, "command-timeout", 5*time.Second, "Set timeout for commands sent to the vehicle.")
 	flag.DurationVar(&connTimeout, "connect-timeout", 20*time.Second, "Set timeout for establishing initial connection.")
+	flag.DurationVar(&verifyTimeout, "verify-timeout", 0, "If a command's outcome is uncertain, spend up to `duration` checking vehicle state to determine if it succeeded")
+	flag.StringVar(&recordFile, "record", "", "Record messages exchanged with the vehicle to `file` for later replay")
 
 	config.RegisterCommandLineFlags()
+	config.OnWake = reportWake
 	flag.Parse()
 	if !debug {
 		if debugEnv, ok := os.LookupEnv("TESLA_VERBOSE"); ok {
@@ -130,6 +158,7 @@ func main() {
 		log.SetLevel(log.LevelDebug)
 	}
 	config.ReadFromEnvironment()
+	wakeTimeout = connTimeout
 
 	args := flag.Args()
 	if len(args) > 0 {
@@ -159,6 +188,16 @@ func main() {
 		return
 	}
 
+	if recordFile != "" {
+		capture, err := os.Create(recordFile)
+		if err != nil {
+			writeErr("Error creating capture file: %s", err)
+			return
+		}
+		defer capture.Close()
+		config.Capture = capture
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
 	defer cancel()
 
@@ -177,6 +216,28 @@ func main() {
 	if car != nil {
 		defer car.Disconnect()
 		defer config.UpdateCachedSessions(car)
+		car.SetVerifyTimeout(verifyTimeout)
+	}
+
+	if acct != nil {
+		connectOther = func(ctx context.Context, vin string) (*vehicle.Vehicle, error) {
+			skey, err := config.PrivateKey()
+			if err != nil {
+				return nil, err
+			}
+			other, err := acct.GetVehicle(ctx, vin, skey, nil)
+			if err != nil {
+				return nil, err
+			}
+			if err := other.Connect(ctx); err != nil {
+				return nil, err
+			}
+			if err := other.StartSession(ctx, []protocol.Domain{protocol.DomainVCSEC}); err != nil {
+				other.Disconnect()
+				return nil, err
+			}
+			return other, nil
+		}
 	}
 
 	if flag.NArg() > 0 {
@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/cache"
 	"github.com/teslamotors/vehicle-command/pkg/cli"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
 	"github.com/teslamotors/vehicle-command/pkg/proxy"
@@ -27,6 +28,8 @@ const (
 	EnvHost    = "TESLA_HTTP_PROXY_HOST"
 	EnvPort    = "TESLA_HTTP_PROXY_PORT"
 	EnvTimeout = "TESLA_HTTP_PROXY_TIMEOUT"
+	EnvMaxAge  = "TESLA_HTTP_PROXY_SESSION_MAX_AGE"
+	EnvVerify  = "TESLA_HTTP_PROXY_VERIFY_TIMEOUT"
 	EnvVerbose = "TESLA_VERBOSE"
 )
 
@@ -42,6 +45,8 @@ type HttpProxyConfig struct {
 	host         string
 	port         int
 	timeout      time.Duration
+	maxAge       time.Duration
+	verify       time.Duration
 }
 
 var (
@@ -55,6 +60,8 @@ func init() {
 	flag.StringVar(&httpConfig.host, "host", "localhost", "Proxy server `hostname`")
 	flag.IntVar(&httpConfig.port, "port", defaultPort, "`Port` to listen on")
 	flag.DurationVar(&httpConfig.timeout, "timeout", proxy.DefaultTimeout, "Timeout interval when sending commands")
+	flag.DurationVar(&httpConfig.verify, "verify-timeout", 0, "If a command's outcome is uncertain, spend up to `duration` checking vehicle state to determine if it succeeded")
+	flag.DurationVar(&httpConfig.maxAge, "session-max-age", 0, "Discard cached vehicle sessions that haven't been used for this `duration` (zero to keep sessions indefinitely)")
 }
 
 func Usage() {
@@ -118,6 +125,12 @@ func main() {
 		return
 	}
 	p.Timeout = httpConfig.timeout
+	p.VerifyTimeout = httpConfig.verify
+	p.SetSessionMaxAge(httpConfig.maxAge)
+	if config.CacheFilename != "" {
+		log.Debug("Sharing sessions using %s", config.CacheFilename)
+		p.SetSessionStore(cache.NewFileStore(config.CacheFilename))
+	}
 	addr := fmt.Sprintf("%s:%d", httpConfig.host, httpConfig.port)
 	log.Info("Listening on %s", addr)
 
@@ -172,5 +185,23 @@ func readFromEnvironment() error {
 		}
 	}
 
+	if httpConfig.maxAge == 0 {
+		if maxAgeEnv, ok := os.LookupEnv(EnvMaxAge); ok {
+			httpConfig.maxAge, err = time.ParseDuration(maxAgeEnv)
+			if err != nil {
+				return fmt.Errorf("invalid session max age: %s", maxAgeEnv)
+			}
+		}
+	}
+
+	if httpConfig.verify == 0 {
+		if verifyEnv, ok := os.LookupEnv(EnvVerify); ok {
+			httpConfig.verify, err = time.ParseDuration(verifyEnv)
+			if err != nil {
+				return fmt.Errorf("invalid verify timeout: %s", verifyEnv)
+			}
+		}
+	}
+
 	return nil
 }
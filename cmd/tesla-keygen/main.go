@@ -13,21 +13,31 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
+
+	"golang.org/x/term"
 
 	"github.com/teslamotors/vehicle-command/internal/authentication"
 	"github.com/teslamotors/vehicle-command/internal/log"
 	"github.com/teslamotors/vehicle-command/pkg/cli"
+	"github.com/teslamotors/vehicle-command/pkg/keyshare"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
 )
 
+const envBundlePasscode = "TESLA_KEY_BUNDLE_PASSCODE"
+
 func writeErr(format string, a ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, a...)
 	fmt.Fprintf(os.Stderr, "\n")
 }
 
 const usageText = `
-Creates or deletes a private key and saves it in the system keyring, or migrates a key from a
-plaintext file into the system keyring.
+Creates or deletes a private key and saves it in the system keyring, migrates a key from a
+plaintext file into the system keyring, or imports a guest key bundle created by
+tesla-control share-key.
+
+When importing a bundle, the program reads the passcode from $TESLA_KEY_BUNDLE_PASSCODE or
+prompts for it.
 
 The program writes the public key to stdout (except when deleting a key). When using the create
 option, the program will not overwrite an existing unless invoked with -f.
@@ -40,7 +50,7 @@ func cliUsage() {
 }
 
 func usage(w io.Writer) {
-	fmt.Fprintf(w, "usage: %s [OPTION...] create|delete|export|migrate\n", filepath.Base(os.Args[0]))
+	fmt.Fprintf(w, "usage: %s [OPTION...] create|delete|export|migrate|import BUNDLE_FILE\n", filepath.Base(os.Args[0]))
 	fmt.Fprintln(w, usageText)
 	fmt.Fprintln(w, "")
 	fmt.Fprintln(w, "OPTIONS:")
@@ -74,6 +84,35 @@ func printPrivateKey(skey protocol.ECDHPrivateKey) error {
 	return nil
 }
 
+func importBundle(filename string) (protocol.ECDHPrivateKey, error) {
+	bundle, err := keyshare.ReadBundle(filename)
+	if err != nil {
+		return nil, err
+	}
+	passcode := os.Getenv(envBundlePasscode)
+	if passcode == "" {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil, fmt.Errorf("set %s or run interactively to provide the passcode", envBundlePasscode)
+		}
+		fmt.Fprintf(os.Stderr, "Passcode: ")
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		passcode = string(b)
+	}
+	skey, err := bundle.Open(passcode)
+	if err != nil {
+		return nil, err
+	}
+	writeErr("Imported %s key for %s, valid until %s", bundle.Role, bundle.VIN, bundle.Expires.Local().Format(time.RFC1123))
+	if !bundle.Expires.After(time.Now()) {
+		writeErr("Warning: this key has already expired.")
+	}
+	return skey, nil
+}
+
 func main() {
 	// Command-line variables
 	var (
@@ -100,7 +139,7 @@ func main() {
 	}
 	config.ReadFromEnvironment()
 
-	if flag.NArg() != 1 {
+	if flag.NArg() != 1 && !(flag.NArg() == 2 && flag.Arg(0) == "import") {
 		usage(os.Stderr)
 		return
 	}
@@ -143,6 +182,22 @@ func main() {
 			writeErr("Failed to generate private key: %s", err)
 			return
 		}
+	case "import":
+		if flag.NArg() != 2 {
+			usage(os.Stderr)
+			return
+		}
+		if !overwrite {
+			if _, err := config.PrivateKey(); err == nil {
+				writeErr("Key already exists. Run with -f to replace it.")
+				return
+			}
+		}
+		skey, err = importBundle(flag.Arg(1))
+		if err != nil {
+			writeErr("Failed to import key bundle: %s", err)
+			return
+		}
 	case "export":
 		skey, err = config.PrivateKey()
 		if err == nil {
@@ -8,6 +8,7 @@ require (
 	github.com/go-ble/ble v0.0.0-20220207185428-60d1eecf2633
 	github.com/golang-jwt/jwt/v5 v5.2.1
 	github.com/google/shlex v0.0.0-20191202100458-e7afc7fbc510
+	golang.org/x/sys v0.8.0
 	golang.org/x/term v0.5.0
 	google.golang.org/protobuf v1.34.2
 )
@@ -29,7 +30,6 @@ require (
 	github.com/pkg/errors v0.8.1 // indirect
 	github.com/raff/goble v0.0.0-20190909174656-72afc67d6a99 // indirect
 	github.com/sirupsen/logrus v1.5.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
 )
 
 replace github.com/JuulLabs-OSS/cbgo => github.com/tinygo-org/cbgo v0.0.4
@@ -66,6 +66,23 @@ func (s *Signer) RemotePublicKeyBytes() []byte {
 	return append([]byte{}, s.verifierPublicBytes...)
 }
 
+// ClockTime returns the Signer's estimate of the Verifier's clock, in seconds since the start of the
+// current epoch.
+func (s *Signer) ClockTime() uint32 {
+	return s.timestamp()
+}
+
+// Epoch returns the identifier of the Verifier's current epoch. The Verifier starts a new epoch,
+// and restarts its clock, when it loses state (for example, after a reboot).
+func (s *Signer) Epoch() []byte {
+	return append([]byte{}, s.epoch[:]...)
+}
+
+// Counter returns the anti-replay counter of the most recently authorized message.
+func (s *Signer) Counter() uint32 {
+	return s.counter
+}
+
 // ImportSessionInfo allows creation of a Signer with cached SessionInfo.
 // This can be used to avoid a round trip with the Verifier.
 func ImportSessionInfo(private ECDHPrivateKey, verifierName, encodedInfo []byte, generatedAt time.Time) (*Signer, error) {
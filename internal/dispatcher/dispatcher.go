@@ -3,6 +3,7 @@ package dispatcher
 import (
 	"context"
 	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"sync"
@@ -12,12 +13,16 @@ import (
 	"github.com/teslamotors/vehicle-command/internal/log"
 	"github.com/teslamotors/vehicle-command/pkg/connector"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/tracing"
 
 	"google.golang.org/protobuf/proto"
 
 	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
 )
 
+// resyncTimeout limits how long the Dispatcher waits for updated session info after a reconnect.
+var resyncTimeout = 10 * time.Second
+
 // Dispatcher objects send (encrypted) messages to a vehicle and route incoming messages to the
 // appropriate receiver object.
 type Dispatcher struct {
@@ -26,7 +31,11 @@ type Dispatcher struct {
 	address    []byte
 
 	latencyLock sync.Mutex
-	maxLatency  time.Duration
+	maxLatency  time.Duration // If zero, use conn.AllowedLatency()
+
+	policyLock    sync.Mutex
+	refreshPolicy RefreshPolicy
+	policyChanged chan struct{}
 
 	doneLock  sync.Mutex
 	terminate chan struct{}
@@ -37,18 +46,34 @@ type Dispatcher struct {
 
 	handlerLock sync.Mutex
 	handlers    map[receiverKey]*receiver
+
+	subscriptionLock sync.Mutex
+	subscriptions    []*subscription
+
+	faultLock    sync.Mutex
+	sessionFault func(universal.Domain)
+
+	// queues serialize the authorization and transmission of commands to each domain, so that
+	// the vehicle receives anti-replay counters in increasing order. Each channel has capacity one
+	// and is used as a mutex that can be abandoned when a context expires.
+	queueLock sync.Mutex
+	queues    map[universal.Domain]chan struct{}
+
+	monitor Monitor
 }
 
 // New creates a Dispatcher from a Connector.
 func New(conn connector.Connector, privateKey authentication.ECDHPrivateKey) (*Dispatcher, error) {
 	dispatcher := Dispatcher{
-		conn:       conn,
-		maxLatency: conn.AllowedLatency(),
-		address:    make([]byte, addressLength),
-		sessions:   make(map[universal.Domain]*session),
-		handlers:   make(map[receiverKey]*receiver),
-		privateKey: privateKey,
-		done:       make(chan bool),
+		conn:          conn,
+		address:       make([]byte, addressLength),
+		sessions:      make(map[universal.Domain]*session),
+		handlers:      make(map[receiverKey]*receiver),
+		queues:        make(map[universal.Domain]chan struct{}),
+		privateKey:    privateKey,
+		done:          make(chan bool),
+		refreshPolicy: defaultRefreshPolicy,
+		policyChanged: make(chan struct{}, 1),
 	}
 	if _, err := rand.Read(dispatcher.address); err != nil {
 		return nil, err
@@ -64,6 +89,23 @@ func (d *Dispatcher) SetMaxLatency(latency time.Duration) {
 	}
 }
 
+// Monitor returns the Monitor that records d's activity. Higher layers may use it to report
+// events, such as command completion, that the Dispatcher can't observe itself.
+func (d *Dispatcher) Monitor() *Monitor {
+	return &d.monitor
+}
+
+// OnSessionFault registers a function that's called whenever the vehicle rejects the client's
+// session with a domain and responds with updated session info. The function is only called after
+// the session info has been authenticated and accepted. This allows session caches to
+// discard the rejected session. The function is called from the goroutine that receives messages
+// from the vehicle, so it must not block.
+func (d *Dispatcher) OnSessionFault(handler func(domain universal.Domain)) {
+	d.faultLock.Lock()
+	defer d.faultLock.Unlock()
+	d.sessionFault = handler
+}
+
 // RetryInterval fetches the transport-layer dependent recommended delay between retry attempts.
 func (d *Dispatcher) RetryInterval() time.Duration {
 	return d.conn.RetryInterval()
@@ -71,6 +113,10 @@ func (d *Dispatcher) RetryInterval() time.Duration {
 
 // StartSession sends a blocking request start an authenticated session with a universal.Domain.
 func (d *Dispatcher) StartSession(ctx context.Context, domain universal.Domain) error {
+	if d.authorizer() != nil {
+		// The connector holds the sessions.
+		return nil
+	}
 	var err error
 	var sessionReady bool
 	d.sessionLock.Lock()
@@ -86,6 +132,18 @@ func (d *Dispatcher) StartSession(ctx context.Context, domain universal.Domain)
 	if err != nil || sessionReady {
 		return err
 	}
+	ctx, span := tracing.Start(ctx, "dispatcher.StartSession")
+	span.SetAttribute(tracing.AttrVIN, d.conn.VIN())
+	span.SetAttribute(tracing.AttrDomain, domain.String())
+	start := time.Now()
+	err = d.handshake(ctx, domain, s)
+	d.monitor.HandshakeCompleted(domain, time.Since(start), err)
+	span.End(err)
+	return err
+}
+
+// handshake requests session info from domain until s is ready.
+func (d *Dispatcher) handshake(ctx context.Context, domain universal.Domain, s *session) error {
 	for {
 		recv, err := d.RequestSessionInfo(ctx, domain)
 		if err != nil {
@@ -179,8 +237,14 @@ func (d *Dispatcher) checkForSessionUpdate(message *universal.RoutableMessage, h
 	d.latencyLock.Lock()
 	maxLatency := d.maxLatency
 	d.latencyLock.Unlock()
+	if maxLatency == 0 {
+		// Connectors that switch between transports may change their latency requirements, so
+		// this isn't cached.
+		maxLatency = d.conn.AllowedLatency()
+	}
 
 	if handler.expired(maxLatency) {
+		d.monitor.SessionInfoDiscarded(domain, time.Since(handler.requestSentAt))
 		log.Warning("[%02x] Discarding session info because it was received more than %s after request", message.GetRequestUuid(), maxLatency)
 		return
 	}
@@ -192,19 +256,32 @@ func (d *Dispatcher) checkForSessionUpdate(message *universal.RoutableMessage, h
 	var err error
 
 	d.sessionLock.Lock()
-	defer d.sessionLock.Unlock()
-
 	session, ok := d.sessions[domain]
+	if ok {
+		err = session.ProcessHello(message.GetRequestUuid(), sessionInfo, tag)
+	}
+	d.sessionLock.Unlock()
+
 	if !ok {
 		log.Error("[%02x] Dropping session from unregistered domain %s", message.GetRequestUuid(), domain)
 		return
 	}
-
-	if err = session.ProcessHello(message.GetRequestUuid(), sessionInfo, tag); err != nil {
+	if err != nil {
 		log.Warning("[%02x] Session info error: %s", message.GetRequestUuid(), err)
 		return
 	}
 	log.Info("[%02x] Updated session info for %s", message.GetRequestUuid(), domain)
+
+	// Only report faults that came with authenticated session info; otherwise anyone who can
+	// inject messages could purge session caches.
+	if message.GetSignedMessageStatus().GetSignedMessageFault() != universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE {
+		d.faultLock.Lock()
+		sessionFault := d.sessionFault
+		d.faultLock.Unlock()
+		if sessionFault != nil {
+			sessionFault(domain)
+		}
+	}
 }
 
 func (d *Dispatcher) process(message *universal.RoutableMessage) {
@@ -212,6 +289,7 @@ func (d *Dispatcher) process(message *universal.RoutableMessage) {
 
 	if message.GetFromDestination() == nil {
 		log.Warning("[xxx] Dropping message with missing source")
+		d.monitor.MessageDropped(DropMissingSource)
 		return
 	}
 	key.domain = message.GetFromDestination().GetDomain()
@@ -219,6 +297,7 @@ func (d *Dispatcher) process(message *universal.RoutableMessage) {
 	requestUUID := message.GetRequestUuid()
 	if len(requestUUID) != uuidLength && len(requestUUID) != 0 {
 		log.Warning("[xxx] Dropping message with invalid request UUID length")
+		d.monitor.MessageDropped(DropInvalidUUID)
 		return
 	}
 	if key.domain != universal.Domain_DOMAIN_VEHICLE_SECURITY {
@@ -228,23 +307,27 @@ func (d *Dispatcher) process(message *universal.RoutableMessage) {
 	destination := message.GetToDestination()
 	if destination == nil {
 		log.Warning("[%02x] Dropping message with missing destination", message.GetRequestUuid())
+		d.monitor.MessageDropped(DropMissingDestination)
 		return
 	}
 
-	switch d := destination.SubDestination.(type) {
+	switch dest := destination.SubDestination.(type) {
 	case *universal.Destination_Domain:
-		log.Debug("[%02x] Dropping message to %s", message.GetRequestUuid(), d.Domain)
+		log.Debug("[%02x] Dropping message to %s", message.GetRequestUuid(), dest.Domain)
+		d.monitor.MessageDropped(DropWrongDestination)
 		return
 	case *universal.Destination_RoutingAddress:
 		// Continue
 	default:
 		log.Debug("[%02x] Dropping message with unrecognized destination type", message.GetRequestUuid())
+		d.monitor.MessageDropped(DropWrongDestination)
 		return
 	}
 
 	addr := destination.GetRoutingAddress()
 	if len(addr) != addressLength {
 		log.Warning("[%02x] Dropping message with invalid address length", message.GetRequestUuid())
+		d.monitor.MessageDropped(DropInvalidAddress)
 		return
 	}
 	copy(key.address[:], addr)
@@ -253,7 +336,11 @@ func (d *Dispatcher) process(message *universal.RoutableMessage) {
 	handler, ok := d.handlers[key]
 	d.handlerLock.Unlock()
 	if !ok {
+		if d.publish(key.domain, message) {
+			return
+		}
 		log.Warning("[%02x] Dropping message without registered handler %s", requestUUID, key.String())
+		d.monitor.MessageDropped(DropNoHandler)
 		return
 	}
 
@@ -267,6 +354,7 @@ func (d *Dispatcher) process(message *universal.RoutableMessage) {
 	case handler.ch <- message:
 	default:
 		log.Error("[%02x] Dropping response to command because response handler queue is full", requestUUID)
+		d.monitor.MessageDropped(DropHandlerQueueFull)
 	}
 }
 
@@ -295,8 +383,16 @@ func (d *Dispatcher) listen(ready chan<- struct{}) {
 	}
 	terminate := d.terminate
 	d.doneLock.Unlock()
+	// The connection may close before d is stopped, so background refreshes need their own signal.
+	exited := make(chan struct{})
+	defer close(exited)
+	go d.refreshIdleSessions(exited)
 	listening := make(chan struct{}, 2)
 	listening <- struct{}{}
+	var reconnected <-chan struct{}
+	if rc, ok := d.conn.(connector.ReconnectingConnector); ok {
+		reconnected = rc.Reconnected()
+	}
 	defer func() {
 		d.done <- true
 	}()
@@ -309,9 +405,12 @@ func (d *Dispatcher) listen(ready chan<- struct{}) {
 			message := new(universal.RoutableMessage)
 			if err := proto.Unmarshal(messageBytes, message); err != nil {
 				log.Warning("Dropping unparseable message: %s", err)
+				d.monitor.MessageDropped(DropUnparseable)
 				continue
 			}
 			d.process(message)
+		case <-reconnected:
+			go d.resyncSessions(terminate)
 		case <-terminate:
 			return
 		case <-listening:
@@ -320,6 +419,31 @@ func (d *Dispatcher) listen(ready chan<- struct{}) {
 	}
 }
 
+// resyncSessions requests fresh session info for every established session. This is used after
+// the Connector re-establishes a lost link, since the vehicle may have restarted (and therefore
+// started a new session epoch) while it was unreachable. Returns early if terminate is closed.
+func (d *Dispatcher) resyncSessions(terminate <-chan struct{}) {
+	var domains []universal.Domain
+	d.sessionLock.Lock()
+	for domain, s := range d.sessions {
+		if s == nil {
+			continue
+		}
+		s.lock.Lock()
+		if s.ready {
+			domains = append(domains, domain)
+		}
+		s.lock.Unlock()
+	}
+	d.sessionLock.Unlock()
+	if len(domains) == 0 {
+		return
+	}
+
+	log.Info("Connection re-established; resynchronizing sessions")
+	d.refreshSessions(terminate, domains)
+}
+
 // Stop signals any goroutine running Listen to exit.
 func (d *Dispatcher) Stop() {
 	d.doneLock.Lock()
@@ -333,6 +457,19 @@ func (d *Dispatcher) Stop() {
 
 // Send a message to a vehicle.
 func (d *Dispatcher) Send(ctx context.Context, message *universal.RoutableMessage, auth connector.AuthMethod) (protocol.Receiver, error) {
+	ctx, span := tracing.Start(ctx, "dispatcher.Send")
+	span.SetAttribute(tracing.AttrVIN, d.conn.VIN())
+	span.SetAttribute(tracing.AttrDomain, message.GetToDestination().GetDomain().String())
+	span.SetAttribute(tracing.AttrAuthMethod, auth.String())
+	recv, err := d.send(ctx, message, auth)
+	if uuid := message.GetUuid(); uuid != nil {
+		span.SetAttribute(tracing.AttrRequestUUID, hex.EncodeToString(uuid))
+	}
+	span.End(err)
+	return recv, err
+}
+
+func (d *Dispatcher) send(ctx context.Context, message *universal.RoutableMessage, auth connector.AuthMethod) (protocol.Receiver, error) {
 	d.doneLock.Lock()
 	listening := d.terminate != nil
 	d.doneLock.Unlock()
@@ -368,20 +505,19 @@ func (d *Dispatcher) Send(ctx context.Context, message *universal.RoutableMessag
 		SubDestination: &universal.Destination_RoutingAddress{RoutingAddress: addr},
 	}
 
+	transmit := d.conn.Send
 	if auth != connector.AuthMethodNone {
-		d.sessionLock.Lock()
-		session, ok := d.sessions[message.GetToDestination().GetDomain()]
-		if ok {
-			session.lock.Lock()
-			ok = session.ready
-			session.lock.Unlock()
-		}
-		d.sessionLock.Unlock()
-		if !ok {
-			log.Warning("No session available for %s", message.GetToDestination().GetDomain())
-			return nil, protocol.ErrNoSession
+		// Hold the domain's place in line until the message is transmitted (or fails to
+		// transmit). Commands to other domains don't wait, and responses are handled
+		// concurrently, so multiple commands may be in flight at once.
+		release, err := d.enqueue(ctx, key.domain)
+		if err != nil {
+			return nil, err
 		}
-		if err := session.Authorize(ctx, message, auth); err != nil {
+		defer release()
+		if authorizer := d.authorizer(); authorizer != nil {
+			transmit = authorizer.SendAuthorized
+		} else if err := d.authorize(ctx, key.domain, message, auth); err != nil {
 			return nil, err
 		}
 	}
@@ -397,25 +533,80 @@ func (d *Dispatcher) Send(ctx context.Context, message *universal.RoutableMessag
 		}
 	}()
 
-	for {
-		err = d.conn.Send(ctx, encodedMessage)
+	policy, ok := protocol.RetryPolicyFromContext(ctx)
+	if !ok {
+		policy = protocol.DefaultRetryPolicy
+	}
+	for attempts := 1; ; attempts++ {
+		err = transmit(ctx, encodedMessage)
 		if err == nil {
 			return resp, nil
 		}
-		if !protocol.ShouldRetry(err) {
+		if errors.Is(err, protocol.ErrTransportChanged) {
+			// Retransmitting the same message won't help, but the caller can re-authorize it.
+			log.Debug("[%02x] Transport changed before transmission", message.GetUuid())
+			return nil, err
+		}
+		if !policy.ShouldRetry(err, attempts) {
 			log.Warning("[%02x] Terminal transmission error: %s", message.GetUuid(), err)
 			return nil, err
 		}
 		log.Debug("[%02x] Retrying transmission after error: %s", message.GetUuid(), err)
-		select {
-		case <-ctx.Done():
-			return nil, &protocol.CommandError{Err: ctx.Err(), PossibleSuccess: false, PossibleTemporary: true}
-		case <-time.After(d.conn.RetryInterval()):
-			continue
+		d.monitor.Retrying(key.domain, err)
+		if err := policy.Wait(ctx, attempts, d.conn.RetryInterval()); err != nil {
+			return nil, &protocol.CommandError{Err: err, PossibleSuccess: false, PossibleTemporary: true}
 		}
 	}
 }
 
+// authorize signs message using the session with domain.
+func (d *Dispatcher) authorize(ctx context.Context, domain universal.Domain, message *universal.RoutableMessage, auth connector.AuthMethod) error {
+	d.sessionLock.Lock()
+	session, ok := d.sessions[domain]
+	if ok {
+		session.lock.Lock()
+		ok = session.ready
+		session.lock.Unlock()
+	}
+	d.sessionLock.Unlock()
+	if !ok {
+		log.Warning("No session available for %s", domain)
+		return protocol.ErrNoSession
+	}
+	if err := d.refreshIfNeeded(ctx, domain, session); err != nil {
+		return err
+	}
+	return session.Authorize(ctx, message, auth)
+}
+
+// authorizer returns d.conn if it authorizes commands using sessions it holds on the client's
+// behalf, and nil otherwise.
+func (d *Dispatcher) authorizer() connector.AuthorizingConnector {
+	if authorizer, ok := d.conn.(connector.AuthorizingConnector); ok && authorizer.Authorizes() {
+		return authorizer
+	}
+	return nil
+}
+
+// enqueue blocks until the caller may authorize and transmit a command to domain. The caller must
+// invoke the returned function once transmission is complete.
+func (d *Dispatcher) enqueue(ctx context.Context, domain universal.Domain) (func(), error) {
+	d.queueLock.Lock()
+	queue, ok := d.queues[domain]
+	if !ok {
+		queue = make(chan struct{}, 1)
+		d.queues[domain] = queue
+	}
+	d.queueLock.Unlock()
+
+	select {
+	case queue <- struct{}{}:
+		return func() { <-queue }, nil
+	case <-ctx.Done():
+		return nil, &protocol.CommandError{Err: ctx.Err(), PossibleSuccess: false, PossibleTemporary: true}
+	}
+}
+
 // SessionInfoRequest returns a RoutableMesasge that initiates a handshake with a vehicle Domain.
 func SessionInfoRequest(domain universal.Domain, publicBytes []byte) *universal.RoutableMessage {
 	request := universal.RoutableMessage{
@@ -453,7 +644,7 @@ func (d *Dispatcher) Cache() []CacheEntry {
 		if session == nil {
 			continue
 		}
-		encodedInfo := session.export()
+		encodedInfo, syncedAt := session.export()
 		if encodedInfo == nil {
 			continue
 		}
@@ -461,6 +652,7 @@ func (d *Dispatcher) Cache() []CacheEntry {
 			CreatedAt:   time.Now(),
 			Domain:      int(domain),
 			SessionInfo: encodedInfo,
+			SyncedAt:    syncedAt,
 		}
 		entries = append(entries, entry)
 	}
@@ -482,11 +674,18 @@ func (d *Dispatcher) LoadCache(entries []CacheEntry) error {
 		if err != nil {
 			return fmt.Errorf("invalid cache: %s", err)
 		}
+		s.syncedAt = entry.SyncedAt
+		if s.syncedAt.IsZero() {
+			s.syncedAt = entry.CreatedAt
+		}
 		sessions[universal.Domain(entry.Domain)] = s
 	}
 
 	d.sessionLock.Lock()
-	defer d.sessionLock.Unlock()
 	d.sessions = sessions
+	d.sessionLock.Unlock()
+	for domain := range sessions {
+		d.monitor.SessionLoadedFromCache(domain)
+	}
 	return nil
 }
@@ -718,6 +718,29 @@ func TestRetrySend(t *testing.T) {
 	}
 }
 
+func TestRetrySendWithPolicy(t *testing.T) {
+	dispatcher, conn := getTestSetup(t)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx = protocol.WithRetryPolicy(ctx, protocol.RetryPolicy{MaxAttempts: 2, Retry: protocol.RetryTemporary})
+
+	for i := 0; i < 3; i++ {
+		conn.EnqueueSendError(&protocol.CommandError{Err: errTimeout, PossibleSuccess: false, PossibleTemporary: true})
+	}
+	rsp, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodNone)
+	if err == nil {
+		rsp.Close()
+	}
+	if !errors.Is(err, errTimeout) {
+		t.Errorf("Expected transmission to fail after two attempts, got %v", err)
+	}
+	if n := dispatcher.Monitor().Stats().Domains[testDomain].Retries; n != 1 {
+		t.Errorf("Expected one retry but got %d", n)
+	}
+}
+
 func TestSendTimeout(t *testing.T) {
 	dispatcher, conn := getTestSetup(t)
 	defer conn.Close()
@@ -984,3 +1007,182 @@ func TestCache(t *testing.T) {
 		t.Errorf("Timed out waiting for response")
 	}
 }
+
+func TestSessionFaultHandler(t *testing.T) {
+	dispatcher, conn := getTestSetup(t)
+	defer conn.Close()
+
+	faults := make(chan universal.Domain, 1)
+	dispatcher.OnSessionFault(func(domain universal.Domain) {
+		faults <- domain
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+
+	rsp, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodHMAC)
+	if err != nil {
+		t.Fatalf("Error sending command: %s", err)
+	}
+	defer rsp.Close()
+
+	reply := conn.SessionInfoReply(rsp, dispatcher.privateKey.PublicBytes())
+	reply.SignedMessageStatus = &universal.MessageStatus{
+		OperationStatus:    universal.OperationStatus_E_OPERATIONSTATUS_ERROR,
+		SignedMessageFault: universal.MessageFault_E_MESSAGEFAULT_ERROR_INVALID_TOKEN_OR_COUNTER,
+	}
+	conn.EnqueueReply(t, encodeRoutableMessage(t, reply))
+
+	select {
+	case domain := <-faults:
+		if domain != testDomain {
+			t.Errorf("Expected fault for %s but got %s", testDomain, domain)
+		}
+	case <-ctx.Done():
+		t.Fatal("Session fault handler wasn't called")
+	}
+
+	// Session info without a fault doesn't indicate the session was rejected.
+	rsp2, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodHMAC)
+	if err != nil {
+		t.Fatalf("Error sending command: %s", err)
+	}
+	defer rsp2.Close()
+	conn.EnqueueReply(t, encodeRoutableMessage(t, conn.SessionInfoReply(rsp2, dispatcher.privateKey.PublicBytes())))
+	select {
+	case <-rsp2.Recv():
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for reply")
+	}
+	select {
+	case domain := <-faults:
+		t.Errorf("Unexpected session fault for %s", domain)
+	default:
+	}
+
+	// Faults that come with unauthenticated session info could be spoofed.
+	rsp3, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodHMAC)
+	if err != nil {
+		t.Fatalf("Error sending command: %s", err)
+	}
+	defer rsp3.Close()
+	spoofed := conn.SessionInfoReply(rsp3, dispatcher.privateKey.PublicBytes())
+	spoofed.SubSigData = nil
+	spoofed.SignedMessageStatus = &universal.MessageStatus{
+		OperationStatus:    universal.OperationStatus_E_OPERATIONSTATUS_ERROR,
+		SignedMessageFault: universal.MessageFault_E_MESSAGEFAULT_ERROR_INVALID_TOKEN_OR_COUNTER,
+	}
+	conn.EnqueueReply(t, encodeRoutableMessage(t, spoofed))
+	select {
+	case <-rsp3.Recv():
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for reply")
+	}
+	select {
+	case domain := <-faults:
+		t.Errorf("Unauthenticated session info triggered fault for %s", domain)
+	default:
+	}
+}
+
+func TestQueueRespectsContext(t *testing.T) {
+	dispatcher, conn := getTestSetup(t)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+
+	release, err := dispatcher.enqueue(ctx, testDomain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Another command to the same domain waits for its turn...
+	shortCtx, shortCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer shortCancel()
+	if _, err := dispatcher.Send(shortCtx, testCommand(), connector.AuthMethodHMAC); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded while queued but got %v", err)
+	}
+	// ...but commands to other domains and unauthenticated commands don't.
+	if otherRelease, err := dispatcher.enqueue(ctx, testDomain+1); err != nil {
+		t.Errorf("Queue for other domain was blocked: %s", err)
+	} else {
+		otherRelease()
+	}
+	if rsp, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodNone); err != nil {
+		t.Errorf("Unauthenticated command was blocked: %s", err)
+	} else {
+		rsp.Close()
+	}
+
+	release()
+	rsp, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodHMAC)
+	if err != nil {
+		t.Fatalf("Command failed after queue was released: %s", err)
+	}
+	rsp.Close()
+}
+
+type recordingObserver struct {
+	NopObserver
+	drops chan DropReason
+}
+
+func (r *recordingObserver) MessageDropped(reason DropReason) {
+	r.drops <- reason
+}
+
+func TestMonitor(t *testing.T) {
+	dispatcher, conn := getTestSetup(t)
+	defer conn.Close()
+
+	observer := &recordingObserver{drops: make(chan DropReason, 1)}
+	dispatcher.Monitor().SetObserver(observer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+
+	if stats := dispatcher.Monitor().Stats().Domains[testDomain]; stats.Handshakes != 1 || stats.HandshakeLatency <= 0 {
+		t.Errorf("Unexpected handshake stats: %+v", stats)
+	}
+
+	// Retransmissions are counted by error class.
+	const errCount = 2
+	for i := 0; i < errCount; i++ {
+		conn.EnqueueSendError(&protocol.CommandError{Err: errTimeout, PossibleSuccess: false, PossibleTemporary: true})
+	}
+	rsp, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Close()
+	stats := dispatcher.Monitor().Stats()
+	if n := stats.Retries[protocol.ErrorClassTemporary]; n != errCount {
+		t.Errorf("Expected %d retries but got %d (%+v)", errCount, n, stats.Retries)
+	}
+	if n := stats.Domains[testDomain].Retries; n != errCount {
+		t.Errorf("Expected %d retries for %s but got %d", errCount, testDomain, n)
+	}
+
+	// Dropped messages are reported to the observer.
+	conn.EnqueueReply(t, encodeRoutableMessage(t, &universal.RoutableMessage{}))
+	select {
+	case reason := <-observer.drops:
+		if reason != DropMissingSource {
+			t.Errorf("Unexpected drop reason: %s", reason)
+		}
+	case <-ctx.Done():
+		t.Fatal("Observer wasn't notified of dropped message")
+	}
+	if n := dispatcher.Monitor().Stats().Dropped[DropMissingSource]; n != 1 {
+		t.Errorf("Expected one dropped message but got %d", n)
+	}
+
+	// Sessions loaded from a cache are counted as cache hits.
+	if err := dispatcher.LoadCache(dispatcher.Cache()); err != nil {
+		t.Fatal(err)
+	}
+	if n := dispatcher.Monitor().Stats().Domains[testDomain].CacheHits; n != 1 {
+		t.Errorf("Expected one cache hit but got %d", n)
+	}
+}
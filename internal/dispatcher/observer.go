@@ -0,0 +1,221 @@
+package dispatcher
+
+import (
+	"sync"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// DropReason identifies why a message from the vehicle was discarded.
+type DropReason int
+
+const (
+	DropUnparseable DropReason = iota
+	DropMissingSource
+	DropInvalidUUID
+	DropMissingDestination
+	DropWrongDestination // Addressed to a vehicle domain or an unrecognized destination type
+	DropInvalidAddress
+	DropNoHandler // No pending request or subscription matches the message
+	DropHandlerQueueFull
+	DropSubscriptionQueueFull // A subscriber isn't keeping up with unsolicited messages
+)
+
+var dropReasonNames = map[DropReason]string{
+	DropUnparseable:           "unparseable",
+	DropMissingSource:         "missing source",
+	DropInvalidUUID:           "invalid request UUID",
+	DropMissingDestination:    "missing destination",
+	DropWrongDestination:      "wrong destination",
+	DropInvalidAddress:        "invalid address",
+	DropNoHandler:             "no handler",
+	DropHandlerQueueFull:      "handler queue full",
+	DropSubscriptionQueueFull: "subscription queue full",
+}
+
+func (r DropReason) String() string {
+	if name, ok := dropReasonNames[r]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Observer receives notifications about communication with a vehicle, e.g. for exporting
+// metrics. Methods are called synchronously from the goroutines that send and receive messages,
+// so they must return quickly and be safe for concurrent use.
+//
+// Embed NopObserver in implementations to remain compatible with future versions of this
+// interface.
+type Observer interface {
+	// HandshakeCompleted is called when a handshake with a domain succeeds or fails.
+	HandshakeCompleted(domain universal.Domain, latency time.Duration, err error)
+	// SessionLoadedFromCache is called when a session is resumed without a handshake.
+	SessionLoadedFromCache(domain universal.Domain)
+	// Retrying is called before a message is retransmitted or a command is retried after err.
+	Retrying(domain universal.Domain, err error)
+	// MessageDropped is called when a message from the vehicle is discarded.
+	MessageDropped(reason DropReason)
+	// SessionInfoDiscarded is called when clock-synchronization info from the vehicle is
+	// discarded because it arrived more than the maximum allowed latency after the request.
+	SessionInfoDiscarded(domain universal.Domain, age time.Duration)
+	// CommandCompleted is called when a command finishes, including any retries.
+	CommandCompleted(domain universal.Domain, latency time.Duration, err error)
+}
+
+// NopObserver implements Observer by ignoring all notifications.
+type NopObserver struct{}
+
+func (NopObserver) HandshakeCompleted(universal.Domain, time.Duration, error) {}
+func (NopObserver) SessionLoadedFromCache(universal.Domain)                   {}
+func (NopObserver) Retrying(universal.Domain, error)                          {}
+func (NopObserver) MessageDropped(DropReason)                                 {}
+func (NopObserver) SessionInfoDiscarded(universal.Domain, time.Duration)      {}
+func (NopObserver) CommandCompleted(universal.Domain, time.Duration, error)   {}
+
+// DomainStats contains counters for a single vehicle domain. Latencies are cumulative; divide by
+// the corresponding count to compute an average.
+type DomainStats struct {
+	Handshakes        uint64        // Successful handshakes
+	HandshakeFailures uint64        // Failed handshakes
+	HandshakeLatency  time.Duration // Total duration of successful handshakes
+	CacheHits         uint64        // Sessions resumed from a cache instead of a handshake
+	StaleSessionInfo  uint64        // Clock-sync updates discarded for exceeding the max latency
+	Retries           uint64        // Retransmissions and command retries
+	Commands          uint64        // Commands the vehicle responded to, including nominal errors
+	CommandFailures   uint64        // Commands that failed without a response (e.g., timeouts)
+	CommandLatency    time.Duration // Total duration of responded-to commands, including retries
+	Refreshes         uint64        // Session info requests for sessions that were already established
+}
+
+// Stats is a snapshot of the counters maintained by a Monitor.
+type Stats struct {
+	Domains map[universal.Domain]DomainStats
+	Retries map[string]uint64     // Keyed by protocol.ErrorClass
+	Dropped map[DropReason]uint64 // Messages from the vehicle that were discarded
+}
+
+// Monitor implements Observer by maintaining Stats and forwarding notifications to another
+// Observer. The zero value is ready to use.
+type Monitor struct {
+	lock     sync.Mutex
+	stats    Stats
+	observer Observer
+}
+
+// SetObserver forwards future notifications to observer. Passing nil stops forwarding.
+func (m *Monitor) SetObserver(observer Observer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.observer = observer
+}
+
+// Stats returns a copy of m's counters.
+func (m *Monitor) Stats() Stats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stats := Stats{
+		Domains: make(map[universal.Domain]DomainStats, len(m.stats.Domains)),
+		Retries: make(map[string]uint64, len(m.stats.Retries)),
+		Dropped: make(map[DropReason]uint64, len(m.stats.Dropped)),
+	}
+	for k, v := range m.stats.Domains {
+		stats.Domains[k] = v
+	}
+	for k, v := range m.stats.Retries {
+		stats.Retries[k] = v
+	}
+	for k, v := range m.stats.Dropped {
+		stats.Dropped[k] = v
+	}
+	return stats
+}
+
+// update applies f to the counters for domain and returns the Observer that should be notified.
+func (m *Monitor) update(domain universal.Domain, f func(*DomainStats)) Observer {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stats.Domains == nil {
+		m.stats.Domains = make(map[universal.Domain]DomainStats)
+	}
+	stats := m.stats.Domains[domain]
+	f(&stats)
+	m.stats.Domains[domain] = stats
+	return m.observer
+}
+
+func (m *Monitor) HandshakeCompleted(domain universal.Domain, latency time.Duration, err error) {
+	observer := m.update(domain, func(s *DomainStats) {
+		if err != nil {
+			s.HandshakeFailures++
+		} else {
+			s.Handshakes++
+			s.HandshakeLatency += latency
+		}
+	})
+	if observer != nil {
+		observer.HandshakeCompleted(domain, latency, err)
+	}
+}
+
+func (m *Monitor) SessionLoadedFromCache(domain universal.Domain) {
+	if observer := m.update(domain, func(s *DomainStats) { s.CacheHits++ }); observer != nil {
+		observer.SessionLoadedFromCache(domain)
+	}
+}
+
+func (m *Monitor) Retrying(domain universal.Domain, err error) {
+	class := protocol.ErrorClass(err)
+	observer := m.update(domain, func(s *DomainStats) {
+		s.Retries++
+		if m.stats.Retries == nil {
+			m.stats.Retries = make(map[string]uint64)
+		}
+		m.stats.Retries[class]++
+	})
+	if observer != nil {
+		observer.Retrying(domain, err)
+	}
+}
+
+// sessionRefreshed counts a request for updated session info for an established session.
+func (m *Monitor) sessionRefreshed(domain universal.Domain) {
+	m.update(domain, func(s *DomainStats) { s.Refreshes++ })
+}
+
+func (m *Monitor) MessageDropped(reason DropReason) {
+	m.lock.Lock()
+	if m.stats.Dropped == nil {
+		m.stats.Dropped = make(map[DropReason]uint64)
+	}
+	m.stats.Dropped[reason]++
+	observer := m.observer
+	m.lock.Unlock()
+	if observer != nil {
+		observer.MessageDropped(reason)
+	}
+}
+
+func (m *Monitor) SessionInfoDiscarded(domain universal.Domain, age time.Duration) {
+	if observer := m.update(domain, func(s *DomainStats) { s.StaleSessionInfo++ }); observer != nil {
+		observer.SessionInfoDiscarded(domain, age)
+	}
+}
+
+func (m *Monitor) CommandCompleted(domain universal.Domain, latency time.Duration, err error) {
+	observer := m.update(domain, func(s *DomainStats) {
+		// A nominal error means the vehicle received and authenticated the command, but declined
+		// to execute it, so the round trip was successful.
+		if err != nil && !protocol.IsNominalError(err) {
+			s.CommandFailures++
+		} else {
+			s.Commands++
+			s.CommandLatency += latency
+		}
+	})
+	if observer != nil {
+		observer.CommandCompleted(domain, latency, err)
+	}
+}
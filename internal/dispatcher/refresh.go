@@ -0,0 +1,198 @@
+package dispatcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// minDriftInterval is the shortest interval over which clock drift is estimated.
+var minDriftInterval = time.Minute
+
+const (
+	// driftSmoothing is the weight given to each new clock drift sample. Samples include network
+	// latency, so a single sample isn't trusted on its own.
+	driftSmoothing = 0.25
+	// maxDriftRate caps the clock drift estimate, in seconds per second. Vehicle clocks don't
+	// drift anywhere near this fast, so larger samples are measurement errors.
+	maxDriftRate = 0.01
+)
+
+// SessionHealth describes how close a session is to being rejected by the vehicle.
+type SessionHealth struct {
+	// Age is the time since the vehicle last sent session info, either in response to a
+	// handshake or when rejecting a command. Sessions loaded from a cache may be hours old.
+	Age time.Duration
+	// ClockError is the estimated difference between the client's estimate of the vehicle's
+	// clock and the vehicle's actual clock, based on drift observed in previous updates.
+	ClockError time.Duration
+	// CounterHeadroom is the number of commands that can be authorized before the anti-replay
+	// counter overflows.
+	CounterHeadroom uint32
+}
+
+// RefreshPolicy determines when a Dispatcher requests updated session info from the vehicle before
+// using a session, instead of waiting for the vehicle to reject a command. A refresh takes one
+// round trip, but a rejected command takes the same round trip and then must be retried. Zero
+// values disable the corresponding check.
+type RefreshPolicy struct {
+	// MaxAge is the maximum SessionHealth.Age of a session used to authorize commands. Long-lived
+	// sessions are more likely to be invalidated by a vehicle reboot or, if other processes share
+	// the same key and session cache, by anti-replay counters advancing elsewhere.
+	MaxAge time.Duration
+	// MaxClockError is the maximum SessionHealth.ClockError of a session used to authorize
+	// commands. Commands are rejected if the vehicle thinks they've expired.
+	MaxClockError time.Duration
+	// MinCounterHeadroom is the minimum SessionHealth.CounterHeadroom of a session used to
+	// authorize commands. The vehicle resets counters when it starts a new epoch.
+	MinCounterHeadroom uint32
+	// BackgroundInterval controls how often the Dispatcher checks for sessions that will need to
+	// be refreshed before the next check, so that commands don't have to wait for the refresh.
+	// Background refreshes keep idle connections busy, so they're disabled by default.
+	BackgroundInterval time.Duration
+}
+
+var defaultRefreshPolicy = RefreshPolicy{
+	MaxAge:             time.Hour,
+	MaxClockError:      2 * time.Second,
+	MinCounterHeadroom: 1 << 16,
+}
+
+func (p *RefreshPolicy) needsRefresh(health SessionHealth) bool {
+	return (p.MaxAge > 0 && health.Age > p.MaxAge) ||
+		(p.MaxClockError > 0 && health.ClockError > p.MaxClockError) ||
+		health.CounterHeadroom < p.MinCounterHeadroom
+}
+
+// SetRefreshPolicy controls when d refreshes sessions. By default, sessions are refreshed
+// before use if they're more than an hour old or their estimated clock error exceeds two
+// seconds, and background refreshes are disabled.
+func (d *Dispatcher) SetRefreshPolicy(policy RefreshPolicy) {
+	d.policyLock.Lock()
+	d.refreshPolicy = policy
+	d.policyLock.Unlock()
+	select {
+	case d.policyChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Dispatcher) getRefreshPolicy() RefreshPolicy {
+	d.policyLock.Lock()
+	defer d.policyLock.Unlock()
+	return d.refreshPolicy
+}
+
+// SessionHealth returns the health of d's session with domain. Returns false if there's no
+// established session.
+func (d *Dispatcher) SessionHealth(domain universal.Domain) (SessionHealth, bool) {
+	d.sessionLock.Lock()
+	s, ok := d.sessions[domain]
+	d.sessionLock.Unlock()
+	if !ok || s == nil {
+		return SessionHealth{}, false
+	}
+	return s.health(time.Now())
+}
+
+// refreshIfNeeded refreshes s before it's used to authorize a command, if required by d's
+// RefreshPolicy. A failed refresh isn't fatal, since the session may still be valid; if it isn't,
+// the vehicle will reject the command and include updated session info in its response.
+func (d *Dispatcher) refreshIfNeeded(ctx context.Context, domain universal.Domain, s *session) error {
+	policy := d.getRefreshPolicy()
+	if health, ok := s.health(time.Now()); !ok || !policy.needsRefresh(health) {
+		return nil
+	}
+	log.Info("Refreshing %s session before use", domain)
+	if err := d.refreshSession(ctx, domain); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Warning("Failed to refresh %s session: %s", domain, err)
+	}
+	return nil
+}
+
+// refreshSession requests updated session info from domain and waits for the reply. The reply is
+// processed by checkForSessionUpdate before it's delivered.
+func (d *Dispatcher) refreshSession(ctx context.Context, domain universal.Domain) error {
+	d.monitor.sessionRefreshed(domain)
+	recv, err := d.RequestSessionInfo(ctx, domain)
+	if err != nil {
+		return err
+	}
+	defer recv.Close()
+	select {
+	case reply := <-recv.Recv():
+		return protocol.GetError(reply)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// refreshIdleSessions periodically refreshes sessions that would otherwise need to be refreshed
+// before the next check. Returns when terminate is closed.
+func (d *Dispatcher) refreshIdleSessions(terminate <-chan struct{}) {
+	for {
+		policy := d.getRefreshPolicy()
+		var timer *time.Timer
+		var tick <-chan time.Time
+		if policy.BackgroundInterval > 0 {
+			timer = time.NewTimer(policy.BackgroundInterval)
+			tick = timer.C
+		}
+		select {
+		case <-terminate:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-d.policyChanged:
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		case <-tick:
+		}
+
+		var domains []universal.Domain
+		next := time.Now().Add(policy.BackgroundInterval)
+		d.sessionLock.Lock()
+		for domain, s := range d.sessions {
+			if s == nil {
+				continue
+			}
+			if health, ok := s.health(next); ok && policy.needsRefresh(health) {
+				domains = append(domains, domain)
+			}
+		}
+		d.sessionLock.Unlock()
+		if len(domains) == 0 {
+			continue
+		}
+		log.Debug("Refreshing idle sessions")
+		d.refreshSessions(terminate, domains)
+	}
+}
+
+// refreshSessions refreshes each of the provided domains, giving up if terminate is closed.
+func (d *Dispatcher) refreshSessions(terminate <-chan struct{}, domains []universal.Domain) {
+	ctx, cancel := context.WithTimeout(context.Background(), resyncTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-terminate:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for _, domain := range domains {
+		if err := d.refreshSession(ctx, domain); err != nil {
+			log.Warning("Failed to refresh %s session: %s", domain, err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package dispatcher
+
+import (
+	"context"
+	"crypto/rand"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+)
+
+func countSessionInfoRequests(conn *dummyConnector) int {
+	conn.lock.Lock()
+	defer conn.lock.Unlock()
+	count := 0
+	for _, message := range conn.inbox {
+		if message.GetSessionInfoRequest() != nil {
+			count++
+		}
+	}
+	return count
+}
+
+// loadCachedDispatcher returns a Dispatcher that resumes a session created syncedAgo in the past.
+func loadCachedDispatcher(t *testing.T, syncedAgo time.Duration) (*Dispatcher, *dummyConnector) {
+	t.Helper()
+	original, conn := getTestSetup(t)
+	entries := original.Cache()
+	original.Stop()
+	conn.Close()
+	for i := range entries {
+		entries[i].SyncedAt = time.Now().Add(-syncedAgo)
+	}
+
+	// The vehicle's keys don't change, so it can authenticate updates to the cached session.
+	keys := conn.keys
+	conn = newDummyConnector(t)
+	conn.keys = keys
+	dispatcher, err := New(conn, original.privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dispatcher.LoadCache(entries); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+	if err := dispatcher.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return dispatcher, conn
+}
+
+func TestRefreshStaleCachedSession(t *testing.T) {
+	dispatcher, conn := loadCachedDispatcher(t, 3*time.Hour)
+	defer conn.Close()
+
+	health, ok := dispatcher.SessionHealth(testDomain)
+	if !ok || health.Age < 3*time.Hour {
+		t.Fatalf("Unexpected session health: %+v", health)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+	rsp, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Close()
+
+	if n := countSessionInfoRequests(conn); n != 1 {
+		t.Errorf("Expected session to be refreshed once, but sent %d session info requests", n)
+	}
+	if n := dispatcher.Monitor().Stats().Domains[testDomain].Refreshes; n != 1 {
+		t.Errorf("Expected one refresh but got %d", n)
+	}
+	if health, _ := dispatcher.SessionHealth(testDomain); health.Age > time.Minute {
+		t.Errorf("Session wasn't refreshed: %+v", health)
+	}
+}
+
+func TestDoNotRefreshRecentCachedSession(t *testing.T) {
+	dispatcher, conn := loadCachedDispatcher(t, time.Minute)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+	rsp, err := dispatcher.Send(ctx, testCommand(), connector.AuthMethodHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Close()
+
+	if n := countSessionInfoRequests(conn); n != 0 {
+		t.Errorf("Expected no session info requests but sent %d", n)
+	}
+}
+
+func TestBackgroundRefresh(t *testing.T) {
+	dispatcher, conn := loadCachedDispatcher(t, time.Minute)
+	defer conn.Close()
+	defer dispatcher.Stop()
+
+	dispatcher.SetRefreshPolicy(RefreshPolicy{
+		MaxAge:             2 * time.Minute,
+		BackgroundInterval: 10 * time.Millisecond,
+	})
+	if n := countSessionInfoRequests(conn); n != 0 {
+		t.Fatalf("Expected no session info requests before refresh but sent %d", n)
+	}
+	dispatcher.SetRefreshPolicy(RefreshPolicy{
+		MaxAge:             time.Minute,
+		BackgroundInterval: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+	for countSessionInfoRequests(conn) == 0 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Session wasn't refreshed in the background")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func TestClockDriftEstimate(t *testing.T) {
+	key, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSession(key, "vin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	// Short intervals are ignored.
+	s.syncedAt = now.Add(-time.Second)
+	s.observeDrift(1000, 1005, now)
+	if s.driftRate != 0 {
+		t.Errorf("Expected drift over short interval to be ignored, got %f", s.driftRate)
+	}
+
+	// Off by 11 seconds (10 after allowing for rounding) in 1000 seconds. Each sample only moves
+	// the estimate part of the way.
+	s.syncedAt = now.Add(-1000 * time.Second)
+	s.observeDrift(1011, 1000, now)
+	if s.driftRate <= 0 || s.driftRate >= 0.01 {
+		t.Errorf("Expected smoothed drift rate below 0.01, got %f", s.driftRate)
+	}
+	for i := 0; i < 100; i++ {
+		s.observeDrift(1011, 1000, now)
+	}
+	if math.Abs(s.driftRate-0.01) > 1e-6 {
+		t.Errorf("Expected drift rate to converge to 0.01, got %f", s.driftRate)
+	}
+
+	// Outliers are capped.
+	s.observeDrift(1000, 5000, now)
+	if s.driftRate > maxDriftRate {
+		t.Errorf("Expected drift rate to be capped, got %f", s.driftRate)
+	}
+
+	policy := defaultRefreshPolicy
+	if policy.needsRefresh(SessionHealth{Age: 100 * time.Second, ClockError: time.Duration(s.driftRate * float64(100*time.Second)), CounterHeadroom: 0xFFFFFFFF}) {
+		t.Error("Expected estimated clock error of 1s to be acceptable")
+	}
+	if !policy.needsRefresh(SessionHealth{Age: 300 * time.Second, ClockError: time.Duration(s.driftRate * float64(300*time.Second)), CounterHeadroom: 0xFFFFFFFF}) {
+		t.Error("Expected estimated clock error of 3s to require refresh")
+	}
+	if !policy.needsRefresh(SessionHealth{CounterHeadroom: 10}) {
+		t.Error("Expected low counter headroom to require refresh")
+	}
+}
+
+func TestClockDriftEpochChange(t *testing.T) {
+	vehicleKey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewSession(clientKey, "vin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := func(verifier *authentication.Verifier) {
+		t.Helper()
+		challenge := []byte("challenge")
+		info, tag, err := verifier.SignedSessionInfo(challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.ProcessHello(challenge, info, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	newVerifier := func() *authentication.Verifier {
+		t.Helper()
+		verifier, err := authentication.NewVerifier(vehicleKey, []byte("vin"), testDomain, clientKey.PublicBytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return verifier
+	}
+
+	hello(newVerifier())
+	// Pretend the session was established an hour ago, so that the client expects the vehicle's
+	// clock to read about an hour past its current value.
+	info, err := s.ctx.ExportSessionInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ctx, err = authentication.ImportSessionInfo(clientKey, []byte("vin"), info, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	s.syncedAt = time.Now().Add(-time.Hour)
+	s.driftRate = 0.001
+
+	// The vehicle reboots, which starts a new epoch and restarts its clock.
+	hello(newVerifier())
+	if s.driftRate != 0 {
+		t.Errorf("Expected drift estimate to be reset after epoch change, got %f", s.driftRate)
+	}
+	if health, ok := s.health(time.Now().Add(time.Minute)); !ok || health.ClockError != 0 {
+		t.Errorf("Unexpected session health after epoch change: %+v", health)
+	}
+}
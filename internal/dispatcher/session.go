@@ -1,6 +1,7 @@
 package dispatcher
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"sync"
@@ -20,6 +21,9 @@ type CacheEntry struct {
 	CreatedAt   time.Time `json:"created_at"`
 	Domain      int       `json:"domain"`
 	SessionInfo []byte    `json:"data"`
+	// SyncedAt is when the vehicle last confirmed the session info. If it's zero, CreatedAt is
+	// used instead.
+	SyncedAt time.Time `json:"synced_at"`
 }
 
 type session struct {
@@ -32,6 +36,8 @@ type session struct {
 	private     authentication.ECDHPrivateKey
 	ready       bool
 	readySignal chan struct{}
+	syncedAt    time.Time // When session info was last received from the vehicle
+	driftRate   float64   // Estimated clock drift, in seconds per second
 }
 
 // NewSession creates a new session object that can authorize commands going to
@@ -93,17 +99,52 @@ func (s *session) VehiclePublicKeyBytes() []byte {
 	return s.ctx.RemotePublicKeyBytes()
 }
 
-func (s *session) export() []byte {
+func (s *session) export() ([]byte, time.Time) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	if s.ctx == nil {
-		return nil
+		return nil, time.Time{}
 	}
 	info, err := s.ctx.ExportSessionInfo()
 	if err != nil {
-		return nil
+		return nil, time.Time{}
+	}
+	return info, s.syncedAt
+}
+
+// health estimates how close s will be to rejection by the vehicle at time t. Returns false if s
+// isn't ready to authorize commands.
+func (s *session) health(t time.Time) (SessionHealth, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx == nil || !s.ready {
+		return SessionHealth{}, false
 	}
-	return info
+	age := t.Sub(s.syncedAt)
+	return SessionHealth{
+		Age:             age,
+		ClockError:      time.Duration(s.driftRate * float64(age)),
+		CounterHeadroom: 0xFFFFFFFF - s.ctx.Counter(),
+	}, true
+}
+
+// observeDrift updates the clock drift estimate after the vehicle reports that its clock reads
+// actual at time t, when s expected it to read predicted.
+func (s *session) observeDrift(predicted, actual uint32, t time.Time) {
+	elapsed := t.Sub(s.syncedAt)
+	if elapsed < minDriftInterval {
+		// The clock has one-second resolution, so short intervals give noisy estimates.
+		return
+	}
+	offset := int64(actual) - int64(predicted)
+	if offset < 0 {
+		offset = -offset
+	}
+	if offset > 0 {
+		offset-- // Allow for rounding
+	}
+	sample := min(float64(offset)/elapsed.Seconds(), maxDriftRate)
+	s.driftRate += driftSmoothing * (sample - s.driftRate)
 }
 
 // ProcessHello verifies a session info message from the vehicle.
@@ -115,13 +156,27 @@ func (s *session) ProcessHello(challenge, info, tag []byte) error {
 	defer s.lock.Unlock()
 
 	var err error
+	now := time.Now()
 	if s.ctx == nil {
 		s.ctx, err = authentication.NewAuthenticatedSigner(s.private, s.vin, challenge, info, tag)
 		if err != nil {
 			return err
 		}
 	} else {
-		err = s.ctx.UpdateSignedSessionInfo(challenge, info, tag)
+		predicted := s.ctx.ClockTime()
+		epoch := s.ctx.Epoch()
+		if err = s.ctx.UpdateSignedSessionInfo(challenge, info, tag); err == nil {
+			if bytes.Equal(epoch, s.ctx.Epoch()) {
+				s.observeDrift(predicted, s.ctx.ClockTime(), now)
+			} else {
+				// The vehicle's clock restarted, so the prediction was based on a different clock
+				// and the previous estimate no longer applies.
+				s.driftRate = 0
+			}
+		}
+	}
+	if err == nil {
+		s.syncedAt = now
 	}
 
 	if err == nil && !s.ready {
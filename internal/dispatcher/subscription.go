@@ -0,0 +1,130 @@
+package dispatcher
+
+import (
+	"sync"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// subscription receives unsolicited messages from a vehicle domain. It implements
+// protocol.Receiver.
+type subscription struct {
+	domain     universal.Domain
+	filter     func(*universal.RoutableMessage) bool
+	ch         chan *universal.RoutableMessage
+	dispatcher *Dispatcher
+	closeOnce  sync.Once
+	// If requireSession is true, messages are only delivered once d has an authenticated session
+	// with domain.
+	requireSession bool
+}
+
+// Recv returns a channel that receives messages matching the subscription. The channel is closed
+// when the subscription is closed.
+func (s *subscription) Recv() <-chan *universal.RoutableMessage {
+	return s.ch
+}
+
+// Close stops delivery of messages to the subscription.
+func (s *subscription) Close() {
+	s.closeOnce.Do(func() {
+		s.dispatcher.unsubscribe(s)
+	})
+}
+
+// Subscribe registers interest in messages from domain that don't match a pending request, such as
+// status updates that VCSEC pushes over BLE. If filter is not nil, only messages for which it
+// returns true are delivered. The filter is called from the goroutine that receives messages from
+// the vehicle, so it must not block.
+//
+// Subscribed messages are unauthenticated: vehicles don't sign them, and the Dispatcher doesn't
+// verify them. Anyone in radio range can forge one, so callers must treat their contents as
+// advisory. To limit exposure, the Dispatcher only delivers them once it has completed a handshake
+// with domain; messages that arrive before then are dropped. Messages that contain session info or
+// a request UUID are never delivered: the Dispatcher can't verify session info that it didn't
+// request, and a message with a request UUID is a response (for example, a late reply to a request
+// that has already timed out) rather than a push.
+//
+// Every subscriber whose filter accepts a message receives it. If a subscriber's queue is full,
+// the message is dropped for that subscriber. The caller must Close the returned Receiver when
+// it's no longer needed.
+func (d *Dispatcher) Subscribe(domain universal.Domain, filter func(*universal.RoutableMessage) bool) protocol.Receiver {
+	return d.subscribe(domain, filter, true)
+}
+
+// SubscribeUnauthenticated is like Subscribe, but delivers messages even if d hasn't completed a
+// handshake with domain, for example while a client waits for its key to be paired. As with
+// Subscribe, the messages are unauthenticated and must only be used for advisory purposes, such as
+// ending a wait early.
+func (d *Dispatcher) SubscribeUnauthenticated(domain universal.Domain, filter func(*universal.RoutableMessage) bool) protocol.Receiver {
+	return d.subscribe(domain, filter, false)
+}
+
+func (d *Dispatcher) subscribe(domain universal.Domain, filter func(*universal.RoutableMessage) bool, requireSession bool) protocol.Receiver {
+	s := &subscription{
+		domain:         domain,
+		filter:         filter,
+		ch:             make(chan *universal.RoutableMessage, receiverBufferSize),
+		dispatcher:     d,
+		requireSession: requireSession,
+	}
+	d.subscriptionLock.Lock()
+	defer d.subscriptionLock.Unlock()
+	d.subscriptions = append(d.subscriptions, s)
+	return s
+}
+
+func (d *Dispatcher) unsubscribe(s *subscription) {
+	d.subscriptionLock.Lock()
+	defer d.subscriptionLock.Unlock()
+	for i, sub := range d.subscriptions {
+		if sub == s {
+			d.subscriptions = append(d.subscriptions[:i], d.subscriptions[i+1:]...)
+			break
+		}
+	}
+	// Holding subscriptionLock guarantees that process isn't sending on s.ch.
+	close(s.ch)
+}
+
+// authenticated returns true if d has completed a handshake with domain.
+func (d *Dispatcher) authenticated(domain universal.Domain) bool {
+	d.sessionLock.Lock()
+	s, ok := d.sessions[domain]
+	d.sessionLock.Unlock()
+	if !ok || s == nil {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ready
+}
+
+// publish delivers an unsolicited message from domain to subscribers. Returns false if no
+// subscriber accepted the message. Responses, which carry the UUID of the request they answer, are
+// never published.
+func (d *Dispatcher) publish(domain universal.Domain, message *universal.RoutableMessage) bool {
+	d.subscriptionLock.Lock()
+	defer d.subscriptionLock.Unlock()
+	if len(d.subscriptions) == 0 || message.GetSessionInfo() != nil || len(message.GetRequestUuid()) != 0 {
+		return false
+	}
+	authenticated := d.authenticated(domain)
+	accepted := false
+	for _, s := range d.subscriptions {
+		if s.domain != domain || (s.requireSession && !authenticated) || (s.filter != nil && !s.filter(message)) {
+			continue
+		}
+		accepted = true
+		select {
+		case s.ch <- message:
+		default:
+			log.Warning("[%02x] Dropping unsolicited message because subscription queue is full", message.GetRequestUuid())
+			d.monitor.MessageDropped(DropSubscriptionQueueFull)
+		}
+	}
+	return accepted
+}
@@ -0,0 +1,140 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/signatures"
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+func unsolicitedMessage(d *Dispatcher, domain universal.Domain, payload []byte) *universal.RoutableMessage {
+	address := make([]byte, addressLength)
+	copy(address, d.address)
+	return &universal.RoutableMessage{
+		ToDestination: &universal.Destination{
+			SubDestination: &universal.Destination_RoutingAddress{RoutingAddress: address},
+		},
+		FromDestination: &universal.Destination{
+			SubDestination: &universal.Destination_Domain{Domain: domain},
+		},
+		Uuid: testUUID(),
+		Payload: &universal.RoutableMessage_ProtobufMessageAsBytes{
+			ProtobufMessageAsBytes: payload,
+		},
+	}
+}
+
+func expectDrop(t *testing.T, drops <-chan DropReason, expected DropReason) {
+	t.Helper()
+	select {
+	case reason := <-drops:
+		if reason != expected {
+			t.Errorf("Expected drop reason %s but got %s", expected, reason)
+		}
+	case <-time.After(quiescentDelay):
+		t.Errorf("Expected message to be dropped (%s)", expected)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	dispatcher, conn := getTestSetup(t)
+	defer conn.Close()
+
+	observer := &recordingObserver{drops: make(chan DropReason, 4)}
+	dispatcher.Monitor().SetObserver(observer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+
+	sub := dispatcher.Subscribe(testDomain, func(message *universal.RoutableMessage) bool {
+		return !bytes.Equal(message.GetProtobufMessageAsBytes(), []byte("filtered"))
+	})
+	defer sub.Close()
+	other := dispatcher.Subscribe(testDomain+1, nil)
+	defer other.Close()
+
+	conn.EnqueueReply(t, encodeRoutableMessage(t, unsolicitedMessage(dispatcher, testDomain, []byte("filtered"))))
+	expectDrop(t, observer.drops, DropNoHandler)
+
+	// The vehicle can't authenticate session info that wasn't requested.
+	withSessionInfo := unsolicitedMessage(dispatcher, testDomain, testPayload)
+	withSessionInfo.Payload = &universal.RoutableMessage_SessionInfo{SessionInfo: []byte("info")}
+	withSessionInfo.SubSigData = &universal.RoutableMessage_SignatureData{
+		SignatureData: &signatures.SignatureData{},
+	}
+	conn.EnqueueReply(t, encodeRoutableMessage(t, withSessionInfo))
+	expectDrop(t, observer.drops, DropNoHandler)
+
+	// Late replies to requests whose handlers have closed aren't pushes.
+	lateReply := unsolicitedMessage(dispatcher, testDomain, testPayload)
+	lateReply.RequestUuid = testUUID()
+	conn.EnqueueReply(t, encodeRoutableMessage(t, lateReply))
+	expectDrop(t, observer.drops, DropNoHandler)
+
+	// Messages from domains without an authenticated session aren't delivered.
+	conn.EnqueueReply(t, encodeRoutableMessage(t, unsolicitedMessage(dispatcher, testDomain+1, testPayload)))
+	expectDrop(t, observer.drops, DropNoHandler)
+
+	conn.EnqueueReply(t, encodeRoutableMessage(t, unsolicitedMessage(dispatcher, testDomain, testPayload)))
+	select {
+	case message := <-sub.Recv():
+		if !bytes.Equal(message.GetProtobufMessageAsBytes(), testPayload) {
+			t.Errorf("Unexpected message: %+v", message)
+		}
+	case <-ctx.Done():
+		t.Fatal("Unsolicited message wasn't delivered")
+	}
+	select {
+	case message := <-other.Recv():
+		t.Errorf("Message delivered to wrong domain: %+v", message)
+	default:
+	}
+
+	sub.Close()
+	if _, open := <-sub.Recv(); open {
+		t.Error("Expected subscription channel to be closed")
+	}
+	conn.EnqueueReply(t, encodeRoutableMessage(t, unsolicitedMessage(dispatcher, testDomain, testPayload)))
+	expectDrop(t, observer.drops, DropNoHandler)
+}
+
+func TestSubscriptionQueueFull(t *testing.T) {
+	dispatcher, conn := getTestSetup(t)
+	defer conn.Close()
+
+	observer := &recordingObserver{drops: make(chan DropReason, 1)}
+	dispatcher.Monitor().SetObserver(observer)
+
+	sub := dispatcher.Subscribe(testDomain, nil)
+	defer sub.Close()
+	for i := 0; i <= receiverBufferSize; i++ {
+		conn.EnqueueReply(t, encodeRoutableMessage(t, unsolicitedMessage(dispatcher, testDomain, testPayload)))
+	}
+	expectDrop(t, observer.drops, DropSubscriptionQueueFull)
+	if n := len(sub.Recv()); n != receiverBufferSize {
+		t.Errorf("Expected %d queued messages but got %d", receiverBufferSize, n)
+	}
+}
+
+func TestSubscribeUnauthenticated(t *testing.T) {
+	dispatcher, conn := getTestSetup(t)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), quiescentDelay)
+	defer cancel()
+
+	sub := dispatcher.SubscribeUnauthenticated(testDomain+1, nil)
+	defer sub.Close()
+	conn.EnqueueReply(t, encodeRoutableMessage(t, unsolicitedMessage(dispatcher, testDomain+1, testPayload)))
+	select {
+	case message := <-sub.Recv():
+		if !bytes.Equal(message.GetProtobufMessageAsBytes(), testPayload) {
+			t.Errorf("Unexpected message: %+v", message)
+		}
+	case <-ctx.Done():
+		t.Fatal("Message from domain without session wasn't delivered")
+	}
+}
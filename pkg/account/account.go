@@ -18,6 +18,7 @@ import (
 	"github.com/teslamotors/vehicle-command/pkg/cache"
 	"github.com/teslamotors/vehicle-command/pkg/connector"
 	"github.com/teslamotors/vehicle-command/pkg/connector/inet"
+	"github.com/teslamotors/vehicle-command/pkg/tracing"
 	"github.com/teslamotors/vehicle-command/pkg/vehicle"
 )
 
@@ -147,14 +148,27 @@ func New(oauthToken, userAgent string) (*Account, error) {
 // sessions parameter may also be nil, but providing a cache.SessionCache avoids a round-trip
 // handshake with the Vehicle in subsequent connections.
 func (a *Account) GetVehicle(ctx context.Context, vin string, privateKey authentication.ECDHPrivateKey, sessions *cache.SessionCache) (*vehicle.Vehicle, error) {
-	conn := inet.NewConnection(vin, a.authHeader, a.Host, a.UserAgent)
+	_, span := tracing.Start(ctx, "account.GetVehicle")
+	span.SetAttribute(tracing.AttrVIN, vin)
+	conn := a.NewConnection(vin)
 	car, err := vehicle.NewVehicle(conn, privateKey, sessions)
 	if err != nil {
 		conn.Close()
 	}
+	span.End(err)
 	return car, err
 }
 
+// NewConnection returns a Fleet API connection to the vehicle with the provided vin, authorized
+// using a's credentials. Most clients should use [Account.GetVehicle] instead; this method is
+// useful for wrapping the connection (for example, with a [capture.Recorder]) before passing it to
+// [vehicle.NewVehicle].
+//
+// [capture.Recorder]: https://pkg.go.dev/github.com/teslamotors/vehicle-command/pkg/connector/capture#Recorder
+func (a *Account) NewConnection(vin string) *inet.Connection {
+	return inet.NewConnection(vin, a.authHeader, a.Host, a.UserAgent)
+}
+
 // Get sends an HTTP GET request to endpoint.
 //
 // The endpoint should contain only the path (e.g., "api/1/vehicles/foo"); the domain is determined
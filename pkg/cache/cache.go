@@ -1,25 +1,50 @@
 package cache
 
 import (
+	"container/list"
 	"encoding/json"
 	"io"
 	"os"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/teslamotors/vehicle-command/internal/dispatcher"
+	"github.com/teslamotors/vehicle-command/internal/log"
 )
 
 type SessionCache struct {
 	MaxEntries int
-	Vehicles   map[string][]dispatcher.CacheEntry `json:"vehicles"`
-	lock       sync.Mutex
+	// MaxAge limits how long a session may be reused after it was last saved to the cache. Zero
+	// means sessions don't expire.
+	MaxAge   time.Duration
+	Vehicles map[string][]dispatcher.CacheEntry `json:"vehicles"`
+	lock     sync.Mutex
+	store    SessionStore
+
+	// recent orders VINs from most to least recently used. Entries added to Vehicles directly,
+	// e.g. by Import, are indexed lazily by index.
+	recent   *list.List
+	elements map[string]*list.Element
+	// invalidated records when sessions were invalidated, so that stale copies loaded from store
+	// are discarded. A marker is kept until a newer session for the same domain replaces it.
+	invalidated map[string]map[int]time.Time
+	stats       Stats
+}
+
+// Stats contains counters that describe the effectiveness of a SessionCache.
+type Stats struct {
+	Hits          uint64 // Lookups that returned at least one session
+	Misses        uint64 // Lookups that didn't return any sessions
+	Evictions     uint64 // Vehicles removed to keep the cache under MaxEntries
+	Expirations   uint64 // Sessions discarded because they were older than MaxAge
+	Invalidations uint64 // Sessions discarded because the vehicle rejected them
 }
 
 // New returns a SessionCache with that holds session state for up to maxEntries vehicles.
 // The SessionCache uses a least-recently-used (LRU) eviction strategy, with the caveat that for
-// this purpose a session is "used" when its used to authorize a command, not when it's loaded from
-// or saved to the SessionCache.
+// this purpose a session is "used" when it's loaded to authorize commands or saved afterwards,
+// not when the SessionCache is imported or exported.
 //
 // Set maxEntries to zero for an unbounded cache.
 func New(maxEntries int) *SessionCache {
@@ -29,6 +54,19 @@ func New(maxEntries int) *SessionCache {
 	}
 }
 
+// NewWithStore returns a SessionCache that writes sessions through to store, and that consults
+// store when looking up sessions. This allows processes that share store to reuse each other's
+// sessions instead of performing their own handshakes with each vehicle. The SessionCache keeps up
+// to maxEntries vehicles in memory; store is responsible for managing its own capacity.
+//
+// Sessions in memory are kept up to date with store on each lookup, so there's no need to use
+// [SessionCache.Export] with a SessionCache created by this function.
+func NewWithStore(maxEntries int, store SessionStore) *SessionCache {
+	c := New(maxEntries)
+	c.store = store
+	return c
+}
+
 // Import a SessionCache using data in r.
 // The data should previously have been generated using [SessionCache.Export].
 func Import(r io.Reader) (*SessionCache, error) {
@@ -74,40 +112,224 @@ func (c *SessionCache) ExportToFile(filename string) error {
 // It's recommended that clients use the vehicle.UpdateCachedSessions method instead in order to
 // avoid accessing the internal dispatcher package.
 func (c *SessionCache) Update(vin string, sessions []dispatcher.CacheEntry) error {
+	sessions = c.update(vin, sessions)
+	if c.store != nil {
+		return c.store.Store(vin, sessions)
+	}
+	return nil
+}
+
+// update replaces the sessions for vin, excluding sessions that have expired or been invalidated,
+// and returns the sessions that were kept.
+func (c *SessionCache) update(vin string, sessions []dispatcher.CacheEntry) []dispatcher.CacheEntry {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	c.index()
+	sessions = c.discardExpired(c.discardInvalidated(vin, sessions))
+	if len(sessions) == 0 {
+		c.remove(vin)
+		return nil
+	}
 	c.Vehicles[vin] = sessions
-	if c.MaxEntries > 0 && len(c.Vehicles) > c.MaxEntries {
-		// TODO: Replace with a proper cache
-		oldestVIN := vin
-		oldestCreationTime := time.Now()
-		for v, sessions := range c.Vehicles {
-			// Each vehicle has multiple sessions associated with it, one for each domain. The age
-			// of cache entry is the age of its most recent session.
-			mostRecent := time.Time{}
-			for _, entry := range sessions {
-				if entry.CreatedAt.After(mostRecent) {
-					mostRecent = entry.CreatedAt
-				}
-			}
-			if mostRecent.Before(oldestCreationTime) {
-				oldestVIN = v
-				oldestCreationTime = mostRecent
-			}
-		}
-		delete(c.Vehicles, oldestVIN)
+	c.touch(vin)
+	for c.MaxEntries > 0 && len(c.Vehicles) > c.MaxEntries && c.recent.Len() > 0 {
+		c.remove(c.recent.Back().Value.(string))
+		c.stats.Evictions++
 	}
-	return nil
+	return sessions
 }
 
 // GetEntry returns the sessions associated with vin.
 // This method intended for use by the internal dispatcher package; other clients should have no
 // use for it.
 func (c *SessionCache) GetEntry(vin string) ([]dispatcher.CacheEntry, bool) {
+	if c.store != nil {
+		stored, err := c.store.Load(vin)
+		if err != nil {
+			log.Warning("Failed to load sessions from store: %s", err)
+		} else if stored != nil {
+			c.lock.Lock()
+			local := c.Vehicles[vin]
+			c.lock.Unlock()
+			c.update(vin, MergeSessions(local, stored))
+		}
+	}
+
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	session, ok := c.Vehicles[vin]
-	return session, ok
+	c.index()
+	sessions := c.Vehicles[vin]
+	if current := c.discardExpired(sessions); len(current) < len(sessions) {
+		if len(current) == 0 {
+			c.remove(vin)
+		} else {
+			c.Vehicles[vin] = current
+		}
+		sessions = current
+	}
+	if len(sessions) == 0 {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.touch(vin)
+	return sessions, true
+}
+
+// Invalidate removes the session for a vehicle domain, typically because the vehicle rejected it.
+// The domain is a universal.Domain value.
+//
+// If c was created using [NewWithStore], copies of the session that are subsequently loaded from
+// the store are discarded as well, unless they were saved after the invalidation. If the store
+// implements [SessionInvalidator], the session is also removed from the store so that other
+// processes stop using it.
+func (c *SessionCache) Invalidate(vin string, domain int) {
+	at := c.invalidate(vin, domain)
+	if invalidator, ok := c.store.(SessionInvalidator); ok {
+		if err := invalidator.Invalidate(vin, domain, at); err != nil {
+			log.Warning("Failed to invalidate session in store: %s", err)
+		}
+	}
+}
+
+func (c *SessionCache) invalidate(vin string, domain int) time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+
+	c.index()
+	sessions := c.Vehicles[vin]
+	var remaining []dispatcher.CacheEntry
+	for _, entry := range sessions {
+		if entry.Domain != domain {
+			remaining = append(remaining, entry)
+		}
+	}
+	c.stats.Invalidations += uint64(len(sessions) - len(remaining))
+	if len(remaining) == 0 {
+		c.remove(vin)
+	} else {
+		c.Vehicles[vin] = remaining
+	}
+
+	if c.store != nil {
+		if c.invalidated == nil {
+			c.invalidated = make(map[string]map[int]time.Time)
+		}
+		if c.invalidated[vin] == nil {
+			c.invalidated[vin] = make(map[int]time.Time)
+		}
+		c.invalidated[vin][domain] = now
+	}
+	return now
+}
+
+// Stats returns counters that describe how c has been used since it was created.
+func (c *SessionCache) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// discardExpired filters out sessions that are older than c.MaxAge. The caller must hold c.lock.
+func (c *SessionCache) discardExpired(sessions []dispatcher.CacheEntry) []dispatcher.CacheEntry {
+	if c.MaxAge <= 0 {
+		return sessions
+	}
+	cutoff := time.Now().Add(-c.MaxAge)
+	var current []dispatcher.CacheEntry
+	for _, entry := range sessions {
+		if entry.CreatedAt.After(cutoff) {
+			current = append(current, entry)
+		}
+	}
+	c.stats.Expirations += uint64(len(sessions) - len(current))
+	return current
+}
+
+// discardInvalidated filters out sessions that were saved before the domain was invalidated. The
+// caller must hold c.lock.
+func (c *SessionCache) discardInvalidated(vin string, sessions []dispatcher.CacheEntry) []dispatcher.CacheEntry {
+	invalidated, ok := c.invalidated[vin]
+	if !ok {
+		return sessions
+	}
+	var valid []dispatcher.CacheEntry
+	for _, entry := range sessions {
+		if at, ok := invalidated[entry.Domain]; ok {
+			if !entry.CreatedAt.After(at) {
+				continue
+			}
+			// A newer session replaces the stale one everywhere it's merged.
+			delete(invalidated, entry.Domain)
+		}
+		valid = append(valid, entry)
+	}
+	if len(invalidated) == 0 {
+		delete(c.invalidated, vin)
+	}
+	return valid
+}
+
+// touch marks vin as the most recently used vehicle. The caller must hold c.lock.
+func (c *SessionCache) touch(vin string) {
+	if element, ok := c.elements[vin]; ok {
+		c.recent.MoveToFront(element)
+	} else {
+		c.elements[vin] = c.recent.PushFront(vin)
+	}
+}
+
+// remove deletes vin from c. Invalidation markers are kept, since the store may still hold the
+// rejected sessions; they're cleared when newer sessions replace them. The caller must hold c.lock.
+func (c *SessionCache) remove(vin string) {
+	if element, ok := c.elements[vin]; ok {
+		c.recent.Remove(element)
+		delete(c.elements, vin)
+	}
+	delete(c.Vehicles, vin)
+}
+
+// index updates the LRU list to reflect vehicles that were added to or removed from c.Vehicles
+// directly. Vehicles that haven't been used since they were added are treated as less recently
+// used than all other vehicles, and ordered among themselves by the age of their newest session.
+// The caller must hold c.lock.
+func (c *SessionCache) index() {
+	if c.Vehicles == nil {
+		c.Vehicles = make(map[string][]dispatcher.CacheEntry)
+	}
+	if c.recent == nil {
+		c.recent = list.New()
+		c.elements = make(map[string]*list.Element)
+	}
+	if len(c.elements) == len(c.Vehicles) {
+		return
+	}
+	for vin, element := range c.elements {
+		if _, ok := c.Vehicles[vin]; !ok {
+			c.recent.Remove(element)
+			delete(c.elements, vin)
+		}
+	}
+	var unindexed []string
+	for vin := range c.Vehicles {
+		if _, ok := c.elements[vin]; !ok {
+			unindexed = append(unindexed, vin)
+		}
+	}
+	newest := func(vin string) time.Time {
+		var t time.Time
+		for _, entry := range c.Vehicles[vin] {
+			if entry.CreatedAt.After(t) {
+				t = entry.CreatedAt
+			}
+		}
+		return t
+	}
+	sort.Slice(unindexed, func(i, j int) bool { return newest(unindexed[i]).After(newest(unindexed[j])) })
+	for _, vin := range unindexed {
+		c.elements[vin] = c.recent.PushBack(vin)
+	}
 }
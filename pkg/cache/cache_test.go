@@ -2,6 +2,7 @@ package cache
 
 import (
 	"bytes"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
@@ -76,8 +77,6 @@ func TestImportExport(t *testing.T) {
 func TestEviction(t *testing.T) {
 	c := generateTestCache(t, 0)
 	c.MaxEntries = 5
-	// Note that generateTestSessions(n) adds an entry with timestamp n, and entries are evicted
-	// based on timestamp, not the order in which they were added to the cache.
 	c.Update("7", generateTestSessions(7))
 	c.Update("4", generateTestSessions(4))
 	c.Update("5", generateTestSessions(5))
@@ -89,11 +88,133 @@ func TestEviction(t *testing.T) {
 	c.Update("5", generateTestSessions(5))
 	verifyCache(t, c, []int{3, 4, 5, 6, 7})
 
-	// Evicts oldest entry
+	// Evicts least recently used entry
 	c.Update("8", generateTestSessions(8))
-	verifyCache(t, c, []int{4, 5, 6, 7, 8})
+	verifyCache(t, c, []int{3, 4, 5, 6, 8})
 
-	// Older entry doesn't evict newer entry
+	// Eviction depends on use, not on session timestamps
 	c.Update("1", generateTestSessions(1))
-	verifyCache(t, c, []int{4, 5, 6, 7, 8})
+	verifyCache(t, c, []int{1, 3, 5, 6, 8})
+
+	// Loading sessions counts as use
+	if _, ok := c.GetEntry("3"); !ok {
+		t.Fatal("Missing entry")
+	}
+	c.Update("9", generateTestSessions(9))
+	verifyCache(t, c, []int{1, 3, 5, 8, 9})
+
+	if stats := c.Stats(); stats.Evictions != 3 || stats.Hits != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestEvictionAfterImport(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := generateTestCache(t, 3).Export(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Import(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.MaxEntries = 3
+	// Imported entries are evicted before used ones, oldest session first.
+	c.Update("9", generateTestSessions(9))
+	verifyCache(t, c, []int{1, 2, 9})
+	c.Update("8", generateTestSessions(8))
+	verifyCache(t, c, []int{2, 8, 9})
+}
+
+func TestMaxAge(t *testing.T) {
+	c := New(0)
+	c.MaxAge = time.Hour
+	now := time.Now()
+	c.Update("vin", []dispatcher.CacheEntry{
+		{CreatedAt: now.Add(-2 * time.Hour), Domain: 2},
+		{CreatedAt: now, Domain: 3},
+	})
+	sessions, ok := c.GetEntry("vin")
+	if !ok || len(sessions) != 1 || sessions[0].Domain != 3 {
+		t.Fatalf("Expected only unexpired session, got %+v", sessions)
+	}
+
+	c.Update("old", []dispatcher.CacheEntry{{CreatedAt: now.Add(-2 * time.Hour), Domain: 2}})
+	if _, ok := c.GetEntry("old"); ok {
+		t.Error("Expected expired sessions to be discarded")
+	}
+	if _, ok := c.Vehicles["old"]; ok {
+		t.Error("Expired vehicle wasn't removed from cache")
+	}
+	if stats := c.Stats(); stats.Expirations != 2 || stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := New(0)
+	now := time.Now()
+	c.Update("vin", []dispatcher.CacheEntry{{CreatedAt: now, Domain: 2}, {CreatedAt: now, Domain: 3}})
+
+	c.Invalidate("vin", 2)
+	sessions, ok := c.GetEntry("vin")
+	if !ok || len(sessions) != 1 || sessions[0].Domain != 3 {
+		t.Fatalf("Expected only domain 3 to remain, got %+v", sessions)
+	}
+
+	c.Invalidate("vin", 3)
+	if _, ok := c.GetEntry("vin"); ok {
+		t.Error("Expected all sessions to be invalidated")
+	}
+	// Invalidating missing sessions is a no-op.
+	c.Invalidate("vin", 3)
+	c.Invalidate("other", 2)
+	if stats := c.Stats(); stats.Invalidations != 2 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestInvalidateDiscardsStaleStoredSessions(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "cache.json"))
+	c := NewWithStore(0, store)
+	stale := time.Now().Add(-time.Minute)
+	if err := c.Update("vin", []dispatcher.CacheEntry{{CreatedAt: stale, Domain: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Invalidate("vin", 2)
+	for i := 0; i < 2; i++ {
+		if _, ok := c.GetEntry("vin"); ok {
+			t.Errorf("Stale session was reloaded from store after invalidation (lookup %d)", i+1)
+		}
+	}
+	// Other processes sharing the store stop loading the rejected session.
+	if stored, err := store.Load("vin"); err != nil || len(stored) != 0 {
+		t.Errorf("Invalidated session remains in store: %+v, %v", stored, err)
+	}
+
+	// Sessions saved after the invalidation, e.g. by another process, are used.
+	if err := store.Store("vin", []dispatcher.CacheEntry{{CreatedAt: time.Now().Add(time.Second), Domain: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.GetEntry("vin"); !ok {
+		t.Error("Fresh session in store was discarded")
+	}
+}
+
+func TestUpdateDoesNotStoreExpiredSessions(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "cache.json"))
+	c := NewWithStore(0, store)
+	c.MaxAge = time.Minute
+	fresh := time.Now()
+	expired := fresh.Add(-time.Hour)
+	if err := c.Update("vin", []dispatcher.CacheEntry{{CreatedAt: expired, Domain: 2}, {CreatedAt: fresh, Domain: 3}}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Load("vin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Domain != 3 {
+		t.Errorf("Expected only the unexpired session to be stored but got %+v", stored)
+	}
 }
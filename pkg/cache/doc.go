@@ -14,6 +14,12 @@
 //
 // The same SessionCache may safely be used with different VINs.
 //
+// Processes that talk to the same vehicles, such as several replicas of a server, can share
+// sessions by creating their caches with [NewWithStore]. A [SessionStore] merges updates from all
+// processes, keeping the newest session for each vehicle domain. This package provides a
+// [FileStore] for processes on the same host and [NewKeyValueStore] for adapting external
+// key-value databases.
+//
 // If a SessionCache is exported using its [SessionCache.Export] or [SessionCache.ExportToFile]
 // methods, access controls should be used to prevent third parties from reading or tampering with
 // the data.
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/dispatcher"
+)
+
+// FileStore is a SessionStore that saves sessions in a JSON file. Multiple processes may share the
+// same file, such as several replicas of tesla-http-proxy or concurrent invocations of
+// tesla-control.
+//
+// Updates are serialized using an advisory lock on a separate file, with the same name as the
+// JSON file plus a ".lock" suffix, and written atomically by replacing the JSON file. The file
+// format is compatible with [SessionCache.ExportToFile], so existing cache files can be used
+// without conversion.
+//
+// A FileStore never evicts vehicles. Every update rewrites the entire file, so FileStores are best
+// suited to deployments that communicate with a modest number of vehicles.
+type FileStore struct {
+	filename string
+}
+
+// NewFileStore returns a SessionStore backed by filename. The file is created on the first call
+// to Store if it doesn't already exist.
+func NewFileStore(filename string) *FileStore {
+	return &FileStore{filename: filename}
+}
+
+type fileContents struct {
+	Vehicles map[string][]dispatcher.CacheEntry `json:"vehicles"`
+}
+
+func (s *FileStore) read() (*fileContents, error) {
+	contents := fileContents{Vehicles: make(map[string][]dispatcher.CacheEntry)}
+	data, err := os.ReadFile(s.filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &contents, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, err
+	}
+	if contents.Vehicles == nil {
+		contents.Vehicles = make(map[string][]dispatcher.CacheEntry)
+	}
+	return &contents, nil
+}
+
+// Load returns the sessions stored for vin. Updates replace the file atomically, so Load doesn't
+// need to acquire the lock.
+func (s *FileStore) Load(vin string) ([]dispatcher.CacheEntry, error) {
+	contents, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	return contents.Vehicles[vin], nil
+}
+
+// Store merges sessions into the file, blocking until other processes have finished updating it.
+func (s *FileStore) Store(vin string, sessions []dispatcher.CacheEntry) error {
+	return s.modify(vin, func(existing []dispatcher.CacheEntry) []dispatcher.CacheEntry {
+		return MergeSessions(existing, sessions)
+	})
+}
+
+// Invalidate removes the session for vin and domain from the file if it was created at or before
+// the time before.
+func (s *FileStore) Invalidate(vin string, domain int, before time.Time) error {
+	return s.modify(vin, func(existing []dispatcher.CacheEntry) []dispatcher.CacheEntry {
+		return discardSession(existing, domain, before)
+	})
+}
+
+// modify replaces the sessions stored for vin with the result of update, blocking until other
+// processes have finished updating the file.
+func (s *FileStore) modify(vin string, update func([]dispatcher.CacheEntry) []dispatcher.CacheEntry) error {
+	unlock, err := lockFile(s.filename + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	contents, err := s.read()
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			return err
+		}
+		// Replace corrupt files rather than failing forever.
+		contents = &fileContents{Vehicles: make(map[string][]dispatcher.CacheEntry)}
+	}
+	if sessions := update(contents.Vehicles[vin]); len(sessions) > 0 {
+		contents.Vehicles[vin] = sessions
+	} else {
+		delete(contents.Vehicles, vin)
+	}
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.filename), filepath.Base(s.filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.filename)
+}
@@ -0,0 +1,14 @@
+//go:build !unix && !windows
+
+package cache
+
+import "sync"
+
+var fileLock sync.Mutex
+
+// lockFile serializes updates within the current process. Platforms without file locking can't
+// safely share a FileStore between processes.
+func lockFile(filename string) (func(), error) {
+	fileLock.Lock()
+	return fileLock.Unlock, nil
+}
@@ -0,0 +1,159 @@
+package cache
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/dispatcher"
+)
+
+// SessionStore persists sessions outside of a SessionCache, allowing several processes to share
+// them. See [NewWithStore].
+//
+// Implementations must be safe for concurrent use and should never discard a session in favor of
+// an older one; [MergeSessions] implements the expected semantics.
+type SessionStore interface {
+	// Load returns the sessions stored for vin, or nil if there are none.
+	Load(vin string) ([]dispatcher.CacheEntry, error)
+	// Store merges sessions into the sessions stored for vin, keeping the entry with the most
+	// recent CreatedAt time for each domain.
+	Store(vin string, sessions []dispatcher.CacheEntry) error
+}
+
+// SessionInvalidator is implemented by SessionStores that can discard a session the vehicle
+// rejected, so that other processes sharing the store stop using it. [SessionCache.Invalidate]
+// uses it when available. The FileStore and the stores returned by [NewKeyValueStore] implement
+// SessionInvalidator.
+type SessionInvalidator interface {
+	// Invalidate removes the session stored for vin and domain if it was created at or before
+	// the time before. Newer sessions are kept.
+	Invalidate(vin string, domain int, before time.Time) error
+}
+
+// discardSession removes the session for domain from sessions if it was created at or before
+// the time before.
+func discardSession(sessions []dispatcher.CacheEntry, domain int, before time.Time) []dispatcher.CacheEntry {
+	var kept []dispatcher.CacheEntry
+	for _, entry := range sessions {
+		if entry.Domain == domain && !entry.CreatedAt.After(before) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept
+}
+
+// MergeSessions combines two lists of sessions for the same vehicle. The result contains one entry
+// per domain: the one with the most recent CreatedAt time. If both lists contain entries for a
+// domain with the same CreatedAt time, the entry from b is used.
+func MergeSessions(a, b []dispatcher.CacheEntry) []dispatcher.CacheEntry {
+	newest := make(map[int]dispatcher.CacheEntry)
+	for _, list := range [][]dispatcher.CacheEntry{a, b} {
+		for _, entry := range list {
+			if current, ok := newest[entry.Domain]; ok && current.CreatedAt.After(entry.CreatedAt) {
+				continue
+			}
+			newest[entry.Domain] = entry
+		}
+	}
+	merged := make([]dispatcher.CacheEntry, 0, len(newest))
+	for _, entry := range newest {
+		merged = append(merged, entry)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Domain < merged[j].Domain })
+	return merged
+}
+
+// KeyValueStore is a minimal interface to an external key-value database, such as Redis or
+// etcd. Use [NewKeyValueStore] to turn a KeyValueStore into a SessionStore.
+type KeyValueStore interface {
+	// Get returns the value associated with key. The ok return value is false if key isn't
+	// present.
+	Get(key string) (value []byte, ok bool, err error)
+	// Put associates key with value.
+	Put(key string, value []byte) error
+}
+
+// AtomicKeyValueStore is a KeyValueStore that supports atomic read-modify-write operations.
+//
+// Update calls modify with the current value of key (nil if key isn't present) and replaces the
+// value with the result. If another client changes the value in the meantime, Update must retry
+// or fail rather than overwrite the other client's changes.
+type AtomicKeyValueStore interface {
+	KeyValueStore
+	Update(key string, modify func(value []byte) ([]byte, error)) error
+}
+
+type kvStore struct {
+	kv     KeyValueStore
+	prefix string
+}
+
+// NewKeyValueStore returns a SessionStore that saves the sessions for each vehicle as a JSON value
+// under the key prefix+VIN.
+//
+// If kv implements [AtomicKeyValueStore], then concurrent updates are merged safely. Otherwise
+// Store reads, merges, and writes back the existing value in separate operations, and a session
+// saved by one client may occasionally be overwritten by a concurrent update from another. This
+// doesn't affect correctness: a client that loads an outdated session receives up-to-date
+// session info from the vehicle the first time it sends a command.
+func NewKeyValueStore(kv KeyValueStore, prefix string) SessionStore {
+	return &kvStore{kv: kv, prefix: prefix}
+}
+
+func decodeSessions(value []byte) ([]dispatcher.CacheEntry, error) {
+	if value == nil {
+		return nil, nil
+	}
+	var sessions []dispatcher.CacheEntry
+	if err := json.Unmarshal(value, &sessions); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (s *kvStore) Load(vin string) ([]dispatcher.CacheEntry, error) {
+	value, ok, err := s.kv.Get(s.prefix + vin)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return decodeSessions(value)
+}
+
+func (s *kvStore) Store(vin string, sessions []dispatcher.CacheEntry) error {
+	return s.modify(vin, func(existing []dispatcher.CacheEntry) []dispatcher.CacheEntry {
+		return MergeSessions(existing, sessions)
+	})
+}
+
+func (s *kvStore) Invalidate(vin string, domain int, before time.Time) error {
+	return s.modify(vin, func(existing []dispatcher.CacheEntry) []dispatcher.CacheEntry {
+		return discardSession(existing, domain, before)
+	})
+}
+
+// modify replaces the sessions stored for vin with the result of update.
+func (s *kvStore) modify(vin string, update func([]dispatcher.CacheEntry) []dispatcher.CacheEntry) error {
+	merge := func(value []byte) ([]byte, error) {
+		existing, err := decodeSessions(value)
+		if err != nil {
+			// Overwrite corrupt values rather than failing forever.
+			existing = nil
+		}
+		return json.Marshal(update(existing))
+	}
+
+	key := s.prefix + vin
+	if atomic, ok := s.kv.(AtomicKeyValueStore); ok {
+		return atomic.Update(key, merge)
+	}
+	value, _, err := s.kv.Get(key)
+	if err != nil {
+		return err
+	}
+	if value, err = merge(value); err != nil {
+		return err
+	}
+	return s.kv.Put(key, value)
+}
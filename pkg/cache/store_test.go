@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/dispatcher"
+)
+
+func entryAt(domain int, created int64) dispatcher.CacheEntry {
+	return dispatcher.CacheEntry{
+		CreatedAt:   time.Unix(created, 0),
+		Domain:      domain,
+		SessionInfo: []byte{byte(domain), byte(created)},
+	}
+}
+
+func checkSessions(t *testing.T, sessions []dispatcher.CacheEntry, expected ...dispatcher.CacheEntry) {
+	t.Helper()
+	if len(sessions) != len(expected) {
+		t.Fatalf("Expected %d sessions but got %d", len(expected), len(sessions))
+	}
+	for i, entry := range sessions {
+		if entry.Domain != expected[i].Domain || !entry.CreatedAt.Equal(expected[i].CreatedAt) {
+			t.Errorf("Expected session %d to be for domain %d at %s but got domain %d at %s",
+				i, expected[i].Domain, expected[i].CreatedAt, entry.Domain, entry.CreatedAt)
+		}
+	}
+}
+
+func TestMergeSessions(t *testing.T) {
+	a := []dispatcher.CacheEntry{entryAt(2, 10), entryAt(3, 20)}
+	b := []dispatcher.CacheEntry{entryAt(3, 15), entryAt(2, 30), entryAt(4, 5)}
+	checkSessions(t, MergeSessions(a, b), entryAt(2, 30), entryAt(3, 20), entryAt(4, 5))
+	checkSessions(t, MergeSessions(nil, a), a...)
+	checkSessions(t, MergeSessions(a, nil), a...)
+}
+
+func TestFileStore(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cache.json")
+	replicaA := NewFileStore(filename)
+	replicaB := NewFileStore(filename)
+
+	if sessions, err := replicaA.Load("vin"); err != nil || sessions != nil {
+		t.Fatalf("Expected no sessions in new store, got %v, %v", sessions, err)
+	}
+	if err := replicaA.Store("vin", []dispatcher.CacheEntry{entryAt(2, 10), entryAt(3, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := replicaB.Store("vin", []dispatcher.CacheEntry{entryAt(3, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	// Older sessions don't overwrite newer ones.
+	if err := replicaA.Store("vin", []dispatcher.CacheEntry{entryAt(3, 15)}); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := replicaA.Load("vin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSessions(t, sessions, entryAt(2, 10), entryAt(3, 20))
+}
+
+func TestFileStoreConcurrentProcesses(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cache.json")
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Each writer uses its own FileStore, so only the file lock serializes updates.
+			if err := NewFileStore(filename).Store(strconv.Itoa(i), []dispatcher.CacheEntry{entryAt(2, int64(i))}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	store := NewFileStore(filename)
+	for i := 0; i < writers; i++ {
+		sessions, err := store.Load(strconv.Itoa(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSessions(t, sessions, entryAt(2, int64(i)))
+	}
+}
+
+func TestFileStoreReadsExportedCache(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cache.json")
+	if err := generateTestCache(t, 2).ExportToFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := NewFileStore(filename).Load("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != testSessionCount {
+		t.Errorf("Expected %d sessions but got %d", testSessionCount, len(sessions))
+	}
+}
+
+func TestFileStoreReplacesCorruptFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "cache.json")
+	if err := os.WriteFile(filename, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := NewFileStore(filename)
+	if _, err := store.Load("vin"); err == nil {
+		t.Error("Expected error when loading corrupt file")
+	}
+	if err := store.Store("vin", []dispatcher.CacheEntry{entryAt(2, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := store.Load("vin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSessions(t, sessions, entryAt(2, 10))
+}
+
+type memoryKV struct {
+	lock   sync.Mutex
+	values map[string][]byte
+}
+
+func (m *memoryKV) Get(key string) ([]byte, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	value, ok := m.values[key]
+	return value, ok, nil
+}
+
+func (m *memoryKV) Put(key string, value []byte) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+type atomicMemoryKV struct {
+	memoryKV
+	updates int
+}
+
+func (m *atomicMemoryKV) Update(key string, modify func([]byte) ([]byte, error)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	value, err := modify(m.values[key])
+	if err != nil {
+		return err
+	}
+	m.values[key] = value
+	m.updates++
+	return nil
+}
+
+func TestKeyValueStore(t *testing.T) {
+	kv := &memoryKV{values: make(map[string][]byte)}
+	store := NewKeyValueStore(kv, "sessions/")
+	if err := store.Store("vin", []dispatcher.CacheEntry{entryAt(2, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Store("vin", []dispatcher.CacheEntry{entryAt(2, 10), entryAt(3, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := kv.values["sessions/vin"]; !ok {
+		t.Error("Expected sessions to be stored under prefixed key")
+	}
+	sessions, err := store.Load("vin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSessions(t, sessions, entryAt(2, 20), entryAt(3, 10))
+}
+
+func TestAtomicKeyValueStore(t *testing.T) {
+	kv := &atomicMemoryKV{memoryKV: memoryKV{values: make(map[string][]byte)}}
+	store := NewKeyValueStore(kv, "")
+	if err := store.Store("vin", []dispatcher.CacheEntry{entryAt(2, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	if kv.updates != 1 {
+		t.Errorf("Expected store to use atomic update")
+	}
+	sessions, err := store.Load("vin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSessions(t, sessions, entryAt(2, 20))
+}
+
+func TestStoreInvalidate(t *testing.T) {
+	stores := map[string]SessionStore{
+		"file":         NewFileStore(filepath.Join(t.TempDir(), "cache.json")),
+		"key-value":    NewKeyValueStore(&memoryKV{values: make(map[string][]byte)}, ""),
+		"atomic-value": NewKeyValueStore(&atomicMemoryKV{memoryKV: memoryKV{values: make(map[string][]byte)}}, ""),
+	}
+	for name, store := range stores {
+		if err := store.Store("vin", []dispatcher.CacheEntry{entryAt(2, 10), entryAt(3, 30)}); err != nil {
+			t.Fatal(err)
+		}
+		invalidator := store.(SessionInvalidator)
+		// Sessions newer than the invalidation are kept.
+		if err := invalidator.Invalidate("vin", 3, time.Unix(20, 0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := invalidator.Invalidate("vin", 2, time.Unix(20, 0)); err != nil {
+			t.Fatal(err)
+		}
+		sessions, err := store.Load("vin")
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		checkSessions(t, sessions, entryAt(3, 30))
+	}
+}
+
+func TestSharedStore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "cache.json"))
+	replicaA := NewWithStore(0, store)
+	replicaB := NewWithStore(0, store)
+
+	if err := replicaA.Update("vin", []dispatcher.CacheEntry{entryAt(2, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	sessions, ok := replicaB.GetEntry("vin")
+	if !ok {
+		t.Fatal("Session created by one replica not visible to another")
+	}
+	checkSessions(t, sessions, entryAt(2, 10))
+
+	// Replica B's newer session for domain 3 merges with replica A's session for domain 2.
+	if err := replicaB.Update("vin", []dispatcher.CacheEntry{entryAt(3, 20)}); err != nil {
+		t.Fatal(err)
+	}
+	sessions, ok = replicaA.GetEntry("vin")
+	if !ok {
+		t.Fatal("Missing sessions")
+	}
+	checkSessions(t, sessions, entryAt(2, 10), entryAt(3, 20))
+}
@@ -48,11 +48,12 @@ However, most [vehicle.Vehicle] commands do not work over unauthenticated connec
 package cli
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
 	"sort"
 	"strings"
@@ -60,7 +61,12 @@ import (
 	"github.com/teslamotors/vehicle-command/internal/log"
 	"github.com/teslamotors/vehicle-command/pkg/account"
 	"github.com/teslamotors/vehicle-command/pkg/cache"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
 	"github.com/teslamotors/vehicle-command/pkg/connector/ble"
+	"github.com/teslamotors/vehicle-command/pkg/connector/bridge"
+	"github.com/teslamotors/vehicle-command/pkg/connector/capture"
+	"github.com/teslamotors/vehicle-command/pkg/connector/hybrid"
+	"github.com/teslamotors/vehicle-command/pkg/connector/mux"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
 	"github.com/teslamotors/vehicle-command/pkg/vehicle"
 
@@ -114,6 +120,12 @@ const (
 	EnvTeslaKeyringPass  = "TESLA_KEYRING_PASSWORD"
 	EnvTeslaKeyringPath  = "TESLA_KEYRING_PATH"
 	EnvTeslaKeyringDebug = "TESLA_KEYRING_DEBUG"
+
+	EnvTeslaBLEBridge           = "TESLA_BLE_BRIDGE"
+	EnvTeslaBLEBridgeSecret     = "TESLA_BLE_BRIDGE_SECRET"
+	EnvTeslaBLEBridgeSecretFile = "TESLA_BLE_BRIDGE_SECRET_FILE"
+	EnvTeslaBLESocket           = "TESLA_BLE_SOCKET"
+	EnvTeslaHybrid              = "TESLA_HYBRID"
 )
 
 // Flag controls what options should be scanned from the command line and/or environment variables.
@@ -135,6 +147,8 @@ var (
 	ErrNoKeySpecified        = errors.New("private key location not provided")
 	ErrNoAvailableTransports = errors.New("no available transports (configuration must permit BLE and/or OAuth)")
 	ErrKeyNotFound           = keyring.ErrKeyNotFound
+	ErrNoBridgeSecret        = errors.New("BLE bridge secret not provided")
+	ErrCaptureUnsupported    = errors.New("recording is not supported in hybrid mode")
 )
 
 // Config fields determine how a client authenticates to vehicles and/or Tesla's backend.
@@ -150,10 +164,32 @@ type Config struct {
 	BackendType      backendType
 	Debug            bool // Enable keyring debug messages
 
+	// If BLEBridge is set (host:port), BLE connections are relayed through a tesla-ble-bridge
+	// server instead of using a local BLE radio. The bridge secret is read from
+	// BLEBridgeSecretFile or, if that's not set, the TESLA_BLE_BRIDGE_SECRET environment variable.
+	BLEBridge           string
+	BLEBridgeSecretFile string
+
+	// If BLESocket is set, BLE connections are shared through the tesla-ble-daemon listening on
+	// this Unix domain socket instead of using the BLE radio directly.
+	BLESocket string
+
+	// If Hybrid is set and both BLE and OAuth parameters are available, Connect prefers BLE and
+	// falls back to Fleet API whenever the vehicle is out of BLE range.
+	Hybrid bool
+
 	// Domains can limit a vehicle connection to relevant subsystems, which can reduce
 	// connection latency and avoid waking up the infotainment system unnecessarily.
 	Domains DomainList
 
+	// OnWake is called when Connect wakes the vehicle in order to reach infotainment. See
+	// [vehicle.Vehicle.StartSessionSleepAware].
+	OnWake func(vehicle.WakeProgress)
+
+	// If Capture is not nil, datagrams exchanged with the vehicle are recorded to Capture using the
+	// file format defined by the [capture] package.
+	Capture io.Writer
+
 	password   *string
 	sessions   *cache.SessionCache
 	acct       *account.Account
@@ -194,6 +230,12 @@ func (c *Config) RegisterCommandLineFlags() {
 		flag.StringVar(&c.KeyringTokenName, "token-name", "", "System keyring `name` for OAuth token. Defaults to $TESLA_TOKEN_NAME.")
 		flag.StringVar(&c.TokenFilename, "token-file", "", "`File` containing OAuth token. Defaults to $TESLA_TOKEN_FILE.")
 	}
+	if c.Flags.isSet(FlagBLE) {
+		flag.StringVar(&c.BLEBridge, "ble-bridge", "", "Connect to BLE through a tesla-ble-bridge at `address` (host:port). Defaults to $TESLA_BLE_BRIDGE.")
+		flag.StringVar(&c.BLEBridgeSecretFile, "ble-bridge-secret-file", "", "A `file` containing the BLE bridge secret. Defaults to $TESLA_BLE_BRIDGE_SECRET_FILE.")
+		flag.StringVar(&c.BLESocket, "ble-socket", "", "Connect to BLE through the tesla-ble-daemon listening on the Unix socket at `path`. Defaults to $TESLA_BLE_SOCKET.")
+		flag.BoolVar(&c.Hybrid, "hybrid", false, "Use BLE when the vehicle is in range and Fleet API otherwise. Defaults to $TESLA_HYBRID.")
+	}
 	if c.Flags.isSet(FlagOAuth) || c.Flags.isSet(FlagPrivateKey) {
 		var names []string
 		for _, name := range keyring.AvailableBackends() {
@@ -257,6 +299,26 @@ func (c *Config) ReadFromEnvironment() {
 			log.Debug("Set OAuth token file to '%s'", c.TokenFilename)
 		}
 	}
+	if c.Flags.isSet(FlagBLE) {
+		if c.BLEBridge == "" {
+			c.BLEBridge = os.Getenv(EnvTeslaBLEBridge)
+			log.Debug("Set BLE bridge to '%s'", c.BLEBridge)
+		}
+		if c.BLEBridgeSecretFile == "" {
+			c.BLEBridgeSecretFile = os.Getenv(EnvTeslaBLEBridgeSecretFile)
+			log.Debug("Set BLE bridge secret file to '%s'", c.BLEBridgeSecretFile)
+		}
+		if c.BLESocket == "" {
+			c.BLESocket = os.Getenv(EnvTeslaBLESocket)
+			log.Debug("Set BLE socket to '%s'", c.BLESocket)
+		}
+		if !c.Hybrid {
+			if hybrid, ok := os.LookupEnv(EnvTeslaHybrid); ok {
+				c.Hybrid = hybrid != "false" && hybrid != "0"
+				log.Debug("Set hybrid mode to %t", c.Hybrid)
+			}
+		}
+	}
 	if c.Flags.isSet(FlagOAuth) || c.Flags.isSet(FlagPrivateKey) {
 		if c.BackendType.String() == string(keyring.InvalidBackend) {
 			if err := c.BackendType.Set(os.Getenv(EnvTeslaKeyringType)); err == nil {
@@ -287,8 +349,7 @@ func (c *Config) ReadFromEnvironment() {
 // nothing.
 func (c *Config) UpdateCachedSessions(v *vehicle.Vehicle) {
 	if c.CacheFilename != "" && c.sessions != nil {
-		v.UpdateCachedSessions(c.sessions)
-		if err := c.sessions.ExportToFile(c.CacheFilename); err != nil {
+		if err := v.UpdateCachedSessions(c.sessions); err != nil {
 			log.Error("Error updating cache: %s", err)
 		}
 	}
@@ -327,6 +388,10 @@ func (c *Config) PrivateKey() (skey protocol.ECDHPrivateKey, err error) {
 // If c.TokenFilename is set, the returned account will not be nil and the vehicle will use a
 // connector.inet connection if a VIN was provided. If no token filename is set, c.VIN is required,
 // the account will be nil, and the vehicle will use a connector.ble connection.
+//
+// If a private key is available, Connect starts sessions with c.Domains. The vehicle is only woken
+// up if c.Domains explicitly includes infotainment; otherwise a sleeping vehicle's infotainment
+// system is skipped.
 func (c *Config) Connect(ctx context.Context) (acct *account.Account, car *vehicle.Vehicle, err error) {
 	if c.VIN == "" && c.KeyringTokenName == "" && c.TokenFilename == "" {
 		return nil, nil, fmt.Errorf("must provide VIN and/or OAuth token")
@@ -346,7 +411,14 @@ func (c *Config) Connect(ctx context.Context) (acct *account.Account, car *vehic
 		log.Debug("Client public key: %02x", skey.PublicBytes())
 	}
 
-	if c.Flags.isSet(FlagOAuth) && (c.KeyringTokenName != "" || c.TokenFilename != "") {
+	haveOAuth := c.Flags.isSet(FlagOAuth) && (c.KeyringTokenName != "" || c.TokenFilename != "")
+	if c.Hybrid && haveOAuth && c.Flags.isSet(FlagBLE) && c.Flags.isSet(FlagVIN) && c.VIN != "" {
+		log.Debug("Connecting over BLE with Fleet API fallback...")
+		acct, car, err = c.ConnectHybrid(ctx, skey)
+	} else if c.UsesBLERelay() && c.Flags.isSet(FlagBLE) && c.Flags.isSet(FlagVIN) && c.VIN != "" {
+		log.Debug("BLE relay configured. Connecting over BLE...")
+		car, err = c.ConnectLocal(ctx, skey)
+	} else if haveOAuth {
 		log.Debug("Required OAuth parameters supplied by CLI and/or environment. Connecting over the Internet...")
 		acct, car, err = c.ConnectRemote(ctx, skey)
 	} else if c.Flags.isSet(FlagBLE) && c.Flags.isSet(FlagVIN) {
@@ -371,7 +443,7 @@ func (c *Config) Connect(ctx context.Context) (acct *account.Account, car *vehic
 	}
 	if skey != nil {
 		log.Info("Securing connection...")
-		if err := car.StartSession(ctx, c.Domains); err != nil {
+		if err := car.StartSessionSleepAware(ctx, c.Domains, c.OnWake); err != nil {
 			return nil, nil, err
 		}
 	}
@@ -382,16 +454,10 @@ func (c *Config) loadCache() error {
 	if c.CacheFilename == "" {
 		return nil
 	}
-	log.Debug("Loading cache from %s...", c.CacheFilename)
-	var err error
-	c.sessions, err = cache.ImportFromFile(c.CacheFilename)
-	if err != nil {
-		if !errors.Is(err, fs.ErrNotExist) {
-			return fmt.Errorf("failed to load session cache: %s", err)
-		}
-		// Create a new cache if one couldn't be loaded from the file
-		c.sessions = cache.New(0)
-	}
+	log.Debug("Using session cache %s", c.CacheFilename)
+	// The file is shared with other processes, such as concurrent invocations of tesla-control,
+	// so sessions are loaded and merged into it as needed rather than imported up front.
+	c.sessions = cache.NewWithStore(0, cache.NewFileStore(c.CacheFilename))
 	return nil
 }
 
@@ -449,7 +515,11 @@ func (c *Config) ConnectRemote(ctx context.Context, skey protocol.ECDHPrivateKey
 	acct = c.acct
 
 	if c.Flags.isSet(FlagVIN) && c.VIN != "" {
-		car, err = acct.GetVehicle(ctx, c.VIN, skey, c.sessions)
+		if c.Capture == nil {
+			car, err = acct.GetVehicle(ctx, c.VIN, skey, c.sessions)
+		} else {
+			car, err = c.newVehicle(acct.NewConnection(c.VIN), skey)
+		}
 
 		if err != nil {
 			return nil, nil, fmt.Errorf("failed to initialize vehicle connection: %s", err)
@@ -458,16 +528,105 @@ func (c *Config) ConnectRemote(ctx context.Context, skey protocol.ECDHPrivateKey
 	return
 }
 
-// ConnectLocal connects to a vehicle over BLE.
+// BLEBridgeSecret returns the secret used to authenticate to the BLE bridge.
+func (c *Config) BLEBridgeSecret() ([]byte, error) {
+	if c.BLEBridgeSecretFile != "" {
+		secret, err := os.ReadFile(c.BLEBridgeSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		secret = bytes.TrimSpace(secret)
+		if len(secret) == 0 {
+			return nil, ErrNoBridgeSecret
+		}
+		return secret, nil
+	}
+	if secret := os.Getenv(EnvTeslaBLEBridgeSecret); secret != "" {
+		return []byte(secret), nil
+	}
+	return nil, ErrNoBridgeSecret
+}
+
+// UsesBLERelay returns true if BLE connections are made through a tesla-ble-bridge or
+// tesla-ble-daemon instead of a local BLE radio. When a relay is configured, Connect prefers it to
+// Fleet API unless Hybrid is set.
+func (c *Config) UsesBLERelay() bool {
+	return c.BLEBridge != "" || c.BLESocket != ""
+}
+
+// dialLocal opens a BLE connection to c.VIN. The connection is made through the BLE bridge if
+// c.BLEBridge is set, or through the local BLE daemon if c.BLESocket is set.
+func (c *Config) dialLocal(ctx context.Context) (connector.Connector, error) {
+	switch {
+	case c.BLEBridge != "":
+		secret, err := c.BLEBridgeSecret()
+		if err != nil {
+			return nil, err
+		}
+		log.Debug("Connecting to BLE bridge %s...", c.BLEBridge)
+		return bridge.Dial(ctx, c.BLEBridge, c.VIN, secret)
+	case c.BLESocket != "":
+		log.Debug("Connecting to BLE daemon at %s...", c.BLESocket)
+		return mux.Dial(ctx, c.BLESocket, c.VIN)
+	default:
+		return ble.NewConnection(ctx, c.VIN)
+	}
+}
+
+// ConnectLocal connects to a vehicle over BLE. The connection is made through the BLE bridge if
+// c.BLEBridge is set, or through the local BLE daemon if c.BLESocket is set.
 func (c *Config) ConnectLocal(ctx context.Context, skey protocol.ECDHPrivateKey) (car *vehicle.Vehicle, err error) {
-	conn, err := ble.NewConnection(ctx, c.VIN)
+	conn, err := c.dialLocal(ctx)
 	if err != nil {
 		return nil, err
 	}
+	return c.newVehicle(conn, skey)
+}
 
-	car, err = vehicle.NewVehicle(conn, skey, c.sessions)
+// ConnectHybrid connects to a vehicle over BLE when it's in range and over Fleet API otherwise,
+// switching between the two as needed. See [hybrid.Connection].
+func (c *Config) ConnectHybrid(ctx context.Context, skey protocol.ECDHPrivateKey) (acct *account.Account, car *vehicle.Vehicle, err error) {
+	if c.acct == nil {
+		c.acct, err = c.Account()
+		if err != nil {
+			return
+		}
+	}
+	acct = c.acct
+	if c.Capture != nil {
+		// Recorders don't expose the active transport, which a Vehicle needs in order to choose
+		// the right authentication method.
+		return nil, nil, ErrCaptureUnsupported
+	}
+	conn := hybrid.NewConnection(ctx, acct.NewConnection(c.VIN), c.dialLocal, hybrid.Config{})
+	car, err = c.newVehicle(conn, skey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	return
 }
+
+// newVehicle initializes a Vehicle that uses conn, recording traffic to c.Capture if it is set.
+// If initialization fails, conn is closed.
+func (c *Config) newVehicle(conn connector.Connector, skey protocol.ECDHPrivateKey) (*vehicle.Vehicle, error) {
+	if c.Capture != nil {
+		var recorder connector.Connector
+		var err error
+		if fleetAPI, ok := conn.(connector.FleetAPIConnector); ok {
+			recorder, err = capture.NewFleetAPIRecorder(fleetAPI, c.Capture)
+		} else {
+			recorder, err = capture.NewRecorder(conn, c.Capture)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = recorder
+	}
+	car, err := vehicle.NewVehicle(conn, skey, c.sessions)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return car, nil
+}
@@ -0,0 +1,257 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+var (
+	// ErrUnknownCommand indicates that the registry doesn't contain a command.
+	ErrUnknownCommand = errors.New("unrecognized command")
+	// ErrInvalidTime indicates that a time of day couldn't be parsed.
+	ErrInvalidTime = errors.New("invalid time")
+)
+
+// ParamError indicates that a parameter is missing or has an invalid value.
+type ParamError struct {
+	Param   string
+	Missing bool
+	Err     error
+}
+
+func (e *ParamError) Error() string {
+	if e.Missing {
+		return fmt.Sprintf("missing %s param", e.Param)
+	}
+	if e.Err != nil {
+		return fmt.Sprintf("invalid %s param: %s", e.Param, e.Err)
+	}
+	return fmt.Sprintf("invalid %s param", e.Param)
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// Handler executes a command. Most handlers return a nil result, but handlers that create a
+// resource on the vehicle, such as a charging schedule, return its identifier.
+type Handler func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error)
+
+// Command describes a vehicle command.
+type Command struct {
+	// Name identifies the command in tesla-control (e.g., "charging-set-limit").
+	Name string
+	// Endpoint identifies the command in the HTTP proxy's REST API (e.g., "set_charge_limit").
+	Endpoint string
+	Help     string
+	// Domain is the only domain used by the command, or protocol.DomainNone if the command may
+	// use either domain.
+	Domain protocol.Domain
+	// RequiresAuth is true if the command must be authorized by a private key enrolled on the
+	// vehicle.
+	RequiresAuth bool
+	// Params lists the command's parameters. Required parameters precede optional ones, which
+	// allows them to be passed positionally on the command line.
+	Params  []Param
+	Handler Handler
+}
+
+// ParseStrings converts command-line arguments, keyed by parameter name, into Args. An optional
+// parameter may be set to "-" to omit it while providing subsequent parameters.
+func (c *Command) ParseStrings(values map[string]string) (Args, error) {
+	args := make(Args)
+	for i := range c.Params {
+		p := &c.Params[i]
+		text, ok := values[p.Name]
+		if !ok || (text == omitted && !p.Required) {
+			continue
+		}
+		value, err := p.parseString(text)
+		if err != nil {
+			return nil, err
+		}
+		args[p.Name] = value
+	}
+	return args, c.applyDefaults(args)
+}
+
+// ParseJSON converts a decoded JSON object into Args. Parameters may be encoded as JSON values of
+// the appropriate type, or as strings that use the same syntax as command-line arguments. Keys
+// that don't correspond to a parameter are ignored.
+func (c *Command) ParseJSON(values map[string]interface{}) (Args, error) {
+	args := make(Args)
+	for i := range c.Params {
+		p := &c.Params[i]
+		raw, ok := values[p.Name]
+		if !ok || raw == nil {
+			continue
+		}
+		value, err := p.parseJSON(raw)
+		if err != nil {
+			return nil, err
+		}
+		args[p.Name] = value
+	}
+	return args, c.applyDefaults(args)
+}
+
+func (c *Command) applyDefaults(args Args) error {
+	for i := range c.Params {
+		p := &c.Params[i]
+		if _, ok := args[p.Name]; ok {
+			continue
+		}
+		if p.Required {
+			return &ParamError{Param: p.Name, Missing: true}
+		}
+		if p.Default != "" {
+			value, err := p.parseString(p.Default)
+			if err != nil {
+				return err
+			}
+			args[p.Name] = value
+		}
+	}
+	return nil
+}
+
+// Args holds parsed parameter values. Accessors return the zero value for parameters that were
+// omitted; use Has to distinguish omitted parameters from zero values.
+type Args map[string]interface{}
+
+// Has returns true if the named parameter was provided or has a default value.
+func (a Args) Has(name string) bool {
+	_, ok := a[name]
+	return ok
+}
+
+// Bool returns the value of a TypeBool parameter.
+func (a Args) Bool(name string) bool {
+	value, _ := a[name].(bool)
+	return value
+}
+
+// Int returns the value of a TypeInt, TypeEnum, or TypeDays parameter.
+func (a Args) Int(name string) int64 {
+	value, _ := a[name].(int64)
+	return value
+}
+
+// Float returns the value of a TypeFloat or TypeTemperature parameter.
+func (a Args) Float(name string) float64 {
+	value, _ := a[name].(float64)
+	return value
+}
+
+// String returns the value of a TypeString parameter.
+func (a Args) String(name string) string {
+	value, _ := a[name].(string)
+	return value
+}
+
+// Duration returns the value of a TypeDuration or TypeTimeOfDay parameter.
+func (a Args) Duration(name string) time.Duration {
+	value, _ := a[name].(time.Duration)
+	return value
+}
+
+var byName, byEndpoint = index(registry)
+
+func index(commands []*Command) (map[string]*Command, map[string]*Command) {
+	names := make(map[string]*Command)
+	endpoints := make(map[string]*Command)
+	for _, c := range commands {
+		names[c.Name] = c
+		endpoints[c.Endpoint] = c
+	}
+	return names, endpoints
+}
+
+// Lookup returns the command with the given tesla-control name.
+func Lookup(name string) (*Command, bool) {
+	c, ok := byName[name]
+	return c, ok
+}
+
+// LookupEndpoint returns the command with the given REST API endpoint name.
+func LookupEndpoint(endpoint string) (*Command, bool) {
+	c, ok := byEndpoint[endpoint]
+	return c, ok
+}
+
+// All returns every command in the registry, sorted by name.
+func All() []*Command {
+	commands := make([]*Command, len(registry))
+	copy(commands, registry)
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
+}
+
+// action adapts a Vehicle method that doesn't take any arguments.
+func action(method func(*vehicle.Vehicle, context.Context) error) Handler {
+	return func(ctx context.Context, car *vehicle.Vehicle, _ Args) (interface{}, error) {
+		return nil, method(car, ctx)
+	}
+}
+
+// ParseDays converts a comma-separated list of day names, such as "Mon,Wed" or "weekdays", into a
+// bitmask with Sunday as the least-significant bit.
+func ParseDays(days string) (int32, error) {
+	var mask int32
+	for _, d := range strings.Split(days, ",") {
+		if v, ok := dayNamesBitMask[strings.TrimSpace(strings.ToUpper(d))]; ok {
+			mask |= v
+		} else {
+			return 0, fmt.Errorf("unrecognized day name: %v", d)
+		}
+	}
+	return mask, nil
+}
+
+// MinutesAfterMidnight converts a 24-hour "HH:MM" time of day into minutes after midnight.
+func MinutesAfterMidnight(hoursAndMinutes string) (int32, error) {
+	components := strings.Split(hoursAndMinutes, ":")
+	if len(components) != 2 {
+		return 0, fmt.Errorf("%w: expected HH:MM", ErrInvalidTime)
+	}
+	hours, err := strconv.Atoi(components[0])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTime, err)
+	}
+	minutes, err := strconv.Atoi(components[1])
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidTime, err)
+	}
+
+	if hours > 23 || hours < 0 || minutes > 59 || minutes < 0 {
+		return 0, fmt.Errorf("%w: hours or minutes outside valid range", ErrInvalidTime)
+	}
+	return int32(60*hours + minutes), nil
+}
+
+var dayNamesBitMask = map[string]int32{
+	"SUN":       1,
+	"SUNDAY":    1,
+	"MON":       2,
+	"MONDAY":    2,
+	"TUES":      4,
+	"TUESDAY":   4,
+	"WED":       8,
+	"WEDNESDAY": 8,
+	"THURS":     16,
+	"THURSDAY":  16,
+	"FRI":       32,
+	"FRIDAY":    32,
+	"SAT":       64,
+	"SATURDAY":  64,
+	"ALL":       127,
+	"WEEKDAYS":  62,
+}
@@ -0,0 +1,255 @@
+package command_test
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/command"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+func TestRegistry(t *testing.T) {
+	names := make(map[string]bool)
+	endpoints := make(map[string]bool)
+	for _, c := range command.All() {
+		if names[c.Name] {
+			t.Errorf("duplicate command name %s", c.Name)
+		}
+		if endpoints[c.Endpoint] {
+			t.Errorf("duplicate endpoint %s", c.Endpoint)
+		}
+		names[c.Name] = true
+		endpoints[c.Endpoint] = true
+
+		if c.Handler == nil {
+			t.Errorf("%s has no handler", c.Name)
+		}
+		if c.Help == "" {
+			t.Errorf("%s has no help text", c.Name)
+		}
+		if c.Domain == protocol.DomainNone && c.RequiresAuth {
+			t.Errorf("%s requires authentication but doesn't specify a domain", c.Name)
+		}
+		if found, ok := command.Lookup(c.Name); !ok || found != c {
+			t.Errorf("Lookup(%s) failed", c.Name)
+		}
+		if found, ok := command.LookupEndpoint(c.Endpoint); !ok || found != c {
+			t.Errorf("LookupEndpoint(%s) failed", c.Endpoint)
+		}
+
+		optional := false
+		for _, p := range c.Params {
+			if p.Required && optional {
+				t.Errorf("%s: required param %s follows an optional param", c.Name, p.Name)
+			}
+			optional = !p.Required
+			if p.Type == command.TypeEnum && len(p.Choices()) == 0 {
+				t.Errorf("%s: enum param %s has no values", c.Name, p.Name)
+			}
+		}
+		// Make sure defaults are valid.
+		values := make(map[string]string)
+		for _, p := range c.Params {
+			if p.Required {
+				values[p.Name] = validExample(p)
+			}
+		}
+		if _, err := c.ParseStrings(values); err != nil {
+			t.Errorf("%s: %s", c.Name, err)
+		}
+	}
+	if _, ok := command.Lookup("not-a-command"); ok {
+		t.Error("found nonexistent command")
+	}
+}
+
+func validExample(p command.Param) string {
+	switch p.Type {
+	case command.TypeBool:
+		return "on"
+	case command.TypeInt, command.TypeFloat, command.TypeTemperature:
+		return strconv.FormatFloat(p.Min, 'f', -1, 64)
+	case command.TypeEnum:
+		return p.Choices()[0]
+	case command.TypeDays:
+		return "all"
+	case command.TypeTimeOfDay:
+		return "12:00"
+	case command.TypeDuration:
+		return "1h"
+	}
+	return "example"
+}
+
+func TestParseStrings(t *testing.T) {
+	heater, ok := command.Lookup("seat-heater")
+	if !ok {
+		t.Fatal("seat-heater not found")
+	}
+	args, err := heater.ParseStrings(map[string]string{"seat_position": "2nd-row-LEFT", "level": "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Int("seat_position") != 2 || args.Int("level") != 2 {
+		t.Errorf("unexpected args: %v", args)
+	}
+
+	var paramErr *command.ParamError
+	_, err = heater.ParseStrings(map[string]string{"seat_position": "roof", "level": "low"})
+	if !errors.As(err, &paramErr) || paramErr.Param != "seat_position" || paramErr.Missing {
+		t.Errorf("expected invalid seat_position, got %v", err)
+	}
+	_, err = heater.ParseStrings(map[string]string{"seat_position": "front-left"})
+	if !errors.As(err, &paramErr) || paramErr.Param != "level" || !paramErr.Missing {
+		t.Errorf("expected missing level, got %v", err)
+	}
+
+	temp, _ := command.Lookup("climate-set-temp")
+	args, err = temp.ParseStrings(map[string]string{"driver_temp": "212F"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(args.Float("driver_temp")-100) > 1e-9 || args.Has("passenger_temp") {
+		t.Errorf("unexpected args: %v", args)
+	}
+	if _, err = temp.ParseStrings(map[string]string{"driver_temp": "hot"}); !errors.As(err, &paramErr) {
+		t.Errorf("expected invalid driver_temp, got %v", err)
+	}
+
+	schedule, _ := command.Lookup("charging-schedule-add")
+	args, err = schedule.ParseStrings(map[string]string{
+		"days_of_week": "weekdays",
+		"lat":          "37.5",
+		"lon":          "-122",
+		"start_time":   "-",
+		"end_time":     "6:30",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Int("days_of_week") != 62 {
+		t.Errorf("unexpected days_of_week: %d", args.Int("days_of_week"))
+	}
+	if args.Has("start_time") || args.Duration("end_time") != 6*time.Hour+30*time.Minute {
+		t.Errorf("unexpected times: %v", args)
+	}
+	if !args.Bool("enabled") {
+		t.Error("expected enabled to default to true")
+	}
+	_, err = schedule.ParseStrings(map[string]string{"days_of_week": "all", "lat": "91", "lon": "0"})
+	if !errors.As(err, &paramErr) || paramErr.Param != "lat" {
+		t.Errorf("expected out-of-range lat, got %v", err)
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	volume, _ := command.Lookup("media-set-volume")
+	args, err := volume.ParseJSON(map[string]interface{}{"volume": 5.5, "extra": "ignored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Float("volume") != 5.5 {
+		t.Errorf("unexpected volume: %v", args.Float("volume"))
+	}
+	_, err = volume.ParseJSON(nil)
+	if err == nil || err.Error() != "missing volume param" {
+		t.Errorf("expected missing volume param, got %v", err)
+	}
+	_, err = volume.ParseJSON(map[string]interface{}{"volume": true})
+	if err == nil || err.Error() != "invalid volume param" {
+		t.Errorf("expected invalid volume param, got %v", err)
+	}
+
+	cooler, _ := command.LookupEndpoint("remote_seat_cooler_request")
+	args, err = cooler.ParseJSON(map[string]interface{}{"seat_position": 1.0, "seat_cooler_level": 4.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Int("seat_position") != 1 || args.Int("seat_cooler_level") != 4 {
+		t.Errorf("unexpected args: %v", args)
+	}
+	if _, err = cooler.ParseJSON(map[string]interface{}{"seat_position": 0.0, "seat_cooler_level": 1.0}); err == nil {
+		t.Error("expected error for unknown seat position")
+	}
+
+	amps, _ := command.LookupEndpoint("set_charging_amps")
+	if _, err = amps.ParseJSON(map[string]interface{}{"charging_amps": 16.5}); err == nil {
+		t.Error("expected error for non-integer charging_amps")
+	}
+	args, err = amps.ParseJSON(map[string]interface{}{"charging_amps": "32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Int("charging_amps") != 32 {
+		t.Errorf("unexpected charging_amps: %d", args.Int("charging_amps"))
+	}
+
+	update, _ := command.LookupEndpoint("schedule_software_update")
+	args, err = update.ParseJSON(map[string]interface{}{"offset_sec": 90.0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.Duration("offset_sec") != 90*time.Second {
+		t.Errorf("unexpected offset_sec: %s", args.Duration("offset_sec"))
+	}
+}
+
+func TestMinutesAfterMidnight(t *testing.T) {
+	type params struct {
+		str     string
+		minutes int32
+		err     error
+	}
+	testCases := []params{
+		{str: "3:03", minutes: 183},
+		{str: "0:00", minutes: 0},
+		{str: "", err: command.ErrInvalidTime},
+		{str: "3:", err: command.ErrInvalidTime},
+		{str: ":40", err: command.ErrInvalidTime},
+		{str: "3:40pm", err: command.ErrInvalidTime},
+		{str: "25:40", err: command.ErrInvalidTime},
+		{str: "23:40", minutes: 23*60 + 40},
+		{str: "23:60", err: command.ErrInvalidTime},
+		{str: "23:-01", err: command.ErrInvalidTime},
+		{str: "24:00", err: command.ErrInvalidTime},
+		{str: "-2:00", err: command.ErrInvalidTime},
+	}
+	for _, test := range testCases {
+		minutes, err := command.MinutesAfterMidnight(test.str)
+		if !errors.Is(err, test.err) {
+			t.Errorf("expected '%s' to result in error %s, but got %s", test.str, test.err, err)
+		} else if test.minutes != minutes {
+			t.Errorf("expected MinutesAfterMidnight('%s') = %d, but got %d", test.str, test.minutes, minutes)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	type params struct {
+		str   string
+		mask  int32
+		isErr bool
+	}
+	testCases := []params{
+		{str: "SUN", mask: 1},
+		{str: "SUN, WED", mask: 1 + 8},
+		{str: "SUN, WEDnesday", mask: 1 + 8},
+		{str: "sUN,wEd", mask: 1 + 8},
+		{str: "all", mask: 127},
+		{str: "sun,all", mask: 127},
+		{str: "mon,tues,wed,thurs", mask: 2 + 4 + 8 + 16},
+		{str: "marketday", isErr: true},
+		{str: "sun mon", isErr: true},
+	}
+	for _, test := range testCases {
+		mask, err := command.ParseDays(test.str)
+		if (err != nil) != test.isErr {
+			t.Errorf("day string '%s' gave unexpected err = %s", test.str, err)
+		} else if mask != test.mask {
+			t.Errorf("day string '%s' gave mask %s instead of %s", test.str, strconv.FormatInt(int64(mask), 2), strconv.FormatInt(int64(test.mask), 2))
+		}
+	}
+}
@@ -0,0 +1,23 @@
+/*
+Package command defines the vehicle commands supported by tesla-control and the HTTP proxy.
+
+Each [Command] declares its parameters, the domain it uses, and whether it requires a private key
+enrolled on the vehicle. Parameters can be parsed from command-line strings ([Command.ParseStrings])
+or from a JSON request body ([Command.ParseJSON]); both produce the same [Args], so a command has
+the same semantics regardless of how it's invoked:
+
+	cmd, ok := command.Lookup("charging-set-limit")
+	if !ok {
+		return command.ErrUnknownCommand
+	}
+	args, err := cmd.ParseStrings(map[string]string{"percent": "80"})
+	if err != nil {
+		return err
+	}
+	_, err = cmd.Handler(ctx, car, args)
+
+Commands that are part of the [Fleet API] use the Fleet API's endpoint name and parameter names.
+
+[Fleet API]: https://developer.tesla.com/docs/fleet-api/endpoints/vehicle-commands
+*/
+package command
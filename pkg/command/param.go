@@ -0,0 +1,185 @@
+package command
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// omitted is the command-line value used to skip an optional parameter.
+const omitted = "-"
+
+// ParamType determines how a parameter is parsed and which Args accessor returns its value.
+type ParamType int
+
+const (
+	// TypeString parameters are passed through unmodified.
+	TypeString ParamType = iota
+	// TypeBool parameters are JSON booleans or strings such as "on", "off", "true", or "false".
+	TypeBool
+	// TypeInt parameters are integers.
+	TypeInt
+	// TypeFloat parameters are decimal numbers.
+	TypeFloat
+	// TypeEnum parameters are one of Param.Values, given by name or by index.
+	TypeEnum
+	// TypeDays parameters are comma-separated day names (see ParseDays), or a bitmask.
+	TypeDays
+	// TypeTimeOfDay parameters are a 24-hour "HH:MM" time, or a number of minutes after midnight.
+	TypeTimeOfDay
+	// TypeDuration parameters are a duration such as "2h30m", or a number of seconds.
+	TypeDuration
+	// TypeTemperature parameters are in Celsius unless given as a string with an "F" suffix, such
+	// as "70F".
+	TypeTemperature
+)
+
+// Param describes a command parameter.
+type Param struct {
+	// Name is the JSON key used by the REST API. The command-line help uses the uppercase name.
+	Name     string
+	Type     ParamType
+	Help     string
+	Required bool
+	// Default is used when an optional parameter is omitted. It has the same syntax as a
+	// command-line argument. An empty Default leaves the parameter unset.
+	Default string
+	// Values names the values of a TypeEnum parameter. A name's index is its numeric value. Empty
+	// names are placeholders for invalid values.
+	Values []string
+	// Min and Max restrict the values of numeric parameters if Max is greater than Min.
+	Min, Max float64
+}
+
+// Choices returns the valid names of a TypeEnum parameter.
+func (p *Param) Choices() []string {
+	var choices []string
+	for _, name := range p.Values {
+		if name != "" {
+			choices = append(choices, name)
+		}
+	}
+	return choices
+}
+
+func (p *Param) invalid(err error) error {
+	return &ParamError{Param: p.Name, Err: err}
+}
+
+func (p *Param) invalidChoice() error {
+	return p.invalid(fmt.Errorf("expected one of %s", strings.Join(p.Choices(), ", ")))
+}
+
+func (p *Param) checkRange(value float64) error {
+	if p.Max > p.Min && (value < p.Min || value > p.Max) {
+		return p.invalid(fmt.Errorf("must be between %g and %g", p.Min, p.Max))
+	}
+	return nil
+}
+
+func (p *Param) parseJSON(raw interface{}) (interface{}, error) {
+	switch value := raw.(type) {
+	case string:
+		return p.parseString(value)
+	case bool:
+		if p.Type == TypeBool {
+			return value, nil
+		}
+	case float64:
+		return p.parseNumber(value)
+	}
+	return nil, p.invalid(nil)
+}
+
+func (p *Param) parseNumber(value float64) (interface{}, error) {
+	integral := value == math.Trunc(value)
+	switch p.Type {
+	case TypeInt:
+		if !integral {
+			return nil, p.invalid(fmt.Errorf("expected an integer"))
+		}
+		return int64(value), p.checkRange(value)
+	case TypeFloat:
+		return value, p.checkRange(value)
+	case TypeTemperature:
+		return value, p.checkRange(value)
+	case TypeEnum:
+		if !integral || value < 0 || int(value) >= len(p.Values) || p.Values[int(value)] == "" {
+			return nil, p.invalidChoice()
+		}
+		return int64(value), nil
+	case TypeDays:
+		if !integral || value < 0 || value > float64(dayNamesBitMask["ALL"]) {
+			return nil, p.invalid(fmt.Errorf("invalid day mask"))
+		}
+		return int64(value), nil
+	case TypeTimeOfDay:
+		// Validation is left to the vehicle for consistency with the Fleet API.
+		return time.Duration(value) * time.Minute, nil
+	case TypeDuration:
+		return time.Duration(value * float64(time.Second)), nil
+	}
+	return nil, p.invalid(nil)
+}
+
+func (p *Param) parseString(text string) (interface{}, error) {
+	switch p.Type {
+	case TypeString:
+		return text, nil
+	case TypeBool:
+		switch strings.ToLower(text) {
+		case "on", "true", "yes", "1":
+			return true, nil
+		case "off", "false", "no", "0":
+			return false, nil
+		}
+		return nil, p.invalid(fmt.Errorf("expected 'on' or 'off'"))
+	case TypeEnum:
+		for i, name := range p.Values {
+			if name != "" && strings.EqualFold(name, text) {
+				return int64(i), nil
+			}
+		}
+	case TypeDays:
+		mask, err := ParseDays(text)
+		if err != nil {
+			return nil, p.invalid(err)
+		}
+		return int64(mask), nil
+	case TypeTimeOfDay:
+		if strings.Contains(text, ":") {
+			minutes, err := MinutesAfterMidnight(text)
+			if err != nil {
+				return nil, p.invalid(err)
+			}
+			return time.Duration(minutes) * time.Minute, nil
+		}
+	case TypeDuration:
+		if duration, err := time.ParseDuration(text); err == nil {
+			return duration, nil
+		}
+	case TypeTemperature:
+		unit := strings.ToUpper(text[len(text)-min(len(text), 1):])
+		if unit == "C" || unit == "F" {
+			text = text[:len(text)-1]
+		}
+		degrees, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, p.invalid(fmt.Errorf("format as 22C or 72F"))
+		}
+		if unit == "F" {
+			degrees = (degrees - 32.0) * 5.0 / 9.0
+		}
+		return degrees, p.checkRange(degrees)
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		if p.Type == TypeEnum {
+			return nil, p.invalidChoice()
+		}
+		return nil, p.invalid(err)
+	}
+	return p.parseNumber(value)
+}
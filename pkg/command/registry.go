@@ -0,0 +1,645 @@
+package command
+
+import (
+	"context"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+// Seat positions are numbered as in the Fleet API's remote_seat_heater_request endpoint.
+var seatPositions = []vehicle.SeatPosition{
+	vehicle.SeatFrontLeft,
+	vehicle.SeatFrontRight,
+	vehicle.SeatSecondRowLeft,
+	vehicle.SeatSecondRowLeftBack,
+	vehicle.SeatSecondRowCenter,
+	vehicle.SeatSecondRowRight,
+	vehicle.SeatSecondRowRightBack,
+	vehicle.SeatThirdRowLeft,
+	vehicle.SeatThirdRowRight,
+}
+
+var (
+	seatNames = []string{
+		"front-left", "front-right", "2nd-row-left", "2nd-row-left-back", "2nd-row-center",
+		"2nd-row-right", "2nd-row-right-back", "3rd-row-left", "3rd-row-right",
+	}
+	// frontSeatNames are numbered as in carserver.HvacSeatCoolerActions_HvacSeatCoolerPosition_E
+	// and carserver.AutoSeatClimateAction_AutoSeatPosition_E.
+	frontSeatNames = []string{"", "front-left", "front-right"}
+	frontSeats     = []vehicle.SeatPosition{vehicle.SeatUnknown, vehicle.SeatFrontLeft, vehicle.SeatFrontRight}
+	levelNames     = []string{"off", "low", "medium", "high"}
+)
+
+var (
+	dayParams = []Param{
+		{Name: "days_of_week", Type: TypeDays, Required: true, Help: "Comma-separated list of any of Sun, Mon, Tues, Wed, Thurs, Fri, Sat OR all OR weekdays"},
+		{Name: "lat", Type: TypeFloat, Required: true, Min: -90, Max: 90, Help: "Latitude of location"},
+		{Name: "lon", Type: TypeFloat, Required: true, Min: -180, Max: 180, Help: "Longitude of location"},
+	}
+	scheduleParams = []Param{
+		{Name: "one_time", Type: TypeBool, Help: "'on' to run once instead of repeating weekly"},
+		{Name: "id", Type: TypeInt, Min: 0, Max: 1 << 53, Help: "ID of the schedule to modify. Omit for new schedules."},
+		{Name: "enabled", Type: TypeBool, Default: "on", Help: "Whether the schedule is enabled. Defaults to 'on'."},
+	}
+	removeScheduleParams = []Param{
+		{Name: "type", Type: TypeEnum, Values: []string{"id", "home", "work", "other"}, Default: "id", Help: "Remove schedules at home, work, or other locations, or the schedule with ID. Defaults to id."},
+		{Name: "id", Type: TypeInt, Min: 0, Max: 1 << 53, Help: "ID of schedule to remove when TYPE is id"},
+	}
+	onParam = Param{Name: "on", Type: TypeBool, Required: true, Help: "'on' or 'off'"}
+)
+
+func params(groups ...[]Param) []Param {
+	var all []Param
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+// scheduleID returns the ID of the schedule described by args, or a new ID based on the current
+// time if args doesn't specify one.
+func scheduleID(args Args) uint64 {
+	if id := uint64(args.Int("id")); id != 0 {
+		return id
+	}
+	return uint64(time.Now().Unix())
+}
+
+// removeSchedules removes the schedules selected by removeScheduleParams.
+func removeSchedules(args Args, byID func(id uint64) error, batch func(home, work, other bool) error) error {
+	switch removeScheduleParams[0].Values[args.Int("type")] {
+	case "home":
+		return batch(true, false, false)
+	case "work":
+		return batch(false, true, false)
+	case "other":
+		return batch(false, false, true)
+	}
+	if !args.Has("id") {
+		return &ParamError{Param: "id", Missing: true}
+	}
+	return byID(uint64(args.Int("id")))
+}
+
+func chargingPolicy(args Args, enabledKey, weekdaysOnlyKey string) vehicle.ChargingPolicy {
+	if args.Bool(weekdaysOnlyKey) {
+		return vehicle.ChargingPolicyWeekdays
+	}
+	if args.Bool(enabledKey) {
+		return vehicle.ChargingPolicyAllDays
+	}
+	return vehicle.ChargingPolicyOff
+}
+
+var registry = []*Command{
+	// Security
+	{
+		Name: "unlock", Endpoint: "door_unlock", Help: "Unlock vehicle",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).Unlock),
+	},
+	{
+		Name: "lock", Endpoint: "door_lock", Help: "Lock vehicle",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).Lock),
+	},
+	{
+		Name: "drive", Endpoint: "remote_start_drive", Help: "Remote start vehicle",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).RemoteDrive),
+	},
+	{
+		Name: "autosecure-modelx", Endpoint: "auto_secure_vehicle", Help: "Close falcon-wing doors and lock vehicle. Model X only.",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).AutoSecureVehicle),
+	},
+	{
+		Name: "wake", Endpoint: "wake_up", Help: "Wake up vehicle",
+		Handler: action((*vehicle.Vehicle).Wakeup),
+	},
+	{
+		Name: "sentry-mode", Endpoint: "set_sentry_mode", Help: "Set sentry mode to ON ('on' or 'off')",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{onParam},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetSentryMode(ctx, args.Bool("on"))
+		},
+	},
+	{
+		Name: "guest-mode", Endpoint: "guest_mode", Help: "Enable or disable Guest Mode",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "enable", Type: TypeBool, Required: true, Help: "'on' or 'off'"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetGuestMode(ctx, args.Bool("enable"))
+		},
+	},
+	{
+		Name: "erase-guest-data", Endpoint: "erase_user_data", Help: "Erase Guest Mode user data",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).EraseGuestData),
+	},
+	{
+		Name: "pin-to-drive", Endpoint: "set_pin_to_drive", Help: "Enable or disable PIN to Drive",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			onParam,
+			{Name: "password", Type: TypeString, Help: "Four-digit PIN"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetPINToDrive(ctx, args.Bool("on"), args.String("password"))
+		},
+	},
+	{
+		Name: "pin-to-drive-reset", Endpoint: "reset_pin_to_drive_pin", Help: "Remove PIN to Drive",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ResetPIN),
+	},
+	{
+		Name: "valet-mode", Endpoint: "set_valet_mode", Help: "Enable or disable Valet Mode",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			onParam,
+			{Name: "password", Type: TypeString, Help: "Four-digit PIN"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetValetMode(ctx, args.Bool("on"), args.String("password"))
+		},
+	},
+	{
+		Name: "valet-mode-reset-pin", Endpoint: "reset_valet_pin", Help: "Clear Valet Mode PIN",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ResetValetPin),
+	},
+	{
+		Name: "speed-limit-activate", Endpoint: "speed_limit_activate", Help: "Activate Speed Limit Mode",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "pin", Type: TypeString, Required: true, Help: "Four-digit PIN"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.ActivateSpeedLimit(ctx, args.String("pin"))
+		},
+	},
+	{
+		Name: "speed-limit-deactivate", Endpoint: "speed_limit_deactivate", Help: "Deactivate Speed Limit Mode",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "pin", Type: TypeString, Required: true, Help: "Four-digit PIN"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.DeactivateSpeedLimit(ctx, args.String("pin"))
+		},
+	},
+	{
+		Name: "speed-limit-clear-pin", Endpoint: "speed_limit_clear_pin", Help: "Clear Speed Limit Mode PIN",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "pin", Type: TypeString, Required: true, Help: "Four-digit PIN"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.ClearSpeedLimitPIN(ctx, args.String("pin"))
+		},
+	},
+	{
+		Name: "speed-limit-set", Endpoint: "speed_limit_set_limit", Help: "Set Speed Limit Mode's limit",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "limit_mph", Type: TypeFloat, Required: true, Help: "Speed limit in MPH"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SpeedLimitSetLimitMPH(ctx, args.Float("limit_mph"))
+		},
+	},
+	{
+		Name: "homelink", Endpoint: "trigger_homelink", Help: "Trigger HomeLink at LAT LON",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			{Name: "lat", Type: TypeFloat, Required: true, Min: -90, Max: 90, Help: "Latitude of vehicle"},
+			{Name: "lon", Type: TypeFloat, Required: true, Min: -180, Max: 180, Help: "Longitude of vehicle"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.TriggerHomelink(ctx, float32(args.Float("lat")), float32(args.Float("lon")))
+		},
+	},
+	{
+		Name: "vehicle-name", Endpoint: "set_vehicle_name", Help: "Set vehicle name",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "vehicle_name", Type: TypeString, Required: true, Help: "New name"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetVehicleName(ctx, args.String("vehicle_name"))
+		},
+	},
+
+	// Closures
+	{
+		Name: "actuate-trunk", Endpoint: "actuate_trunk", Help: "Open the frunk or trunk",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Params: []Param{{Name: "which_trunk", Type: TypeEnum, Values: []string{"rear", "front"}, Default: "rear", Help: "Trunk to open. Defaults to rear."}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			if args.Int("which_trunk") == 1 {
+				return nil, car.OpenFrunk(ctx)
+			}
+			return nil, car.OpenTrunk(ctx)
+		},
+	},
+	{
+		Name: "trunk-open", Endpoint: "open_trunk", Help: "Open vehicle trunk. Note that trunk-close only works on certain vehicle types.",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).OpenTrunk),
+	},
+	{
+		Name: "trunk-move", Endpoint: "move_trunk", Help: "Toggle trunk open/closed. Closing is only available on certain vehicle types.",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ActuateTrunk),
+	},
+	{
+		Name: "trunk-close", Endpoint: "close_trunk", Help: "Closes vehicle trunk. Only available on certain vehicle types.",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).CloseTrunk),
+	},
+	{
+		Name: "frunk-open", Endpoint: "open_frunk", Help: "Open vehicle frunk. Note that there's no frunk-close command!",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).OpenFrunk),
+	},
+	{
+		Name: "tonneau-open", Endpoint: "open_tonneau", Help: "Open Cybertruck tonneau.",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).OpenTonneau),
+	},
+	{
+		Name: "tonneau-close", Endpoint: "close_tonneau", Help: "Close Cybertruck tonneau.",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).CloseTonneau),
+	},
+	{
+		Name: "tonneau-stop", Endpoint: "stop_tonneau", Help: "Stop moving Cybertruck tonneau.",
+		Domain: protocol.DomainVCSEC, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).StopTonneau),
+	},
+	{
+		Name: "windows", Endpoint: "window_control", Help: "Vent or close all windows",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "command", Type: TypeEnum, Values: []string{"vent", "close"}, Required: true, Help: "Window action"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			if args.Int("command") == 1 {
+				return nil, car.CloseWindows(ctx)
+			}
+			return nil, car.VentWindows(ctx)
+		},
+	},
+	{
+		Name: "windows-vent", Endpoint: "vent_windows", Help: "Vent all windows",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).VentWindows),
+	},
+	{
+		Name: "windows-close", Endpoint: "close_windows", Help: "Close all windows",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).CloseWindows),
+	},
+	{
+		Name: "sunroof-set-level", Endpoint: "set_sunroof_level", Help: "Move sunroof to LEVEL",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "level", Type: TypeInt, Required: true, Min: 0, Max: 100, Help: "Percent open (0-100)"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.ChangeSunroofState(ctx, int32(args.Int("level")))
+		},
+	},
+	{
+		Name: "charge-port-open", Endpoint: "charge_port_door_open", Help: "Open charge port",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ChargePortOpen),
+	},
+	{
+		Name: "charge-port-close", Endpoint: "charge_port_door_close", Help: "Close charge port",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ChargePortClose),
+	},
+
+	// Alerts
+	{
+		Name: "honk", Endpoint: "honk_horn", Help: "Honk horn",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).HonkHorn),
+	},
+	{
+		Name: "flash-lights", Endpoint: "flash_lights", Help: "Flash lights",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).FlashLights),
+	},
+	{
+		Name: "ping", Endpoint: "ping", Help: "Ping vehicle",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).Ping),
+	},
+
+	// Climate
+	{
+		Name: "climate-on", Endpoint: "auto_conditioning_start", Help: "Turn on climate control",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ClimateOn),
+	},
+	{
+		Name: "climate-off", Endpoint: "auto_conditioning_stop", Help: "Turn off climate control",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ClimateOff),
+	},
+	{
+		Name: "climate-set-temp", Endpoint: "set_temps", Help: "Set temperature",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			{Name: "driver_temp", Type: TypeTemperature, Required: true, Help: "Desired temperature (e.g., 70f or 21c; defaults to Celsius)"},
+			{Name: "passenger_temp", Type: TypeTemperature, Help: "Passenger temperature, if different from driver"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			driver := float32(args.Float("driver_temp"))
+			passenger := driver
+			if args.Has("passenger_temp") {
+				passenger = float32(args.Float("passenger_temp"))
+			}
+			return nil, car.ChangeClimateTemp(ctx, driver, passenger)
+		},
+	},
+	{
+		Name: "seat-heater", Endpoint: "remote_seat_heater_request", Help: "Set seat heater at SEAT_POSITION to LEVEL",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			{Name: "seat_position", Type: TypeEnum, Values: seatNames, Required: true, Help: "Seat to heat"},
+			{Name: "level", Type: TypeEnum, Values: levelNames, Required: true, Help: "Heater level"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			spec := map[vehicle.SeatPosition]vehicle.Level{
+				seatPositions[args.Int("seat_position")]: vehicle.Level(args.Int("level")),
+			}
+			return nil, car.SetSeatHeater(ctx, spec)
+		},
+	},
+	{
+		Name: "seat-cooler", Endpoint: "remote_seat_cooler_request", Help: "Set seat cooler at SEAT_POSITION to SEAT_COOLER_LEVEL",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			{Name: "seat_position", Type: TypeEnum, Values: frontSeatNames, Required: true, Help: "Seat to cool"},
+			{Name: "seat_cooler_level", Type: TypeEnum, Values: append([]string{""}, levelNames...), Required: true, Help: "Cooler level"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			// The REST API numbers levels starting at one.
+			level := vehicle.Level(args.Int("seat_cooler_level") - 1)
+			return nil, car.SetSeatCooler(ctx, level, frontSeats[args.Int("seat_position")])
+		},
+	},
+	{
+		Name: "auto-seat-and-climate", Endpoint: "remote_auto_seat_climate_request", Help: "Turn on automatic seat heating and HVAC",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			{Name: "auto_seat_position", Type: TypeEnum, Values: frontSeatNames, Required: true, Help: "Seat to heat or cool automatically"},
+			{Name: "auto_climate_on", Type: TypeBool, Default: "on", Help: "'on' (default) or 'off'"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			positions := []vehicle.SeatPosition{frontSeats[args.Int("auto_seat_position")]}
+			return nil, car.AutoSeatAndClimate(ctx, positions, args.Bool("auto_climate_on"))
+		},
+	},
+	{
+		Name: "steering-wheel-heater", Endpoint: "remote_steering_wheel_heater_request", Help: "Set steering wheel heater to ON ('on' or 'off')",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{onParam},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetSteeringWheelHeater(ctx, args.Bool("on"))
+		},
+	},
+	{
+		Name: "bioweapon-mode", Endpoint: "set_bioweapon_mode", Help: "Set Bioweapon Defense Mode to ON ('on' or 'off')",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			onParam,
+			{Name: "manual_override", Type: TypeBool, Help: "'on' to override automatic climate control"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetBioweaponDefenseMode(ctx, args.Bool("on"), args.Bool("manual_override"))
+		},
+	},
+	{
+		Name: "cabin-overheat-protection", Endpoint: "set_cabin_overheat_protection", Help: "Set Cabin Overheat Protection to ON ('on' or 'off')",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			onParam,
+			{Name: "fan_only", Type: TypeBool, Help: "'on' to use the fan without air conditioning"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetCabinOverheatProtection(ctx, args.Bool("on"), args.Bool("fan_only"))
+		},
+	},
+	{
+		Name: "cabin-overheat-protection-temp", Endpoint: "set_cop_temp", Help: "Set the temperature at which Cabin Overheat Protection activates",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "cop_temp", Type: TypeEnum, Values: []string{"", "low", "medium", "high"}, Required: true, Help: "Activation temperature"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetCabinOverheatProtectionTemperature(ctx, vehicle.Level(args.Int("cop_temp")))
+		},
+	},
+	{
+		Name: "climate-keeper", Endpoint: "set_climate_keeper_mode", Help: "Set Climate Keeper mode",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			{Name: "climate_keeper_mode", Type: TypeEnum, Values: []string{"off", "on", "dog", "camp"}, Required: true, Help: "Climate Keeper mode"},
+			{Name: "manual_override", Type: TypeBool, Help: "'on' to override automatic climate control"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			mode := vehicle.ClimateKeeperMode(args.Int("climate_keeper_mode"))
+			return nil, car.SetClimateKeeperMode(ctx, mode, args.Bool("manual_override"))
+		},
+	},
+	{
+		Name: "preconditioning-max", Endpoint: "set_preconditioning_max", Help: "Set max defrost to ON ('on' or 'off')",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			onParam,
+			{Name: "manual_override", Type: TypeBool, Help: "'on' to override automatic climate control"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetPreconditioningMax(ctx, args.Bool("on"), args.Bool("manual_override"))
+		},
+	},
+
+	// Charging
+	{
+		Name: "charging-start", Endpoint: "charge_start", Help: "Start charging",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ChargeStart),
+	},
+	{
+		Name: "charging-stop", Endpoint: "charge_stop", Help: "Stop charging",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ChargeStop),
+	},
+	{
+		Name: "charging-max-range", Endpoint: "charge_max_range", Help: "Set charge limit to max range",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ChargeMaxRange),
+	},
+	{
+		Name: "charging-standard-range", Endpoint: "charge_standard", Help: "Set charge limit to standard range",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ChargeStandardRange),
+	},
+	{
+		Name: "charging-set-limit", Endpoint: "set_charge_limit", Help: "Set charge limit to PERCENT",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "percent", Type: TypeInt, Required: true, Min: 0, Max: 100, Help: "Charging limit"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.ChangeChargeLimit(ctx, int32(args.Int("percent")))
+		},
+	},
+	{
+		Name: "charging-set-amps", Endpoint: "set_charging_amps", Help: "Set charge current to CHARGING_AMPS",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "charging_amps", Type: TypeInt, Required: true, Min: 0, Max: 1 << 16, Help: "Charging current"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetChargingAmps(ctx, int32(args.Int("charging_amps")))
+		},
+	},
+	{
+		Name: "charging-schedule", Endpoint: "set_scheduled_charging", Help: "Enable or disable daily scheduled charging at TIME",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			{Name: "enable", Type: TypeBool, Required: true, Help: "'on' or 'off'"},
+			{Name: "time", Type: TypeTimeOfDay, Help: "Time to start charging (e.g., 22:00, or minutes after midnight)"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.ScheduleCharging(ctx, args.Bool("enable"), args.Duration("time"))
+		},
+	},
+	{
+		Name: "charging-schedule-cancel", Endpoint: "cancel_scheduled_charging", Help: "Cancel scheduled charge start",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.ScheduleCharging(ctx, false, 0)
+		},
+	},
+	{
+		Name: "scheduled-departure", Endpoint: "set_scheduled_departure", Help: "Enable or disable charging and preconditioning for a DEPARTURE_TIME",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{
+			{Name: "enable", Type: TypeBool, Required: true, Help: "'on' or 'off'"},
+			{Name: "departure_time", Type: TypeTimeOfDay, Help: "Departure time (e.g., 7:30)"},
+			{Name: "end_off_peak_time", Type: TypeTimeOfDay, Help: "End of off-peak electricity rates"},
+			{Name: "preconditioning_enabled", Type: TypeBool, Help: "Precondition before departure"},
+			{Name: "preconditioning_weekdays_only", Type: TypeBool, Help: "Only precondition on weekdays"},
+			{Name: "off_peak_charging_enabled", Type: TypeBool, Help: "Charge during off-peak hours"},
+			{Name: "off_peak_charging_weekdays_only", Type: TypeBool, Help: "Only charge during off-peak hours on weekdays"},
+		},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			if !args.Bool("enable") {
+				return nil, car.ClearScheduledDeparture(ctx)
+			}
+			offPeakPolicy := chargingPolicy(args, "off_peak_charging_enabled", "off_peak_charging_weekdays_only")
+			preconditionPolicy := chargingPolicy(args, "preconditioning_enabled", "preconditioning_weekdays_only")
+			return nil, car.ScheduleDeparture(ctx, args.Duration("departure_time"), args.Duration("end_off_peak_time"), preconditionPolicy, offPeakPolicy)
+		},
+	},
+	{
+		Name: "charging-schedule-add", Endpoint: "add_charge_schedule", Help: "Schedule charge for DAYS_OF_WEEK between START_TIME and END_TIME at LAT LON. Prints the schedule ID.",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: params(dayParams, []Param{
+			{Name: "start_time", Type: TypeTimeOfDay, Help: "Time to start charging (24-hour clock, e.g. 22:00)"},
+			{Name: "end_time", Type: TypeTimeOfDay, Help: "Time to stop charging. May be on the following day."},
+		}, scheduleParams, []Param{
+			{Name: "start_enabled", Type: TypeBool, Help: "Defaults to 'on' if START_TIME is set"},
+			{Name: "end_enabled", Type: TypeBool, Help: "Defaults to 'on' if END_TIME is set"},
+		}),
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			schedule := vehicle.ChargeSchedule{
+				Id:           scheduleID(args),
+				DaysOfWeek:   int32(args.Int("days_of_week")),
+				Latitude:     float32(args.Float("lat")),
+				Longitude:    float32(args.Float("lon")),
+				StartTime:    int32(args.Duration("start_time") / time.Minute),
+				EndTime:      int32(args.Duration("end_time") / time.Minute),
+				StartEnabled: args.Has("start_time"),
+				EndEnabled:   args.Has("end_time"),
+				OneTime:      args.Bool("one_time"),
+				Enabled:      args.Bool("enabled"),
+			}
+			if args.Has("start_enabled") {
+				schedule.StartEnabled = args.Bool("start_enabled")
+			}
+			if args.Has("end_enabled") {
+				schedule.EndEnabled = args.Bool("end_enabled")
+			}
+			if err := car.AddChargeSchedule(ctx, &schedule); err != nil {
+				return nil, err
+			}
+			return schedule.Id, nil
+		},
+	},
+	{
+		Name: "charging-schedule-remove", Endpoint: "remove_charge_schedule", Help: "Removes charging schedule of TYPE [ID]",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: removeScheduleParams,
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, removeSchedules(args,
+				func(id uint64) error { return car.RemoveChargeSchedule(ctx, id) },
+				func(home, work, other bool) error { return car.BatchRemoveChargeSchedules(ctx, home, work, other) })
+		},
+	},
+	{
+		Name: "precondition-schedule-add", Endpoint: "add_precondition_schedule", Help: "Schedule precondition for DAYS_OF_WEEK PRECONDITION_TIME at LAT LON. Prints the schedule ID.",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: params(dayParams[:1], []Param{
+			{Name: "precondition_time", Type: TypeTimeOfDay, Required: true, Help: "Time to precondition by (24-hour clock, e.g. 22:00)"},
+		}, dayParams[1:], scheduleParams),
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			schedule := vehicle.PreconditionSchedule{
+				Id:               scheduleID(args),
+				DaysOfWeek:       int32(args.Int("days_of_week")),
+				Latitude:         float32(args.Float("lat")),
+				Longitude:        float32(args.Float("lon")),
+				PreconditionTime: int32(args.Duration("precondition_time") / time.Minute),
+				OneTime:          args.Bool("one_time"),
+				Enabled:          args.Bool("enabled"),
+			}
+			if err := car.AddPreconditionSchedule(ctx, &schedule); err != nil {
+				return nil, err
+			}
+			return schedule.Id, nil
+		},
+	},
+	{
+		Name: "precondition-schedule-remove", Endpoint: "remove_precondition_schedule", Help: "Removes precondition schedule of TYPE [ID]",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: removeScheduleParams,
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, removeSchedules(args,
+				func(id uint64) error { return car.RemovePreconditionSchedule(ctx, id) },
+				func(home, work, other bool) error {
+					return car.BatchRemovePreconditionSchedules(ctx, home, work, other)
+				})
+		},
+	},
+
+	// Media
+	{
+		Name: "media-set-volume", Endpoint: "adjust_volume", Help: "Set volume",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "volume", Type: TypeFloat, Required: true, Min: 0, Max: 10, Help: "Set volume (0.0-10.0)"}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.SetVolume(ctx, float32(args.Float("volume")))
+		},
+	},
+	{
+		Name: "media-toggle-playback", Endpoint: "media_toggle_playback", Help: "Toggle between play/pause",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).ToggleMediaPlayback),
+	},
+
+	// Software updates
+	{
+		Name: "software-update-start", Endpoint: "schedule_software_update", Help: "Start software update after OFFSET_SEC",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Params: []Param{{Name: "offset_sec", Type: TypeDuration, Required: true, Help: "Time to wait before starting update. Examples: 2h, 10m, 30 (seconds)."}},
+		Handler: func(ctx context.Context, car *vehicle.Vehicle, args Args) (interface{}, error) {
+			return nil, car.ScheduleSoftwareUpdate(ctx, args.Duration("offset_sec"))
+		},
+	},
+	{
+		Name: "software-update-cancel", Endpoint: "cancel_software_update", Help: "Cancel a pending software update",
+		Domain: protocol.DomainInfotainment, RequiresAuth: true,
+		Handler: action((*vehicle.Vehicle).CancelSoftwareUpdate),
+	},
+}
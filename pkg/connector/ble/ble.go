@@ -4,13 +4,10 @@ package ble
 
 import (
 	"context"
-	"crypto/sha1"
-	"fmt"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/go-ble/ble"
 	"github.com/teslamotors/vehicle-command/internal/log"
 	"github.com/teslamotors/vehicle-command/pkg/connector"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
@@ -20,31 +17,39 @@ const maxBLEMessageSize = 1024
 
 var ErrMaxConnectionsExceeded = protocol.NewError("the vehicle is already connected to the maximum number of BLE devices", false, false)
 
+// ErrReconnecting indicates the BLE link to the vehicle was lost and the Connection is trying to
+// re-establish it. The error is temporary, so clients may retry.
+var ErrReconnecting = protocol.NewError("BLE link lost, reconnecting", false, true)
+
 var (
 	rxTimeout  = time.Second     // Timeout interval between receiving chunks of a mesasge
 	maxLatency = 4 * time.Second // Max allowed error when syncing vehicle clock
 )
 
 var (
-	vehicleServiceUUID = ble.MustParse("00000211-b2d1-43f0-9b88-960cebf8b91e")
-	toVehicleUUID      = ble.MustParse("00000212-b2d1-43f0-9b88-960cebf8b91e")
-	fromVehicleUUID    = ble.MustParse("00000213-b2d1-43f0-9b88-960cebf8b91e")
-)
-
-var (
-	device ble.Device
-	mu     sync.Mutex
+	reconnectTimeout    = 20 * time.Second // Time limit for each reconnect attempt
+	minReconnectBackoff = time.Second      // Delay before the first reconnect attempt
+	maxReconnectBackoff = 30 * time.Second // Maximum delay between reconnect attempts
 )
 
+// Connection implements connector.ReconnectingConnector. If the BLE link drops, the Connection
+// scans for the vehicle in the background, with exponential backoff, until it's back in range.
+// Send returns ErrReconnecting in the meantime.
 type Connection struct {
 	vin         string
+	dial        gattDialer
 	inbox       chan []byte
-	txChar      *ble.Characteristic
-	rxChar      *ble.Characteristic
+	reconnected chan struct{}
+	done        chan struct{}
+	lock        sync.Mutex // Serializes Send
+
+	rxLock      sync.Mutex
 	inputBuffer []byte
-	client      ble.Client
 	lastRx      time.Time
-	lock        sync.Mutex
+
+	linkLock sync.Mutex
+	client   gattClient
+	closed   bool
 }
 
 func (c *Connection) PreferredAuthMethod() connector.AuthMethod {
@@ -59,6 +64,12 @@ func (c *Connection) Receive() <-chan []byte {
 	return c.inbox
 }
 
+// Reconnected returns a channel that receives a value whenever the Connection re-establishes a
+// lost BLE link.
+func (c *Connection) Reconnected() <-chan struct{} {
+	return c.reconnected
+}
+
 func (c *Connection) flush() bool {
 	if len(c.inputBuffer) >= 2 {
 		msgLength := 256*int(c.inputBuffer[0]) + int(c.inputBuffer[1])
@@ -82,8 +93,17 @@ func (c *Connection) flush() bool {
 }
 
 func (c *Connection) Close() {
-	c.client.ClearSubscriptions()
-	c.client.CancelConnection()
+	c.linkLock.Lock()
+	defer c.linkLock.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	close(c.done)
+	if c.client != nil {
+		c.client.Close()
+		c.client = nil
+	}
 }
 
 func (c *Connection) AllowedLatency() time.Duration {
@@ -91,6 +111,8 @@ func (c *Connection) AllowedLatency() time.Duration {
 }
 
 func (c *Connection) rx(p []byte) {
+	c.rxLock.Lock()
+	defer c.rxLock.Unlock()
 	if time.Since(c.lastRx) > rxTimeout {
 		c.inputBuffer = []byte{}
 	}
@@ -104,6 +126,16 @@ func (c *Connection) Send(ctx context.Context, buffer []byte) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	c.linkLock.Lock()
+	client, closed := c.client, c.closed
+	c.linkLock.Unlock()
+	if closed {
+		return protocol.ErrNotConnected
+	}
+	if client == nil {
+		return ErrReconnecting
+	}
+
 	var out []byte
 	log.Debug("TX: %02x", buffer)
 	out = append(out, uint8(len(buffer)>>8), uint8(len(buffer)))
@@ -113,8 +145,14 @@ func (c *Connection) Send(ctx context.Context, buffer []byte) error {
 		if blockLength > len(out) {
 			blockLength = len(out)
 		}
-		if err := c.client.WriteCharacteristic(c.txChar, out[:blockLength], false); err != nil {
-			return err
+		if err := client.Write(out[:blockLength]); err != nil {
+			select {
+			case <-client.Disconnected():
+				// The vehicle discards partial messages, so it's safe to retry.
+				return ErrReconnecting
+			default:
+				return err
+			}
 		}
 		out = out[blockLength:]
 	}
@@ -126,10 +164,23 @@ func (c *Connection) VIN() string {
 }
 
 func NewConnection(ctx context.Context, vin string) (*Connection, error) {
+	return newConnection(ctx, vin, dialBLE)
+}
+
+func newConnection(ctx context.Context, vin string, dial gattDialer) (*Connection, error) {
+	conn := &Connection{
+		vin:         vin,
+		dial:        dial,
+		inbox:       make(chan []byte, 5),
+		reconnected: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
 	var lastError error
 	for {
-		conn, err := tryToConnect(ctx, vin)
+		client, err := dial(ctx, vin, conn.rx)
 		if err == nil {
+			conn.client = client
+			go conn.maintainLink(client)
 			return conn, nil
 		}
 		if strings.Contains(err.Error(), "operation not permitted") {
@@ -146,84 +197,78 @@ func NewConnection(ctx context.Context, vin string) (*Connection, error) {
 	}
 }
 
-func tryToConnect(ctx context.Context, vin string) (*Connection, error) {
-	var err error
-	// We don't want concurrent calls to NewConnection that would defeat
-	// the point of reusing the existing BLE device. Note that this is not
-	// an issue on MacOS, but multiple calls to newDevice() on Linux leads to failures.
-	mu.Lock()
-	defer mu.Unlock()
-
-	if device != nil {
-		log.Debug("Reusing existing BLE device")
-	} else {
-		log.Debug("Creating new BLE device")
-		device, err = newDevice()
-		if err != nil {
-			return nil, fmt.Errorf("failed to find a BLE device: %s", err)
+// maintainLink waits for client to disconnect and then re-establishes the link, repeating until
+// c is closed.
+func (c *Connection) maintainLink(client gattClient) {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-client.Disconnected():
 		}
-		ble.SetDefaultDevice(device)
-	}
 
-	vinBytes := []byte(vin)
-	digest := sha1.Sum(vinBytes)
-
-	localName := fmt.Sprintf("S%02xC", digest[:8])
-	log.Debug("Searching for BLE beacon %s...", localName)
-	canConnect := false
-	filter := func(adv ble.Advertisement) bool {
-		ln := adv.LocalName()
-		if ln != localName {
-			return false
+		c.linkLock.Lock()
+		if c.closed {
+			c.linkLock.Unlock()
+			return
 		}
-		canConnect = adv.Connectable()
-		return true
-	}
+		c.client = nil
+		c.linkLock.Unlock()
+		client.Close()
+		log.Warning("BLE link to vehicle lost")
 
-	client, err := ble.Connect(ctx, filter)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find BLE beacon for %s (%s): %s", vin, localName, err)
+		if client = c.reconnect(); client == nil {
+			return
+		}
+		select {
+		case c.reconnected <- struct{}{}:
+		default:
+		}
 	}
+}
 
-	if !canConnect {
-		return nil, ErrMaxConnectionsExceeded
-	}
+// reconnect scans for the vehicle until a link is established or c is closed. Returns nil if c
+// was closed.
+func (c *Connection) reconnect() gattClient {
+	backoff := minReconnectBackoff
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
 
-	log.Debug("Connecting to BLE beacon %s...", client.Addr())
-	services, err := client.DiscoverServices([]ble.UUID{vehicleServiceUUID})
-	if err != nil {
-		return nil, fmt.Errorf("ble: failed to enumerate device services: %s", err)
-	}
-	if len(services) == 0 {
-		return nil, fmt.Errorf("ble: failed to discover service")
-	}
+		ctx, cancel := context.WithTimeout(context.Background(), reconnectTimeout)
+		go func() {
+			select {
+			case <-c.done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		client, err := c.dial(ctx, c.vin, c.rx)
+		cancel()
+		if err != nil {
+			log.Debug("BLE reconnect attempt failed: %s", err)
+			continue
+		}
 
-	characteristics, err := client.DiscoverCharacteristics([]ble.UUID{toVehicleUUID, fromVehicleUUID}, services[0])
-	if err != nil {
-		return nil, fmt.Errorf("ble: failed to discover service characteristics: %s", err)
-	}
+		c.rxLock.Lock()
+		c.inputBuffer = []byte{}
+		c.rxLock.Unlock()
 
-	conn := Connection{
-		vin:    vin,
-		client: client,
-		inbox:  make(chan []byte, 5),
-	}
-	for _, characteristic := range characteristics {
-		if characteristic.UUID.Equal(toVehicleUUID) {
-			conn.txChar = characteristic
-		} else if characteristic.UUID.Equal(fromVehicleUUID) {
-			conn.rxChar = characteristic
-		}
-		if _, err := client.DiscoverDescriptors(nil, characteristic); err != nil {
-			return nil, fmt.Errorf("ble: couldn't fetch descriptors: %s", err)
+		c.linkLock.Lock()
+		if c.closed {
+			c.linkLock.Unlock()
+			client.Close()
+			return nil
 		}
+		c.client = client
+		c.linkLock.Unlock()
+		log.Info("Reconnected to vehicle BLE")
+		return client
 	}
-	if conn.txChar == nil || conn.rxChar == nil {
-		return nil, fmt.Errorf("ble: failed to find required characteristics")
-	}
-	if err := client.Subscribe(conn.rxChar, true, conn.rx); err != nil {
-		return nil, fmt.Errorf("ble: failed to subscribe to RX: %s", err)
-	}
-	log.Info("Connected to vehicle BLE")
-	return &conn, nil
 }
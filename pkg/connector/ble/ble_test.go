@@ -0,0 +1,297 @@
+package ble
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/sim"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+
+	"google.golang.org/protobuf/proto"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+const testVIN = "0123456789ABCDEFG"
+
+var errOutOfRange = errors.New("vehicle out of range")
+
+func init() {
+	minReconnectBackoff = time.Millisecond
+	maxReconnectBackoff = 10 * time.Millisecond
+}
+
+// fakeClient implements gattClient. Messages written to it are reassembled and delivered to an
+// emulated vehicle, and the vehicle's responses are sent back as 20-byte notifications.
+type fakeClient struct {
+	car *sim.Connection
+	rx  func([]byte)
+
+	lock         sync.Mutex
+	written      []byte
+	chunks       [][]byte
+	handshakes   int
+	disconnected chan struct{}
+	closed       bool
+}
+
+func (f *fakeClient) Write(chunk []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	select {
+	case <-f.disconnected:
+		return errOutOfRange
+	default:
+	}
+	f.chunks = append(f.chunks, append([]byte{}, chunk...))
+	f.written = append(f.written, chunk...)
+	if len(f.written) < 2 {
+		return nil
+	}
+	length := 256*int(f.written[0]) + int(f.written[1])
+	if len(f.written) < 2+length {
+		return nil
+	}
+	message := f.written[2 : 2+length]
+	f.written = f.written[2+length:]
+	var decoded universal.RoutableMessage
+	if proto.Unmarshal(message, &decoded) == nil && decoded.GetSessionInfoRequest() != nil {
+		f.handshakes++
+	}
+	if f.car == nil {
+		return nil
+	}
+	if err := f.car.Send(context.Background(), message); err != nil {
+		return err
+	}
+	select {
+	case reply := <-f.car.Receive():
+		encoded := append([]byte{uint8(len(reply) >> 8), uint8(len(reply))}, reply...)
+		for len(encoded) > 0 {
+			n := min(20, len(encoded))
+			f.rx(encoded[:n])
+			encoded = encoded[n:]
+		}
+	default:
+	}
+	return nil
+}
+
+func (f *fakeClient) handshakeCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.handshakes
+}
+
+func (f *fakeClient) Disconnected() <-chan struct{} {
+	return f.disconnected
+}
+
+func (f *fakeClient) Close() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.closed = true
+}
+
+// drop emulates the vehicle leaving BLE range.
+func (f *fakeClient) drop() {
+	close(f.disconnected)
+}
+
+// fakeRadio implements a gattDialer that connects to an emulated vehicle when it's in range.
+type fakeRadio struct {
+	car *sim.Connection
+
+	lock     sync.Mutex
+	inRange  bool
+	attempts int
+	clients  []*fakeClient
+}
+
+func (r *fakeRadio) dial(ctx context.Context, vin string, rx func([]byte)) (gattClient, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.attempts++
+	if !r.inRange {
+		return nil, errOutOfRange
+	}
+	client := &fakeClient{car: r.car, rx: rx, disconnected: make(chan struct{})}
+	r.clients = append(r.clients, client)
+	return client, nil
+}
+
+func (r *fakeRadio) setInRange(inRange bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.inRange = inRange
+	if !inRange && len(r.clients) > 0 {
+		r.clients[len(r.clients)-1].drop()
+	}
+}
+
+func (r *fakeRadio) client() *fakeClient {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.clients[len(r.clients)-1]
+}
+
+func (r *fakeRadio) linkCount() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.clients)
+}
+
+func (r *fakeRadio) dialAttempts() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.attempts
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestFraming(t *testing.T) {
+	ctx := testContext(t)
+	radio := &fakeRadio{inRange: true}
+	conn, err := newConnection(ctx, testVIN, radio.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	message := bytes.Repeat([]byte{0xAB}, 45)
+	if err := conn.Send(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	chunks := radio.client().chunks
+	if len(chunks) != 3 || len(chunks[0]) != 20 || len(chunks[2]) != 7 {
+		t.Errorf("Unexpected chunks: %02x", chunks)
+	}
+	if chunks[0][0] != 0 || chunks[0][1] != 45 {
+		t.Errorf("Missing length prefix: %02x", chunks[0])
+	}
+
+	// Deliver a response in pieces.
+	radio.client().rx([]byte{0, 3, 1})
+	radio.client().rx([]byte{2, 3})
+	select {
+	case reply := <-conn.Receive():
+		if !bytes.Equal(reply, []byte{1, 2, 3}) {
+			t.Errorf("Unexpected reply: %02x", reply)
+		}
+	case <-ctx.Done():
+		t.Fatal("Reply not delivered")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	ctx := testContext(t)
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+
+	radio := &fakeRadio{car: car, inRange: true}
+	conn, err := newConnection(ctx, testVIN, radio.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := vehicle.NewVehicle(conn, skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer v.Disconnect()
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	if err := v.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+
+	radio.setInRange(false)
+	for conn.Send(ctx, []byte{0x32, 0x00}) != ErrReconnecting {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Connection did not detect link loss")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if !protocol.ShouldRetry(ErrReconnecting) {
+		t.Error("Clients should retry after ErrReconnecting")
+	}
+	// The vehicle restarts while it's out of range, which invalidates the client's sessions.
+	car.Reboot()
+
+	// After reconnecting, the Vehicle should resynchronize both sessions without waiting for a
+	// command to fail.
+	radio.setInRange(true)
+	for radio.linkCount() < 2 || radio.client().handshakeCount() < 2 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Sessions were not resynchronized after reconnecting")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if err := v.Lock(ctx); err != nil {
+		t.Fatalf("Lock failed after reconnecting: %s", err)
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+	if len(radio.clients) != 2 {
+		t.Errorf("Expected 2 links but got %d", len(radio.clients))
+	}
+	if !radio.clients[0].closed {
+		t.Error("Lost link was not closed")
+	}
+}
+
+func TestCloseStopsReconnecting(t *testing.T) {
+	ctx := testContext(t)
+	radio := &fakeRadio{inRange: true}
+	conn, err := newConnection(ctx, testVIN, radio.dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	radio.setInRange(false)
+	for radio.dialAttempts() < 3 {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Connection did not try to reconnect")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	conn.Close()
+	conn.Close()
+	if err := conn.Send(ctx, []byte{0x32, 0x00}); !errors.Is(err, protocol.ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected but got %v", err)
+	}
+	attempts := radio.dialAttempts()
+	time.Sleep(50 * time.Millisecond)
+	if radio.dialAttempts() > attempts+1 {
+		t.Error("Connection kept reconnecting after Close")
+	}
+}
@@ -0,0 +1,134 @@
+package ble
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"sync"
+
+	"github.com/go-ble/ble"
+	"github.com/teslamotors/vehicle-command/internal/log"
+)
+
+var (
+	vehicleServiceUUID = ble.MustParse("00000211-b2d1-43f0-9b88-960cebf8b91e")
+	toVehicleUUID      = ble.MustParse("00000212-b2d1-43f0-9b88-960cebf8b91e")
+	fromVehicleUUID    = ble.MustParse("00000213-b2d1-43f0-9b88-960cebf8b91e")
+)
+
+var (
+	device ble.Device
+	mu     sync.Mutex
+)
+
+// gattClient is a link to the vehicle's GATT service. It hides the platform BLE stack from
+// Connection so that link management can be tested without a radio.
+type gattClient interface {
+	// Write sends one chunk of a message to the vehicle's TX characteristic.
+	Write(chunk []byte) error
+	// Disconnected returns a channel that's closed when the link is lost.
+	Disconnected() <-chan struct{}
+	// Close terminates the link.
+	Close()
+}
+
+// gattDialer scans for the vehicle's beacon, connects, and subscribes to the vehicle's RX
+// characteristic. Notifications are passed to rx.
+type gattDialer func(ctx context.Context, vin string, rx func([]byte)) (gattClient, error)
+
+// bleClient implements gattClient using the go-ble package.
+type bleClient struct {
+	client ble.Client
+	txChar *ble.Characteristic
+}
+
+func (b *bleClient) Write(chunk []byte) error {
+	return b.client.WriteCharacteristic(b.txChar, chunk, false)
+}
+
+func (b *bleClient) Disconnected() <-chan struct{} {
+	return b.client.Disconnected()
+}
+
+func (b *bleClient) Close() {
+	b.client.ClearSubscriptions()
+	b.client.CancelConnection()
+}
+
+func dialBLE(ctx context.Context, vin string, rx func([]byte)) (gattClient, error) {
+	var err error
+	// We don't want concurrent calls to NewConnection that would defeat
+	// the point of reusing the existing BLE device. Note that this is not
+	// an issue on MacOS, but multiple calls to newDevice() on Linux leads to failures.
+	mu.Lock()
+	defer mu.Unlock()
+
+	if device != nil {
+		log.Debug("Reusing existing BLE device")
+	} else {
+		log.Debug("Creating new BLE device")
+		device, err = newDevice()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a BLE device: %s", err)
+		}
+		ble.SetDefaultDevice(device)
+	}
+
+	vinBytes := []byte(vin)
+	digest := sha1.Sum(vinBytes)
+
+	localName := fmt.Sprintf("S%02xC", digest[:8])
+	log.Debug("Searching for BLE beacon %s...", localName)
+	canConnect := false
+	filter := func(adv ble.Advertisement) bool {
+		ln := adv.LocalName()
+		if ln != localName {
+			return false
+		}
+		canConnect = adv.Connectable()
+		return true
+	}
+
+	client, err := ble.Connect(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find BLE beacon for %s (%s): %s", vin, localName, err)
+	}
+
+	if !canConnect {
+		return nil, ErrMaxConnectionsExceeded
+	}
+
+	log.Debug("Connecting to BLE beacon %s...", client.Addr())
+	services, err := client.DiscoverServices([]ble.UUID{vehicleServiceUUID})
+	if err != nil {
+		return nil, fmt.Errorf("ble: failed to enumerate device services: %s", err)
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("ble: failed to discover service")
+	}
+
+	characteristics, err := client.DiscoverCharacteristics([]ble.UUID{toVehicleUUID, fromVehicleUUID}, services[0])
+	if err != nil {
+		return nil, fmt.Errorf("ble: failed to discover service characteristics: %s", err)
+	}
+
+	var txChar, rxChar *ble.Characteristic
+	for _, characteristic := range characteristics {
+		if characteristic.UUID.Equal(toVehicleUUID) {
+			txChar = characteristic
+		} else if characteristic.UUID.Equal(fromVehicleUUID) {
+			rxChar = characteristic
+		}
+		if _, err := client.DiscoverDescriptors(nil, characteristic); err != nil {
+			return nil, fmt.Errorf("ble: couldn't fetch descriptors: %s", err)
+		}
+	}
+	if txChar == nil || rxChar == nil {
+		return nil, fmt.Errorf("ble: failed to find required characteristics")
+	}
+	if err := client.Subscribe(rxChar, true, rx); err != nil {
+		return nil, fmt.Errorf("ble: failed to subscribe to RX: %s", err)
+	}
+	log.Info("Connected to vehicle BLE")
+	return &bleClient{client: client, txChar: txChar}, nil
+}
@@ -0,0 +1,228 @@
+package bridge_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/bridge"
+	"github.com/teslamotors/vehicle-command/pkg/connector/connectortest"
+	"github.com/teslamotors/vehicle-command/pkg/connector/sim"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+const testVIN = "0123456789ABCDEFG"
+
+var testSecret = []byte("correct horse battery staple")
+
+// serve starts a bridge to an emulated vehicle and returns the vehicle and the bridge's address.
+func serve(t *testing.T, ctx context.Context) (*sim.Connection, string) {
+	t.Helper()
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return car, listen(t, ctx, car)
+}
+
+// listen starts a bridge to conn and returns the bridge's address.
+func listen(t *testing.T, ctx context.Context, conn connector.Connector) string {
+	t.Helper()
+	server, err := bridge.NewServer(conn, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- server.Serve(serveCtx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) && !errors.Is(err, bridge.ErrVehicleDisconnected) {
+			t.Errorf("Unexpected error from Serve: %s", err)
+		}
+	})
+	return listener.Addr().String()
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestBridge(t *testing.T) {
+	ctx := testContext(t)
+	car, address := serve(t, ctx)
+
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := bridge.Dial(ctx, address, testVIN, testSecret)
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	if conn.PreferredAuthMethod() != car.PreferredAuthMethod() || conn.AllowedLatency() != car.AllowedLatency() {
+		t.Error("Bridge did not preserve connection parameters")
+	}
+
+	v, err := vehicle.NewVehicle(conn, skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer v.Disconnect()
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	if err := v.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+}
+
+func TestWrongSecret(t *testing.T) {
+	ctx := testContext(t)
+	_, address := serve(t, ctx)
+	if _, err := bridge.Dial(ctx, address, testVIN, []byte("hunter2")); !errors.Is(err, bridge.ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed but got %v", err)
+	}
+	if _, err := bridge.Dial(ctx, address, testVIN, nil); !errors.Is(err, bridge.ErrEmptySecret) {
+		t.Errorf("Expected ErrEmptySecret but got %v", err)
+	}
+}
+
+func TestWrongVIN(t *testing.T) {
+	ctx := testContext(t)
+	_, address := serve(t, ctx)
+	var rejected *bridge.RejectedError
+	if _, err := bridge.Dial(ctx, address, "7SAYGDEF0PF000000", testSecret); !errors.As(err, &rejected) {
+		t.Errorf("Expected RejectedError but got %v", err)
+	}
+}
+
+// readFrame reads an unauthenticated handshake frame from r.
+func readFrame(r io.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	payload := make([]byte, binary.BigEndian.Uint32(length[:]))
+	_, err := io.ReadFull(r, payload)
+	return payload, err
+}
+
+func writeFrame(w io.Writer, payload []byte) error {
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	_, err := w.Write(append(frame, payload...))
+	return err
+}
+
+// TestTamperedAccept verifies that a network attacker can't downgrade the authentication method
+// the server reports to the client.
+func TestTamperedAccept(t *testing.T) {
+	ctx := testContext(t)
+	_, address := serve(t, ctx)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		server, err := net.Dial("tcp", address)
+		if err != nil {
+			return
+		}
+		defer server.Close()
+		// Relay the server and client hellos unmodified.
+		for _, hop := range []struct{ from, to net.Conn }{{server, client}, {client, server}} {
+			payload, err := readFrame(hop.from)
+			if err != nil || writeFrame(hop.to, payload) != nil {
+				return
+			}
+		}
+		payload, err := readFrame(server)
+		if err != nil {
+			return
+		}
+		var accept map[string]interface{}
+		if err := json.Unmarshal(payload, &accept); err != nil {
+			return
+		}
+		accept["auth_method"] = connector.AuthMethodHMAC
+		if payload, err = json.Marshal(accept); err != nil {
+			return
+		}
+		writeFrame(client, payload)
+		io.Copy(io.Discard, client)
+	}()
+
+	if _, err := bridge.Dial(ctx, listener.Addr().String(), testVIN, testSecret); !errors.Is(err, bridge.ErrAuthenticationFailed) {
+		t.Errorf("Expected ErrAuthenticationFailed but got %v", err)
+	}
+}
+
+func TestTakeover(t *testing.T) {
+	ctx := testContext(t)
+	_, address := serve(t, ctx)
+	first, err := bridge.Dial(ctx, address, testVIN, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := bridge.Dial(ctx, address, testVIN, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	select {
+	case _, ok := <-first.Receive():
+		if ok {
+			t.Error("Received unexpected datagram")
+		}
+	case <-ctx.Done():
+		t.Error("First client was not disconnected")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	connectortest.Run(t, func(t *testing.T) (connector.Connector, connectortest.Peer) {
+		pipe, peer := connectortest.NewPipe(testVIN, connector.AuthMethodGCM)
+		address := listen(t, context.Background(), pipe)
+		conn, err := bridge.Dial(context.Background(), address, testVIN, testSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, peer
+	}, nil)
+}
@@ -0,0 +1,172 @@
+package bridge
+
+import (
+	"context"
+	"crypto/hmac"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+// Connection implements connector.Connector by relaying datagrams through a bridge [Server].
+type Connection struct {
+	vin            string
+	authMethod     connector.AuthMethod
+	retryInterval  time.Duration
+	allowedLatency time.Duration
+
+	conn    net.Conn
+	channel *channel
+	inbox   chan []byte
+
+	writeLock sync.Mutex
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Dial connects to the bridge Server listening on address (host:port) and requests a connection to
+// the vehicle with the provided vin. The secret must match the one used by the Server.
+func Dial(ctx context.Context, address, vin string, secret []byte) (*Connection, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	c, err := handshake(ctx, conn, vin, secret)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.listen()
+	return c, nil
+}
+
+func handshake(ctx context.Context, conn net.Conn, vin string, secret []byte) (*Connection, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	ch := &channel{rw: conn, txDir: directionToServer, rxDir: directionToClient}
+
+	var hello serverHello
+	if err := ch.readJSON(&hello); err != nil {
+		return nil, err
+	}
+	if hello.Version != protocolVersion {
+		return nil, &RejectedError{Reason: "unsupported protocol version"}
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	err = ch.writeJSON(&clientHello{
+		Nonce: nonce,
+		VIN:   vin,
+		Proof: derive(secret, labelClientProof, hello.Nonce, nonce, vin),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var accept serverAccept
+	if err := ch.readJSON(&accept); err != nil {
+		return nil, err
+	}
+	if accept.Error == reasonAuthenticationFailed {
+		return nil, ErrAuthenticationFailed
+	}
+	if accept.Error != "" {
+		return nil, &RejectedError{Reason: accept.Error}
+	}
+	if !hmac.Equal(accept.Proof, accept.proof(secret, hello.Nonce, nonce, vin)) {
+		return nil, ErrAuthenticationFailed
+	}
+	ch.key = derive(secret, labelSessionKey, hello.Nonce, nonce, vin)
+
+	return &Connection{
+		vin:            vin,
+		authMethod:     accept.AuthMethod,
+		retryInterval:  accept.RetryInterval,
+		allowedLatency: accept.AllowedLatency,
+		conn:           conn,
+		channel:        ch,
+		inbox:          make(chan []byte, connector.BufferSize),
+		done:           make(chan struct{}),
+	}, nil
+}
+
+func (c *Connection) listen() {
+	defer close(c.inbox)
+	for {
+		payload, err := c.channel.readFrame()
+		if err != nil {
+			select {
+			case <-c.done:
+			default:
+				log.Warning("Bridge connection terminated: %s", err)
+			}
+			return
+		}
+		select {
+		case c.inbox <- payload:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Connection) Send(ctx context.Context, buffer []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	select {
+	case <-c.done:
+		return protocol.ErrNotConnected
+	default:
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	if err := c.channel.writeFrame(buffer); err != nil {
+		if ctx.Err() != nil {
+			return &protocol.CommandError{Err: ctx.Err(), PossibleSuccess: true, PossibleTemporary: false}
+		}
+		log.Warning("Failed to write to bridge: %s", err)
+		return protocol.ErrNotConnected
+	}
+	return nil
+}
+
+func (c *Connection) Receive() <-chan []byte {
+	return c.inbox
+}
+
+func (c *Connection) VIN() string {
+	return c.vin
+}
+
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *Connection) PreferredAuthMethod() connector.AuthMethod {
+	return c.authMethod
+}
+
+func (c *Connection) RetryInterval() time.Duration {
+	return c.retryInterval
+}
+
+func (c *Connection) AllowedLatency() time.Duration {
+	return c.allowedLatency
+}
@@ -0,0 +1,216 @@
+/*
+Package bridge relays a vehicle connection, typically BLE, over a TCP channel.
+
+A [Server] runs on a host that is within Bluetooth range of a vehicle (such as a Raspberry Pi in a
+garage) and forwards datagrams between a local connector.Connector and a remote client. A
+[Connection] implements connector.Connector on the remote host, so a vehicle.Vehicle can use the
+bridged connection as if the BLE radio were attached locally. The Connection reports the
+authentication method, retry interval, and latency limits of the Server's underlying connection.
+
+The vehicle protocol authenticates (and for BLE, encrypts) commands end to end, so the bridge
+doesn't need to be trusted with the client's private key. However, an open bridge would allow
+anyone on the network to use the BLE radio, for example to send add-key requests. Clients and
+servers therefore share a secret. Both parties prove knowledge of the secret during a handshake
+and then authenticate every frame using HMAC-SHA256 with a per-connection key and sequence
+numbers, which prevents injection, replay, and reordering of frames on the network. Frames are not
+encrypted.
+
+# Wire format
+
+Every frame consists of a 4-byte big-endian payload length, the payload, and (after the
+handshake) a 32-byte HMAC tag. The handshake consists of three JSON payloads:
+
+ 1. The server sends a serverHello containing a random nonce.
+ 2. The client sends a clientHello containing its own nonce, the VIN it wants to reach, and a proof
+    that it knows the shared secret.
+ 3. The server sends a serverAccept containing its proof, the connection parameters, or an error.
+    The proof covers the connection parameters, so they can't be modified in transit (for
+    example, to downgrade the client from AES-GCM to HMAC authentication).
+
+Subsequent payloads are datagrams exchanged with the vehicle.
+*/
+package bridge
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+const (
+	protocolVersion = 2
+	nonceLength     = 32
+	tagLength       = sha256.Size
+	maxFrameLength  = connector.MaxResponseLength
+)
+
+var (
+	// ErrAuthenticationFailed indicates the client and server do not share the same secret, or a
+	// frame was tampered with.
+	ErrAuthenticationFailed = protocol.NewError("bridge authentication failed", false, false)
+	// ErrFrameTooLarge indicates a peer attempted to send a frame that exceeds the maximum length.
+	ErrFrameTooLarge = errors.New("bridge frame exceeds maximum length")
+	// ErrEmptySecret indicates the caller did not provide a shared secret.
+	ErrEmptySecret = errors.New("bridge secret must not be empty")
+)
+
+// RejectedError is returned by [Dial] when the server declines a connection.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("bridge rejected connection: %s", e.Reason)
+}
+
+type serverHello struct {
+	Version int    `json:"version"`
+	Nonce   []byte `json:"nonce"`
+}
+
+type clientHello struct {
+	Nonce []byte `json:"nonce"`
+	VIN   string `json:"vin"`
+	Proof []byte `json:"proof"`
+}
+
+type serverAccept struct {
+	Error          string               `json:"error,omitempty"`
+	Proof          []byte               `json:"proof,omitempty"`
+	AuthMethod     connector.AuthMethod `json:"auth_method"`
+	RetryInterval  time.Duration        `json:"retry_interval"`
+	AllowedLatency time.Duration        `json:"allowed_latency"`
+}
+
+// reasonAuthenticationFailed is sent in serverAccept.Error when the client's proof is invalid.
+const reasonAuthenticationFailed = "authentication failed"
+
+const (
+	labelClientProof = "client proof"
+	labelServerProof = "server proof"
+	labelSessionKey  = "session key"
+)
+
+// derive returns HMAC-SHA256(secret, label || serverNonce || clientNonce || vin || extra).
+func derive(secret []byte, label string, serverNonce, clientNonce []byte, vin string, extra ...[]byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(label))
+	mac.Write(serverNonce)
+	mac.Write(clientNonce)
+	mac.Write([]byte(vin))
+	for _, data := range extra {
+		mac.Write(data)
+	}
+	return mac.Sum(nil)
+}
+
+// proof returns the server's proof for a, which also authenticates a's connection parameters.
+func (a *serverAccept) proof(secret []byte, serverNonce, clientNonce []byte, vin string) []byte {
+	var params [24]byte
+	binary.BigEndian.PutUint64(params[0:], uint64(a.AuthMethod))
+	binary.BigEndian.PutUint64(params[8:], uint64(a.RetryInterval))
+	binary.BigEndian.PutUint64(params[16:], uint64(a.AllowedLatency))
+	return derive(secret, labelServerProof, serverNonce, clientNonce, vin, params[:])
+}
+
+func newNonce() ([]byte, error) {
+	nonce := make([]byte, nonceLength)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
+
+// Direction bytes prevent reflecting a frame back to its sender.
+const (
+	directionToServer byte = 1
+	directionToClient byte = 2
+)
+
+// channel reads and writes frames. Methods that read are not safe to call concurrently with each
+// other, nor are methods that write; a reader and a writer may run concurrently.
+type channel struct {
+	rw        io.ReadWriter
+	key       []byte
+	txSeq     uint64
+	rxSeq     uint64
+	txDir     byte
+	rxDir     byte
+	lengthBuf [4]byte
+}
+
+func (c *channel) tag(direction byte, seq uint64, payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	var header [9]byte
+	header[0] = direction
+	binary.BigEndian.PutUint64(header[1:], seq)
+	mac.Write(header[:])
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (c *channel) writeFrame(payload []byte) error {
+	if len(payload) > maxFrameLength {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, 4, 4+len(payload)+tagLength)
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+	if c.key != nil {
+		frame = append(frame, c.tag(c.txDir, c.txSeq, payload)...)
+		c.txSeq++
+	}
+	_, err := c.rw.Write(frame)
+	return err
+}
+
+func (c *channel) readFrame() ([]byte, error) {
+	if _, err := io.ReadFull(c.rw, c.lengthBuf[:]); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint32(c.lengthBuf[:]))
+	if length > maxFrameLength {
+		return nil, ErrFrameTooLarge
+	}
+	frameLength := length
+	if c.key != nil {
+		frameLength += tagLength
+	}
+	frame := make([]byte, frameLength)
+	if _, err := io.ReadFull(c.rw, frame); err != nil {
+		return nil, err
+	}
+	payload := frame[:length]
+	if c.key != nil {
+		if !hmac.Equal(frame[length:], c.tag(c.rxDir, c.rxSeq, payload)) {
+			return nil, ErrAuthenticationFailed
+		}
+		c.rxSeq++
+	}
+	return payload, nil
+}
+
+func (c *channel) writeJSON(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.writeFrame(payload)
+}
+
+func (c *channel) readJSON(v interface{}) error {
+	payload, err := c.readFrame()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
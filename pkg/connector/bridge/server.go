@@ -0,0 +1,213 @@
+package bridge
+
+import (
+	"context"
+	"crypto/hmac"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+)
+
+// handshakeTimeout limits how long an unauthenticated client can hold a connection open.
+var handshakeTimeout = 10 * time.Second
+
+// ErrVehicleDisconnected indicates the Server's underlying connection to the vehicle was closed.
+var ErrVehicleDisconnected = errors.New("vehicle connection closed")
+
+// Server relays datagrams between a vehicle connection and a remote [Connection].
+//
+// The vehicle connection can only be used by one client at a time. When a new client
+// authenticates, the previous client (if any) is disconnected. This allows clients to reconnect
+// immediately after a network failure, without waiting for the Server to notice that the previous
+// TCP connection is dead.
+type Server struct {
+	conn   connector.Connector
+	secret []byte
+
+	lock   sync.Mutex
+	active *session
+}
+
+type session struct {
+	conn      net.Conn
+	channel   *channel
+	writeLock sync.Mutex
+	closeOnce sync.Once
+}
+
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		s.conn.Close()
+	})
+}
+
+func (s *session) write(payload []byte) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return s.channel.writeFrame(payload)
+}
+
+// NewServer returns a Server that relays datagrams exchanged with conn to clients that know
+// secret.
+func NewServer(conn connector.Connector, secret []byte) (*Server, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+	return &Server{conn: conn, secret: append([]byte{}, secret...)}, nil
+}
+
+// Serve accepts client connections from listener until ctx expires, listener fails, or the
+// vehicle connection is closed. The listener is closed when Serve returns, but the vehicle
+// connection is not.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var vehicleClosed atomic.Bool
+	go func() {
+		if !s.relayFromVehicle(serveCtx) {
+			vehicleClosed.Store(true)
+		}
+		cancel()
+	}()
+	go func() {
+		<-serveCtx.Done()
+		listener.Close()
+	}()
+
+	var err error
+	for {
+		var conn net.Conn
+		if conn, err = listener.Accept(); err != nil {
+			break
+		}
+		go s.handle(serveCtx, conn)
+	}
+
+	cancel()
+	s.lock.Lock()
+	if s.active != nil {
+		s.active.close()
+		s.active = nil
+	}
+	s.lock.Unlock()
+
+	if vehicleClosed.Load() {
+		return ErrVehicleDisconnected
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// relayFromVehicle forwards datagrams from the vehicle to the active client until ctx expires.
+// Datagrams received while no client is connected are discarded. Returns false if the vehicle
+// connection was closed.
+func (s *Server) relayFromVehicle(ctx context.Context) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case buffer, ok := <-s.conn.Receive():
+			if !ok {
+				log.Warning("Vehicle connection closed")
+				return false
+			}
+			s.lock.Lock()
+			active := s.active
+			s.lock.Unlock()
+			if active == nil {
+				log.Debug("Discarding datagram because no client is connected")
+				continue
+			}
+			if err := active.write(buffer); err != nil {
+				log.Warning("Failed to relay datagram to client: %s", err)
+				s.disconnect(active)
+			}
+		}
+	}
+}
+
+func (s *Server) disconnect(sess *session) {
+	sess.close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.active == sess {
+		s.active = nil
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	sess, err := s.handshake(conn)
+	if err != nil {
+		log.Warning("Rejected bridge client %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	log.Info("Bridge client %s connected", conn.RemoteAddr())
+
+	s.lock.Lock()
+	if s.active != nil {
+		log.Info("Disconnecting previous bridge client %s", s.active.conn.RemoteAddr())
+		s.active.close()
+	}
+	s.active = sess
+	s.lock.Unlock()
+	defer s.disconnect(sess)
+
+	for {
+		payload, err := sess.channel.readFrame()
+		if err != nil {
+			log.Info("Bridge client %s disconnected: %s", conn.RemoteAddr(), err)
+			return
+		}
+		if err := s.conn.Send(ctx, payload); err != nil {
+			// The client is responsible for retrying.
+			log.Warning("Failed to relay datagram to vehicle: %s", err)
+		}
+	}
+}
+
+func (s *Server) handshake(conn net.Conn) (*session, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	ch := &channel{rw: conn, txDir: directionToClient, rxDir: directionToServer}
+
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	if err := ch.writeJSON(&serverHello{Version: protocolVersion, Nonce: nonce}); err != nil {
+		return nil, err
+	}
+
+	var hello clientHello
+	if err := ch.readJSON(&hello); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(hello.Proof, derive(s.secret, labelClientProof, nonce, hello.Nonce, hello.VIN)) {
+		ch.writeJSON(&serverAccept{Error: reasonAuthenticationFailed})
+		return nil, ErrAuthenticationFailed
+	}
+	if hello.VIN != s.conn.VIN() {
+		ch.writeJSON(&serverAccept{Error: "bridge is connected to a different vehicle"})
+		return nil, errors.New("client requested a different VIN")
+	}
+	accept := serverAccept{
+		AuthMethod:     s.conn.PreferredAuthMethod(),
+		RetryInterval:  s.conn.RetryInterval(),
+		AllowedLatency: s.conn.AllowedLatency(),
+	}
+	accept.Proof = accept.proof(s.secret, nonce, hello.Nonce, hello.VIN)
+	if err := ch.writeJSON(&accept); err != nil {
+		return nil, err
+	}
+	ch.key = derive(s.secret, labelSessionKey, nonce, hello.Nonce, hello.VIN)
+	return &session{conn: conn, channel: ch}, nil
+}
@@ -0,0 +1,165 @@
+package capture_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/capture"
+	"github.com/teslamotors/vehicle-command/pkg/connector/connectortest"
+	"github.com/teslamotors/vehicle-command/pkg/connector/sim"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+const testVIN = "0123456789ABCDEFG"
+
+func newKey(t *testing.T) authentication.ECDHPrivateKey {
+	t.Helper()
+	key, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// exercise connects to a vehicle using conn and sends a few commands. The last command is
+// expected to fail.
+func exercise(t *testing.T, conn connector.Connector, skey authentication.ECDHPrivateKey) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	car, err := vehicle.NewVehicle(conn, skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer car.Disconnect()
+	if err := car.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := car.StartSession(ctx, nil); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	if err := car.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+	if err := car.ChangeChargeLimit(ctx, 90); err != nil {
+		t.Fatalf("ChangeChargeLimit failed: %s", err)
+	}
+	return car.ChangeChargeLimit(ctx, 10)
+}
+
+func record(t *testing.T) []byte {
+	t.Helper()
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey := newKey(t)
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+
+	var file bytes.Buffer
+	recorder, err := capture.NewRecorder(car, &file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nominalErr *protocol.NominalError
+	if err := exercise(t, recorder, skey); !errors.As(err, &nominalErr) {
+		t.Fatalf("Expected nominal error but got %v", err)
+	}
+	return file.Bytes()
+}
+
+func TestRecord(t *testing.T) {
+	header, records, err := capture.ReadAll(bytes.NewReader(record(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.VIN != testVIN || header.AuthMethod != connector.AuthMethodGCM {
+		t.Errorf("Unexpected header: %+v", header)
+	}
+	// Two handshakes and three commands, each with a response.
+	if len(records) != 10 {
+		t.Fatalf("Expected 10 records but got %d", len(records))
+	}
+	for _, record := range records {
+		if _, err := record.Message(); err != nil {
+			t.Errorf("Couldn't decode %s record: %s", record.Direction, err)
+		}
+	}
+}
+
+func TestReplay(t *testing.T) {
+	capturedData := record(t)
+
+	// The replayer should work with a different client key than the recording.
+	skey := newKey(t)
+	replayer, err := capture.NewReplayer(bytes.NewReader(capturedData), skey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nominalErr *protocol.NominalError
+	if err := exercise(t, replayer, skey); !errors.As(err, &nominalErr) {
+		t.Errorf("Expected replay to reproduce nominal error but got %v", err)
+	}
+	if remaining := replayer.Remaining(); remaining != 0 {
+		t.Errorf("Client didn't replay %d records", remaining)
+	}
+}
+
+func TestReplayDiverged(t *testing.T) {
+	var file bytes.Buffer
+	if _, err := capture.NewWriter(&file, capture.Header{VIN: testVIN}); err != nil {
+		t.Fatal(err)
+	}
+	replayer, err := capture.NewReplayer(&file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replayer.Send(context.Background(), nil); !errors.Is(err, capture.ErrCaptureExhausted) {
+		t.Errorf("Expected ErrCaptureExhausted but got %v", err)
+	}
+}
+
+func TestInvalidFormat(t *testing.T) {
+	for _, data := range []string{"", "{}", `{"format":"vehicle-command-capture","version":2}`} {
+		if _, err := capture.NewReader(strings.NewReader(data)); !errors.Is(err, capture.ErrUnsupportedFormat) {
+			t.Errorf("Expected ErrUnsupportedFormat for '%s' but got %v", data, err)
+		}
+	}
+
+	var file bytes.Buffer
+	if _, err := capture.NewWriter(&file, capture.Header{}); err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"direction":"sideways"}` + "\n")
+	reader, err := capture.NewReader(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Next(); err == nil || err == io.EOF {
+		t.Errorf("Expected invalid direction error but got %v", err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	connectortest.Run(t, func(t *testing.T) (connector.Connector, connectortest.Peer) {
+		pipe, peer := connectortest.NewPipe(testVIN, connector.AuthMethodGCM)
+		recorder, err := capture.NewRecorder(pipe, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return recorder, peer
+	}, nil)
+}
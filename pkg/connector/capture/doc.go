@@ -0,0 +1,48 @@
+/*
+Package capture records the datagrams exchanged with a vehicle and replays them later.
+
+A [Recorder] wraps an existing [connector.Connector] and writes every datagram passed to Send and
+every datagram delivered on Receive to a capture file. A [Replayer] implements
+[connector.Connector] by feeding a capture file back to a client, which allows reproducing a
+failing BLE or Fleet API exchange deterministically in a unit test:
+
+	file, err := os.Open("failure.capture")
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	conn, err := capture.NewReplayer(file, skey)
+	if err != nil {
+		panic(err)
+	}
+	car, err := vehicle.NewVehicle(conn, skey, nil)
+
+# File format
+
+A capture file consists of newline-delimited JSON objects. The first line is a [Header] that
+identifies the format and describes the connection:
+
+	{"format":"vehicle-command-capture","version":1,"vin":"0123456789ABCDEFG","auth_method":1,"retry_interval":1000000000,"allowed_latency":4000000000,"started_at":"2024-01-02T15:04:05.999999999Z"}
+
+Each subsequent line is a [Record] containing a single datagram:
+
+	{"time":"2024-01-02T15:04:06.123456789Z","direction":"tx","data":"Mg..."}
+
+The direction is "tx" for datagrams sent to the vehicle and "rx" for datagrams received from the
+vehicle. The data field contains the base64-encoded datagram, which is normally a protobuf-encoded
+universal.RoutableMessage (see [Record.Message]). Durations are in nanoseconds and auth_method uses
+the numeric values of [connector.AuthMethod].
+
+# Replay
+
+Clients generate random request UUIDs and routing addresses, so a [Replayer] cannot return
+recorded responses verbatim. Instead, it matches each outgoing message to the next unused
+recorded message with the same destination domain and message type, and rewrites the recorded
+responses to that message so that they are addressed to the live client. Recorded timestamps are
+ignored; responses are queued as soon as the message that triggered them is sent.
+
+Session info sent by the vehicle is authenticated using the client's private key and the request
+UUID. If the Replayer is given a private key, it re-authenticates recorded session info for the
+live request using that key, so the key does not have to match the one used to make the recording.
+*/
+package capture
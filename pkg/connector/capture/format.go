@@ -0,0 +1,143 @@
+package capture
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+const (
+	// FormatName identifies capture files.
+	FormatName = "vehicle-command-capture"
+	// FormatVersion is the version of the capture file format written by this package.
+	FormatVersion = 1
+)
+
+// ErrUnsupportedFormat indicates a file is not a capture file, or uses an unsupported version of
+// the format.
+var ErrUnsupportedFormat = errors.New("unsupported capture file format")
+
+// Direction indicates whether a datagram was sent to or received from a vehicle.
+type Direction string
+
+const (
+	DirectionSend    Direction = "tx"
+	DirectionReceive Direction = "rx"
+)
+
+// Header describes the connection a capture file was recorded from.
+type Header struct {
+	Format         string               `json:"format"`
+	Version        int                  `json:"version"`
+	VIN            string               `json:"vin"`
+	AuthMethod     connector.AuthMethod `json:"auth_method"`
+	RetryInterval  time.Duration        `json:"retry_interval"`
+	AllowedLatency time.Duration        `json:"allowed_latency"`
+	StartedAt      time.Time            `json:"started_at"`
+}
+
+// Record contains a single datagram.
+type Record struct {
+	Time      time.Time `json:"time"`
+	Direction Direction `json:"direction"`
+	Data      []byte    `json:"data"`
+}
+
+// Message decodes r.Data.
+func (r *Record) Message() (*universal.RoutableMessage, error) {
+	var message universal.RoutableMessage
+	if err := proto.Unmarshal(r.Data, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Writer writes a capture file. It is safe for concurrent use.
+type Writer struct {
+	lock    sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewWriter writes header to w and returns a Writer that can be used to append records. The
+// Format and Version fields of header are populated automatically.
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	header.Format = FormatName
+	header.Version = FormatVersion
+	encoder := json.NewEncoder(w)
+	if err := encoder.Encode(&header); err != nil {
+		return nil, err
+	}
+	return &Writer{encoder: encoder}, nil
+}
+
+// Write appends record to the capture file.
+func (w *Writer) Write(record *Record) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.encoder.Encode(record)
+}
+
+// Reader reads a capture file.
+type Reader struct {
+	decoder *json.Decoder
+	header  Header
+}
+
+// NewReader reads the header of a capture file from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := Reader{decoder: json.NewDecoder(r)}
+	if err := reader.decoder.Decode(&reader.header); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, err)
+	}
+	if reader.header.Format != FormatName || reader.header.Version != FormatVersion {
+		return nil, ErrUnsupportedFormat
+	}
+	return &reader, nil
+}
+
+// Header returns the header of the capture file.
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next record in the capture file, or io.EOF if there are no more records.
+func (r *Reader) Next() (*Record, error) {
+	var record Record
+	if err := r.decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+	if record.Direction != DirectionSend && record.Direction != DirectionReceive {
+		return nil, fmt.Errorf("invalid record direction '%s'", record.Direction)
+	}
+	return &record, nil
+}
+
+// ReadAll reads a complete capture file from r.
+func ReadAll(r io.Reader) (*Header, []Record, error) {
+	reader, err := NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	var records []Record
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		records = append(records, *record)
+	}
+	header := reader.Header()
+	return &header, records, nil
+}
@@ -0,0 +1,155 @@
+package capture
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+)
+
+// Recorder is a connector.Connector that records datagrams exchanged by an underlying Connector.
+type Recorder struct {
+	conn   connector.Connector
+	writer *Writer
+	outbox chan []byte
+
+	done      chan struct{}
+	finished  chan struct{}
+	closeOnce sync.Once
+
+	errLock  sync.Mutex
+	writeErr error
+}
+
+// NewRecorder writes a capture file header to w and returns a Recorder that records the datagrams
+// exchanged by conn to w. Closing the Recorder closes conn but not w.
+//
+// Use [NewFleetAPIRecorder] instead if conn implements connector.FleetAPIConnector.
+func NewRecorder(conn connector.Connector, w io.Writer) (*Recorder, error) {
+	writer, err := NewWriter(w, Header{
+		VIN:            conn.VIN(),
+		AuthMethod:     conn.PreferredAuthMethod(),
+		RetryInterval:  conn.RetryInterval(),
+		AllowedLatency: conn.AllowedLatency(),
+		StartedAt:      time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	recorder := &Recorder{
+		conn:     conn,
+		writer:   writer,
+		outbox:   make(chan []byte, connector.BufferSize),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go recorder.forward()
+	return recorder, nil
+}
+
+// FleetAPIRecorder is a Recorder that preserves the connector.FleetAPIConnector methods of the
+// underlying connection. Fleet API commands are not datagrams and are not recorded.
+type FleetAPIRecorder struct {
+	*Recorder
+	fleetAPI connector.FleetAPIConnector
+}
+
+// NewFleetAPIRecorder is equivalent to [NewRecorder], but the returned value also implements
+// connector.FleetAPIConnector.
+func NewFleetAPIRecorder(conn connector.FleetAPIConnector, w io.Writer) (*FleetAPIRecorder, error) {
+	recorder, err := NewRecorder(conn, w)
+	if err != nil {
+		return nil, err
+	}
+	return &FleetAPIRecorder{Recorder: recorder, fleetAPI: conn}, nil
+}
+
+func (f *FleetAPIRecorder) SendFleetAPICommand(ctx context.Context, endpoint string, command interface{}) ([]byte, error) {
+	return f.fleetAPI.SendFleetAPICommand(ctx, endpoint, command)
+}
+
+func (f *FleetAPIRecorder) Wakeup(ctx context.Context) error {
+	return f.fleetAPI.Wakeup(ctx)
+}
+
+// Err returns the first error encountered while writing to the capture file, if any. Write errors
+// do not interrupt communication with the vehicle.
+func (r *Recorder) Err() error {
+	r.errLock.Lock()
+	defer r.errLock.Unlock()
+	return r.writeErr
+}
+
+func (r *Recorder) record(direction Direction, buffer []byte) {
+	err := r.writer.Write(&Record{
+		Time:      time.Now(),
+		Direction: direction,
+		Data:      buffer,
+	})
+	if err == nil {
+		return
+	}
+	r.errLock.Lock()
+	defer r.errLock.Unlock()
+	if r.writeErr == nil {
+		log.Warning("Failed to write to capture file: %s", err)
+		r.writeErr = err
+	}
+}
+
+func (r *Recorder) forward() {
+	defer close(r.finished)
+	for {
+		select {
+		case buffer, ok := <-r.conn.Receive():
+			if !ok {
+				return
+			}
+			r.record(DirectionReceive, buffer)
+			select {
+			case r.outbox <- buffer:
+			case <-r.done:
+				return
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *Recorder) Receive() <-chan []byte {
+	return r.outbox
+}
+
+func (r *Recorder) Send(ctx context.Context, buffer []byte) error {
+	r.record(DirectionSend, buffer)
+	return r.conn.Send(ctx, buffer)
+}
+
+func (r *Recorder) VIN() string {
+	return r.conn.VIN()
+}
+
+func (r *Recorder) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		<-r.finished
+		r.conn.Close()
+		close(r.outbox)
+	})
+}
+
+func (r *Recorder) PreferredAuthMethod() connector.AuthMethod {
+	return r.conn.PreferredAuthMethod()
+}
+
+func (r *Recorder) RetryInterval() time.Duration {
+	return r.conn.RetryInterval()
+}
+
+func (r *Recorder) AllowedLatency() time.Duration {
+	return r.conn.AllowedLatency()
+}
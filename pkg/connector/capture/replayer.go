@@ -0,0 +1,232 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/signatures"
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// ErrCaptureExhausted indicates a client sent a message that does not correspond to any remaining
+// message in the capture file.
+var ErrCaptureExhausted = errors.New("no matching message in capture file")
+
+// Replayer is a connector.Connector that replays a capture file.
+type Replayer struct {
+	header     Header
+	privateKey protocol.ECDHPrivateKey
+	inbox      chan []byte
+
+	lock     sync.Mutex
+	closed   bool
+	records  []Record
+	consumed []bool
+	cursor   int // Index of the first record that has not been consumed or delivered
+
+	// Maps recorded request UUIDs and routing addresses to their live equivalents.
+	uuids     map[string][]byte
+	addresses map[string][]byte
+}
+
+// NewReplayer reads a capture file from r and returns a Connector that replays it.
+//
+// If privateKey is not nil, recorded session info is re-authenticated using privateKey so that a
+// client that uses privateKey accepts it. Otherwise session info is replayed as recorded, and
+// clients discard it unless they reuse the request UUIDs from the capture file.
+func NewReplayer(r io.Reader, privateKey protocol.ECDHPrivateKey) (*Replayer, error) {
+	header, records, err := ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	received := 0
+	for _, record := range records {
+		if record.Direction == DirectionReceive {
+			received++
+		}
+	}
+	replayer := &Replayer{
+		header:     *header,
+		privateKey: privateKey,
+		// The inbox is large enough to hold every recorded response, so that replaying a
+		// capture never blocks on a slow client.
+		inbox:     make(chan []byte, received),
+		records:   records,
+		consumed:  make([]bool, len(records)),
+		uuids:     make(map[string][]byte),
+		addresses: make(map[string][]byte),
+	}
+	// Deliver any messages the vehicle sent before the client's first message.
+	replayer.deliver()
+	return replayer, nil
+}
+
+// Remaining returns the number of recorded datagrams that have not been replayed yet. Tests can
+// use this to check that a client sent every recorded message.
+func (r *Replayer) Remaining() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	remaining := 0
+	for i := r.cursor; i < len(r.records); i++ {
+		if !r.consumed[i] {
+			remaining++
+		}
+	}
+	return remaining
+}
+
+// matches returns true if live plausibly corresponds to recorded.
+func matches(recorded, live *universal.RoutableMessage) bool {
+	if recorded.GetToDestination().GetDomain() != live.GetToDestination().GetDomain() {
+		return false
+	}
+	if (recorded.GetSessionInfoRequest() == nil) != (live.GetSessionInfoRequest() == nil) {
+		return false
+	}
+	return (recorded.GetSignatureData() == nil) == (live.GetSignatureData() == nil)
+}
+
+// Send consumes the first unused recorded message that matches buffer and queues the responses
+// that were recorded before the next outgoing message.
+func (r *Replayer) Send(ctx context.Context, buffer []byte) error {
+	var live universal.RoutableMessage
+	if err := proto.Unmarshal(buffer, &live); err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return protocol.ErrNotConnected
+	}
+
+	for i := r.cursor; i < len(r.records); i++ {
+		if r.consumed[i] || r.records[i].Direction != DirectionSend {
+			continue
+		}
+		recorded, err := r.records[i].Message()
+		if err != nil || !matches(recorded, &live) {
+			continue
+		}
+		r.consumed[i] = true
+		if uuid := recorded.GetUuid(); uuid != nil {
+			r.uuids[string(uuid)] = live.GetUuid()
+		}
+		if addr := recorded.GetFromDestination().GetRoutingAddress(); addr != nil {
+			r.addresses[string(addr)] = live.GetFromDestination().GetRoutingAddress()
+		}
+		r.deliver()
+		return nil
+	}
+	log.Warning("[%02x] Replay diverged from capture file", live.GetUuid())
+	return ErrCaptureExhausted
+}
+
+// deliver queues recorded responses until reaching a recorded message that the client hasn't sent
+// yet. The caller must hold r.lock.
+func (r *Replayer) deliver() {
+	for ; r.cursor < len(r.records); r.cursor++ {
+		if r.consumed[r.cursor] {
+			continue
+		}
+		record := &r.records[r.cursor]
+		if record.Direction == DirectionSend {
+			return
+		}
+		r.consumed[r.cursor] = true
+		r.inbox <- r.rewrite(record.Data)
+	}
+}
+
+// rewrite addresses a recorded response to the live client. The caller must hold r.lock.
+func (r *Replayer) rewrite(buffer []byte) []byte {
+	var message universal.RoutableMessage
+	if err := proto.Unmarshal(buffer, &message); err != nil {
+		// Replaying malformed messages verbatim is useful for reproducing failures.
+		return buffer
+	}
+	if addr, ok := r.addresses[string(message.GetToDestination().GetRoutingAddress())]; ok {
+		message.ToDestination = &universal.Destination{
+			SubDestination: &universal.Destination_RoutingAddress{RoutingAddress: addr},
+		}
+	}
+	uuid, ok := r.uuids[string(message.GetRequestUuid())]
+	if !ok {
+		return buffer
+	}
+	message.RequestUuid = uuid
+	if err := r.authenticateSessionInfo(&message); err != nil {
+		log.Warning("[%02x] Failed to authenticate replayed session info: %s", uuid, err)
+	}
+	rewritten, err := proto.Marshal(&message)
+	if err != nil {
+		return buffer
+	}
+	return rewritten
+}
+
+// authenticateSessionInfo replaces the session info tag in message, if any, with one that is valid
+// for r.privateKey and the message's request UUID.
+func (r *Replayer) authenticateSessionInfo(message *universal.RoutableMessage) error {
+	encodedInfo := message.GetSessionInfo()
+	if encodedInfo == nil || r.privateKey == nil || message.GetSignatureData().GetSessionInfoTag() == nil {
+		return nil
+	}
+	var info signatures.SessionInfo
+	if err := proto.Unmarshal(encodedInfo, &info); err != nil {
+		return err
+	}
+	if len(info.GetPublicKey()) == 0 {
+		return nil
+	}
+	session, err := r.privateKey.Exchange(info.GetPublicKey())
+	if err != nil {
+		return err
+	}
+	tag, err := session.SessionInfoHMAC([]byte(r.header.VIN), message.GetRequestUuid(), encodedInfo)
+	if err != nil {
+		return err
+	}
+	message.GetSignatureData().GetSessionInfoTag().Tag = tag
+	return nil
+}
+
+func (r *Replayer) Receive() <-chan []byte {
+	return r.inbox
+}
+
+func (r *Replayer) VIN() string {
+	return r.header.VIN
+}
+
+func (r *Replayer) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.inbox)
+	}
+}
+
+func (r *Replayer) PreferredAuthMethod() connector.AuthMethod {
+	return r.header.AuthMethod
+}
+
+// RetryInterval returns the recorded retry interval. Since replayed responses are queued
+// immediately, clients usually only wait this long if they diverge from the capture file.
+func (r *Replayer) RetryInterval() time.Duration {
+	return r.header.RetryInterval
+}
+
+func (r *Replayer) AllowedLatency() time.Duration {
+	return r.header.AllowedLatency
+}
@@ -0,0 +1,301 @@
+/*
+Package chaos implements a Connector that injects faults into the datagrams exchanged by another
+Connector.
+
+It's intended for testing how clients handle packet loss, reordering, corruption, and transport
+errors. For example, the following connection loses a tenth of the vehicle's responses and fails
+roughly a fifth of all transmissions with one of the errors returned by the inet package:
+
+	conn := chaos.NewConnection(car, chaos.Config{
+		Seed:       1,
+		Inbound:    chaos.Faults{Drop: 0.1},
+		SendErrors: chaos.InetErrors(0.05),
+	})
+
+Faults are selected using a pseudorandom number generator, so a test that uses the same Seed and
+sends the same sequence of datagrams experiences the same faults. Note that this only holds if
+the client does not call Send concurrently.
+*/
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/inet"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+// Faults configures the faults injected into datagrams travelling in one direction. Fields other
+// than MaxDelay are probabilities between 0 and 1. Multiple faults may be applied to the same
+// datagram.
+type Faults struct {
+	Drop      float64 // Datagram is discarded.
+	Duplicate float64 // Datagram is delivered twice.
+	Reorder   float64 // Datagram is held back and delivered after the next datagram.
+	Truncate  float64 // Datagram is truncated to a random length.
+	Corrupt   float64 // A random bit of the datagram is flipped.
+	Delay     float64 // Datagram is delayed by up to MaxDelay.
+
+	MaxDelay time.Duration
+}
+
+// ErrorFault causes Send to fail with Err.
+type ErrorFault struct {
+	Err         error
+	Probability float64
+	// If Delivered is true, the datagram is forwarded to the vehicle before Send returns Err. This
+	// emulates errors that occur after the vehicle receives a request, such as a lost HTTP
+	// response.
+	Delivered bool
+}
+
+// InetErrors returns ErrorFaults for the errors an inet.Connection returns when Fleet API or the
+// vehicle is unavailable. Each error is injected with the given probability.
+func InetErrors(probability float64) []ErrorFault {
+	return []ErrorFault{
+		{Err: inet.ErrVehicleNotAwake, Probability: probability},
+		{Err: &inet.HttpError{Code: http.StatusRequestTimeout}, Probability: probability},
+		{Err: &inet.HttpError{Code: http.StatusMisdirectedRequest}, Probability: probability},
+		{Err: &inet.HttpError{Code: http.StatusServiceUnavailable}, Probability: probability},
+		{Err: protocol.ErrProtocolNotSupported, Probability: probability},
+	}
+}
+
+// Config determines which faults a Connection injects.
+type Config struct {
+	Seed       int64
+	Outbound   Faults       // Faults applied to datagrams passed to Send.
+	Inbound    Faults       // Faults applied to datagrams received from the vehicle.
+	SendErrors []ErrorFault // Checked in order; at most one error is injected per call to Send.
+}
+
+// Stats counts the faults a Connection has injected.
+type Stats struct {
+	Dropped    int
+	Duplicated int
+	Reordered  int
+	Truncated  int
+	Corrupted  int
+	Delayed    int
+	Errors     int
+}
+
+// Connection is a connector.Connector that injects faults into an underlying Connector.
+type Connection struct {
+	conn   connector.Connector
+	outbox chan []byte
+
+	lock         sync.Mutex
+	config       Config
+	rng          *rand.Rand
+	stats        Stats
+	heldOutbound []byte
+	heldInbound  []byte
+
+	done      chan struct{}
+	finished  chan struct{}
+	closeOnce sync.Once
+}
+
+// NewConnection returns a Connection that injects faults into the datagrams exchanged by conn.
+// Closing the returned Connection closes conn.
+//
+// Use [NewFleetAPIConnection] instead if conn implements connector.FleetAPIConnector.
+func NewConnection(conn connector.Connector, config Config) *Connection {
+	c := &Connection{
+		conn:     conn,
+		outbox:   make(chan []byte, connector.BufferSize),
+		config:   config,
+		rng:      rand.New(rand.NewSource(config.Seed)),
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	go c.forward()
+	return c
+}
+
+// FleetAPIConnection is a Connection that preserves the connector.FleetAPIConnector methods of the
+// underlying connection. Faults are not injected into Fleet API commands.
+type FleetAPIConnection struct {
+	*Connection
+	fleetAPI connector.FleetAPIConnector
+}
+
+// NewFleetAPIConnection is equivalent to [NewConnection], but the returned value also implements
+// connector.FleetAPIConnector.
+func NewFleetAPIConnection(conn connector.FleetAPIConnector, config Config) *FleetAPIConnection {
+	return &FleetAPIConnection{Connection: NewConnection(conn, config), fleetAPI: conn}
+}
+
+func (f *FleetAPIConnection) SendFleetAPICommand(ctx context.Context, endpoint string, command interface{}) ([]byte, error) {
+	return f.fleetAPI.SendFleetAPICommand(ctx, endpoint, command)
+}
+
+func (f *FleetAPIConnection) Wakeup(ctx context.Context) error {
+	return f.fleetAPI.Wakeup(ctx)
+}
+
+// SetConfig replaces the fault configuration and reseeds the random number generator. This is
+// useful for injecting faults only after a client has connected to the vehicle.
+func (c *Connection) SetConfig(config Config) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.config = config
+	c.rng = rand.New(rand.NewSource(config.Seed))
+}
+
+// Stats returns the number of faults injected so far.
+func (c *Connection) Stats() Stats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.stats
+}
+
+// roll returns true with the given probability. The caller must hold c.lock.
+func (c *Connection) roll(probability float64) bool {
+	return probability > 0 && c.rng.Float64() < probability
+}
+
+// apply returns the datagrams that should be delivered, in order, in place of buffer, and how long
+// to wait before delivering them. The held parameter points to the datagram being held back for
+// reordering, if any. The caller must hold c.lock.
+func (c *Connection) apply(faults *Faults, held *[]byte, buffer []byte) ([][]byte, time.Duration) {
+	if c.roll(faults.Drop) {
+		c.stats.Dropped++
+		return nil, 0
+	}
+	if len(buffer) > 0 && c.roll(faults.Truncate) {
+		c.stats.Truncated++
+		buffer = append([]byte{}, buffer[:c.rng.Intn(len(buffer))]...)
+	}
+	if len(buffer) > 0 && c.roll(faults.Corrupt) {
+		c.stats.Corrupted++
+		buffer = append([]byte{}, buffer...)
+		bit := c.rng.Intn(8 * len(buffer))
+		buffer[bit/8] ^= 1 << (bit % 8)
+	}
+	if *held == nil && c.roll(faults.Reorder) {
+		c.stats.Reordered++
+		*held = buffer
+		return nil, 0
+	}
+	datagrams := [][]byte{buffer}
+	if c.roll(faults.Duplicate) {
+		c.stats.Duplicated++
+		datagrams = append(datagrams, buffer)
+	}
+	if *held != nil {
+		datagrams = append(datagrams, *held)
+		*held = nil
+	}
+	var delay time.Duration
+	if faults.MaxDelay > 0 && c.roll(faults.Delay) {
+		c.stats.Delayed++
+		delay = time.Duration(c.rng.Int63n(int64(faults.MaxDelay)))
+	}
+	return datagrams, delay
+}
+
+// Send forwards buffer to the underlying Connector after applying the configured outbound faults.
+// Delays are applied before Send returns.
+func (c *Connection) Send(ctx context.Context, buffer []byte) error {
+	c.lock.Lock()
+	var injected *ErrorFault
+	for i := range c.config.SendErrors {
+		if c.roll(c.config.SendErrors[i].Probability) {
+			c.stats.Errors++
+			injected = &c.config.SendErrors[i]
+			break
+		}
+	}
+	var datagrams [][]byte
+	var delay time.Duration
+	if injected == nil || injected.Delivered {
+		datagrams, delay = c.apply(&c.config.Outbound, &c.heldOutbound, buffer)
+	}
+	c.lock.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return &protocol.CommandError{Err: ctx.Err(), PossibleSuccess: false, PossibleTemporary: true}
+		}
+	}
+	for _, datagram := range datagrams {
+		if err := c.conn.Send(ctx, datagram); err != nil {
+			return err
+		}
+	}
+	if injected != nil {
+		log.Debug("[chaos] Injecting error: %s", injected.Err)
+		return injected.Err
+	}
+	return nil
+}
+
+func (c *Connection) forward() {
+	defer close(c.finished)
+	for {
+		select {
+		case buffer, ok := <-c.conn.Receive():
+			if !ok {
+				return
+			}
+			c.lock.Lock()
+			datagrams, delay := c.apply(&c.config.Inbound, &c.heldInbound, buffer)
+			c.lock.Unlock()
+			if delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-c.done:
+					return
+				}
+			}
+			for _, datagram := range datagrams {
+				select {
+				case c.outbox <- datagram:
+				case <-c.done:
+					return
+				}
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Connection) Receive() <-chan []byte {
+	return c.outbox
+}
+
+func (c *Connection) VIN() string {
+	return c.conn.VIN()
+}
+
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		<-c.finished
+		c.conn.Close()
+		close(c.outbox)
+	})
+}
+
+func (c *Connection) PreferredAuthMethod() connector.AuthMethod {
+	return c.conn.PreferredAuthMethod()
+}
+
+func (c *Connection) RetryInterval() time.Duration {
+	return c.conn.RetryInterval()
+}
+
+func (c *Connection) AllowedLatency() time.Duration {
+	return c.conn.AllowedLatency()
+}
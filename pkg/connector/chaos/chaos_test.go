@@ -0,0 +1,178 @@
+package chaos_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/chaos"
+	"github.com/teslamotors/vehicle-command/pkg/connector/connectortest"
+	"github.com/teslamotors/vehicle-command/pkg/connector/inet"
+	"github.com/teslamotors/vehicle-command/pkg/connector/sim"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+// connect returns an emulated car, a fault-injecting connection to it, and a Vehicle that has
+// established sessions over the connection. Faults are only enabled after sessions are
+// established.
+func connect(t *testing.T, ctx context.Context, config chaos.Config) (*sim.Connection, *chaos.Connection, *vehicle.Vehicle) {
+	t.Helper()
+	car, err := sim.NewConnection("0123456789ABCDEFG", connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+	conn := chaos.NewConnection(car, chaos.Config{})
+	v, err := vehicle.NewVehicle(conn, skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Disconnect)
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	conn.SetConfig(config)
+	return car, conn, v
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	ctx := testContext(t)
+	car, conn, v := connect(t, ctx, chaos.Config{
+		Seed: 1,
+		SendErrors: []chaos.ErrorFault{
+			{Err: &inet.HttpError{Code: http.StatusServiceUnavailable}, Probability: 0.5},
+		},
+	})
+	for i := 0; i < 5; i++ {
+		if err := v.Unlock(ctx); err != nil {
+			t.Fatalf("Unlock failed: %s", err)
+		}
+	}
+	if conn.Stats().Errors == 0 {
+		t.Error("No errors were injected")
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+}
+
+func TestTerminalError(t *testing.T) {
+	ctx := testContext(t)
+	_, _, v := connect(t, ctx, chaos.Config{
+		SendErrors: []chaos.ErrorFault{{Err: protocol.ErrProtocolNotSupported, Probability: 1}},
+	})
+	if err := v.Unlock(ctx); !errors.Is(err, protocol.ErrProtocolNotSupported) {
+		t.Errorf("Expected ErrProtocolNotSupported but got %v", err)
+	}
+}
+
+func TestDuplicateRequests(t *testing.T) {
+	ctx := testContext(t)
+	car, conn, v := connect(t, ctx, chaos.Config{
+		Outbound: chaos.Faults{Duplicate: 1},
+	})
+	// The vehicle rejects the duplicate as a replay, but the client should accept the response to
+	// the original.
+	if err := v.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+	if err := v.ChangeChargeLimit(ctx, 60); err != nil {
+		t.Fatalf("ChangeChargeLimit failed: %s", err)
+	}
+	if conn.Stats().Duplicated != 2 {
+		t.Errorf("Expected 2 duplicated datagrams but got %d", conn.Stats().Duplicated)
+	}
+	if limit := car.State().ChargeLimitPercent; limit != 60 {
+		t.Errorf("Unexpected charge limit: %d", limit)
+	}
+}
+
+func TestLostResponse(t *testing.T) {
+	ctx := testContext(t)
+	car, _, v := connect(t, ctx, chaos.Config{
+		Inbound: chaos.Faults{Drop: 1},
+	})
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := v.Unlock(shortCtx); !protocol.MayHaveSucceeded(err) {
+		t.Errorf("Expected ambiguous error but got %v", err)
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+}
+
+func TestCorruptedResponses(t *testing.T) {
+	ctx := testContext(t)
+	_, conn, v := connect(t, ctx, chaos.Config{
+		Seed:    2,
+		Inbound: chaos.Faults{Truncate: 0.5, Corrupt: 0.5},
+	})
+	// Corrupted responses may be misinterpreted or dropped, but they shouldn't crash the client.
+	for i := 0; i < 10; i++ {
+		shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		v.Unlock(shortCtx)
+		cancel()
+	}
+	stats := conn.Stats()
+	if stats.Truncated == 0 || stats.Corrupted == 0 {
+		t.Errorf("Expected truncated and corrupted datagrams: %+v", stats)
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	config := chaos.Config{
+		Seed:     3,
+		Outbound: chaos.Faults{Drop: 0.2, Duplicate: 0.2, Reorder: 0.2, Corrupt: 0.2, Truncate: 0.2},
+	}
+	var results []chaos.Stats
+	for i := 0; i < 2; i++ {
+		car, err := sim.NewConnection("0123456789ABCDEFG", connector.AuthMethodGCM)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := chaos.NewConnection(car, config)
+		for j := 0; j < 50; j++ {
+			if err := conn.Send(context.Background(), []byte{0x32, 0x00}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		conn.Close()
+		results = append(results, conn.Stats())
+	}
+	if !reflect.DeepEqual(results[0], results[1]) {
+		t.Errorf("Same seed produced different faults: %+v vs %+v", results[0], results[1])
+	}
+}
+
+func TestConformance(t *testing.T) {
+	// Without any faults configured, a chaos.Connection must behave like the connection it wraps.
+	connectortest.Run(t, func(t *testing.T) (connector.Connector, connectortest.Peer) {
+		pipe, peer := connectortest.NewPipe("0123456789ABCDEFG", connector.AuthMethodGCM)
+		return chaos.NewConnection(pipe, chaos.Config{}), peer
+	}, nil)
+}
@@ -2,6 +2,7 @@ package connector
 
 import (
 	"context"
+	"fmt"
 	"time"
 )
 
@@ -19,6 +20,18 @@ const (
 	AuthMethodHMAC
 )
 
+func (a AuthMethod) String() string {
+	switch a {
+	case AuthMethodNone:
+		return "none"
+	case AuthMethodGCM:
+		return "gcm"
+	case AuthMethodHMAC:
+		return "hmac"
+	}
+	return fmt.Sprintf("AuthMethod(%d)", int32(a))
+}
+
 // BufferSize is the number of inbound messages that can be queued.
 const BufferSize = 5
 
@@ -63,6 +76,30 @@ type Connector interface {
 	AllowedLatency() time.Duration
 }
 
+// MultipathConnector is implemented by Connectors that switch between several underlying transports,
+// such as BLE and Fleet API. The PreferredAuthMethod, RetryInterval, and AllowedLatency methods
+// describe the transport that's currently in use, and may return different values over time.
+type MultipathConnector interface {
+	Connector
+
+	// Transport returns the underlying Connector that's currently used to send datagrams. Clients
+	// can use the returned value to determine transport-specific capabilities, for example by
+	// checking if it implements FleetAPIConnector, but should not send datagrams through it
+	// directly.
+	Transport() Connector
+}
+
+// ReconnectingConnector is implemented by Connectors that transparently re-establish their link to
+// the vehicle after it's lost, such as when the vehicle moves out of BLE range and returns.
+type ReconnectingConnector interface {
+	Connector
+
+	// Reconnected returns a channel that receives a value each time the link is re-established.
+	// Datagrams sent or received around the time the link was lost may not have been delivered,
+	// and the vehicle may have restarted in the meantime.
+	Reconnected() <-chan struct{}
+}
+
 // FleetAPIConnector is a superset of Connector (which sends datagrams to vehicles) that also allows
 // sending commands to Fleet API.
 type FleetAPIConnector interface {
@@ -70,3 +107,18 @@ type FleetAPIConnector interface {
 	SendFleetAPICommand(ctx context.Context, endpoint string, command interface{}) ([]byte, error)
 	Wakeup(ctx context.Context) error
 }
+
+// AuthorizingConnector is implemented by Connectors that can authorize commands using sessions held
+// on the client's behalf, such as a connection to a daemon that has its own private key. Clients
+// don't need a private key or a handshake with the vehicle to send authenticated commands through
+// an AuthorizingConnector that Authorizes.
+type AuthorizingConnector interface {
+	Connector
+
+	// Authorizes returns true if commands can be sent using SendAuthorized.
+	Authorizes() bool
+
+	// SendAuthorized authorizes buffer, an encoded RoutableMessage without signature data, and
+	// sends it to the vehicle. Responses are received through the Receive channel, as usual.
+	SendAuthorized(ctx context.Context, buffer []byte) error
+}
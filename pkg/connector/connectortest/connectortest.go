@@ -0,0 +1,380 @@
+package connectortest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+// Peer is the vehicle end of a Connector under test.
+type Peer interface {
+	// Deliver sends a datagram from the vehicle to the Connector. Deliver may block if the
+	// Connector applies backpressure, but must not block indefinitely once the Connector is
+	// closed.
+	Deliver(datagram []byte)
+
+	// Sent returns a channel that receives datagrams the Connector sent to the vehicle.
+	Sent() <-chan []byte
+}
+
+// Disconnector is implemented by Peers that can emulate loss of the link to the vehicle. If the
+// Peer returned by a [Factory] implements Disconnector, [Run] checks that the Connector reports
+// the failure.
+type Disconnector interface {
+	Disconnect()
+}
+
+// Factory returns a new Connector and the Peer on the other end of it. Run calls Close on the
+// Connector when each test completes; use t.Cleanup to release other resources.
+type Factory func(t *testing.T) (connector.Connector, Peer)
+
+// Options adjusts the conformance suite for connectors with documented limitations.
+type Options struct {
+	// MaxResponseLength is the largest datagram the Connector must be able to receive. Defaults to
+	// connector.MaxResponseLength. Only lower this for transports with inherent limits, such as
+	// BLE.
+	MaxResponseLength int
+
+	// Timeout limits how long each test waits for the Connector. Defaults to 2 seconds.
+	Timeout time.Duration
+}
+
+func (o *Options) withDefaults() Options {
+	var opts Options
+	if o != nil {
+		opts = *o
+	}
+	if opts.MaxResponseLength == 0 {
+		opts.MaxResponseLength = connector.MaxResponseLength
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	return opts
+}
+
+type conformanceTest struct {
+	name string
+	run  func(t *testing.T, conn connector.Connector, peer Peer, opts Options)
+}
+
+var conformanceTests = []conformanceTest{
+	{"Metadata", testMetadata},
+	{"Send", testSend},
+	{"Receive", testReceive},
+	{"ConcurrentSend", testConcurrentSend},
+	{"ConcurrentReceive", testConcurrentReceive},
+	{"MaxResponseLength", testMaxResponseLength},
+	{"BufferSize", testBufferSize},
+	{"CanceledSend", testCanceledSend},
+	{"IdempotentClose", testIdempotentClose},
+	{"SendAfterClose", testSendAfterClose},
+	{"Disconnect", testDisconnect},
+}
+
+// Run executes the conformance suite as subtests of t. The factory is called once per subtest.
+// The opts may be nil.
+func Run(t *testing.T, factory Factory, opts *Options) {
+	options := opts.withDefaults()
+	for _, test := range conformanceTests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, peer := factory(t)
+			t.Cleanup(conn.Close)
+			test.run(t, conn, peer, options)
+		})
+	}
+}
+
+func testContext(t *testing.T, opts Options) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func randomDatagram(t *testing.T, length int) []byte {
+	t.Helper()
+	datagram := make([]byte, length)
+	if _, err := rand.Read(datagram); err != nil {
+		t.Fatal(err)
+	}
+	return datagram
+}
+
+// receive reads one datagram from conn.
+func receive(t *testing.T, ctx context.Context, conn connector.Connector) []byte {
+	t.Helper()
+	select {
+	case datagram, ok := <-conn.Receive():
+		if !ok {
+			t.Fatal("Receive channel closed unexpectedly")
+		}
+		return datagram
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for datagram from vehicle")
+	}
+	return nil
+}
+
+// sent reads one datagram that conn sent to peer.
+func sent(t *testing.T, ctx context.Context, peer Peer) []byte {
+	t.Helper()
+	select {
+	case datagram := <-peer.Sent():
+		return datagram
+	case <-ctx.Done():
+		t.Fatal("Timed out waiting for datagram to reach vehicle")
+	}
+	return nil
+}
+
+// finishes fails t if f doesn't return before ctx expires.
+func finishes(t *testing.T, ctx context.Context, description string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		t.Fatalf("%s did not return", description)
+	}
+}
+
+func testMetadata(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	if conn.VIN() == "" {
+		t.Error("VIN() returned an empty string")
+	}
+	if method := conn.PreferredAuthMethod(); method != connector.AuthMethodGCM && method != connector.AuthMethodHMAC {
+		t.Errorf("PreferredAuthMethod() returned unsupported value %d", method)
+	}
+	if conn.RetryInterval() <= 0 {
+		t.Errorf("RetryInterval() returned non-positive duration %s", conn.RetryInterval())
+	}
+	if conn.AllowedLatency() <= 0 {
+		t.Errorf("AllowedLatency() returned non-positive duration %s", conn.AllowedLatency())
+	}
+}
+
+func testSend(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	ctx := testContext(t, opts)
+	datagram := randomDatagram(t, 64)
+	if err := conn.Send(ctx, datagram); err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+	// Connectors may not retain references to the caller's buffer.
+	expected := append([]byte{}, datagram...)
+	datagram[0] ^= 0xFF
+	if received := sent(t, ctx, peer); !bytes.Equal(received, expected) {
+		t.Errorf("Vehicle received %02x instead of %02x", received, expected)
+	}
+}
+
+func testReceive(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	ctx := testContext(t, opts)
+	datagram := randomDatagram(t, 64)
+	go peer.Deliver(datagram)
+	if received := receive(t, ctx, conn); !bytes.Equal(received, datagram) {
+		t.Errorf("Received %02x instead of %02x", received, datagram)
+	}
+}
+
+func testConcurrentSend(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	const senders = 8
+	const datagramsPerSender = 10
+	ctx := testContext(t, opts)
+
+	expected := make(map[string]bool)
+	var wg sync.WaitGroup
+	errs := make(chan error, senders*datagramsPerSender)
+	for i := 0; i < senders; i++ {
+		var datagrams [][]byte
+		for j := 0; j < datagramsPerSender; j++ {
+			datagram := []byte(fmt.Sprintf("sender %d datagram %d", i, j))
+			expected[string(datagram)] = true
+			datagrams = append(datagrams, datagram)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, datagram := range datagrams {
+				if err := conn.Send(ctx, datagram); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	for len(expected) > 0 {
+		select {
+		case err := <-errs:
+			t.Fatalf("Concurrent Send failed: %s", err)
+		default:
+		}
+		datagram := sent(t, ctx, peer)
+		if !expected[string(datagram)] {
+			t.Fatalf("Vehicle received unexpected or duplicate datagram %q", datagram)
+		}
+		delete(expected, string(datagram))
+	}
+	wg.Wait()
+}
+
+func testConcurrentReceive(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	const readers = 4
+	const count = 4 * connector.BufferSize
+	ctx := testContext(t, opts)
+
+	results := make(chan []byte, count)
+	for i := 0; i < readers; i++ {
+		go func() {
+			for {
+				select {
+				case datagram, ok := <-conn.Receive():
+					if !ok {
+						return
+					}
+					results <- datagram
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		for i := 0; i < count; i++ {
+			peer.Deliver([]byte(fmt.Sprintf("datagram %d", i)))
+		}
+	}()
+
+	seen := make(map[string]bool)
+	for len(seen) < count {
+		select {
+		case datagram := <-results:
+			if seen[string(datagram)] {
+				t.Fatalf("Received duplicate datagram %q", datagram)
+			}
+			seen[string(datagram)] = true
+		case <-ctx.Done():
+			t.Fatalf("Received %d of %d datagrams", len(seen), count)
+		}
+	}
+}
+
+func testMaxResponseLength(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	ctx := testContext(t, opts)
+	datagram := randomDatagram(t, opts.MaxResponseLength)
+	go peer.Deliver(datagram)
+	if received := receive(t, ctx, conn); !bytes.Equal(received, datagram) {
+		t.Errorf("Received %d-byte datagram that doesn't match the %d-byte datagram sent by the vehicle", len(received), len(datagram))
+	}
+}
+
+func testBufferSize(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	ctx := testContext(t, opts)
+	var datagrams [][]byte
+	for i := 0; i < connector.BufferSize; i++ {
+		datagrams = append(datagrams, randomDatagram(t, 32))
+	}
+	// The Connector must queue BufferSize datagrams even if the client isn't reading them yet.
+	finishes(t, ctx, "Delivering BufferSize datagrams without reading them", func() {
+		for _, datagram := range datagrams {
+			peer.Deliver(datagram)
+		}
+	})
+	pending := make(map[string]bool)
+	for _, datagram := range datagrams {
+		pending[string(datagram)] = true
+	}
+	for len(pending) > 0 {
+		datagram := receive(t, ctx, conn)
+		if !pending[string(datagram)] {
+			t.Fatalf("Received unexpected or duplicate datagram %02x", datagram)
+		}
+		delete(pending, string(datagram))
+	}
+}
+
+func testCanceledSend(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	ctx := testContext(t, opts)
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	datagram := randomDatagram(t, 16)
+	var err error
+	finishes(t, ctx, "Send with a canceled context", func() {
+		err = conn.Send(canceled, datagram)
+	})
+	// Connectors may transmit datagrams even if the context is canceled, but if Send fails the
+	// error must allow the client to determine whether to retry.
+	var commandErr protocol.Error
+	if err != nil && !errors.As(err, &commandErr) {
+		t.Errorf("Send returned %q, which doesn't implement protocol.Error", err)
+	}
+}
+
+func testIdempotentClose(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	ctx := testContext(t, opts)
+	finishes(t, ctx, "Close", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn.Close()
+			}()
+		}
+		wg.Wait()
+		conn.Close()
+	})
+}
+
+func testSendAfterClose(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	ctx := testContext(t, opts)
+	conn.Close()
+	var err error
+	finishes(t, ctx, "Send after Close", func() {
+		err = conn.Send(ctx, randomDatagram(t, 16))
+	})
+	if err == nil {
+		t.Fatal("Send succeeded after Close")
+	}
+	var commandErr protocol.Error
+	if !errors.As(err, &commandErr) {
+		t.Errorf("Send returned %q after Close, which doesn't implement protocol.Error", err)
+	}
+}
+
+func testDisconnect(t *testing.T, conn connector.Connector, peer Peer, opts Options) {
+	disconnector, ok := peer.(Disconnector)
+	if !ok {
+		t.Skip("Peer does not implement Disconnector")
+	}
+	ctx := testContext(t, opts)
+	disconnector.Disconnect()
+	// Connectors may take some time to detect the failure.
+	for {
+		err := conn.Send(ctx, randomDatagram(t, 16))
+		if err != nil {
+			var commandErr protocol.Error
+			if !errors.As(err, &commandErr) {
+				t.Errorf("Send returned %q after disconnect, which doesn't implement protocol.Error", err)
+			}
+			return
+		}
+		select {
+		case <-peer.Sent():
+		case <-ctx.Done():
+			t.Fatal("Send did not fail after the vehicle disconnected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
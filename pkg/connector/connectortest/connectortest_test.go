@@ -0,0 +1,14 @@
+package connectortest_test
+
+import (
+	"testing"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/connectortest"
+)
+
+func TestPipe(t *testing.T) {
+	connectortest.Run(t, func(t *testing.T) (connector.Connector, connectortest.Peer) {
+		return connectortest.NewPipe("0123456789ABCDEFG", connector.AuthMethodGCM)
+	}, nil)
+}
@@ -0,0 +1,22 @@
+/*
+Package connectortest provides a conformance suite for connector.Connector implementations.
+
+The connector.Connector interface documents several requirements that the vehicle package relies
+on but that the type system can't enforce: Send and Receive must be thread safe, Close must be
+idempotent, the Receive channel must be able to queue connector.BufferSize datagrams and must
+support datagrams of connector.MaxResponseLength bytes, and errors should implement
+protocol.Error so that clients can decide whether to retry. [Run] checks these requirements.
+
+The suite needs to control the far end of the connection. Connectors that talk to a vehicle
+through some other process (a relay, a serial adapter, etc.) typically do so by wrapping a
+connector or server that tests can control. [NewPipe] returns an in-memory connection and a [Peer]
+that emulates the vehicle end of it, which is convenient for testing such wrappers:
+
+	func TestConformance(t *testing.T) {
+		connectortest.Run(t, func(t *testing.T) (connector.Connector, connectortest.Peer) {
+			vehicle, peer := connectortest.NewPipe("0123456789ABCDEFG", connector.AuthMethodGCM)
+			return myrelay.New(vehicle), peer
+		}, nil)
+	}
+*/
+package connectortest
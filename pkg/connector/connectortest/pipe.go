@@ -0,0 +1,133 @@
+package connectortest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+// Pipe is an in-memory connector.Connector. Datagrams sent through the Pipe are delivered to its
+// [PipePeer], and vice versa.
+type Pipe struct {
+	vin        string
+	authMethod connector.AuthMethod
+	inbox      chan []byte
+	sent       chan []byte
+
+	lock         sync.Mutex
+	closed       bool
+	disconnected bool
+	done         chan struct{}
+
+	// deliverLock prevents the inbox from being closed while PipePeer.Deliver is writing to it.
+	deliverLock sync.RWMutex
+}
+
+// PipePeer is the vehicle end of a [Pipe]. It implements [Peer] and [Disconnector].
+type PipePeer struct {
+	pipe *Pipe
+}
+
+// NewPipe returns a connected Pipe and PipePeer.
+func NewPipe(vin string, authMethod connector.AuthMethod) (*Pipe, *PipePeer) {
+	p := &Pipe{
+		vin:        vin,
+		authMethod: authMethod,
+		inbox:      make(chan []byte, connector.BufferSize),
+		sent:       make(chan []byte, connector.BufferSize),
+		done:       make(chan struct{}),
+	}
+	return p, &PipePeer{pipe: p}
+}
+
+func (p *Pipe) Send(ctx context.Context, buffer []byte) error {
+	p.lock.Lock()
+	stopped := p.closed || p.disconnected
+	p.lock.Unlock()
+	if stopped {
+		return protocol.ErrNotConnected
+	}
+	datagram := append([]byte{}, buffer...)
+	select {
+	case p.sent <- datagram:
+		return nil
+	case <-p.done:
+		return protocol.ErrNotConnected
+	case <-ctx.Done():
+		return &protocol.CommandError{Err: ctx.Err(), PossibleSuccess: false, PossibleTemporary: true}
+	}
+}
+
+func (p *Pipe) Receive() <-chan []byte {
+	return p.inbox
+}
+
+func (p *Pipe) VIN() string {
+	return p.vin
+}
+
+// Close closes p. Subsequent calls to Send fail with protocol.ErrNotConnected.
+func (p *Pipe) Close() {
+	p.lock.Lock()
+	p.closed = true
+	p.lock.Unlock()
+	p.stop()
+}
+
+// stop unblocks pending calls and closes the inbox.
+func (p *Pipe) stop() {
+	p.lock.Lock()
+	if p.disconnected {
+		p.lock.Unlock()
+		return
+	}
+	p.disconnected = true
+	close(p.done)
+	p.lock.Unlock()
+
+	p.deliverLock.Lock()
+	close(p.inbox)
+	p.deliverLock.Unlock()
+}
+
+func (p *Pipe) PreferredAuthMethod() connector.AuthMethod {
+	return p.authMethod
+}
+
+func (p *Pipe) RetryInterval() time.Duration {
+	return 10 * time.Millisecond
+}
+
+func (p *Pipe) AllowedLatency() time.Duration {
+	return time.Second
+}
+
+// Deliver sends a datagram to the Pipe's Receive channel. Blocks if the channel is full, and
+// discards the datagram if the Pipe is closed.
+func (p *PipePeer) Deliver(datagram []byte) {
+	p.pipe.deliverLock.RLock()
+	defer p.pipe.deliverLock.RUnlock()
+	select {
+	case <-p.pipe.done:
+		return
+	default:
+	}
+	select {
+	case p.pipe.inbox <- append([]byte{}, datagram...):
+	case <-p.pipe.done:
+	}
+}
+
+// Sent returns a channel that receives datagrams passed to the Pipe's Send method.
+func (p *PipePeer) Sent() <-chan []byte {
+	return p.pipe.sent
+}
+
+// Disconnect emulates loss of the link to the vehicle. The Pipe's Receive channel is closed and
+// subsequent calls to Send fail with protocol.ErrNotConnected.
+func (p *PipePeer) Disconnect() {
+	p.pipe.stop()
+}
@@ -0,0 +1,318 @@
+/*
+Package hybrid implements a connector.Connector that prefers a local connection to a vehicle,
+typically BLE, and falls back to Fleet API when the local connection is unavailable.
+
+A [Connection] tries to establish a local connection when it's created and, whenever the local
+connection is down, periodically tries to re-establish it in the background. Datagrams are sent
+over the local connection when it's up and over Fleet API otherwise. Responses from both
+transports are merged into a single Receive() channel, so responses to requests sent before a
+transport switch are still delivered.
+
+Vehicles accept AES-GCM authenticated commands over BLE, but Fleet API only forwards
+HMAC-authenticated commands. A Connection therefore implements connector.MultipathConnector: its
+PreferredAuthMethod, RetryInterval, and AllowedLatency reflect the transport that's currently in
+use. If the local connection fails while sending a message, or if a message authorized for the
+local connection would have to be sent over Fleet API, Send returns protocol.ErrTransportChanged
+without sending the message. A vehicle.Vehicle responds to this error by re-authorizing the message
+using the new transport's preferred method and trying again. Sessions with the vehicle don't depend on the
+transport, so switching transports doesn't require a new handshake; if the vehicle's session state
+has changed, the usual session-resynchronization logic applies.
+*/
+package hybrid
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+const (
+	// DefaultDialTimeout is the default time limit for each attempt to establish a local
+	// connection.
+	DefaultDialTimeout = 10 * time.Second
+	// DefaultReconnectInterval is the default delay between attempts to establish a local
+	// connection while it's unavailable.
+	DefaultReconnectInterval = 30 * time.Second
+)
+
+// Dialer establishes a local connection to a vehicle. Dialers must return promptly when ctx
+// expires.
+type Dialer func(ctx context.Context) (connector.Connector, error)
+
+// Config controls how a Connection manages its local transport.
+type Config struct {
+	// DialTimeout limits each attempt to establish a local connection. Defaults to
+	// DefaultDialTimeout.
+	DialTimeout time.Duration
+	// ReconnectInterval is the delay between attempts to establish a local connection while it's
+	// unavailable. Defaults to DefaultReconnectInterval.
+	ReconnectInterval time.Duration
+}
+
+// link is a local connection and a channel that stops its forwarding goroutine.
+type link struct {
+	conn connector.Connector
+	stop chan struct{}
+}
+
+// Connection implements connector.MultipathConnector and connector.FleetAPIConnector.
+type Connection struct {
+	remote    connector.FleetAPIConnector
+	dialLocal Dialer
+	config    Config
+	inbox     chan []byte
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	lost   chan struct{}
+	wg     sync.WaitGroup
+
+	lock   sync.Mutex
+	local  *link
+	closed bool
+}
+
+// NewConnection returns a Connection that uses dialLocal to reach the vehicle when possible and
+// remote otherwise. Before returning, NewConnection makes one attempt to establish a local
+// connection, subject to ctx and config.DialTimeout.
+//
+// The Connection takes ownership of remote and of local connections returned by dialLocal, and
+// closes them when it's closed.
+func NewConnection(ctx context.Context, remote connector.FleetAPIConnector, dialLocal Dialer, config Config) *Connection {
+	if config.DialTimeout <= 0 {
+		config.DialTimeout = DefaultDialTimeout
+	}
+	if config.ReconnectInterval <= 0 {
+		config.ReconnectInterval = DefaultReconnectInterval
+	}
+	c := &Connection{
+		remote:    remote,
+		dialLocal: dialLocal,
+		config:    config,
+		inbox:     make(chan []byte, connector.BufferSize),
+		lost:      make(chan struct{}, 1),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	c.wg.Add(1)
+	go c.forward(remote.Receive(), nil)
+
+	dialCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		// Allow Close to interrupt the initial dial.
+		select {
+		case <-c.ctx.Done():
+			cancel()
+		case <-dialCtx.Done():
+		}
+	}()
+	c.dial(dialCtx)
+	cancel()
+
+	c.wg.Add(1)
+	go c.maintainLocal()
+	return c
+}
+
+// dial makes one attempt to establish a local connection. Returns true on success.
+func (c *Connection) dial(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, c.config.DialTimeout)
+	defer cancel()
+	conn, err := c.dialLocal(ctx)
+	if err != nil {
+		log.Debug("Local connection unavailable: %s", err)
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		conn.Close()
+		return false
+	}
+	l := &link{conn: conn, stop: make(chan struct{})}
+	c.local = l
+	c.wg.Add(1)
+	go c.forward(conn.Receive(), l)
+	log.Info("Using local connection to vehicle")
+	return true
+}
+
+// maintainLocal re-establishes the local connection whenever it's lost.
+func (c *Connection) maintainLocal() {
+	defer c.wg.Done()
+	for {
+		c.lock.Lock()
+		up := c.local != nil
+		c.lock.Unlock()
+
+		var retry <-chan time.Time
+		if !up {
+			if c.dial(c.ctx) {
+				continue
+			}
+			retry = time.After(c.config.ReconnectInterval)
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.lost:
+		case <-retry:
+		}
+	}
+}
+
+// forward copies datagrams from a transport to c.inbox. If l is not nil, it identifies the local
+// connection that owns datagrams.
+func (c *Connection) forward(datagrams <-chan []byte, l *link) {
+	defer c.wg.Done()
+	var stop <-chan struct{}
+	if l != nil {
+		stop = l.stop
+	}
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-stop:
+			return
+		case buffer, ok := <-datagrams:
+			if !ok {
+				if l != nil {
+					c.dropLocal(l, "connection closed")
+				} else {
+					log.Warning("Fleet API connection closed")
+				}
+				return
+			}
+			select {
+			case c.inbox <- buffer:
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// dropLocal closes l if it's the current local connection and switches to Fleet API.
+func (c *Connection) dropLocal(l *link, reason string) {
+	c.lock.Lock()
+	if c.local != l {
+		c.lock.Unlock()
+		return
+	}
+	c.local = nil
+	c.lock.Unlock()
+
+	log.Warning("Local connection to vehicle lost (%s); using Fleet API", reason)
+	close(l.stop)
+	l.conn.Close()
+	select {
+	case c.lost <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Connection) current() (*link, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.local, c.closed
+}
+
+// Transport returns the connection that's currently used to send datagrams.
+func (c *Connection) Transport() connector.Connector {
+	if l, _ := c.current(); l != nil {
+		return l.conn
+	}
+	return c.remote
+}
+
+// Send transmits buffer over the local connection if it's available and over Fleet API otherwise.
+func (c *Connection) Send(ctx context.Context, buffer []byte) error {
+	l, closed := c.current()
+	if closed {
+		return protocol.ErrNotConnected
+	}
+	if l != nil {
+		err := l.conn.Send(ctx, buffer)
+		if err == nil || ctx.Err() != nil || protocol.MayHaveSucceeded(err) {
+			return err
+		}
+		c.dropLocal(l, err.Error())
+		return protocol.ErrTransportChanged
+	}
+
+	var message universal.RoutableMessage
+	if err := proto.Unmarshal(buffer, &message); err == nil {
+		if message.GetToDestination() == nil {
+			// Messages that aren't wrapped in a RoutableMessage, such as add-key requests, can
+			// only be sent over BLE.
+			return protocol.ErrRequiresBLE
+		}
+		if message.GetSignatureData().GetAES_GCM_PersonalizedData() != nil {
+			return protocol.ErrTransportChanged
+		}
+	}
+	return c.remote.Send(ctx, buffer)
+}
+
+func (c *Connection) Receive() <-chan []byte {
+	return c.inbox
+}
+
+func (c *Connection) VIN() string {
+	return c.remote.VIN()
+}
+
+// Close terminates both transports. Blocks until any in-progress attempt to establish a local
+// connection has returned.
+func (c *Connection) Close() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	l := c.local
+	c.local = nil
+	c.lock.Unlock()
+
+	c.cancel()
+	if l != nil {
+		l.conn.Close()
+	}
+	c.remote.Close()
+	c.wg.Wait()
+	close(c.inbox)
+}
+
+func (c *Connection) PreferredAuthMethod() connector.AuthMethod {
+	return c.Transport().PreferredAuthMethod()
+}
+
+func (c *Connection) RetryInterval() time.Duration {
+	return c.Transport().RetryInterval()
+}
+
+func (c *Connection) AllowedLatency() time.Duration {
+	return c.Transport().AllowedLatency()
+}
+
+// SendFleetAPICommand sends a command to Fleet API, regardless of which transport is in use.
+func (c *Connection) SendFleetAPICommand(ctx context.Context, endpoint string, command interface{}) ([]byte, error) {
+	return c.remote.SendFleetAPICommand(ctx, endpoint, command)
+}
+
+// Wakeup asks Fleet API to wake the vehicle. A vehicle.Vehicle uses its Transport to decide whether
+// to wake the vehicle over BLE instead.
+func (c *Connection) Wakeup(ctx context.Context) error {
+	return c.remote.Wakeup(ctx)
+}
@@ -0,0 +1,264 @@
+package hybrid_test
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/hybrid"
+	"github.com/teslamotors/vehicle-command/pkg/connector/sim"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+var (
+	errOutOfRange = errors.New("vehicle out of range")
+	errEncrypted  = errors.New("fleet api rejects encrypted commands")
+)
+
+// carLock serializes access to the emulated vehicle, so that each link can collect the response
+// to its own request.
+var carLock sync.Mutex
+
+// link is a transport to an emulated vehicle that's shared with other links.
+type link struct {
+	car        *sim.Connection
+	authMethod connector.AuthMethod
+	inbox      chan []byte
+
+	lock   sync.Mutex
+	down   bool
+	closed bool
+	sent   int
+}
+
+func newLink(car *sim.Connection, authMethod connector.AuthMethod) *link {
+	return &link{car: car, authMethod: authMethod, inbox: make(chan []byte, connector.BufferSize)}
+}
+
+func (l *link) cut() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.down = true
+}
+
+func (l *link) count() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.sent
+}
+
+func (l *link) Send(ctx context.Context, buffer []byte) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.down || l.closed {
+		return errOutOfRange
+	}
+	if l.authMethod == connector.AuthMethodHMAC {
+		var message universal.RoutableMessage
+		if err := proto.Unmarshal(buffer, &message); err == nil && message.GetSignatureData().GetAES_GCM_PersonalizedData() != nil {
+			return protocol.NewError(errEncrypted.Error(), false, false)
+		}
+	}
+	l.sent++
+
+	carLock.Lock()
+	defer carLock.Unlock()
+	if err := l.car.Send(ctx, buffer); err != nil {
+		return err
+	}
+	select {
+	case reply := <-l.car.Receive():
+		l.inbox <- reply
+	default:
+	}
+	return nil
+}
+
+func (l *link) Receive() <-chan []byte                    { return l.inbox }
+func (l *link) VIN() string                               { return l.car.VIN() }
+func (l *link) PreferredAuthMethod() connector.AuthMethod { return l.authMethod }
+func (l *link) RetryInterval() time.Duration              { return l.car.RetryInterval() }
+func (l *link) AllowedLatency() time.Duration             { return l.car.AllowedLatency() }
+func (l *link) Wakeup(ctx context.Context) error          { return nil }
+func (l *link) SendFleetAPICommand(context.Context, string, interface{}) ([]byte, error) {
+	return nil, nil
+}
+
+func (l *link) Close() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.closed = true
+}
+
+// harness connects a Vehicle to an emulated car using a hybrid connection.
+type harness struct {
+	car    *sim.Connection
+	remote *link
+	conn   *hybrid.Connection
+	v      *vehicle.Vehicle
+
+	lock    sync.Mutex
+	inRange bool
+	local   *link
+}
+
+func (h *harness) dial(ctx context.Context) (connector.Connector, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if !h.inRange {
+		return nil, errOutOfRange
+	}
+	h.local = newLink(h.car, connector.AuthMethodGCM)
+	return h.local, nil
+}
+
+func (h *harness) setInRange(inRange bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.inRange = inRange
+	if !inRange && h.local != nil {
+		h.local.cut()
+	}
+}
+
+func (h *harness) localLink() *link {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.local
+}
+
+func newHarness(t *testing.T, ctx context.Context, inRange bool) *harness {
+	t.Helper()
+	car, err := sim.NewConnection("0123456789ABCDEFG", connector.AuthMethodHMAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+	h := &harness{car: car, remote: newLink(car, connector.AuthMethodHMAC), inRange: inRange}
+	h.conn = hybrid.NewConnection(ctx, h.remote, h.dial, hybrid.Config{ReconnectInterval: 10 * time.Millisecond})
+	h.v, err = vehicle.NewVehicle(h.conn, skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.v.Disconnect)
+	if err := h.v.StartSession(ctx, nil); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	return h
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestPrefersLocal(t *testing.T) {
+	ctx := testContext(t)
+	h := newHarness(t, ctx, true)
+	if err := h.v.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+	if h.remote.count() != 0 {
+		t.Errorf("Sent %d datagrams over Fleet API while local connection was available", h.remote.count())
+	}
+	if h.conn.PreferredAuthMethod() != connector.AuthMethodGCM {
+		t.Errorf("Unexpected auth method: %d", h.conn.PreferredAuthMethod())
+	}
+}
+
+func TestFallback(t *testing.T) {
+	ctx := testContext(t)
+	h := newHarness(t, ctx, false)
+	if h.conn.Transport() != connector.Connector(h.remote) {
+		t.Fatal("Expected Fleet API transport when vehicle is out of range")
+	}
+	if err := h.v.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+	if state := h.car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+}
+
+func TestSwitchMidSession(t *testing.T) {
+	ctx := testContext(t)
+	h := newHarness(t, ctx, true)
+	if err := h.v.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+
+	// The next command is authorized for BLE, but has to be re-authorized for Fleet API.
+	h.setInRange(false)
+	if err := h.v.ChangeChargeLimit(ctx, 65); err != nil {
+		t.Fatalf("ChangeChargeLimit failed after leaving BLE range: %s", err)
+	}
+	if err := h.v.Lock(ctx); err != nil {
+		t.Fatalf("Lock failed after leaving BLE range: %s", err)
+	}
+	if h.remote.count() == 0 {
+		t.Error("Expected datagrams to be sent over Fleet API")
+	}
+	newKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.v.SendAddKeyRequest(ctx, newKey.PublicKey(), false, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); !errors.Is(err, protocol.ErrRequiresBLE) {
+		t.Errorf("Expected ErrRequiresBLE but got %v", err)
+	}
+
+	// Return to BLE range.
+	h.setInRange(true)
+	for h.conn.Transport() == connector.Connector(h.remote) {
+		select {
+		case <-ctx.Done():
+			t.Fatal("Local connection was not re-established")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	sent := h.remote.count()
+	if err := h.v.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed after returning to BLE range: %s", err)
+	}
+	if h.remote.count() != sent || h.localLink().count() == 0 {
+		t.Error("Expected datagrams to be sent over local connection")
+	}
+	if limit := h.car.State().ChargeLimitPercent; limit != 65 {
+		t.Errorf("Unexpected charge limit: %d", limit)
+	}
+}
+
+func TestClose(t *testing.T) {
+	ctx := testContext(t)
+	h := newHarness(t, ctx, true)
+	h.conn.Close()
+	h.conn.Close()
+	if err := h.conn.Send(ctx, []byte{0x32, 0x00}); !errors.Is(err, protocol.ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected but got %v", err)
+	}
+	if _, ok := <-h.conn.Receive(); ok {
+		t.Error("Receive channel was not closed")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -16,6 +17,7 @@ import (
 	"github.com/teslamotors/vehicle-command/internal/log"
 	"github.com/teslamotors/vehicle-command/pkg/connector"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/tracing"
 )
 
 // MaxLatency is the default maximum latency permitted when updating the vehicle clock estimate.
@@ -66,6 +68,11 @@ func (e *HttpError) Error() string {
 	return e.Message
 }
 
+// HTTPStatus returns the HTTP status code returned by the server. See protocol.ErrorClass.
+func (e *HttpError) HTTPStatus() int {
+	return e.Code
+}
+
 func (e *HttpError) MayHaveSucceeded() bool {
 	if e.Code >= 400 && e.Code < 500 {
 		return false
@@ -81,6 +88,14 @@ func (e *HttpError) Temporary() bool {
 }
 
 func SendFleetAPICommand(ctx context.Context, client *http.Client, userAgent, authHeader string, url string, command interface{}) ([]byte, error) {
+	ctx, span := tracing.Start(ctx, "inet.SendFleetAPICommand")
+	span.SetAttribute(tracing.AttrEndpoint, url)
+	body, err := sendFleetAPICommand(ctx, span, client, userAgent, authHeader, url, command)
+	span.End(err)
+	return body, err
+}
+
+func sendFleetAPICommand(ctx context.Context, span tracing.Span, client *http.Client, userAgent, authHeader string, url string, command interface{}) ([]byte, error) {
 	var body []byte
 	var ok bool
 	if body, ok = command.([]byte); !ok {
@@ -106,6 +121,7 @@ func SendFleetAPICommand(ctx context.Context, client *http.Client, userAgent, au
 		return nil, &protocol.CommandError{Err: err, PossibleSuccess: false, PossibleTemporary: true}
 	}
 	defer result.Body.Close()
+	span.SetAttribute(tracing.AttrHTTPStatus, strconv.Itoa(result.StatusCode))
 
 	body = make([]byte, connector.MaxResponseLength+1)
 	body, err = readWithContext(ctx, result.Body, body)
@@ -0,0 +1,162 @@
+package mux
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+// Connection implements connector.Connector by sharing a [Server]'s vehicle connection. If the
+// Server holds sessions with the vehicle, Connection also implements
+// connector.AuthorizingConnector.
+type Connection struct {
+	vin            string
+	authorizes     bool
+	authMethod     connector.AuthMethod
+	retryInterval  time.Duration
+	allowedLatency time.Duration
+
+	conn  net.Conn
+	inbox chan []byte
+
+	writeLock sync.Mutex
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// Dial connects to the Server listening on the Unix domain socket at path and requests a
+// connection to the vehicle with the provided vin.
+func Dial(ctx context.Context, path, vin string) (*Connection, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, err
+	}
+	c, err := handshake(ctx, conn, vin)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go c.listen()
+	return c, nil
+}
+
+func handshake(ctx context.Context, conn net.Conn, vin string) (*Connection, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if err := writeJSON(conn, &hello{Version: protocolVersion, VIN: vin}); err != nil {
+		return nil, err
+	}
+	var reply accept
+	if err := readJSON(conn, &reply); err != nil {
+		return nil, err
+	}
+	if reply.Error != "" {
+		return nil, &RejectedError{Reason: reply.Error}
+	}
+	return &Connection{
+		vin:            vin,
+		authorizes:     reply.Authorizes,
+		authMethod:     reply.AuthMethod,
+		retryInterval:  reply.RetryInterval,
+		allowedLatency: reply.AllowedLatency,
+		conn:           conn,
+		inbox:          make(chan []byte, connector.BufferSize),
+		done:           make(chan struct{}),
+	}, nil
+}
+
+func (c *Connection) listen() {
+	defer close(c.inbox)
+	for {
+		payload, err := readFrame(c.conn)
+		if err != nil {
+			select {
+			case <-c.done:
+			default:
+				log.Warning("Mux connection terminated: %s", err)
+			}
+			return
+		}
+		select {
+		case c.inbox <- payload:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Connection) Send(ctx context.Context, buffer []byte) error {
+	return c.send(ctx, frameRelay, buffer)
+}
+
+// Authorizes returns true if the Server holds sessions with the vehicle and authorizes commands
+// sent using SendAuthorized.
+func (c *Connection) Authorizes() bool {
+	return c.authorizes
+}
+
+// SendAuthorized asks the Server to authorize buffer, an encoded RoutableMessage without signature
+// data, using its sessions with the vehicle and then send it.
+func (c *Connection) SendAuthorized(ctx context.Context, buffer []byte) error {
+	if !c.authorizes {
+		return protocol.ErrRequiresKey
+	}
+	return c.send(ctx, frameAuthorize, buffer)
+}
+
+func (c *Connection) send(ctx context.Context, kind byte, buffer []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	select {
+	case <-c.done:
+		return protocol.ErrNotConnected
+	default:
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+		defer c.conn.SetWriteDeadline(time.Time{})
+	}
+	if err := writeFrame(c.conn, append([]byte{kind}, buffer...)); err != nil {
+		if ctx.Err() != nil {
+			return &protocol.CommandError{Err: ctx.Err(), PossibleSuccess: true, PossibleTemporary: false}
+		}
+		log.Warning("Failed to write to mux: %s", err)
+		return protocol.ErrNotConnected
+	}
+	return nil
+}
+
+func (c *Connection) Receive() <-chan []byte {
+	return c.inbox
+}
+
+func (c *Connection) VIN() string {
+	return c.vin
+}
+
+func (c *Connection) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *Connection) PreferredAuthMethod() connector.AuthMethod {
+	return c.authMethod
+}
+
+func (c *Connection) RetryInterval() time.Duration {
+	return c.retryInterval
+}
+
+func (c *Connection) AllowedLatency() time.Duration {
+	return c.allowedLatency
+}
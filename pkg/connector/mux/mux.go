@@ -0,0 +1,142 @@
+/*
+Package mux shares a single vehicle connection, typically BLE, between several local processes.
+
+Only one process on a host can own the BLE adapter, and establishing a BLE connection to a vehicle
+(scanning, connecting, and discovering services) takes several seconds. A [Server] owns the vehicle
+connection and accepts clients over a Unix domain socket. Each client is a [Connection], which
+implements connector.Connector, so tools such as tesla-control can use the shared connection as if
+they owned the radio.
+
+# Sessions
+
+A Server that has a private key (see [Server.HoldSessions]) holds its own authenticated sessions
+with the vehicle's security controller (VCSEC) and infotainment system and authorizes commands on
+behalf of its clients. The sessions are established when the Server starts, or for a sleeping
+infotainment system, when a client first sends it a command, and they remain valid for as long as
+the Server runs. Clients don't need a private key or a handshake with the vehicle: a
+[Connection] to such a Server implements connector.AuthorizingConnector, so a vehicle.Vehicle
+that uses it sends commands to the Server unsigned and lets the Server sign them. Every client's
+commands are signed with the Server's key, so any local process that can reach the socket can
+act with the key's permissions.
+
+When the Server doesn't have a private key, clients sign commands with their own keys, and the
+Server forwards the commands unmodified.
+
+# Routing
+
+Clients send datagrams to the vehicle unmodified. Before forwarding a datagram, the Server records
+the routing address and UUID of the message so that it can deliver the vehicle's response to the
+right client. This mirrors the way the dispatcher in a vehicle.Vehicle matches responses to
+requests:
+
+  - Responses addressed to a recorded routing address are delivered to the client that used that
+    address.
+  - Otherwise, responses with a recorded request UUID are delivered to the client that sent that
+    UUID.
+  - Remaining messages, such as unsolicited vehicle status updates, are delivered to all clients.
+
+Routes expire after routeLifetime and are removed when a client disconnects.
+
+# Wire format
+
+Every frame consists of a 4-byte big-endian payload length followed by the payload. The client
+sends a JSON hello containing the VIN it wants to reach, and the server responds with a JSON accept
+message containing the connection parameters or an error. Subsequent frames are datagrams
+exchanged with the vehicle. Frames sent by the client start with a byte that tells the Server
+whether to forward the datagram as-is or to authorize it first.
+
+The socket is not authenticated. Access is controlled by file-system permissions; [Listen] creates
+sockets that are only accessible to the current user.
+*/
+package mux
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+)
+
+const protocolVersion = 2
+
+// Kinds of frames sent by clients after the handshake.
+const (
+	frameRelay     byte = iota // Forward the datagram to the vehicle unmodified
+	frameAuthorize             // Authorize the datagram using the Server's sessions, then forward it
+)
+
+var (
+	// ErrFrameTooLarge indicates a peer attempted to send a frame that exceeds the maximum length.
+	ErrFrameTooLarge = errors.New("mux frame exceeds maximum length")
+	// ErrVehicleDisconnected indicates the Server's underlying connection to the vehicle was closed.
+	ErrVehicleDisconnected = errors.New("vehicle connection closed")
+)
+
+// RejectedError is returned by [Dial] when the server declines a connection.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("mux rejected connection: %s", e.Reason)
+}
+
+type hello struct {
+	Version int    `json:"version"`
+	VIN     string `json:"vin"`
+}
+
+type accept struct {
+	Error          string               `json:"error,omitempty"`
+	Authorizes     bool                 `json:"authorizes,omitempty"`
+	AuthMethod     connector.AuthMethod `json:"auth_method"`
+	RetryInterval  time.Duration        `json:"retry_interval"`
+	AllowedLatency time.Duration        `json:"allowed_latency"`
+}
+
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) > connector.MaxResponseLength {
+		return ErrFrameTooLarge
+	}
+	frame := make([]byte, 4, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	frame = append(frame, payload...)
+	_, err := w.Write(frame)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > connector.MaxResponseLength {
+		return nil, ErrFrameTooLarge
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFrame(w, payload)
+}
+
+func readJSON(r io.Reader, v interface{}) error {
+	payload, err := readFrame(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
@@ -0,0 +1,266 @@
+package mux_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/connectortest"
+	"github.com/teslamotors/vehicle-command/pkg/connector/mux"
+	"github.com/teslamotors/vehicle-command/pkg/connector/sim"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+const testVIN = "0123456789ABCDEFG"
+
+// serve starts a mux for an emulated vehicle and returns the vehicle and the socket path.
+func serve(t *testing.T, ctx context.Context) (*sim.Connection, string) {
+	t.Helper()
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return car, listen(t, ctx, car)
+}
+
+// listen starts a mux that shares conn and returns the socket path.
+func listen(t *testing.T, ctx context.Context, conn connector.Connector) string {
+	t.Helper()
+	return listenServer(t, ctx, mux.NewServer(conn))
+}
+
+// listenServer runs server and returns the socket path.
+func listenServer(t *testing.T, ctx context.Context, server *mux.Server) string {
+	t.Helper()
+	// t.TempDir() paths can exceed the maximum length of a Unix socket path.
+	dir, err := os.MkdirTemp("", "mux")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "ble.sock")
+	listener, err := mux.Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error)
+	go func() {
+		done <- server.Serve(serveCtx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) && !errors.Is(err, mux.ErrVehicleDisconnected) {
+			t.Errorf("Unexpected error from Serve: %s", err)
+		}
+	})
+	return path
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// newKey returns a private key. If car isn't nil, the key is enrolled with car.
+func newKey(t *testing.T, car *sim.Connection) authentication.ECDHPrivateKey {
+	t.Helper()
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if car != nil {
+		if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return skey
+}
+
+// connect returns a Vehicle with a newly enrolled key that uses the mux at path.
+func connect(t *testing.T, ctx context.Context, car *sim.Connection, path string) *vehicle.Vehicle {
+	t.Helper()
+	return connectWithKey(t, ctx, newKey(t, car), path)
+}
+
+// connectWithKey returns a Vehicle that uses the mux at path and authorizes commands with skey.
+// If skey is nil, the Vehicle relies on the mux to authorize commands.
+func connectWithKey(t *testing.T, ctx context.Context, skey authentication.ECDHPrivateKey, path string) *vehicle.Vehicle {
+	t.Helper()
+	conn, err := mux.Dial(ctx, path, testVIN)
+	if err != nil {
+		t.Fatalf("Dial failed: %s", err)
+	}
+	v, err := vehicle.NewVehicle(conn, skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Disconnect)
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	return v
+}
+
+func TestConcurrentClients(t *testing.T) {
+	ctx := testContext(t)
+	car, path := serve(t, ctx)
+
+	var vehicles []*vehicle.Vehicle
+	for i := 0; i < 3; i++ {
+		vehicles = append(vehicles, connect(t, ctx, car, path))
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(vehicles))
+	for _, v := range vehicles {
+		wg.Add(1)
+		go func(v *vehicle.Vehicle) {
+			defer wg.Done()
+			errs <- v.Unlock(ctx)
+			errs <- v.ChangeChargeLimit(ctx, 70)
+		}(v)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Command failed: %s", err)
+		}
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+}
+
+// serveWithSessions starts a mux that holds sessions using skey.
+func serveWithSessions(t *testing.T, ctx context.Context, car *sim.Connection, skey authentication.ECDHPrivateKey) string {
+	t.Helper()
+	server := mux.NewServer(car)
+	server.HoldSessions(skey)
+	return listenServer(t, ctx, server)
+}
+
+func TestHeldSessions(t *testing.T) {
+	ctx := testContext(t)
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := serveWithSessions(t, ctx, car, newKey(t, car))
+
+	var vehicles []*vehicle.Vehicle
+	for i := 0; i < 3; i++ {
+		v := connectWithKey(t, ctx, nil, path)
+		if !v.PrivateKeyAvailable() {
+			t.Fatal("Vehicle can't send authenticated commands through mux that holds sessions")
+		}
+		vehicles = append(vehicles, v)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(vehicles))
+	for _, v := range vehicles {
+		wg.Add(1)
+		go func(v *vehicle.Vehicle) {
+			defer wg.Done()
+			errs <- v.Unlock(ctx)
+			errs <- v.ChangeChargeLimit(ctx, 70)
+		}(v)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Command failed: %s", err)
+		}
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+
+	// Clients that have their own keys use the mux's sessions too.
+	if err := connect(t, ctx, car, path).Lock(ctx); err != nil {
+		t.Errorf("Lock with client key failed: %s", err)
+	}
+}
+
+func TestHeldSessionsKeyNotPaired(t *testing.T) {
+	ctx := testContext(t)
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := serveWithSessions(t, ctx, car, newKey(t, nil))
+	v := connectWithKey(t, ctx, nil, path)
+	if err := v.Lock(ctx); !errors.Is(err, protocol.ErrKeyNotPaired) {
+		t.Errorf("Expected ErrKeyNotPaired but got %v", err)
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	ctx := testContext(t)
+	car, path := serve(t, ctx)
+
+	first := connect(t, ctx, car, path)
+	second := connect(t, ctx, car, path)
+	first.Disconnect()
+	if err := second.Lock(ctx); err != nil {
+		t.Fatalf("Lock failed after another client disconnected: %s", err)
+	}
+	// A new client can reuse the shared connection.
+	third := connect(t, ctx, car, path)
+	if err := third.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+}
+
+func TestWrongVIN(t *testing.T) {
+	ctx := testContext(t)
+	_, path := serve(t, ctx)
+	var rejected *mux.RejectedError
+	if _, err := mux.Dial(ctx, path, "7SAYGDEF0PF000000"); !errors.As(err, &rejected) {
+		t.Errorf("Expected RejectedError but got %v", err)
+	}
+}
+
+func TestListenInUse(t *testing.T) {
+	ctx := testContext(t)
+	_, path := serve(t, ctx)
+	if _, err := mux.Listen(path); err == nil {
+		t.Error("Expected error when listening on a socket that's in use")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Unexpected socket permissions: %o", perm)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	connectortest.Run(t, func(t *testing.T) (connector.Connector, connectortest.Peer) {
+		pipe, peer := connectortest.NewPipe(testVIN, connector.AuthMethodGCM)
+		path := listen(t, context.Background(), pipe)
+		conn, err := mux.Dial(context.Background(), path, testVIN)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, peer
+	}, nil)
+}
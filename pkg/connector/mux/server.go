@@ -0,0 +1,364 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+var (
+	// handshakeTimeout limits how long a client can take to send its hello message.
+	handshakeTimeout = 10 * time.Second
+	// routeLifetime is how long the Server remembers which client sent a message.
+	routeLifetime = time.Minute
+)
+
+// clientQueueSize is the number of datagrams that can be buffered for a slow client before the
+// Server starts discarding them.
+const clientQueueSize = 4 * connector.BufferSize
+
+type routeType int
+
+const (
+	routeAddress routeType = iota
+	routeUUID
+)
+
+type routeKey struct {
+	kind routeType
+	id   string
+}
+
+// recipient receives datagrams from the vehicle.
+type recipient interface {
+	deliver(buffer []byte)
+}
+
+type route struct {
+	recipient recipient
+	expires   time.Time
+}
+
+type client struct {
+	conn      net.Conn
+	outbox    chan []byte
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// deliver queues buffer for transmission to the client without blocking.
+func (c *client) deliver(buffer []byte) {
+	select {
+	case c.outbox <- buffer:
+	default:
+		log.Warning("Discarding datagram because mux client %p is not keeping up", c)
+	}
+}
+
+func (c *client) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case buffer := <-c.outbox:
+			if err := writeFrame(c.conn, buffer); err != nil {
+				log.Debug("Failed to write to mux client %p: %s", c, err)
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+// Server shares a vehicle connection with any number of [Connection] clients.
+type Server struct {
+	conn       connector.Connector
+	privateKey protocol.ECDHPrivateKey
+	sessions   *sessionHolder
+
+	lock      sync.Mutex
+	clients   map[*client]bool
+	routes    map[routeKey]route
+	lastPrune time.Time
+}
+
+// NewServer returns a Server that shares conn.
+func NewServer(conn connector.Connector) *Server {
+	return &Server{
+		conn:    conn,
+		clients: make(map[*client]bool),
+		routes:  make(map[routeKey]route),
+	}
+}
+
+// HoldSessions configures s to establish its own sessions with the vehicle using privateKey and to
+// authorize commands on behalf of clients. It must be called before [Server.Serve].
+func (s *Server) HoldSessions(privateKey protocol.ECDHPrivateKey) {
+	s.privateKey = privateKey
+}
+
+// Listen creates a Unix domain socket at path that is only accessible to the current user. If a
+// socket already exists at path but no server is listening on it, the stale socket is removed.
+func Listen(path string) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another server is already listening on %s", path)
+		}
+		log.Debug("Removing stale socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve accepts client connections from listener until ctx expires, listener fails, or the
+// vehicle connection is closed. The listener is closed when Serve returns, but the vehicle
+// connection is not.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	serveCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if s.privateKey != nil {
+		sessions, err := newSessionHolder(serveCtx, s, s.privateKey)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		defer sessions.close()
+		s.sessions = sessions
+	}
+
+	var vehicleClosed atomic.Bool
+	go func() {
+		if !s.relayFromVehicle(serveCtx) {
+			vehicleClosed.Store(true)
+		}
+		cancel()
+	}()
+	go func() {
+		<-serveCtx.Done()
+		listener.Close()
+	}()
+
+	var err error
+	for {
+		var conn net.Conn
+		if conn, err = listener.Accept(); err != nil {
+			break
+		}
+		go s.handle(serveCtx, conn)
+	}
+
+	cancel()
+	s.lock.Lock()
+	for c := range s.clients {
+		c.close()
+	}
+	s.clients = make(map[*client]bool)
+	s.routes = make(map[routeKey]route)
+	s.lock.Unlock()
+
+	if vehicleClosed.Load() {
+		return ErrVehicleDisconnected
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// relayFromVehicle forwards datagrams from the vehicle to clients until ctx expires. Returns
+// false if the vehicle connection was closed.
+func (s *Server) relayFromVehicle(ctx context.Context) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return true
+		case buffer, ok := <-s.conn.Receive():
+			if !ok {
+				log.Warning("Vehicle connection closed")
+				return false
+			}
+			for _, r := range s.recipients(buffer) {
+				r.deliver(buffer)
+			}
+		}
+	}
+}
+
+// recipients returns the clients that should receive a datagram from the vehicle.
+func (s *Server) recipients(buffer []byte) []recipient {
+	var message universal.RoutableMessage
+	if err := proto.Unmarshal(buffer, &message); err != nil {
+		log.Debug("Broadcasting unparseable message: %s", err)
+		message.Reset()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	keys := []routeKey{
+		{routeAddress, string(message.GetToDestination().GetRoutingAddress())},
+		{routeUUID, string(message.GetRequestUuid())},
+	}
+	for _, key := range keys {
+		if key.id == "" {
+			continue
+		}
+		if r, ok := s.routes[key]; ok && now.Before(r.expires) {
+			return []recipient{r.recipient}
+		}
+	}
+	clients := make([]recipient, 0, len(s.clients))
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// recordRoutes remembers that c sent buffer, so that the vehicle's response can be delivered to c.
+func (s *Server) recordRoutes(c recipient, buffer []byte) {
+	var message universal.RoutableMessage
+	if err := proto.Unmarshal(buffer, &message); err != nil {
+		log.Debug("Mux client %p sent unparseable message: %s", c, err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	if now.Sub(s.lastPrune) > routeLifetime {
+		for key, r := range s.routes {
+			if now.After(r.expires) {
+				delete(s.routes, key)
+			}
+		}
+		s.lastPrune = now
+	}
+	r := route{recipient: c, expires: now.Add(routeLifetime)}
+	if addr := message.GetFromDestination().GetRoutingAddress(); len(addr) > 0 {
+		s.routes[routeKey{routeAddress, string(addr)}] = r
+	}
+	if uuid := message.GetUuid(); len(uuid) > 0 {
+		s.routes[routeKey{routeUUID, string(uuid)}] = r
+	}
+}
+
+func (s *Server) disconnect(c *client) {
+	c.close()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.clients, c)
+	for key, r := range s.routes {
+		if r.recipient == c {
+			delete(s.routes, key)
+		}
+	}
+}
+
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	if err := s.handshake(conn); err != nil {
+		log.Warning("Rejected mux client: %s", err)
+		conn.Close()
+		return
+	}
+	c := &client{
+		conn:   conn,
+		outbox: make(chan []byte, clientQueueSize),
+		done:   make(chan struct{}),
+	}
+	s.lock.Lock()
+	if ctx.Err() != nil {
+		s.lock.Unlock()
+		conn.Close()
+		return
+	}
+	s.clients[c] = true
+	s.lock.Unlock()
+	defer s.disconnect(c)
+	log.Info("Mux client %p connected", c)
+
+	go c.writeLoop()
+	for {
+		frame, err := readFrame(conn)
+		if err != nil {
+			log.Info("Mux client %p disconnected: %s", c, err)
+			return
+		}
+		if len(frame) == 0 {
+			continue
+		}
+		switch kind, payload := frame[0], frame[1:]; kind {
+		case frameRelay:
+			s.recordRoutes(c, payload)
+			if err := s.conn.Send(ctx, payload); err != nil {
+				// The client is responsible for retrying.
+				log.Warning("Failed to relay datagram to vehicle: %s", err)
+			}
+		case frameAuthorize:
+			if s.sessions == nil {
+				log.Warning("Mux client %p requested authorization, but the server has no private key", c)
+				continue
+			}
+			// Authorization may require a handshake, which shouldn't hold up the client's other
+			// messages.
+			go s.sessions.authorize(ctx, c, payload)
+		default:
+			log.Warning("Mux client %p sent unrecognized frame type %d", c, kind)
+		}
+	}
+}
+
+func (s *Server) handshake(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	var h hello
+	if err := readJSON(conn, &h); err != nil {
+		return err
+	}
+	if h.Version != protocolVersion {
+		writeJSON(conn, &accept{Error: "unsupported protocol version"})
+		return errors.New("client uses unsupported protocol version")
+	}
+	if h.VIN != s.conn.VIN() {
+		writeJSON(conn, &accept{Error: "mux is connected to a different vehicle"})
+		return errors.New("client requested a different VIN")
+	}
+	return writeJSON(conn, &accept{
+		Authorizes:     s.privateKey != nil,
+		AuthMethod:     s.conn.PreferredAuthMethod(),
+		RetryInterval:  s.conn.RetryInterval(),
+		AllowedLatency: s.conn.AllowedLatency(),
+	})
+}
@@ -0,0 +1,222 @@
+package mux
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/internal/dispatcher"
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+var (
+	// sessionTimeout limits how long the Server spends on a handshake with a vehicle domain.
+	sessionTimeout = 10 * time.Second
+	// commandLifetime is how long commands authorized by the Server remain valid. The vehicle
+	// rejects commands that expire too far in the future.
+	commandLifetime = 5 * time.Second
+)
+
+// heldDomains are the vehicle domains the Server establishes sessions with.
+var heldDomains = []universal.Domain{
+	universal.Domain_DOMAIN_VEHICLE_SECURITY,
+	universal.Domain_DOMAIN_INFOTAINMENT,
+}
+
+// sessionHolder authorizes commands on behalf of a Server's clients using the Server's private key.
+//
+// A sessionHolder implements connector.Connector so that a dispatcher can reach the vehicle
+// through the Server. Its messages are routed like those of any other client, so the dispatcher
+// receives the vehicle's responses, including the session info that keeps its sessions in sync.
+type sessionHolder struct {
+	server     *Server
+	inbox      chan []byte
+	dispatcher *dispatcher.Dispatcher
+
+	// handshakeLock prevents concurrent handshakes, which would waste time on a slow link.
+	handshakeLock sync.Mutex
+}
+
+// newSessionHolder starts a dispatcher that uses privateKey and begins establishing sessions in
+// the background. The caller must call close when it no longer needs the sessionHolder.
+func newSessionHolder(ctx context.Context, server *Server, privateKey protocol.ECDHPrivateKey) (*sessionHolder, error) {
+	h := &sessionHolder{
+		server: server,
+		inbox:  make(chan []byte, clientQueueSize),
+	}
+	var err error
+	if h.dispatcher, err = dispatcher.New(h, privateKey); err != nil {
+		return nil, err
+	}
+	if err = h.dispatcher.Start(ctx); err != nil {
+		return nil, err
+	}
+	for _, domain := range heldDomains {
+		go func(domain universal.Domain) {
+			if err := h.startSession(ctx, domain); err != nil {
+				// The infotainment system may be asleep. The Server tries again when a client
+				// sends it a command.
+				log.Info("Couldn't start %s session: %s", domain, err)
+			}
+		}(domain)
+	}
+	return h, nil
+}
+
+func (h *sessionHolder) close() {
+	h.dispatcher.Stop()
+}
+
+// startSession establishes a session with domain unless one is already established.
+func (h *sessionHolder) startSession(ctx context.Context, domain universal.Domain) error {
+	if _, ok := h.dispatcher.SessionHealth(domain); ok {
+		return nil
+	}
+	h.handshakeLock.Lock()
+	defer h.handshakeLock.Unlock()
+	if _, ok := h.dispatcher.SessionHealth(domain); ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, sessionTimeout)
+	defer cancel()
+	return h.dispatcher.StartSession(ctx, domain)
+}
+
+// authorize signs buffer, an unsigned message from c, and sends it to the vehicle. The vehicle's
+// responses are delivered to c until c disconnects or routeLifetime elapses.
+func (h *sessionHolder) authorize(ctx context.Context, c *client, buffer []byte) {
+	var message universal.RoutableMessage
+	if err := proto.Unmarshal(buffer, &message); err != nil {
+		log.Debug("Mux client %p sent unparseable message: %s", c, err)
+		return
+	}
+	// The dispatcher replaces the message's address and UUID with its own, so responses must be
+	// readdressed before they're delivered to c.
+	domain := message.GetToDestination().GetDomain()
+	address := message.GetFromDestination().GetRoutingAddress()
+	uuid := message.GetUuid()
+	readdress := func(reply *universal.RoutableMessage) {
+		reply.ToDestination = &universal.Destination{
+			SubDestination: &universal.Destination_RoutingAddress{RoutingAddress: address},
+		}
+		reply.RequestUuid = uuid
+	}
+
+	recv, err := h.send(ctx, domain, &message)
+	if err != nil {
+		log.Warning("Failed to authorize command from mux client %p: %s", c, err)
+		reply := faultReply(domain, err)
+		readdress(reply)
+		h.deliverTo(c, reply)
+		return
+	}
+	defer recv.Close()
+
+	timeout := time.NewTimer(routeLifetime)
+	defer timeout.Stop()
+	for {
+		select {
+		case reply := <-recv.Recv():
+			if reply.GetSessionInfo() != nil {
+				// The session info belongs to the Server's key. The dispatcher has already
+				// processed it, and the client can't authenticate it.
+				reply.Payload = nil
+				reply.SubSigData = nil
+			}
+			readdress(reply)
+			h.deliverTo(c, reply)
+		case <-c.done:
+			return
+		case <-timeout.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send authorizes message using the session with domain, starting the session if necessary, and
+// sends it to the vehicle.
+func (h *sessionHolder) send(ctx context.Context, domain universal.Domain, message *universal.RoutableMessage) (protocol.Receiver, error) {
+	if err := h.startSession(ctx, domain); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, commandLifetime)
+	defer cancel()
+	return h.dispatcher.Send(ctx, message, h.server.conn.PreferredAuthMethod())
+}
+
+func (h *sessionHolder) deliverTo(c *client, reply *universal.RoutableMessage) {
+	encoded, err := proto.Marshal(reply)
+	if err != nil {
+		log.Warning("Failed to encode response for mux client %p: %s", c, err)
+		return
+	}
+	c.deliver(encoded)
+}
+
+// faultReply returns a response that tells a client why the Server couldn't authorize its command.
+func faultReply(domain universal.Domain, err error) *universal.RoutableMessage {
+	fault := universal.MessageFault_E_MESSAGEFAULT_ERROR_TIMEOUT
+	var faultErr *protocol.RoutableMessageError
+	if errors.As(err, &faultErr) {
+		fault = faultErr.Code
+	} else if errors.Is(err, protocol.ErrKeyNotPaired) {
+		fault = universal.MessageFault_E_MESSAGEFAULT_ERROR_UNKNOWN_KEY_ID
+	}
+	return &universal.RoutableMessage{
+		FromDestination: &universal.Destination{
+			SubDestination: &universal.Destination_Domain{Domain: domain},
+		},
+		SignedMessageStatus: &universal.MessageStatus{
+			OperationStatus:    universal.OperationStatus_E_OPERATIONSTATUS_ERROR,
+			SignedMessageFault: fault,
+		},
+	}
+}
+
+// deliver queues a datagram from the vehicle for the dispatcher without blocking.
+func (h *sessionHolder) deliver(buffer []byte) {
+	select {
+	case h.inbox <- buffer:
+	default:
+		log.Warning("Discarding datagram because mux session holder is not keeping up")
+	}
+}
+
+// The following methods implement connector.Connector for the dispatcher.
+
+func (h *sessionHolder) Receive() <-chan []byte {
+	return h.inbox
+}
+
+func (h *sessionHolder) Send(ctx context.Context, buffer []byte) error {
+	h.server.recordRoutes(h, buffer)
+	return h.server.conn.Send(ctx, buffer)
+}
+
+func (h *sessionHolder) VIN() string {
+	return h.server.conn.VIN()
+}
+
+// Close is a no-op. The Server owns the vehicle connection.
+func (h *sessionHolder) Close() {}
+
+func (h *sessionHolder) PreferredAuthMethod() connector.AuthMethod {
+	return h.server.conn.PreferredAuthMethod()
+}
+
+func (h *sessionHolder) RetryInterval() time.Duration {
+	return h.server.conn.RetryInterval()
+}
+
+func (h *sessionHolder) AllowedLatency() time.Duration {
+	return h.server.conn.AllowedLatency()
+}
@@ -0,0 +1,30 @@
+/*
+Package sim implements the Connector interface using an in-process vehicle emulator.
+
+A [Connection] behaves like a car on the other end of a BLE link or Fleet API channel. It
+terminates universal.RoutableMessages addressed to the vehicle security controller (VCSEC) and to
+infotainment, authenticates them using the same code that verifies commands on a real vehicle,
+keeps an emulated keychain, and applies commands to an in-memory [State] that tests can inspect.
+
+This allows clients to exercise a [vehicle.Vehicle] end to end, including handshakes, epoch
+rotation, anti-replay counters, and keychain errors, without access to a physical vehicle:
+
+	car, err := sim.NewConnection("0123456789ABCDEFG", connector.AuthMethodGCM)
+	if err != nil {
+		panic(err)
+	}
+	car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY)
+
+	v, err := vehicle.NewVehicle(car, skey, nil)
+	...
+	if err := v.Unlock(ctx); err != nil {
+		panic(err)
+	}
+	fmt.Println(car.State().LockState) // VEHICLELOCKSTATE_UNLOCKED
+
+The emulator models the protocol, not the vehicle: state transitions are instantaneous, and
+commands that have no modeled effect are acknowledged without changing [State].
+
+[vehicle.Vehicle]: https://pkg.go.dev/github.com/teslamotors/vehicle-command/pkg/vehicle#Vehicle
+*/
+package sim
@@ -0,0 +1,147 @@
+package sim
+
+// This file emulates the infotainment domain, which executes carserver.Actions.
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	carserver "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/carserver"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+const (
+	minChargeLimitPercent = 50
+	maxChargeLimitPercent = 100
+	maxChargingAmps       = 48
+)
+
+func actionError(reason string) *carserver.Response {
+	return &carserver.Response{
+		ActionStatus: &carserver.ActionStatus{
+			Result: carserver.OperationStatus_E_OPERATIONSTATUS_ERROR,
+			ResultReason: &carserver.ResultReason{
+				Reason: &carserver.ResultReason_PlainText{PlainText: reason},
+			},
+		},
+	}
+}
+
+func actionOK() *carserver.Response {
+	return &carserver.Response{
+		ActionStatus: &carserver.ActionStatus{
+			Result: carserver.OperationStatus_E_OPERATIONSTATUS_OK,
+		},
+	}
+}
+
+// isChargingAction returns true for actions that a ROLE_CHARGING_MANAGER key may execute.
+func isChargingAction(action *carserver.VehicleAction) bool {
+	switch action.GetVehicleActionMsg().(type) {
+	case *carserver.VehicleAction_ChargingSetLimitAction,
+		*carserver.VehicleAction_ChargingStartStopAction,
+		*carserver.VehicleAction_SetChargingAmpsAction,
+		*carserver.VehicleAction_ScheduledChargingAction,
+		*carserver.VehicleAction_AddChargeScheduleAction,
+		*carserver.VehicleAction_RemoveChargeScheduleAction,
+		*carserver.VehicleAction_BatchRemoveChargeSchedulesAction,
+		*carserver.VehicleAction_Ping:
+		return true
+	}
+	return false
+}
+
+// handleInfotainment processes an encoded carserver.Action sent by signer. The caller must hold
+// c.lock.
+func (c *Connection) handleInfotainment(signer *KeyEntry, plaintext []byte) (*carserver.Response, universal.MessageFault_E) {
+	var message carserver.Action
+	if err := proto.Unmarshal(plaintext, &message); err != nil {
+		return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_DECODING
+	}
+	action := message.GetVehicleAction()
+	if action == nil {
+		return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_INVALID_COMMAND
+	}
+
+	switch signer.Role {
+	case keys.Role_ROLE_VEHICLE_MONITOR:
+		if _, ok := action.GetVehicleActionMsg().(*carserver.VehicleAction_Ping); !ok {
+			return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_INSUFFICIENT_PRIVILEGES
+		}
+	case keys.Role_ROLE_CHARGING_MANAGER:
+		if !isChargingAction(action) {
+			return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_INSUFFICIENT_PRIVILEGES
+		}
+	}
+
+	switch msg := action.GetVehicleActionMsg().(type) {
+	case *carserver.VehicleAction_Ping:
+		response := actionOK()
+		response.ResponseMsg = &carserver.Response_Ping{
+			Ping: &carserver.Ping{PingId: msg.Ping.GetPingId()},
+		}
+		return response, universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+	case *carserver.VehicleAction_ChargingSetLimitAction:
+		percent := msg.ChargingSetLimitAction.GetPercent()
+		if percent < minChargeLimitPercent || percent > maxChargeLimitPercent {
+			return actionError("invalid_charge_limit"), universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+		}
+		c.state.ChargeLimitPercent = percent
+	case *carserver.VehicleAction_ChargingStartStopAction:
+		switch msg.ChargingStartStopAction.GetChargingAction().(type) {
+		case *carserver.ChargingStartStopAction_Stop:
+			if !c.state.Charging {
+				return actionError("not_charging"), universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+			}
+			c.state.Charging = false
+		case *carserver.ChargingStartStopAction_StartMaxRange:
+			c.state.ChargeLimitPercent = maxChargeLimitPercent
+			c.state.Charging = true
+		default:
+			if c.state.Charging {
+				return actionError("is_charging"), universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+			}
+			c.state.Charging = true
+		}
+	case *carserver.VehicleAction_SetChargingAmpsAction:
+		amps := msg.SetChargingAmpsAction.GetChargingAmps()
+		if amps <= 0 || amps > maxChargingAmps {
+			return actionError("invalid_charging_amps"), universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+		}
+		c.state.ChargingAmps = amps
+	case *carserver.VehicleAction_ChargePortDoorOpen:
+		c.state.ChargePort = vcsec.ClosureState_E_CLOSURESTATE_OPEN
+	case *carserver.VehicleAction_ChargePortDoorClose:
+		c.state.ChargePort = vcsec.ClosureState_E_CLOSURESTATE_CLOSED
+	case *carserver.VehicleAction_HvacAutoAction:
+		c.state.ClimateOn = msg.HvacAutoAction.GetPowerOn()
+	case *carserver.VehicleAction_HvacTemperatureAdjustmentAction:
+		c.state.DriverTempCelsius = msg.HvacTemperatureAdjustmentAction.GetDriverTempCelsius()
+		c.state.PassengerTempCelsius = msg.HvacTemperatureAdjustmentAction.GetPassengerTempCelsius()
+	case *carserver.VehicleAction_HvacSteeringWheelHeaterAction:
+		c.state.SteeringWheelHeaterOn = msg.HvacSteeringWheelHeaterAction.GetPowerOn()
+	case *carserver.VehicleAction_VehicleControlSetSentryModeAction:
+		c.state.SentryModeOn = msg.VehicleControlSetSentryModeAction.GetOn()
+	case *carserver.VehicleAction_SetVehicleNameAction:
+		c.state.VehicleName = msg.SetVehicleNameAction.GetVehicleName()
+	case *carserver.VehicleAction_MediaUpdateVolume:
+		if volume, ok := msg.MediaUpdateVolume.GetMediaVolume().(*carserver.MediaUpdateVolume_VolumeAbsoluteFloat); ok {
+			c.state.MediaVolume = volume.VolumeAbsoluteFloat
+		}
+	}
+	return actionOK(), universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+}
+
+func (c *Connection) infotainmentReply(request *universal.RoutableMessage, response *carserver.Response, fault universal.MessageFault_E) *universal.RoutableMessage {
+	if fault != universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE {
+		return faultReply(request, fault)
+	}
+	encodedResponse, err := proto.Marshal(response)
+	if err != nil {
+		return faultReply(request, universal.MessageFault_E_MESSAGEFAULT_ERROR_INTERNAL)
+	}
+	reply := newReply(request)
+	reply.Payload = &universal.RoutableMessage_ProtobufMessageAsBytes{ProtobufMessageAsBytes: encodedResponse}
+	return reply
+}
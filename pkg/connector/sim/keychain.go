@@ -0,0 +1,481 @@
+package sim
+
+// This file implements the emulated vehicle keychain (also called the whitelist) and the VCSEC
+// operations that modify it.
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/sha1"
+	"errors"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// DefaultKeychainCapacity is the number of keychain slots available on a new emulated vehicle.
+const DefaultKeychainCapacity = 20
+
+// maxKeychainCapacity is constrained by the width of vcsec.WhitelistInfo.SlotMask.
+const maxKeychainCapacity = 32
+
+var (
+	ErrInvalidCapacity = errors.New("keychain capacity must be between 1 and 32")
+	ErrKeychainFull    = errors.New("keychain is full")
+	ErrDuplicateKey    = errors.New("key is already on the keychain")
+)
+
+// KeyEntry describes a public key enrolled on the emulated vehicle's keychain.
+type KeyEntry struct {
+	PublicKey  []byte
+	Role       keys.Role
+	FormFactor vcsec.KeyFormFactor
+	// Impermanent keys are removed by WhitelistOperation.RemoveAllImpermanentKeys.
+	Impermanent bool
+	// If ActiveUntil is non-zero, the key is removed from the keychain at that time.
+	ActiveUntil time.Time
+}
+
+func (k *KeyEntry) expired(now time.Time) bool {
+	return !k.ActiveUntil.IsZero() && !now.Before(k.ActiveUntil)
+}
+
+func (k *KeyEntry) keyID() []byte {
+	digest := sha1.Sum(k.PublicKey)
+	return digest[:4]
+}
+
+type keychain struct {
+	slots []*KeyEntry
+}
+
+func newKeychain(capacity int) keychain {
+	return keychain{slots: make([]*KeyEntry, capacity)}
+}
+
+func (k *keychain) lookup(publicKey []byte) *KeyEntry {
+	_, entry := k.find(publicKey)
+	return entry
+}
+
+func (k *keychain) find(publicKey []byte) (uint32, *KeyEntry) {
+	k.expire()
+	for slot, entry := range k.slots {
+		if entry != nil && bytes.Equal(entry.PublicKey, publicKey) {
+			return uint32(slot), entry
+		}
+	}
+	return 0, nil
+}
+
+func (k *keychain) add(entry *KeyEntry) error {
+	if k.lookup(entry.PublicKey) != nil {
+		return ErrDuplicateKey
+	}
+	for slot := range k.slots {
+		if k.slots[slot] == nil {
+			k.slots[slot] = entry
+			return nil
+		}
+	}
+	return ErrKeychainFull
+}
+
+// expire removes keys that are no longer active.
+func (k *keychain) expire() {
+	now := time.Now()
+	for slot, entry := range k.slots {
+		if entry != nil && entry.expired(now) {
+			k.slots[slot] = nil
+		}
+	}
+}
+
+func (k *keychain) remove(publicKey []byte) bool {
+	if slot, entry := k.find(publicKey); entry != nil {
+		k.slots[slot] = nil
+		return true
+	}
+	return false
+}
+
+func (k *keychain) info() *vcsec.WhitelistInfo {
+	var info vcsec.WhitelistInfo
+	k.expire()
+	for slot, entry := range k.slots {
+		if entry == nil {
+			continue
+		}
+		info.NumberOfEntries++
+		info.SlotMask |= 1 << slot
+		info.WhitelistEntries = append(info.WhitelistEntries, &vcsec.KeyIdentifier{PublicKeySHA1: entry.keyID()})
+	}
+	return &info
+}
+
+func (k *keychain) entryInfo(slot uint32) *vcsec.WhitelistEntryInfo {
+	k.expire()
+	if slot >= uint32(len(k.slots)) || k.slots[slot] == nil {
+		return nil
+	}
+	entry := k.slots[slot]
+	return &vcsec.WhitelistEntryInfo{
+		KeyId:          &vcsec.KeyIdentifier{PublicKeySHA1: entry.keyID()},
+		PublicKey:      &vcsec.PublicKey{PublicKeyRaw: append([]byte{}, entry.PublicKey...)},
+		MetadataForKey: &vcsec.KeyMetadata{KeyFormFactor: entry.FormFactor},
+		Slot:           slot,
+		KeyRole:        entry.Role,
+	}
+}
+
+// AddKey enrolls publicKey on the emulated vehicle's keychain, as if an owner had added it using
+// the vehicle UI.
+func (c *Connection) AddKey(publicKey []byte, role keys.Role, formFactor vcsec.KeyFormFactor) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.keychain.add(&KeyEntry{
+		PublicKey:  append([]byte{}, publicKey...),
+		Role:       role,
+		FormFactor: formFactor,
+	})
+}
+
+// RemoveKey removes publicKey from the emulated vehicle's keychain, as if an owner had removed it
+// using the vehicle UI. Returns false if the key was not enrolled.
+func (c *Connection) RemoveKey(publicKey []byte) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.discardSessions(publicKey)
+	return c.keychain.remove(publicKey)
+}
+
+// Keys returns the enrolled keys, indexed by keychain slot.
+func (c *Connection) Keys() map[uint32]KeyEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entries := make(map[uint32]KeyEntry)
+	c.keychain.expire()
+	for slot, entry := range c.keychain.slots {
+		if entry != nil {
+			entries[uint32(slot)] = *entry
+		}
+	}
+	return entries
+}
+
+// SetKeychainCapacity changes the number of keychain slots. Keys in slots beyond the new capacity
+// are removed.
+func (c *Connection) SetKeychainCapacity(capacity int) error {
+	if capacity < 1 || capacity > maxKeychainCapacity {
+		return ErrInvalidCapacity
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	slots := make([]*KeyEntry, capacity)
+	copy(slots, c.keychain.slots)
+	c.keychain.slots = slots
+	return nil
+}
+
+// PendingKeyRequests returns add-key requests that have been received over the (emulated) BLE
+// link but not yet approved.
+func (c *Connection) PendingKeyRequests() []KeyEntry {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]KeyEntry{}, c.pendingKeys...)
+}
+
+// ApproveKeyRequests emulates the user tapping an NFC card on the center console and confirming
+// all pending add-key requests. Returns the first error encountered while enrolling keys.
+func (c *Connection) ApproveKeyRequests() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var firstErr error
+	for i := range c.pendingKeys {
+		entry := c.pendingKeys[i]
+		if err := c.keychain.add(&entry); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	c.pendingKeys = nil
+	return firstErr
+}
+
+// RejectKeyRequests emulates the vehicle declining all pending add-key requests. The vehicle
+// reports code to the client that most recently sent it a message.
+func (c *Connection) RejectKeyRequests(code vcsec.WhitelistOperationInformation_E) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.pendingKeys) == 0 {
+		return nil
+	}
+	c.pendingKeys = nil
+	encodedResult, err := proto.Marshal(whitelistOperationResult(code))
+	if err != nil {
+		return err
+	}
+	address := make([]byte, len(c.clientAddress))
+	copy(address, c.clientAddress)
+	message := &universal.RoutableMessage{
+		ToDestination: &universal.Destination{
+			SubDestination: &universal.Destination_RoutingAddress{RoutingAddress: address},
+		},
+		FromDestination: &universal.Destination{
+			SubDestination: &universal.Destination_Domain{Domain: universal.Domain_DOMAIN_VEHICLE_SECURITY},
+		},
+		Payload: &universal.RoutableMessage_ProtobufMessageAsBytes{ProtobufMessageAsBytes: encodedResult},
+	}
+	return c.push(message)
+}
+
+// discardSessions removes the sessions associated with publicKey. The caller must hold c.lock.
+func (c *Connection) discardSessions(publicKey []byte) {
+	for index := range c.verifiers {
+		if index.publicKey == string(publicKey) {
+			delete(c.verifiers, index)
+		}
+	}
+}
+
+// handleToVCSECMessage processes an unauthenticated add-key request. The caller must hold c.lock.
+func (c *Connection) handleToVCSECMessage(buffer []byte) {
+	var envelope vcsec.ToVCSECMessage
+	if err := proto.Unmarshal(buffer, &envelope); err != nil {
+		log.Warning("[sim] Dropping unparseable VCSEC message: %s", err)
+		return
+	}
+	if envelope.GetSignedMessage().GetSignatureType() != vcsec.SignatureType_SIGNATURE_TYPE_PRESENT_KEY {
+		log.Warning("[sim] Dropping VCSEC message with unsupported signature type")
+		return
+	}
+	var message vcsec.UnsignedMessage
+	if err := proto.Unmarshal(envelope.GetSignedMessage().GetProtobufMessageAsBytes(), &message); err != nil {
+		log.Warning("[sim] Dropping unparseable add-key request: %s", err)
+		return
+	}
+	operation := message.GetWhitelistOperation()
+	change := operation.GetAddKeyToWhitelistAndAddPermissions()
+	if change == nil {
+		log.Warning("[sim] Dropping unsupported VCSEC request")
+		return
+	}
+	c.pendingKeys = append(c.pendingKeys, KeyEntry{
+		PublicKey:  append([]byte{}, change.GetKey().GetPublicKeyRaw()...),
+		Role:       change.GetKeyRole(),
+		FormFactor: operation.GetMetadataForKey().GetKeyFormFactor(),
+	})
+}
+
+func whitelistOperationResult(code vcsec.WhitelistOperationInformation_E) *vcsec.FromVCSECMessage {
+	status := vcsec.OperationStatus_E_OPERATIONSTATUS_OK
+	if code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE {
+		status = vcsec.OperationStatus_E_OPERATIONSTATUS_ERROR
+	}
+	return &vcsec.FromVCSECMessage{
+		SubMessage: &vcsec.FromVCSECMessage_CommandStatus{
+			CommandStatus: &vcsec.CommandStatus{
+				OperationStatus: status,
+				SubMessage: &vcsec.CommandStatus_WhitelistOperationStatus{
+					WhitelistOperationStatus: &vcsec.WhitelistOperationStatus{
+						WhitelistOperationInformation: code,
+						OperationStatus:               status,
+					},
+				},
+			},
+		},
+	}
+}
+
+func validPublicKey(publicKey []byte) bool {
+	_, err := ecdh.P256().NewPublicKey(publicKey)
+	return err == nil
+}
+
+// addKeyResult translates an error returned by keychain.add into a status code.
+func addKeyResult(err error) vcsec.WhitelistOperationInformation_E {
+	switch err {
+	case nil:
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE
+	case ErrDuplicateKey:
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_ATTEMPTING_TO_ADD_KEY_THAT_IS_ALREADY_ON_THE_WHITELIST
+	case ErrKeychainFull:
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_WHITELIST_FULL
+	}
+	return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_UNDOCUMENTED_ERROR
+}
+
+// checkNewKey returns the status code for an attempt to enroll publicKey with role.
+func checkNewKey(publicKey []byte, role keys.Role) vcsec.WhitelistOperationInformation_E {
+	switch role {
+	case keys.Role_ROLE_NONE:
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_ATTEMPTING_TO_ADD_KEY_WITHOUT_ROLE
+	case keys.Role_ROLE_SERVICE:
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_ATTEMPTING_TO_ADD_KEY_WITH_SERVICE_ROLE
+	}
+	if !validPublicKey(publicKey) {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_INVALID_PUBLIC_KEY
+	}
+	return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE
+}
+
+// removeImpermanentKeys removes all impermanent keys and their sessions. The caller must hold
+// c.lock.
+func (c *Connection) removeImpermanentKeys() {
+	for slot, entry := range c.keychain.slots {
+		if entry != nil && entry.Impermanent {
+			c.keychain.slots[slot] = nil
+			c.discardSessions(entry.PublicKey)
+		}
+	}
+}
+
+// executeWhitelistOperation applies operation on behalf of signer and returns the resulting status
+// code. The caller must hold c.lock.
+func (c *Connection) executeWhitelistOperation(signer *KeyEntry, operation *vcsec.WhitelistOperation) vcsec.WhitelistOperationInformation_E {
+	formFactor := operation.GetMetadataForKey().GetKeyFormFactor()
+	switch op := operation.GetSubMessage().(type) {
+	case *vcsec.WhitelistOperation_AddKeyToWhitelistAndAddPermissions:
+		return c.addKey(signer, op.AddKeyToWhitelistAndAddPermissions, formFactor, false)
+	case *vcsec.WhitelistOperation_AddImpermanentKey:
+		return c.addKey(signer, op.AddImpermanentKey, formFactor, true)
+	case *vcsec.WhitelistOperation_AddImpermanentKeyAndRemoveExisting:
+		change := op.AddImpermanentKeyAndRemoveExisting
+		if signer.Role != keys.Role_ROLE_OWNER {
+			return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NO_PERMISSION_TO_ADD
+		}
+		if code := checkNewKey(change.GetKey().GetPublicKeyRaw(), change.GetKeyRole()); code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE {
+			return code
+		}
+		c.removeImpermanentKeys()
+		return c.addKey(signer, change, formFactor, true)
+	case *vcsec.WhitelistOperation_RemoveAllImpermanentKeys:
+		if signer.Role != keys.Role_ROLE_OWNER {
+			return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NO_PERMISSION_TO_REMOVE
+		}
+		c.removeImpermanentKeys()
+	case *vcsec.WhitelistOperation_RemovePublicKeyFromWhitelist:
+		publicKey := op.RemovePublicKeyFromWhitelist.GetPublicKeyRaw()
+		if c.keychain.lookup(publicKey) == nil {
+			return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_PUBLIC_KEY_NOT_ON_WHITELIST
+		}
+		if bytes.Equal(publicKey, signer.PublicKey) {
+			return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NO_PERMISSION_TO_REMOVE_ONESELF
+		}
+		if signer.Role != keys.Role_ROLE_OWNER {
+			return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NO_PERMISSION_TO_REMOVE
+		}
+		c.keychain.remove(publicKey)
+		c.discardSessions(publicKey)
+	case *vcsec.WhitelistOperation_AddPermissionsToPublicKey:
+		return c.changePermissions(signer, op.AddPermissionsToPublicKey, func(entry *KeyEntry) {
+			entry.Role = op.AddPermissionsToPublicKey.GetKeyRole()
+		})
+	case *vcsec.WhitelistOperation_RemovePermissionsFromPublicKey:
+		return c.changePermissions(signer, op.RemovePermissionsFromPublicKey, func(entry *KeyEntry) {
+			// Roles aren't cumulative, so removing a key's role leaves it without permissions.
+			if entry.Role == op.RemovePermissionsFromPublicKey.GetKeyRole() {
+				entry.Role = keys.Role_ROLE_NONE
+			}
+		})
+	case *vcsec.WhitelistOperation_UpdateKeyAndPermissions:
+		change := op.UpdateKeyAndPermissions
+		return c.changePermissions(signer, change, func(entry *KeyEntry) {
+			if change.GetKeyRole() != keys.Role_ROLE_NONE {
+				entry.Role = change.GetKeyRole()
+			}
+			entry.ActiveUntil = time.Time{}
+			if seconds := change.GetSecondsToBeActive(); seconds > 0 {
+				entry.ActiveUntil = time.Now().Add(time.Duration(seconds) * time.Second)
+			}
+		})
+	case *vcsec.WhitelistOperation_ReplaceKey:
+		return c.replaceKey(signer, op.ReplaceKey, formFactor)
+	default:
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_UNDOCUMENTED_ERROR
+	}
+	return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE
+}
+
+// addKey enrolls the key described by change on behalf of signer. The caller must hold c.lock.
+func (c *Connection) addKey(signer *KeyEntry, change *vcsec.PermissionChange, formFactor vcsec.KeyFormFactor, impermanent bool) vcsec.WhitelistOperationInformation_E {
+	if signer.Role != keys.Role_ROLE_OWNER {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NO_PERMISSION_TO_ADD
+	}
+	publicKey := change.GetKey().GetPublicKeyRaw()
+	if code := checkNewKey(publicKey, change.GetKeyRole()); code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE {
+		return code
+	}
+	return addKeyResult(c.keychain.add(&KeyEntry{
+		PublicKey:   append([]byte{}, publicKey...),
+		Role:        change.GetKeyRole(),
+		FormFactor:  formFactor,
+		Impermanent: impermanent,
+	}))
+}
+
+// changePermissions applies update to the keychain entry identified by change on behalf of
+// signer. The caller must hold c.lock.
+func (c *Connection) changePermissions(signer *KeyEntry, change *vcsec.PermissionChange, update func(*KeyEntry)) vcsec.WhitelistOperationInformation_E {
+	if signer.Role != keys.Role_ROLE_OWNER {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NO_PERMISSION_TO_CHANGE_PERMISSIONS
+	}
+	publicKey := change.GetKey().GetPublicKeyRaw()
+	if bytes.Equal(publicKey, signer.PublicKey) {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_ATTEMPTING_TO_REMOVE_OWN_PERMISSIONS
+	}
+	if change.GetKeyRole() == keys.Role_ROLE_SERVICE {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_ATTEMPTING_TO_ADD_KEY_WITH_SERVICE_ROLE
+	}
+	entry := c.keychain.lookup(publicKey)
+	if entry == nil {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_PUBLIC_KEY_NOT_ON_WHITELIST
+	}
+	update(entry)
+	return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE
+}
+
+// replaceKey swaps the key identified by replace for a new key in the same slot, on behalf of
+// signer. The caller must hold c.lock.
+func (c *Connection) replaceKey(signer *KeyEntry, replace *vcsec.ReplaceKey, formFactor vcsec.KeyFormFactor) vcsec.WhitelistOperationInformation_E {
+	if signer.Role != keys.Role_ROLE_OWNER {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NO_PERMISSION_TO_ADD
+	}
+	newKey := replace.GetKeyToAdd().GetPublicKeyRaw()
+	if code := checkNewKey(newKey, replace.GetKeyRole()); code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE {
+		return code
+	}
+	if c.keychain.lookup(newKey) != nil {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_ATTEMPTING_TO_ADD_KEY_THAT_IS_ALREADY_ON_THE_WHITELIST
+	}
+	var slot uint32
+	var old *KeyEntry
+	switch target := replace.GetKeyToReplace().(type) {
+	case *vcsec.ReplaceKey_PublicKeyToReplace:
+		slot, old = c.keychain.find(target.PublicKeyToReplace.GetPublicKeyRaw())
+	case *vcsec.ReplaceKey_SlotToReplace:
+		slot = target.SlotToReplace
+		if slot < uint32(len(c.keychain.slots)) {
+			old = c.keychain.slots[slot]
+		}
+	}
+	if old == nil {
+		return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_PUBLIC_KEY_NOT_ON_WHITELIST
+	}
+	if formFactor == vcsec.KeyFormFactor_KEY_FORM_FACTOR_UNKNOWN {
+		formFactor = old.FormFactor
+	}
+	c.keychain.slots[slot] = &KeyEntry{
+		PublicKey:   append([]byte{}, newKey...),
+		Role:        replace.GetKeyRole(),
+		FormFactor:  formFactor,
+		Impermanent: replace.GetImpermanent(),
+	}
+	c.discardSessions(old.PublicKey)
+	return vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NONE
+}
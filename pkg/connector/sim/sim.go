@@ -0,0 +1,318 @@
+package sim
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/signatures"
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+const uuidLength = 16
+
+var (
+	retryInterval = 10 * time.Millisecond // Responses are generated in-process, so retries can be aggressive
+	maxLatency    = 4 * time.Second       // Max allowed error when syncing vehicle clock
+)
+
+// ErrInboxFull indicates the emulator generated a response, but the client wasn't reading from the
+// Receive() channel quickly enough to accept it.
+var ErrInboxFull = protocol.NewError("dropped response because inbox is full", true, false)
+
+type verifierKey struct {
+	domain    universal.Domain
+	publicKey string
+}
+
+// Connection implements the connector.Connector interface by emulating a vehicle in-process.
+type Connection struct {
+	vin        string
+	authMethod connector.AuthMethod
+	inbox      chan []byte
+
+	lock        sync.Mutex
+	closed      bool
+	domainKeys  map[universal.Domain]authentication.ECDHPrivateKey
+	verifiers   map[verifierKey]*authentication.Verifier
+	keychain    keychain
+	pendingKeys []KeyEntry
+	state       State
+	// clientAddress is the routing address of the most recent request, which the vehicle uses for
+	// messages that aren't replies.
+	clientAddress []byte
+}
+
+// NewConnection creates an emulated vehicle with the provided VIN and an empty keychain.
+//
+// The authMethod determines the value returned by PreferredAuthMethod. Use
+// [connector.AuthMethodGCM] to emulate a BLE connection and [connector.AuthMethodHMAC] to emulate a
+// Fleet API connection. The emulator accepts both types of authentication regardless.
+func NewConnection(vin string, authMethod connector.AuthMethod) (*Connection, error) {
+	conn := Connection{
+		vin:        vin,
+		authMethod: authMethod,
+		inbox:      make(chan []byte, connector.BufferSize),
+		domainKeys: make(map[universal.Domain]authentication.ECDHPrivateKey),
+		verifiers:  make(map[verifierKey]*authentication.Verifier),
+		keychain:   newKeychain(DefaultKeychainCapacity),
+		state:      defaultState(),
+	}
+	for _, domain := range []universal.Domain{universal.Domain_DOMAIN_VEHICLE_SECURITY, universal.Domain_DOMAIN_INFOTAINMENT} {
+		key, err := authentication.NewECDHPrivateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		conn.domainKeys[domain] = key
+	}
+	return &conn, nil
+}
+
+func (c *Connection) PreferredAuthMethod() connector.AuthMethod {
+	return c.authMethod
+}
+
+func (c *Connection) RetryInterval() time.Duration {
+	return retryInterval
+}
+
+func (c *Connection) AllowedLatency() time.Duration {
+	return maxLatency
+}
+
+func (c *Connection) Receive() <-chan []byte {
+	return c.inbox
+}
+
+func (c *Connection) VIN() string {
+	return c.vin
+}
+
+func (c *Connection) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !c.closed {
+		c.closed = true
+		close(c.inbox)
+	}
+}
+
+// Send delivers a buffer to the emulated vehicle. Any response is queued on the Receive() channel
+// before Send returns.
+func (c *Connection) Send(ctx context.Context, buffer []byte) error {
+	if err := ctx.Err(); err != nil {
+		return &protocol.CommandError{Err: err, PossibleSuccess: false, PossibleTemporary: true}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return protocol.ErrNotConnected
+	}
+
+	var message universal.RoutableMessage
+	if err := proto.Unmarshal(buffer, &message); err != nil {
+		log.Warning("[sim] Dropping unparseable message: %s", err)
+		return nil
+	}
+
+	// Add-key requests sent over BLE are not wrapped in a RoutableMessage. Since the fields used by
+	// a ToVCSECMessage are reserved in RoutableMessage, the absence of a destination identifies them.
+	if message.GetToDestination() == nil {
+		c.handleToVCSECMessage(buffer)
+		return nil
+	}
+
+	if address := message.GetFromDestination().GetRoutingAddress(); address != nil {
+		c.clientAddress = append([]byte{}, address...)
+	}
+	reply := c.handle(&message)
+	if reply == nil {
+		return nil
+	}
+	return c.push(reply)
+}
+
+// push queues a message from the vehicle on the Receive() channel. The caller must hold c.lock.
+func (c *Connection) push(message *universal.RoutableMessage) error {
+	if c.closed {
+		return protocol.ErrNotConnected
+	}
+	encodedMessage, err := proto.Marshal(message)
+	if err != nil {
+		return err
+	}
+	select {
+	case c.inbox <- encodedMessage:
+		return nil
+	default:
+		return ErrInboxFull
+	}
+}
+
+// Reboot emulates a restart of the vehicle's security controller and infotainment system. The
+// vehicle keeps its keys and state, but existing sessions are discarded; the next command sent by a
+// client fails with an epoch error that includes session info the client can use to resync.
+func (c *Connection) Reboot() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.verifiers = make(map[verifierKey]*authentication.Verifier)
+}
+
+// verifier returns the Verifier responsible for authenticating messages sent to domain by the
+// client that owns publicKey, creating it if needed. The caller must hold c.lock.
+func (c *Connection) verifier(domain universal.Domain, publicKey []byte) (*authentication.Verifier, error) {
+	index := verifierKey{domain: domain, publicKey: string(publicKey)}
+	if verifier, ok := c.verifiers[index]; ok {
+		return verifier, nil
+	}
+	domainKey, ok := c.domainKeys[domain]
+	if !ok {
+		return nil, errors.New("unsupported domain")
+	}
+	verifier, err := authentication.NewVerifier(domainKey, []byte(c.vin), domain, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	c.verifiers[index] = verifier
+	return verifier, nil
+}
+
+func newReply(request *universal.RoutableMessage) *universal.RoutableMessage {
+	uuid := make([]byte, uuidLength)
+	rand.Read(uuid)
+	return &universal.RoutableMessage{
+		ToDestination: request.GetFromDestination(),
+		FromDestination: &universal.Destination{
+			SubDestination: &universal.Destination_Domain{Domain: request.GetToDestination().GetDomain()},
+		},
+		RequestUuid: append([]byte{}, request.GetUuid()...),
+		Uuid:        uuid,
+	}
+}
+
+func faultReply(request *universal.RoutableMessage, fault universal.MessageFault_E) *universal.RoutableMessage {
+	reply := newReply(request)
+	reply.SignedMessageStatus = &universal.MessageStatus{
+		OperationStatus:    universal.OperationStatus_E_OPERATIONSTATUS_ERROR,
+		SignedMessageFault: fault,
+	}
+	return reply
+}
+
+// handle returns the vehicle's reply to message, or nil if the vehicle would not reply. The caller
+// must hold c.lock.
+func (c *Connection) handle(message *universal.RoutableMessage) *universal.RoutableMessage {
+	domain := message.GetToDestination().GetDomain()
+	if _, ok := c.domainKeys[domain]; !ok {
+		log.Debug("[%02x] [sim] Dropping message to %s", message.GetUuid(), domain)
+		return nil
+	}
+	if domain == universal.Domain_DOMAIN_INFOTAINMENT && c.state.Asleep {
+		log.Debug("[%02x] [sim] Dropping message to %s because vehicle is asleep", message.GetUuid(), domain)
+		return nil
+	}
+
+	if request := message.GetSessionInfoRequest(); request != nil {
+		return c.handleSessionInfoRequest(message, request.GetPublicKey())
+	}
+
+	if message.GetSignatureData() == nil {
+		// Only a limited set of VCSEC requests may be sent without authentication.
+		if domain != universal.Domain_DOMAIN_VEHICLE_SECURITY {
+			return faultReply(message, universal.MessageFault_E_MESSAGEFAULT_ERROR_INSUFFICIENT_PRIVILEGES)
+		}
+		response, fault := c.handleVCSEC(nil, message.GetProtobufMessageAsBytes())
+		return c.vcsecReply(message, response, fault)
+	}
+
+	publicKey := message.GetSignatureData().GetSignerIdentity().GetPublicKey()
+	signer := c.keychain.lookup(publicKey)
+	if signer == nil {
+		return faultReply(message, universal.MessageFault_E_MESSAGEFAULT_ERROR_UNKNOWN_KEY_ID)
+	}
+
+	verifier, err := c.verifier(domain, publicKey)
+	if err != nil {
+		return faultReply(message, universal.MessageFault_E_MESSAGEFAULT_ERROR_INTERNAL)
+	}
+	plaintext, err := verifier.Verify(message)
+	if err != nil {
+		return authenticationErrorReply(message, err)
+	}
+
+	if domain == universal.Domain_DOMAIN_VEHICLE_SECURITY {
+		response, fault := c.handleVCSEC(signer, plaintext)
+		return c.vcsecReply(message, response, fault)
+	}
+	response, fault := c.handleInfotainment(signer, plaintext)
+	return c.infotainmentReply(message, response, fault)
+}
+
+// authenticationErrorReply translates an error returned by an authentication.Verifier into the
+// message the vehicle sends back to the client. Errors that may have been caused by a
+// desynchronized client include session info so the client can resync.
+func authenticationErrorReply(request *universal.RoutableMessage, err error) *universal.RoutableMessage {
+	var sigErr *authentication.InvalidSignatureError
+	if errors.As(err, &sigErr) {
+		reply := faultReply(request, sigErr.Code)
+		reply.Payload = &universal.RoutableMessage_SessionInfo{SessionInfo: sigErr.EncodedInfo}
+		reply.SubSigData = &universal.RoutableMessage_SignatureData{
+			SignatureData: &signatures.SignatureData{
+				SigType: &signatures.SignatureData_SessionInfoTag{
+					SessionInfoTag: &signatures.HMAC_Signature_Data{Tag: sigErr.Tag},
+				},
+			},
+		}
+		return reply
+	}
+	var authErr *authentication.Error
+	if errors.As(err, &authErr) {
+		return faultReply(request, authErr.Code)
+	}
+	return faultReply(request, universal.MessageFault_E_MESSAGEFAULT_ERROR_INTERNAL)
+}
+
+func (c *Connection) handleSessionInfoRequest(message *universal.RoutableMessage, publicKey []byte) *universal.RoutableMessage {
+	reply := newReply(message)
+	if c.keychain.lookup(publicKey) == nil {
+		info := &signatures.SessionInfo{Status: signatures.Session_Info_Status_SESSION_INFO_STATUS_KEY_NOT_ON_WHITELIST}
+		encodedInfo, err := proto.Marshal(info)
+		if err != nil {
+			return faultReply(message, universal.MessageFault_E_MESSAGEFAULT_ERROR_INTERNAL)
+		}
+		reply.Payload = &universal.RoutableMessage_SessionInfo{SessionInfo: encodedInfo}
+		return reply
+	}
+	verifier, err := c.verifier(message.GetToDestination().GetDomain(), publicKey)
+	if err != nil {
+		return faultReply(message, universal.MessageFault_E_MESSAGEFAULT_ERROR_BAD_PARAMETER)
+	}
+	if err := verifier.SetSessionInfo(message.GetUuid(), reply); err != nil {
+		return faultReply(message, universal.MessageFault_E_MESSAGEFAULT_ERROR_INTERNAL)
+	}
+	return reply
+}
+
+func (c *Connection) vcsecReply(request *universal.RoutableMessage, response *vcsec.FromVCSECMessage, fault universal.MessageFault_E) *universal.RoutableMessage {
+	if fault != universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE {
+		return faultReply(request, fault)
+	}
+	encodedResponse, err := proto.Marshal(response)
+	if err != nil {
+		return faultReply(request, universal.MessageFault_E_MESSAGEFAULT_ERROR_INTERNAL)
+	}
+	reply := newReply(request)
+	reply.Payload = &universal.RoutableMessage_ProtobufMessageAsBytes{ProtobufMessageAsBytes: encodedResponse}
+	return reply
+}
@@ -0,0 +1,841 @@
+package sim_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/cache"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/sim"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/tracing"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+const testVIN = "0123456789ABCDEFG"
+
+func newKey(t *testing.T) authentication.ECDHPrivateKey {
+	t.Helper()
+	key, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func publicKey(t *testing.T, key authentication.ECDHPrivateKey) *ecdh.PublicKey {
+	t.Helper()
+	pub, err := ecdh.P256().NewPublicKey(key.PublicBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+// connect returns an emulated car and a Vehicle that has started sessions with it. If role is
+// ROLE_NONE, the client key is not enrolled and no sessions are started.
+func connect(t *testing.T, ctx context.Context, role keys.Role) (*sim.Connection, *vehicle.Vehicle, authentication.ECDHPrivateKey) {
+	t.Helper()
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey := newKey(t)
+	if role != keys.Role_ROLE_NONE {
+		if err := car.AddKey(skey.PublicBytes(), role, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v, err := vehicle.NewVehicle(car, skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Disconnect)
+	if role != keys.Role_ROLE_NONE {
+		if err := v.StartSession(ctx, nil); err != nil {
+			t.Fatalf("Failed to start session: %s", err)
+		}
+	}
+	return car, v, skey
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func TestCommands(t *testing.T) {
+	ctx := testContext(t)
+	car, v, _ := connect(t, ctx, keys.Role_ROLE_OWNER)
+
+	if err := v.Unlock(ctx); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED {
+		t.Errorf("Unexpected lock state after unlocking: %s", state)
+	}
+	if err := v.Lock(ctx); err != nil {
+		t.Fatalf("Lock failed: %s", err)
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED {
+		t.Errorf("Unexpected lock state after locking: %s", state)
+	}
+
+	if err := v.OpenTrunk(ctx); err != nil {
+		t.Fatalf("OpenTrunk failed: %s", err)
+	}
+	status, err := v.BodyControllerState(ctx)
+	if err != nil {
+		t.Fatalf("BodyControllerState failed: %s", err)
+	}
+	if trunk := status.GetClosureStatuses().GetRearTrunk(); trunk != vcsec.ClosureState_E_CLOSURESTATE_OPEN {
+		t.Errorf("Unexpected trunk state: %s", trunk)
+	}
+
+	if err := v.ChangeChargeLimit(ctx, 90); err != nil {
+		t.Fatalf("ChangeChargeLimit failed: %s", err)
+	}
+	if limit := car.State().ChargeLimitPercent; limit != 90 {
+		t.Errorf("Unexpected charge limit: %d", limit)
+	}
+	err = v.ChangeChargeLimit(ctx, 10)
+	var nominalErr *protocol.NominalError
+	if !errors.As(err, &nominalErr) {
+		t.Errorf("Expected NominalError for invalid charge limit but got %v", err)
+	}
+}
+
+func TestUnpairedKey(t *testing.T) {
+	ctx := testContext(t)
+	_, v, _ := connect(t, ctx, keys.Role_ROLE_NONE)
+	if err := v.StartSession(ctx, nil); !errors.Is(err, protocol.ErrKeyNotPaired) {
+		t.Errorf("Expected ErrKeyNotPaired but got %v", err)
+	}
+}
+
+func TestReboot(t *testing.T) {
+	ctx := testContext(t)
+	car, v, _ := connect(t, ctx, keys.Role_ROLE_OWNER)
+	car.Reboot()
+	if err := v.Unlock(ctx); err != nil {
+		t.Fatalf("Client failed to resync after reboot: %s", err)
+	}
+	if err := v.ChangeChargeLimit(ctx, 70); err != nil {
+		t.Fatalf("Client failed to resync infotainment after reboot: %s", err)
+	}
+}
+
+// keepOpen prevents a Vehicle from closing the emulator when it disconnects.
+type keepOpen struct {
+	*sim.Connection
+}
+
+func (keepOpen) Close() {}
+
+func TestRebootInvalidatesCachedSessions(t *testing.T) {
+	ctx := testContext(t)
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey := newKey(t)
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := cache.New(0)
+	v, err := vehicle.NewVehicle(keepOpen{car}, skey, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.UpdateCachedSessions(sessions); err != nil {
+		t.Fatal(err)
+	}
+	v.Disconnect()
+
+	resumed, err := vehicle.NewVehicle(car, skey, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Disconnect()
+
+	car.Reboot()
+	if err := resumed.Unlock(ctx); err != nil {
+		t.Fatalf("Client failed to resync after reboot: %s", err)
+	}
+	if n := sessions.Stats().Invalidations; n != 1 {
+		t.Errorf("Expected one invalidated session but got %d", n)
+	}
+	entries, ok := sessions.GetEntry(testVIN)
+	if !ok || len(entries) != 1 || entries[0].Domain != int(universal.Domain_DOMAIN_INFOTAINMENT) {
+		t.Errorf("Expected only infotainment session to remain in cache, got %+v", entries)
+	}
+}
+
+func TestRolePermissions(t *testing.T) {
+	ctx := testContext(t)
+	car, v, _ := connect(t, ctx, keys.Role_ROLE_CHARGING_MANAGER)
+	if err := v.ChangeChargeLimit(ctx, 60); err != nil {
+		t.Fatalf("Charging manager could not change charge limit: %s", err)
+	}
+	if err := v.Unlock(ctx); err == nil {
+		t.Error("Charging manager was able to unlock vehicle")
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+}
+
+func TestKeychainErrors(t *testing.T) {
+	ctx := testContext(t)
+	car, v, skey := connect(t, ctx, keys.Role_ROLE_OWNER)
+	var keychainErr *protocol.KeychainError
+
+	err := v.AddKeyWithRole(ctx, publicKey(t, skey), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY)
+	if !errors.As(err, &keychainErr) || keychainErr.Code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_ATTEMPTING_TO_ADD_KEY_THAT_IS_ALREADY_ON_THE_WHITELIST {
+		t.Errorf("Expected duplicate key error but got %v", err)
+	}
+
+	if err := car.SetKeychainCapacity(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.AddKeyWithRole(ctx, publicKey(t, newKey(t)), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatalf("Failed to add key: %s", err)
+	}
+	err = v.AddKeyWithRole(ctx, publicKey(t, newKey(t)), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY)
+	if !errors.As(err, &keychainErr) || keychainErr.Code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_WHITELIST_FULL {
+		t.Errorf("Expected keychain full error but got %v", err)
+	}
+
+	summary, err := v.KeySummary(ctx)
+	if err != nil {
+		t.Fatalf("KeySummary failed: %s", err)
+	}
+	if summary.GetNumberOfEntries() != 2 {
+		t.Errorf("Expected 2 keys but got %d", summary.GetNumberOfEntries())
+	}
+}
+
+func TestImpermanentKeys(t *testing.T) {
+	ctx := testContext(t)
+	car, v, _ := connect(t, ctx, keys.Role_ROLE_OWNER)
+	first, second := newKey(t), newKey(t)
+
+	if err := v.AddImpermanentKey(ctx, publicKey(t, first), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatalf("Failed to add impermanent key: %s", err)
+	}
+	if err := v.AddImpermanentKeyAndRemoveExisting(ctx, publicKey(t, second), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatalf("Failed to add impermanent key: %s", err)
+	}
+	entries := car.Keys()
+	if len(entries) != 2 {
+		t.Fatalf("Expected owner key and one impermanent key, got %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry.Impermanent && !bytes.Equal(entry.PublicKey, second.PublicBytes()) {
+			t.Errorf("Unexpected impermanent key %02x", entry.PublicKey)
+		}
+	}
+	if err := v.RemoveAllImpermanentKeys(ctx); err != nil {
+		t.Fatalf("Failed to remove impermanent keys: %s", err)
+	}
+	if n := len(car.Keys()); n != 1 {
+		t.Errorf("Expected only the owner key to remain, got %d keys", n)
+	}
+}
+
+func TestKeyPermissions(t *testing.T) {
+	ctx := testContext(t)
+	car, v, skey := connect(t, ctx, keys.Role_ROLE_OWNER)
+	other := newKey(t)
+	if err := car.AddKey(other.PublicBytes(), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+	role := func() keys.Role {
+		for _, entry := range car.Keys() {
+			if bytes.Equal(entry.PublicKey, other.PublicBytes()) {
+				return entry.Role
+			}
+		}
+		return keys.Role_ROLE_NONE
+	}
+
+	if err := v.AddKeyPermissions(ctx, publicKey(t, other), keys.Role_ROLE_CHARGING_MANAGER); err != nil {
+		t.Fatalf("Failed to add permissions: %s", err)
+	}
+	if r := role(); r != keys.Role_ROLE_CHARGING_MANAGER {
+		t.Errorf("Unexpected role after adding permissions: %s", r)
+	}
+	if err := v.RemoveKeyPermissions(ctx, publicKey(t, other), keys.Role_ROLE_CHARGING_MANAGER); err != nil {
+		t.Fatalf("Failed to remove permissions: %s", err)
+	}
+	if r := role(); r != keys.Role_ROLE_NONE {
+		t.Errorf("Unexpected role after removing permissions: %s", r)
+	}
+
+	var keychainErr *protocol.KeychainError
+	err := v.RemoveKeyPermissions(ctx, publicKey(t, skey), keys.Role_ROLE_OWNER)
+	if !errors.As(err, &keychainErr) || keychainErr.Code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_ATTEMPTING_TO_REMOVE_OWN_PERMISSIONS {
+		t.Errorf("Expected error when removing own permissions, got %v", err)
+	}
+	err = v.AddKeyPermissions(ctx, publicKey(t, newKey(t)), keys.Role_ROLE_DRIVER)
+	if !errors.As(err, &keychainErr) || keychainErr.Code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_PUBLIC_KEY_NOT_ON_WHITELIST {
+		t.Errorf("Expected unknown key error, got %v", err)
+	}
+}
+
+func TestUpdateKeyAndPermissions(t *testing.T) {
+	ctx := testContext(t)
+	car, v, _ := connect(t, ctx, keys.Role_ROLE_OWNER)
+	other := newKey(t)
+	if err := car.AddKey(other.PublicBytes(), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.UpdateKeyAndPermissions(ctx, publicKey(t, other), keys.Role_ROLE_VEHICLE_MONITOR, time.Second); err != nil {
+		t.Fatalf("Failed to update key: %s", err)
+	}
+	var updated *sim.KeyEntry
+	for _, entry := range car.Keys() {
+		if bytes.Equal(entry.PublicKey, other.PublicBytes()) {
+			updated = &entry
+		}
+	}
+	if updated == nil || updated.Role != keys.Role_ROLE_VEHICLE_MONITOR || updated.ActiveUntil.IsZero() {
+		t.Fatalf("Unexpected keychain entry: %+v", updated)
+	}
+	time.Sleep(time.Until(updated.ActiveUntil))
+	if n := len(car.Keys()); n != 1 {
+		t.Errorf("Expected time-boxed key to expire, but keychain has %d keys", n)
+	}
+}
+
+func TestReplaceKey(t *testing.T) {
+	ctx := testContext(t)
+	car, v, _ := connect(t, ctx, keys.Role_ROLE_OWNER)
+	old, replacement, third := newKey(t), newKey(t), newKey(t)
+	if err := car.AddKey(old.PublicBytes(), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_NFC_CARD); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.ReplaceKey(ctx, publicKey(t, old), publicKey(t, replacement), keys.Role_ROLE_DRIVER, false); err != nil {
+		t.Fatalf("Failed to replace key: %s", err)
+	}
+	var slot uint32
+	found := false
+	for s, entry := range car.Keys() {
+		if bytes.Equal(entry.PublicKey, old.PublicBytes()) {
+			t.Error("Replaced key is still enrolled")
+		}
+		if bytes.Equal(entry.PublicKey, replacement.PublicBytes()) {
+			slot, found = s, true
+			if entry.FormFactor != vcsec.KeyFormFactor_KEY_FORM_FACTOR_NFC_CARD {
+				t.Errorf("Expected replacement to keep form factor, got %s", entry.FormFactor)
+			}
+		}
+	}
+	if !found {
+		t.Fatal("Replacement key wasn't enrolled")
+	}
+
+	if err := v.ReplaceKeyInSlot(ctx, slot, publicKey(t, third), keys.Role_ROLE_CHARGING_MANAGER, true); err != nil {
+		t.Fatalf("Failed to replace key by slot: %s", err)
+	}
+	if entry := car.Keys()[slot]; !bytes.Equal(entry.PublicKey, third.PublicBytes()) || !entry.Impermanent {
+		t.Errorf("Unexpected entry in slot %d: %+v", slot, entry)
+	}
+
+	var keychainErr *protocol.KeychainError
+	err := v.ReplaceKey(ctx, publicKey(t, old), publicKey(t, newKey(t)), keys.Role_ROLE_DRIVER, false)
+	if !errors.As(err, &keychainErr) || keychainErr.Code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_PUBLIC_KEY_NOT_ON_WHITELIST {
+		t.Errorf("Expected unknown key error, got %v", err)
+	}
+}
+
+func TestListKeys(t *testing.T) {
+	ctx := testContext(t)
+	car, v, skey := connect(t, ctx, keys.Role_ROLE_OWNER)
+	others := []authentication.ECDHPrivateKey{newKey(t), newKey(t), newKey(t)}
+	for _, key := range others {
+		if err := car.AddKey(key.PublicBytes(), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_NFC_CARD); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Leave a gap in the slot mask.
+	car.RemoveKey(others[0].PublicBytes())
+
+	entries, err := v.ListKeys(ctx)
+	if err != nil {
+		t.Fatalf("ListKeys failed: %s", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 keys but got %+v", entries)
+	}
+	if !entries[0].IsOwnKey || !bytes.Equal(entries[0].PublicKey, skey.PublicBytes()) || entries[0].Role != keys.Role_ROLE_OWNER {
+		t.Errorf("Unexpected first entry: %+v", entries[0])
+	}
+	for i, entry := range entries[1:] {
+		if entry.IsOwnKey || entry.Slot != uint32(i+2) || entry.FormFactor != vcsec.KeyFormFactor_KEY_FORM_FACTOR_NFC_CARD {
+			t.Errorf("Unexpected entry: %+v", entry)
+		}
+		if entry.Fingerprint != vehicle.KeyFingerprint(others[i+1].PublicBytes()) {
+			t.Errorf("Unexpected fingerprint for slot %d", entry.Slot)
+		}
+	}
+
+	if err := v.RemoveKeyInSlot(ctx, entries[1].Slot); err != nil {
+		t.Fatalf("RemoveKeyInSlot failed: %s", err)
+	}
+	if _, ok := car.Keys()[entries[1].Slot]; ok {
+		t.Error("Key wasn't removed")
+	}
+	var keychainErr *protocol.KeychainError
+	err = v.RemoveKeyInSlot(ctx, entries[0].Slot)
+	if !errors.As(err, &keychainErr) || keychainErr.Code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_NO_PERMISSION_TO_REMOVE_ONESELF {
+		t.Errorf("Expected error when removing own key, got %v", err)
+	}
+}
+
+func TestPairKey(t *testing.T) {
+	ctx := testContext(t)
+	car, v, skey := connect(t, ctx, keys.Role_ROLE_NONE)
+
+	var stages []vehicle.PairingStage
+	progress := func(p vehicle.PairingProgress) {
+		stages = append(stages, p.Stage)
+		if p.Err != nil {
+			// A key that isn't enrolled yet is expected while waiting, not a polling error.
+			t.Errorf("Unexpected error in %s stage: %s", p.Stage, p.Err)
+		}
+		if p.Stage == vehicle.PairingWaiting && len(stages) == 3 {
+			// Tap the NFC card after the vehicle has been polled twice.
+			if err := car.ApproveKeyRequests(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	err := v.PairKey(ctx, publicKey(t, skey), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY, progress)
+	if err != nil {
+		t.Fatalf("PairKey failed: %s", err)
+	}
+	expected := []vehicle.PairingStage{vehicle.PairingRequestSent, vehicle.PairingWaiting, vehicle.PairingWaiting, vehicle.PairingEnrolled}
+	if len(stages) != len(expected) {
+		t.Fatalf("Expected stages %v but got %v", expected, stages)
+	}
+	for i := range expected {
+		if stages[i] != expected[i] {
+			t.Errorf("Expected stages %v but got %v", expected, stages)
+			break
+		}
+	}
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Errorf("Couldn't start session with paired key: %s", err)
+	}
+}
+
+func TestPairKeyRejected(t *testing.T) {
+	ctx := testContext(t)
+	car, v, skey := connect(t, ctx, keys.Role_ROLE_NONE)
+
+	progress := func(p vehicle.PairingProgress) {
+		if p.Stage == vehicle.PairingWaiting {
+			if err := car.RejectKeyRequests(vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_WHITELIST_FULL); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+	err := v.PairKey(ctx, publicKey(t, skey), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY, progress)
+	var keychainErr *protocol.KeychainError
+	if !errors.Is(err, vehicle.ErrPairingRejected) || !errors.As(err, &keychainErr) {
+		t.Fatalf("Expected rejection but got %v", err)
+	}
+	if keychainErr.Code != vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_WHITELIST_FULL {
+		t.Errorf("Unexpected rejection reason: %s", keychainErr.Code)
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = v.PairKey(shortCtx, publicKey(t, skey), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected timeout but got %v", err)
+	}
+}
+
+func TestAsleep(t *testing.T) {
+	ctx := testContext(t)
+	car, v, _ := connect(t, ctx, keys.Role_ROLE_OWNER)
+	car.UpdateState(func(s *sim.State) { s.Asleep = true })
+
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := v.ChangeChargeLimit(shortCtx, 70); err == nil {
+		t.Error("Sleeping vehicle executed infotainment command")
+	}
+
+	status, err := v.BodyControllerState(ctx)
+	if err != nil {
+		t.Fatalf("BodyControllerState failed: %s", err)
+	}
+	if status.GetVehicleSleepStatus() != vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_ASLEEP {
+		t.Errorf("Unexpected sleep status: %s", status.GetVehicleSleepStatus())
+	}
+
+	if err := v.Wakeup(ctx); err != nil {
+		t.Fatalf("Wakeup failed: %s", err)
+	}
+	if err := v.ChangeChargeLimit(ctx, 70); err != nil {
+		t.Errorf("ChangeChargeLimit failed after wakeup: %s", err)
+	}
+}
+
+// connectAsleep returns a sleeping emulated car and a Vehicle whose key is enrolled but that hasn't
+// started any sessions.
+func connectAsleep(t *testing.T, ctx context.Context) (*sim.Connection, *vehicle.Vehicle) {
+	t.Helper()
+	car, v, skey := connect(t, ctx, keys.Role_ROLE_NONE)
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+	car.UpdateState(func(s *sim.State) { s.Asleep = true })
+	return car, v
+}
+
+func TestStartSessionSleepAware(t *testing.T) {
+	ctx := testContext(t)
+	car, v := connectAsleep(t, ctx)
+
+	var stages []vehicle.WakeStage
+	onWake := func(p vehicle.WakeProgress) { stages = append(stages, p.Stage) }
+	if err := v.StartSessionSleepAware(ctx, []universal.Domain{protocol.DomainInfotainment}, onWake); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	if len(stages) != 2 || stages[0] != vehicle.WakeRequested || stages[1] != vehicle.WakeCompleted {
+		t.Errorf("Unexpected wake progress: %v", stages)
+	}
+	if car.State().Asleep {
+		t.Error("Vehicle wasn't woken up")
+	}
+	if err := v.ChangeChargeLimit(ctx, 70); err != nil {
+		t.Errorf("ChangeChargeLimit failed: %s", err)
+	}
+}
+
+func TestStartSessionSleepAwareDefaultDomains(t *testing.T) {
+	ctx := testContext(t)
+	car, v := connectAsleep(t, ctx)
+
+	onWake := func(p vehicle.WakeProgress) { t.Errorf("Unexpected wake progress: %s", p.Stage) }
+	if err := v.StartSessionSleepAware(ctx, nil, onWake); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	if !car.State().Asleep {
+		t.Error("Vehicle was woken up")
+	}
+	if err := v.Lock(ctx); err != nil {
+		t.Errorf("Lock failed: %s", err)
+	}
+	if err := v.ChangeChargeLimit(ctx, 70); !errors.Is(err, protocol.ErrNoSession) {
+		t.Errorf("Expected ErrNoSession for infotainment command but got %v", err)
+	}
+}
+
+func TestAddKeyRequest(t *testing.T) {
+	ctx := testContext(t)
+	car, v, skey := connect(t, ctx, keys.Role_ROLE_NONE)
+	if err := v.SendAddKeyRequestWithRole(ctx, publicKey(t, skey), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatalf("Failed to send add-key request: %s", err)
+	}
+	if pending := car.PendingKeyRequests(); len(pending) != 1 || pending[0].Role != keys.Role_ROLE_OWNER {
+		t.Fatalf("Unexpected pending requests: %v", pending)
+	}
+	if err := car.ApproveKeyRequests(); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Fatalf("Failed to start session after approval: %s", err)
+	}
+	if err := v.Unlock(ctx); err != nil {
+		t.Errorf("Unlock failed after approval: %s", err)
+	}
+}
+
+// latencyConn wraps an emulated vehicle to delay its responses, and checks that authenticated
+// messages to each domain arrive with increasing anti-replay counters.
+type latencyConn struct {
+	*sim.Connection
+	t       *testing.T
+	latency time.Duration
+	inbox   chan []byte
+	pending sync.WaitGroup
+
+	lock     sync.Mutex
+	closed   bool
+	counters map[universal.Domain]uint32
+}
+
+func newLatencyConn(t *testing.T, car *sim.Connection, latency time.Duration) *latencyConn {
+	return &latencyConn{
+		Connection: car,
+		t:          t,
+		latency:    latency,
+		inbox:      make(chan []byte, 4*connector.BufferSize),
+		counters:   make(map[universal.Domain]uint32),
+	}
+}
+
+func (c *latencyConn) Receive() <-chan []byte {
+	return c.inbox
+}
+
+func (c *latencyConn) Close() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	c.Connection.Close()
+	c.lock.Unlock()
+	c.pending.Wait()
+	close(c.inbox)
+}
+
+func (c *latencyConn) Send(ctx context.Context, buffer []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return protocol.ErrNotConnected
+	}
+
+	var message universal.RoutableMessage
+	if err := proto.Unmarshal(buffer, &message); err == nil {
+		sig := message.GetSignatureData()
+		counter := sig.GetAES_GCM_PersonalizedData().GetCounter() + sig.GetHMAC_PersonalizedData().GetCounter()
+		if counter > 0 {
+			domain := message.GetToDestination().GetDomain()
+			if counter <= c.counters[domain] {
+				c.t.Errorf("Counter %d sent to %s after counter %d", counter, domain, c.counters[domain])
+			}
+			c.counters[domain] = counter
+		}
+	}
+	if err := c.Connection.Send(ctx, buffer); err != nil {
+		return err
+	}
+	// The emulator queues its response before Send returns.
+	for {
+		select {
+		case response := <-c.Connection.Receive():
+			c.pending.Add(1)
+			time.AfterFunc(c.latency, func() {
+				defer c.pending.Done()
+				c.inbox <- response
+			})
+		default:
+			return nil
+		}
+	}
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	const latency = 100 * time.Millisecond
+	const commands = 8
+
+	ctx := testContext(t)
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey := newKey(t)
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+	v, err := vehicle.NewVehicle(newLatencyConn(t, car, latency), skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer v.Disconnect()
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < commands; i++ {
+		wg.Add(2)
+		go func(limit int32) {
+			defer wg.Done()
+			if err := v.ChangeChargeLimit(ctx, limit); err != nil {
+				t.Errorf("ChangeChargeLimit failed: %s", err)
+			}
+		}(int32(60 + i))
+		go func() {
+			defer wg.Done()
+			if err := v.Lock(ctx); err != nil {
+				t.Errorf("Lock failed: %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Sending the commands one at a time would take 2*commands round trips.
+	if elapsed := time.Since(start); elapsed > commands*latency/2 {
+		t.Errorf("Concurrent commands took %s; expected them to be pipelined", elapsed)
+	}
+	if state := car.State().LockState; state != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED {
+		t.Errorf("Unexpected lock state: %s", state)
+	}
+}
+
+type commandObserver struct {
+	vehicle.NopObserver
+	lock     sync.Mutex
+	commands map[universal.Domain]int
+}
+
+func (c *commandObserver) CommandCompleted(domain universal.Domain, latency time.Duration, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.commands[domain]++
+}
+
+func TestStats(t *testing.T) {
+	ctx := testContext(t)
+	_, v, _ := connect(t, ctx, keys.Role_ROLE_OWNER)
+	observer := &commandObserver{commands: make(map[universal.Domain]int)}
+	v.SetObserver(observer)
+
+	if err := v.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.ChangeChargeLimit(ctx, 80); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.ChangeChargeLimit(ctx, 10); err == nil {
+		t.Fatal("Expected invalid charge limit to fail")
+	}
+
+	stats := v.Stats()
+	for _, domain := range []universal.Domain{universal.Domain_DOMAIN_VEHICLE_SECURITY, universal.Domain_DOMAIN_INFOTAINMENT} {
+		if s := stats.Domains[domain]; s.Handshakes != 1 || s.CommandFailures != 0 || s.CommandLatency <= 0 {
+			t.Errorf("Unexpected stats for %s: %+v", domain, s)
+		}
+	}
+	// Commands the vehicle declines to execute still complete a round trip.
+	if n := stats.Domains[universal.Domain_DOMAIN_INFOTAINMENT].Commands; n != 2 {
+		t.Errorf("Expected two infotainment commands but got %d", n)
+	}
+	observer.lock.Lock()
+	defer observer.lock.Unlock()
+	if observer.commands[universal.Domain_DOMAIN_VEHICLE_SECURITY] != 1 || observer.commands[universal.Domain_DOMAIN_INFOTAINMENT] != 2 {
+		t.Errorf("Observer saw unexpected commands: %v", observer.commands)
+	}
+}
+
+type spanRecord struct {
+	name       string
+	parent     string
+	attributes map[string]string
+}
+
+type spanRecorder struct {
+	lock  sync.Mutex
+	spans []*spanRecord
+}
+
+type spanKey struct{}
+
+func (r *spanRecorder) Start(ctx context.Context, name string) (context.Context, tracing.Span) {
+	record := &spanRecord{name: name, attributes: make(map[string]string)}
+	if parent, ok := ctx.Value(spanKey{}).(*spanRecord); ok {
+		record.parent = parent.name
+	}
+	r.lock.Lock()
+	r.spans = append(r.spans, record)
+	r.lock.Unlock()
+	return context.WithValue(ctx, spanKey{}, record), &recordingSpan{r, record}
+}
+
+type recordingSpan struct {
+	r      *spanRecorder
+	record *spanRecord
+}
+
+func (s *recordingSpan) SetAttribute(key, value string) {
+	s.r.lock.Lock()
+	defer s.r.lock.Unlock()
+	s.record.attributes[key] = value
+}
+
+func (s *recordingSpan) End(error) {}
+
+func TestTracing(t *testing.T) {
+	recorder := &spanRecorder{}
+	ctx := tracing.WithTracer(testContext(t), recorder)
+	_, v, _ := connect(t, ctx, keys.Role_ROLE_OWNER)
+	if err := v.ChangeChargeLimit(ctx, 80); err != nil {
+		t.Fatal(err)
+	}
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+	handshakes := 0
+	var send *spanRecord
+	for _, span := range recorder.spans {
+		switch {
+		case span.name == "dispatcher.StartSession":
+			handshakes++
+			if span.attributes[tracing.AttrVIN] != testVIN || span.attributes[tracing.AttrOutcome] != tracing.OutcomeOK {
+				t.Errorf("Unexpected handshake attributes: %v", span.attributes)
+			}
+		case span.name == "dispatcher.Send" && span.parent == "vehicle.Send":
+			send = span
+		}
+	}
+	if handshakes != 2 {
+		t.Errorf("Expected 2 handshake spans but got %d", handshakes)
+	}
+	if send == nil {
+		t.Fatal("Missing span for command")
+	}
+	expected := map[string]string{
+		tracing.AttrDomain:     universal.Domain_DOMAIN_INFOTAINMENT.String(),
+		tracing.AttrAuthMethod: connector.AuthMethodGCM.String(),
+		tracing.AttrOutcome:    tracing.OutcomeOK,
+	}
+	for key, value := range expected {
+		if send.attributes[key] != value {
+			t.Errorf("Expected %s=%s but got %s", key, value, send.attributes[key])
+		}
+	}
+	if len(send.attributes[tracing.AttrRequestUUID]) != 32 {
+		t.Errorf("Unexpected request UUID: %s", send.attributes[tracing.AttrRequestUUID])
+	}
+}
@@ -0,0 +1,88 @@
+package sim
+
+import (
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+// State contains the emulated vehicle state that commands can modify.
+type State struct {
+	Asleep             bool // Infotainment does not respond to messages while asleep
+	LockState          vcsec.VehicleLockState_E
+	UserPresent        bool
+	RemoteDriveEnabled bool
+
+	FrontDriverDoor    vcsec.ClosureState_E
+	FrontPassengerDoor vcsec.ClosureState_E
+	RearDriverDoor     vcsec.ClosureState_E
+	RearPassengerDoor  vcsec.ClosureState_E
+	RearTrunk          vcsec.ClosureState_E
+	FrontTrunk         vcsec.ClosureState_E
+	ChargePort         vcsec.ClosureState_E
+	Tonneau            vcsec.ClosureState_E
+
+	ChargeLimitPercent int32
+	ChargingAmps       int32
+	Charging           bool
+
+	ClimateOn             bool
+	DriverTempCelsius     float32
+	PassengerTempCelsius  float32
+	SteeringWheelHeaterOn bool
+	SentryModeOn          bool
+	VehicleName           string
+	MediaVolume           float32
+}
+
+func defaultState() State {
+	return State{
+		LockState:            vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED,
+		ChargeLimitPercent:   80,
+		ChargingAmps:         32,
+		DriverTempCelsius:    21,
+		PassengerTempCelsius: 21,
+	}
+}
+
+// vehicleStatus returns the VCSEC representation of s.
+func (s *State) vehicleStatus() *vcsec.VehicleStatus {
+	status := &vcsec.VehicleStatus{
+		ClosureStatuses: &vcsec.ClosureStatuses{
+			FrontDriverDoor:    s.FrontDriverDoor,
+			FrontPassengerDoor: s.FrontPassengerDoor,
+			RearDriverDoor:     s.RearDriverDoor,
+			RearPassengerDoor:  s.RearPassengerDoor,
+			RearTrunk:          s.RearTrunk,
+			FrontTrunk:         s.FrontTrunk,
+			ChargePort:         s.ChargePort,
+			Tonneau:            s.Tonneau,
+		},
+		VehicleLockState:   s.LockState,
+		VehicleSleepStatus: vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_AWAKE,
+		UserPresence:       vcsec.UserPresence_E_VEHICLE_USER_PRESENCE_NOT_PRESENT,
+	}
+	if s.Asleep {
+		status.VehicleSleepStatus = vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_ASLEEP
+	}
+	if s.UserPresent {
+		status.UserPresence = vcsec.UserPresence_E_VEHICLE_USER_PRESENCE_PRESENT
+	}
+	if s.Tonneau == vcsec.ClosureState_E_CLOSURESTATE_OPEN {
+		status.DetailedClosureStatus = &vcsec.DetailedClosureStatus{TonneauPercentOpen: 100}
+	}
+	return status
+}
+
+// State returns a snapshot of the emulated vehicle's state.
+func (c *Connection) State() State {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.state
+}
+
+// UpdateState allows tests to modify the emulated vehicle's state, for example to put the vehicle
+// to sleep or open a door.
+func (c *Connection) UpdateState(update func(s *State)) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	update(&c.state)
+}
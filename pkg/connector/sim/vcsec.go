@@ -0,0 +1,126 @@
+package sim
+
+// This file emulates the Vehicle Security Controller (VCSEC) domain, which handles key management,
+// locks, and closures.
+
+import (
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+// canActuate returns true if role is allowed to unlock the vehicle, open closures, etc.
+func canActuate(role keys.Role) bool {
+	switch role {
+	case keys.Role_ROLE_OWNER, keys.Role_ROLE_DRIVER, keys.Role_ROLE_FM, keys.Role_ROLE_SERVICE:
+		return true
+	}
+	return false
+}
+
+// moveClosure returns the state of a closure after applying action.
+func moveClosure(state vcsec.ClosureState_E, action vcsec.ClosureMoveType_E) vcsec.ClosureState_E {
+	switch action {
+	case vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_MOVE:
+		if state == vcsec.ClosureState_E_CLOSURESTATE_CLOSED {
+			return vcsec.ClosureState_E_CLOSURESTATE_OPEN
+		}
+		return vcsec.ClosureState_E_CLOSURESTATE_CLOSED
+	case vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_OPEN:
+		return vcsec.ClosureState_E_CLOSURESTATE_OPEN
+	case vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_CLOSE:
+		return vcsec.ClosureState_E_CLOSURESTATE_CLOSED
+	}
+	return state
+}
+
+// handleVCSEC processes an encoded vcsec.UnsignedMessage. The signer is nil if the message was not
+// authenticated. The caller must hold c.lock.
+func (c *Connection) handleVCSEC(signer *KeyEntry, plaintext []byte) (*vcsec.FromVCSECMessage, universal.MessageFault_E) {
+	var message vcsec.UnsignedMessage
+	if err := proto.Unmarshal(plaintext, &message); err != nil {
+		return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_DECODING
+	}
+
+	if request := message.GetInformationRequest(); request != nil {
+		return c.handleInformationRequest(request), universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+	}
+
+	if signer == nil {
+		return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_INSUFFICIENT_PRIVILEGES
+	}
+
+	if operation := message.GetWhitelistOperation(); operation != nil {
+		return whitelistOperationResult(c.executeWhitelistOperation(signer, operation)), universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+	}
+
+	if !canActuate(signer.Role) {
+		return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_INSUFFICIENT_PRIVILEGES
+	}
+
+	switch payload := message.GetSubMessage().(type) {
+	case *vcsec.UnsignedMessage_RKEAction:
+		switch payload.RKEAction {
+		case vcsec.RKEAction_E_RKE_ACTION_UNLOCK:
+			c.state.LockState = vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED
+		case vcsec.RKEAction_E_RKE_ACTION_LOCK:
+			c.state.LockState = vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED
+		case vcsec.RKEAction_E_RKE_ACTION_REMOTE_DRIVE:
+			c.state.RemoteDriveEnabled = true
+		case vcsec.RKEAction_E_RKE_ACTION_AUTO_SECURE_VEHICLE:
+			c.state.FrontDriverDoor = vcsec.ClosureState_E_CLOSURESTATE_CLOSED
+			c.state.FrontPassengerDoor = vcsec.ClosureState_E_CLOSURESTATE_CLOSED
+			c.state.RearDriverDoor = vcsec.ClosureState_E_CLOSURESTATE_CLOSED
+			c.state.RearPassengerDoor = vcsec.ClosureState_E_CLOSURESTATE_CLOSED
+			c.state.LockState = vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED
+		case vcsec.RKEAction_E_RKE_ACTION_WAKE_VEHICLE:
+			c.state.Asleep = false
+		default:
+			return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_INVALID_COMMAND
+		}
+	case *vcsec.UnsignedMessage_ClosureMoveRequest:
+		request := payload.ClosureMoveRequest
+		c.state.FrontDriverDoor = moveClosure(c.state.FrontDriverDoor, request.GetFrontDriverDoor())
+		c.state.FrontPassengerDoor = moveClosure(c.state.FrontPassengerDoor, request.GetFrontPassengerDoor())
+		c.state.RearDriverDoor = moveClosure(c.state.RearDriverDoor, request.GetRearDriverDoor())
+		c.state.RearPassengerDoor = moveClosure(c.state.RearPassengerDoor, request.GetRearPassengerDoor())
+		c.state.RearTrunk = moveClosure(c.state.RearTrunk, request.GetRearTrunk())
+		c.state.FrontTrunk = moveClosure(c.state.FrontTrunk, request.GetFrontTrunk())
+		c.state.ChargePort = moveClosure(c.state.ChargePort, request.GetChargePort())
+		c.state.Tonneau = moveClosure(c.state.Tonneau, request.GetTonneau())
+	default:
+		return nil, universal.MessageFault_E_MESSAGEFAULT_ERROR_INVALID_COMMAND
+	}
+	return &vcsec.FromVCSECMessage{}, universal.MessageFault_E_MESSAGEFAULT_ERROR_NONE
+}
+
+func (c *Connection) handleInformationRequest(request *vcsec.InformationRequest) *vcsec.FromVCSECMessage {
+	switch request.GetInformationRequestType() {
+	case vcsec.InformationRequestType_INFORMATION_REQUEST_TYPE_GET_WHITELIST_INFO:
+		return &vcsec.FromVCSECMessage{
+			SubMessage: &vcsec.FromVCSECMessage_WhitelistInfo{WhitelistInfo: c.keychain.info()},
+		}
+	case vcsec.InformationRequestType_INFORMATION_REQUEST_TYPE_GET_WHITELIST_ENTRY_INFO:
+		var info *vcsec.WhitelistEntryInfo
+		switch key := request.GetKey().(type) {
+		case *vcsec.InformationRequest_Slot:
+			info = c.keychain.entryInfo(key.Slot)
+		case *vcsec.InformationRequest_PublicKey:
+			if slot, entry := c.keychain.find(key.PublicKey); entry != nil {
+				info = c.keychain.entryInfo(slot)
+			}
+		}
+		if info == nil {
+			return whitelistOperationResult(vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_PUBLIC_KEY_NOT_ON_WHITELIST)
+		}
+		return &vcsec.FromVCSECMessage{
+			SubMessage: &vcsec.FromVCSECMessage_WhitelistEntryInfo{WhitelistEntryInfo: info},
+		}
+	default:
+		return &vcsec.FromVCSECMessage{
+			SubMessage: &vcsec.FromVCSECMessage_VehicleStatus{VehicleStatus: c.state.vehicleStatus()},
+		}
+	}
+}
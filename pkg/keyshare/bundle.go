@@ -0,0 +1,169 @@
+package keyshare
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+const (
+	bundleVersion = 1
+	// Passcodes are generated rather than chosen by users, so they have enough entropy that they
+	// don't need to be stretched by a password-hashing function.
+	passcodeBytes      = 20
+	passcodeGroupSize  = 4
+	passcodeKeyContext = "vehicle-command guest key bundle"
+	privateScalarBytes = 32
+)
+
+var (
+	ErrBundleVersion   = errors.New("unsupported key bundle version")
+	ErrInvalidPasscode = errors.New("incorrect passcode or corrupted key bundle")
+)
+
+// Bundle contains an encrypted guest private key.
+//
+// The VIN, role, and expiration time are not encrypted so that the guest can inspect the bundle
+// before importing it, but they're authenticated along with the private key and can't be modified
+// without invalidating the bundle.
+type Bundle struct {
+	Version    int       `json:"version"`
+	VIN        string    `json:"vin"`
+	Role       string    `json:"role"`
+	Expires    time.Time `json:"expires"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// NewPasscode returns a random passcode for encrypting a Bundle. Passcodes are formatted in
+// groups of characters separated by dashes for readability.
+func NewPasscode() (string, error) {
+	var buffer [passcodeBytes]byte
+	if _, err := rand.Read(buffer[:]); err != nil {
+		return "", err
+	}
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buffer[:])
+	var groups []string
+	for len(encoded) > passcodeGroupSize {
+		groups = append(groups, encoded[:passcodeGroupSize])
+		encoded = encoded[passcodeGroupSize:]
+	}
+	groups = append(groups, encoded)
+	return strings.Join(groups, "-"), nil
+}
+
+// normalizePasscode removes formatting so that passcodes can be entered without dashes or in
+// lowercase.
+func normalizePasscode(passcode string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(passcode) {
+		if c != '-' && c != ' ' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func passcodeCipher(passcode string) (cipher.AEAD, error) {
+	key := sha256.Sum256([]byte(passcodeKeyContext + "\x00" + normalizePasscode(passcode)))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (b *Bundle) associatedData() []byte {
+	return []byte(fmt.Sprintf("%d\n%s\n%s\n%d", b.Version, b.VIN, b.Role, b.Expires.Unix()))
+}
+
+// Seal encrypts skey using passcode. The role should be the name of a keys.Role value (e.g.,
+// "ROLE_DRIVER").
+func Seal(skey protocol.ECDHPrivateKey, passcode, vin, role string, expires time.Time) (*Bundle, error) {
+	native, ok := skey.(*authentication.NativeECDHKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not exportable")
+	}
+	aead, err := passcodeCipher(passcode)
+	if err != nil {
+		return nil, err
+	}
+	bundle := Bundle{
+		Version: bundleVersion,
+		VIN:     vin,
+		Role:    role,
+		Expires: expires.Truncate(time.Second),
+		Nonce:   make([]byte, aead.NonceSize()),
+	}
+	if _, err := rand.Read(bundle.Nonce); err != nil {
+		return nil, err
+	}
+	var scalar [privateScalarBytes]byte
+	native.D.FillBytes(scalar[:])
+	bundle.Ciphertext = aead.Seal(nil, bundle.Nonce, scalar[:], bundle.associatedData())
+	return &bundle, nil
+}
+
+// Open decrypts the private key in b.
+func (b *Bundle) Open(passcode string) (protocol.ECDHPrivateKey, error) {
+	if b.Version != bundleVersion {
+		return nil, ErrBundleVersion
+	}
+	aead, err := passcodeCipher(passcode)
+	if err != nil {
+		return nil, err
+	}
+	if len(b.Nonce) != aead.NonceSize() {
+		return nil, ErrInvalidPasscode
+	}
+	scalar, err := aead.Open(nil, b.Nonce, b.Ciphertext, b.associatedData())
+	if err != nil {
+		return nil, ErrInvalidPasscode
+	}
+	skey := protocol.UnmarshalECDHPrivateKey(scalar)
+	if skey == nil {
+		return nil, ErrInvalidPasscode
+	}
+	return skey, nil
+}
+
+// WriteFile saves b to filename, which must not already exist.
+func (b *Bundle) WriteFile(filename string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// ReadBundle loads a Bundle from filename.
+func ReadBundle(filename string) (*Bundle, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("invalid key bundle: %w", err)
+	}
+	return &bundle, nil
+}
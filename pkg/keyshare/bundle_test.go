@@ -0,0 +1,86 @@
+package keyshare
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passcode, err := NewPasscode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expires := time.Now().Add(time.Hour)
+	bundle, err := Seal(skey, passcode, "0123456789ABCDEFG", "ROLE_DRIVER", expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filename := filepath.Join(t.TempDir(), "bundle.json")
+	if err := bundle.WriteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundle.WriteFile(filename); err == nil {
+		t.Error("Expected error when overwriting bundle")
+	}
+	loaded, err := ReadBundle(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Expires.Unix() != expires.Unix() || loaded.Role != "ROLE_DRIVER" {
+		t.Errorf("Unexpected bundle metadata: %+v", loaded)
+	}
+
+	// Passcodes are case-insensitive and dashes are optional.
+	opened, err := loaded.Open(strings.ToLower(strings.ReplaceAll(passcode, "-", "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened.PublicBytes(), skey.PublicBytes()) {
+		t.Error("Bundle contained wrong private key")
+	}
+}
+
+func TestBundleTampering(t *testing.T) {
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passcode, err := NewPasscode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, err := Seal(skey, passcode, "0123456789ABCDEFG", "ROLE_CHARGING_MANAGER", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherPasscode, err := NewPasscode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bundle.Open(otherPasscode); !errors.Is(err, ErrInvalidPasscode) {
+		t.Errorf("Expected ErrInvalidPasscode with wrong passcode but got %v", err)
+	}
+
+	bundle.Role = "ROLE_OWNER"
+	if _, err := bundle.Open(passcode); !errors.Is(err, ErrInvalidPasscode) {
+		t.Errorf("Expected ErrInvalidPasscode after changing role but got %v", err)
+	}
+
+	bundle.Version = bundleVersion + 1
+	if _, err := bundle.Open(passcode); !errors.Is(err, ErrBundleVersion) {
+		t.Errorf("Expected ErrBundleVersion but got %v", err)
+	}
+}
@@ -0,0 +1,12 @@
+// Package keyshare allows vehicle owners to lend a vehicle by issuing time-limited guest keys.
+//
+// An owner generates a fresh key pair for the guest and enrolls the public key on the vehicle,
+// typically with a restricted role such as ROLE_DRIVER or ROLE_CHARGING_MANAGER. The private key is
+// handed to the guest as a [Bundle], which is encrypted using a randomly generated passcode. The
+// owner sends the bundle and the passcode to the guest over separate channels, and the guest
+// imports the key using tesla-keygen.
+//
+// Vehicle support for key expiration varies, so the owner also records each key in a [Ledger].
+// Periodically calling [Ledger.Sweep] removes keys that have expired or been revoked from every
+// vehicle in the ledger, regardless of whether the vehicle enforced the expiration itself.
+package keyshare
@@ -0,0 +1,228 @@
+package keyshare
+
+import (
+	"context"
+	"crypto/ecdh"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+var ErrGrantNotFound = errors.New("no matching guest key in ledger")
+
+// Grant records a guest key that was enrolled on a vehicle.
+type Grant struct {
+	VIN string `json:"vin"`
+	// PublicKey is the hex-encoded uncompressed public key.
+	PublicKey string `json:"public_key"`
+	Role      string `json:"role"`
+	// Name is an optional label that helps the owner identify the guest.
+	Name    string    `json:"name,omitempty"`
+	Issued  time.Time `json:"issued"`
+	Expires time.Time `json:"expires"`
+	Revoked bool      `json:"revoked,omitempty"`
+	// Removed is set once the key has been removed from the vehicle.
+	Removed *time.Time `json:"removed,omitempty"`
+}
+
+// Fingerprint returns the short identifier of g's public key used by [vehicle.KeyFingerprint].
+func (g *Grant) Fingerprint() string {
+	publicKey, err := hex.DecodeString(g.PublicKey)
+	if err != nil {
+		return ""
+	}
+	return vehicle.KeyFingerprint(publicKey)
+}
+
+// Lapsed returns true if g's key has expired or been revoked but hasn't been removed from the
+// vehicle yet.
+func (g *Grant) Lapsed(now time.Time) bool {
+	return g.Removed == nil && !g.Expires.After(now)
+}
+
+func (g *Grant) matches(id string) bool {
+	id = strings.ToLower(id)
+	return (g.Name != "" && strings.ToLower(g.Name) == id) || g.PublicKey == id || g.Fingerprint() == id
+}
+
+// Ledger is a local record of the guest keys an owner has issued. A Ledger is not safe for
+// concurrent use.
+type Ledger struct {
+	Grants   []*Grant `json:"grants"`
+	filename string
+}
+
+// LoadLedger reads a Ledger from filename. If the file doesn't exist, LoadLedger returns an empty
+// Ledger that will be written to filename when saved.
+func LoadLedger(filename string) (*Ledger, error) {
+	ledger := Ledger{filename: filename}
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &ledger, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &ledger); err != nil {
+		return nil, fmt.Errorf("invalid guest key ledger %s: %w", filename, err)
+	}
+	return &ledger, nil
+}
+
+// Save writes l back to the file it was loaded from. The file is replaced atomically, so an
+// interrupted write doesn't lose track of previously issued keys.
+func (l *Ledger) Save() error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.filename), filepath.Base(l.filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), l.filename)
+}
+
+// Add records a new grant of publicKey on vin.
+func (l *Ledger) Add(vin string, publicKey *ecdh.PublicKey, role, name string, issued, expires time.Time) *Grant {
+	grant := &Grant{
+		VIN:       vin,
+		PublicKey: hex.EncodeToString(publicKey.Bytes()),
+		Role:      role,
+		Name:      name,
+		Issued:    issued.Truncate(time.Second),
+		Expires:   expires.Truncate(time.Second),
+	}
+	l.Grants = append(l.Grants, grant)
+	return grant
+}
+
+// Remove deletes grant from l. It's used to discard a grant whose key was never enrolled.
+func (l *Ledger) Remove(grant *Grant) {
+	l.Grants = slices.DeleteFunc(l.Grants, func(g *Grant) bool { return g == grant })
+}
+
+// Revoke marks every grant that hasn't been removed yet and matches id as lapsed, so that the next
+// call to [Ledger.Sweep] removes it. The id may be the name given to the grant, the key's
+// fingerprint, or the hex-encoded public key.
+func (l *Ledger) Revoke(id string, now time.Time) ([]*Grant, error) {
+	var revoked []*Grant
+	for _, grant := range l.Grants {
+		if grant.Removed != nil || !grant.matches(id) {
+			continue
+		}
+		grant.Revoked = true
+		if grant.Expires.After(now) {
+			grant.Expires = now.Truncate(time.Second)
+		}
+		revoked = append(revoked, grant)
+	}
+	if len(revoked) == 0 {
+		return nil, ErrGrantNotFound
+	}
+	return revoked, nil
+}
+
+// Lapsed returns the grants whose keys should be removed from their vehicles.
+func (l *Ledger) Lapsed(now time.Time) []*Grant {
+	var lapsed []*Grant
+	for _, grant := range l.Grants {
+		if grant.Lapsed(now) {
+			lapsed = append(lapsed, grant)
+		}
+	}
+	return lapsed
+}
+
+// Dialer returns a Vehicle for vin that's ready to send authenticated VCSEC commands.
+type Dialer func(ctx context.Context, vin string) (*vehicle.Vehicle, error)
+
+// Sweep removes the keys of lapsed grants from their vehicles and marks those grants as removed.
+// Keys that are no longer enrolled, for example because the vehicle enforced the expiration
+// itself, are also marked as removed.
+//
+// The dial function is called at most once per VIN. Sweep doesn't disconnect the vehicles it
+// returns. If timeout is positive, dialing each VIN and removing its keys must complete within
+// timeout, so that an unresponsive vehicle doesn't use up the time available for the others. If a
+// vehicle can't be reached, its grants are left in place so that a later sweep can retry them.
+// Sweep returns the grants that were removed along with any errors encountered; the caller is
+// responsible for saving l.
+func (l *Ledger) Sweep(ctx context.Context, now time.Time, timeout time.Duration, dial Dialer) ([]*Grant, error) {
+	byVIN := make(map[string][]*Grant)
+	var vins []string
+	for _, grant := range l.Lapsed(now) {
+		if _, ok := byVIN[grant.VIN]; !ok {
+			vins = append(vins, grant.VIN)
+		}
+		byVIN[grant.VIN] = append(byVIN[grant.VIN], grant)
+	}
+
+	var removed []*Grant
+	var errs []error
+	for _, vin := range vins {
+		grants, err := sweepVIN(ctx, timeout, vin, byVIN[vin], dial)
+		for _, grant := range grants {
+			removedAt := now.Truncate(time.Second)
+			grant.Removed = &removedAt
+			removed = append(removed, grant)
+		}
+		errs = append(errs, err)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// sweepVIN removes the keys of grants from vin and returns the grants whose keys are no longer
+// enrolled.
+func sweepVIN(ctx context.Context, timeout time.Duration, vin string, grants []*Grant, dial Dialer) ([]*Grant, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	car, err := dial(ctx, vin)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", vin, err)
+	}
+	var removed []*Grant
+	var errs []error
+	for _, grant := range grants {
+		if err := removeGrant(ctx, car, grant); err != nil {
+			errs = append(errs, fmt.Errorf("%s: key %s: %w", vin, grant.Fingerprint(), err))
+			continue
+		}
+		removed = append(removed, grant)
+	}
+	return removed, errors.Join(errs...)
+}
+
+func removeGrant(ctx context.Context, car *vehicle.Vehicle, grant *Grant) error {
+	publicKey, err := protocol.PublicKeyBytesFromHex(grant.PublicKey)
+	if err != nil {
+		return err
+	}
+	err = car.RemoveKey(ctx, publicKey)
+	var keychainErr *protocol.KeychainError
+	if errors.As(err, &keychainErr) && keychainErr.Code == vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_PUBLIC_KEY_NOT_ON_WHITELIST {
+		return nil
+	}
+	return err
+}
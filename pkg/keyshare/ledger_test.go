@@ -0,0 +1,215 @@
+package keyshare_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/authentication"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/connector/sim"
+	"github.com/teslamotors/vehicle-command/pkg/keyshare"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+)
+
+const testVIN = "0123456789ABCDEFG"
+
+func newPublicKey(t *testing.T) *ecdh.PublicKey {
+	t.Helper()
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ecdh.P256().NewPublicKey(skey.PublicBytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey
+}
+
+func connectOwner(t *testing.T, ctx context.Context) (*sim.Connection, *vehicle.Vehicle) {
+	t.Helper()
+	car, err := sim.NewConnection(testVIN, connector.AuthMethodGCM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skey, err := authentication.NewECDHPrivateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := car.AddKey(skey.PublicBytes(), keys.Role_ROLE_OWNER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+		t.Fatal(err)
+	}
+	v, err := vehicle.NewVehicle(car, skey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(v.Disconnect)
+	if err := v.StartSession(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	return car, v
+}
+
+func enrolled(car *sim.Connection, publicKey *ecdh.PublicKey) bool {
+	for _, entry := range car.Keys() {
+		if bytes.Equal(entry.PublicKey, publicKey.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLedgerPersistence(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "ledger.json")
+	ledger, err := keyshare.LoadLedger(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ledger.Grants) != 0 {
+		t.Fatalf("Expected empty ledger")
+	}
+	now := time.Now()
+	grant := ledger.Add(testVIN, newPublicKey(t), "ROLE_DRIVER", "contractor", now, now.Add(time.Hour))
+	if err := ledger.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := keyshare.LoadLedger(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Grants) != 1 {
+		t.Fatalf("Expected one grant but got %d", len(loaded.Grants))
+	}
+	got := loaded.Grants[0]
+	if got.PublicKey != grant.PublicKey || got.Name != grant.Name || !got.Expires.Equal(grant.Expires) || !got.Issued.Equal(grant.Issued) {
+		t.Errorf("Ledger didn't round trip: %+v", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	ledger, err := keyshare.LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	kept := ledger.Add(testVIN, newPublicKey(t), "ROLE_DRIVER", "", now, now.Add(time.Hour))
+	removed := ledger.Add(testVIN, newPublicKey(t), "ROLE_DRIVER", "", now, now.Add(time.Hour))
+	ledger.Remove(removed)
+	if len(ledger.Grants) != 1 || ledger.Grants[0] != kept {
+		t.Errorf("Unexpected grants after removal: %v", ledger.Grants)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	ledger, err := keyshare.LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	named := ledger.Add(testVIN, newPublicKey(t), "ROLE_DRIVER", "Contractor", now, now.Add(time.Hour))
+	other := ledger.Add(testVIN, newPublicKey(t), "ROLE_DRIVER", "", now, now.Add(time.Hour))
+
+	if lapsed := ledger.Lapsed(now); len(lapsed) != 0 {
+		t.Errorf("Unexpected lapsed grants: %v", lapsed)
+	}
+	if _, err := ledger.Revoke("someone-else", now); !errors.Is(err, keyshare.ErrGrantNotFound) {
+		t.Errorf("Expected ErrGrantNotFound but got %v", err)
+	}
+	if revoked, err := ledger.Revoke("contractor", now); err != nil || len(revoked) != 1 || revoked[0] != named {
+		t.Errorf("Failed to revoke by name: %v", err)
+	}
+	if revoked, err := ledger.Revoke(other.Fingerprint(), now); err != nil || len(revoked) != 1 || revoked[0] != other {
+		t.Errorf("Failed to revoke by fingerprint: %v", err)
+	}
+	if lapsed := ledger.Lapsed(now); len(lapsed) != 2 {
+		t.Errorf("Expected both grants to be lapsed but got %v", lapsed)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	car, v := connectOwner(t, ctx)
+
+	ledger, err := keyshare.LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	expired := newPublicKey(t)
+	active := newPublicKey(t)
+	forgotten := newPublicKey(t) // Already removed by someone else
+	for _, publicKey := range []*ecdh.PublicKey{expired, active} {
+		if err := car.AddKey(publicKey.Bytes(), keys.Role_ROLE_DRIVER, vcsec.KeyFormFactor_KEY_FORM_FACTOR_CLOUD_KEY); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ledger.Add(testVIN, expired, "ROLE_DRIVER", "", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	ledger.Add(testVIN, active, "ROLE_DRIVER", "", now, now.Add(time.Hour))
+	ledger.Add(testVIN, forgotten, "ROLE_DRIVER", "", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	unreachable := ledger.Add("UNREACHABLEVIN000", newPublicKey(t), "ROLE_DRIVER", "", now.Add(-2*time.Hour), now.Add(-time.Hour))
+
+	dialErr := errors.New("vehicle offline")
+	dial := func(ctx context.Context, vin string) (*vehicle.Vehicle, error) {
+		if vin == testVIN {
+			return v, nil
+		}
+		return nil, dialErr
+	}
+	removed, err := ledger.Sweep(ctx, now, 0, dial)
+	if !errors.Is(err, dialErr) {
+		t.Errorf("Expected error for unreachable vehicle but got %v", err)
+	}
+	if len(removed) != 2 {
+		t.Errorf("Expected two grants to be removed but got %d", len(removed))
+	}
+	if enrolled(car, expired) {
+		t.Error("Expired key is still enrolled")
+	}
+	if !enrolled(car, active) {
+		t.Error("Active key was removed")
+	}
+	if lapsed := ledger.Lapsed(now); len(lapsed) != 1 || lapsed[0] != unreachable {
+		t.Errorf("Expected only unreachable grant to remain lapsed, got %v", lapsed)
+	}
+}
+
+func TestSweepTimeoutPerVIN(t *testing.T) {
+	ledger, err := keyshare.LoadLedger(filepath.Join(t.TempDir(), "ledger.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, vin := range []string{"UNREACHABLEVIN001", "UNREACHABLEVIN002"} {
+		ledger.Add(vin, newPublicKey(t), "ROLE_DRIVER", "", now.Add(-2*time.Hour), now.Add(-time.Hour))
+	}
+
+	const timeout = time.Minute
+	var dialed int
+	dial := func(ctx context.Context, vin string) (*vehicle.Vehicle, error) {
+		dialed++
+		deadline, ok := ctx.Deadline()
+		if !ok || time.Until(deadline) > timeout {
+			t.Errorf("Expected %s to be dialed with its own deadline", vin)
+		}
+		return nil, errors.New("vehicle offline")
+	}
+	if _, err := ledger.Sweep(context.Background(), now, timeout, dial); err == nil {
+		t.Error("Expected error for unreachable vehicles")
+	}
+	if dialed != 2 {
+		t.Errorf("Expected two vehicles to be dialed but got %d", dialed)
+	}
+}
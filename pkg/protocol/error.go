@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -37,6 +38,10 @@ var (
 	ErrUnknown = NewError("vehicle responded with an unrecognized status code", false, false)
 	// ErrNotConnected indicates the vehicle could not be reached.
 	ErrNotConnected = NewError("vehicle not connected", false, false)
+	// ErrTransportChanged indicates a Connector switched transports before it could send a message
+	// that was prepared for the previous transport (see connector.MultipathConnector). The message
+	// was not sent. It should be re-authorized for the new transport rather than retransmitted.
+	ErrTransportChanged = NewError("vehicle transport changed before message could be sent", false, true)
 	// ErrNoSession indicates the client has not established a session with the vehicle. You may
 	// have forgotten to call vehicle.StartSessions(...).
 	ErrNoSession = NewError("cannot send authenticated command before establishing a vehicle session", false, false)
@@ -130,6 +135,52 @@ func ShouldRetry(err error) bool {
 	return false
 }
 
+// Classes returned by ErrorClass. Errors that carry an HTTP status code are classified as
+// "http-<code>" (e.g., "http-503") instead.
+const (
+	ErrorClassBusy             = "busy"
+	ErrorClassNominal          = "nominal"
+	ErrorClassMayHaveSucceeded = "may-have-succeeded"
+	ErrorClassTemporary        = "temporary"
+	ErrorClassContext          = "context"
+	ErrorClassOther            = "other"
+)
+
+// httpStatusError is implemented by errors that carry an HTTP status code, such as
+// inet.HttpError.
+type httpStatusError interface {
+	HTTPStatus() int
+}
+
+// ErrorClass returns one of a small, fixed set of classes that describes err, suitable for
+// grouping errors in metrics.
+func ErrorClass(err error) string {
+	var faultErr *RoutableMessageError
+	if errors.Is(err, ErrBusy) || (errors.As(err, &faultErr) && faultErr.Code == universal.MessageFault_E_MESSAGEFAULT_ERROR_BUSY) {
+		return ErrorClassBusy
+	}
+	if IsNominalError(err) {
+		return ErrorClassNominal
+	}
+	var httpErr httpStatusError
+	if errors.As(err, &httpErr) {
+		return fmt.Sprintf("http-%d", httpErr.HTTPStatus())
+	}
+	var commErr Error
+	if errors.As(err, &commErr) {
+		if commErr.MayHaveSucceeded() {
+			return ErrorClassMayHaveSucceeded
+		}
+		if commErr.Temporary() {
+			return ErrorClassTemporary
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return ErrorClassContext
+	}
+	return ErrorClassOther
+}
+
 // NominalVCSECError indicates the vehicle received and authenticated a command, but could not
 // execute it.
 type NominalError struct {
@@ -1,6 +1,9 @@
 package protocol
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 
 	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
@@ -68,3 +71,32 @@ func TestRetriableError(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{&RoutableMessageError{Code: universal.MessageFault_E_MESSAGEFAULT_ERROR_BUSY}, ErrorClassBusy},
+		{fmt.Errorf("wrapped: %w", ErrBusy), ErrorClassBusy},
+		{&NominalError{Details: errors.New("charge limit too low")}, ErrorClassNominal},
+		{&CommandError{Err: context.DeadlineExceeded, PossibleSuccess: true}, ErrorClassMayHaveSucceeded},
+		{&CommandError{Err: context.DeadlineExceeded, PossibleTemporary: true}, ErrorClassTemporary},
+		{&RoutableMessageError{Code: universal.MessageFault_E_MESSAGEFAULT_ERROR_INVALID_SIGNATURE}, ErrorClassTemporary},
+		{&RoutableMessageError{Code: universal.MessageFault_E_MESSAGEFAULT_ERROR_UNKNOWN_KEY_ID}, ErrorClassOther},
+		{context.Canceled, ErrorClassContext},
+		{fmt.Errorf("wrapped: %w", httpError(503)), "http-503"},
+		{&KeychainError{Code: 1}, ErrorClassOther},
+		{errors.New("unexpected"), ErrorClassOther},
+	}
+	for _, test := range tests {
+		if class := ErrorClass(test.err); class != test.class {
+			t.Errorf("Expected class %q for %v but got %q", test.class, test.err, class)
+		}
+	}
+}
+
+type httpError int
+
+func (e httpError) Error() string   { return fmt.Sprintf("HTTP %d", int(e)) }
+func (e httpError) HTTPStatus() int { return int(e) }
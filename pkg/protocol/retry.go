@@ -0,0 +1,136 @@
+package protocol
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// RetryClass is a set of error categories that a RetryPolicy retries.
+type RetryClass uint
+
+const (
+	// RetryTemporary retries errors that are Temporary and can't have succeeded.
+	RetryTemporary RetryClass = 1 << iota
+	// RetryMayHaveSucceeded retries Temporary errors even if the command may have been executed.
+	// This is only safe for idempotent commands, such as locking the vehicle.
+	RetryMayHaveSucceeded
+	// RetryNominal retries commands that the vehicle received and authenticated but declined to
+	// execute (see NominalError). This is useful for commands that the vehicle may accept once it
+	// finishes an operation that's in progress.
+	RetryNominal
+	// RetryBusy retries commands that the vehicle couldn't process because it was busy, typically
+	// because it's finishing waking up. See ErrBusy.
+	RetryBusy
+)
+
+// RetryPolicy controls how commands are retried after an error.
+//
+// Retries happen at two layers. Transmissions that fail are resent without modification, and
+// commands that the vehicle rejects (or that fail in a way that requires re-authentication) are
+// authorized again and resent. MaxAttempts applies to each layer separately.
+type RetryPolicy struct {
+	// MaxAttempts limits the number of attempts, including the first. Zero means retries continue
+	// until the context expires.
+	MaxAttempts int
+	// InitialInterval is the delay before the first retry. If zero, the Connector's
+	// RetryInterval is used.
+	InitialInterval time.Duration
+	// Multiplier scales the delay after each retry. Values less than one are treated as one,
+	// meaning the delay is constant.
+	Multiplier float64
+	// MaxInterval caps the delay between attempts. Zero means no cap.
+	MaxInterval time.Duration
+	// Jitter randomizes each delay by up to the given fraction (between 0 and 1) in either
+	// direction, which prevents clients that failed at the same time from retrying in lockstep.
+	Jitter float64
+	// Retry is the set of errors that are retried.
+	Retry RetryClass
+}
+
+// DefaultRetryPolicy retries Temporary errors (including busy vehicles) at the Connector's
+// RetryInterval until the context expires, but never retries commands that may have succeeded.
+var DefaultRetryPolicy = RetryPolicy{
+	Retry: RetryTemporary | RetryBusy,
+}
+
+type retryPolicyKey struct{}
+
+// WithRetryPolicy returns a child of ctx that causes commands sent using the child to follow policy,
+// overriding any policy configured on the vehicle.
+func WithRetryPolicy(ctx context.Context, policy RetryPolicy) context.Context {
+	return context.WithValue(ctx, retryPolicyKey{}, policy)
+}
+
+// RetryPolicyFromContext returns the policy attached to ctx by WithRetryPolicy, if any.
+func RetryPolicyFromContext(ctx context.Context) (RetryPolicy, bool) {
+	policy, ok := ctx.Value(retryPolicyKey{}).(RetryPolicy)
+	return policy, ok
+}
+
+func isBusy(err error) bool {
+	if err == ErrBusy {
+		return true
+	}
+	if faultErr, ok := err.(*RoutableMessageError); ok {
+		return faultErr.Code == universal.MessageFault_E_MESSAGEFAULT_ERROR_BUSY
+	}
+	return false
+}
+
+// ShouldRetry returns true if a command that has been attempted the given number of times should
+// be retried after err.
+func (p *RetryPolicy) ShouldRetry(err error, attempts int) bool {
+	if err == nil || (p.MaxAttempts > 0 && attempts >= p.MaxAttempts) {
+		return false
+	}
+	switch {
+	case isBusy(err):
+		return p.Retry&RetryBusy != 0
+	case IsNominalError(err):
+		return p.Retry&RetryNominal != 0
+	case !Temporary(err):
+		return false
+	case MayHaveSucceeded(err):
+		return p.Retry&RetryMayHaveSucceeded != 0
+	}
+	return p.Retry&RetryTemporary != 0
+}
+
+// Delay returns how long to wait after the given number of attempts before trying again. The
+// retryInterval is used if p.InitialInterval is zero.
+func (p *RetryPolicy) Delay(attempts int, retryInterval time.Duration) time.Duration {
+	delay := float64(retryInterval)
+	if p.InitialInterval > 0 {
+		delay = float64(p.InitialInterval)
+	}
+	if p.Multiplier > 1 && attempts > 1 {
+		delay *= math.Pow(p.Multiplier, float64(attempts-1))
+	}
+	if p.MaxInterval > 0 && delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+	if jitter := math.Min(p.Jitter, 1); jitter > 0 {
+		delay *= 1 + jitter*(2*rand.Float64()-1)
+	}
+	if delay >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(delay)
+}
+
+// Wait blocks until it's time to try again after the given number of attempts, or until ctx
+// expires, in which case it returns ctx.Err().
+func (p *RetryPolicy) Wait(ctx context.Context, attempts int, retryInterval time.Duration) error {
+	timer := time.NewTimer(p.Delay(attempts, retryInterval))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+func TestRetryPolicyErrorClasses(t *testing.T) {
+	temporary := NewError("temporary", false, true)
+	mayHaveSucceeded := NewError("may have succeeded", true, true)
+	permanent := NewError("permanent", false, false)
+	nominal := &NominalError{Details: permanent}
+	busyFault := &RoutableMessageError{Code: universal.MessageFault_E_MESSAGEFAULT_ERROR_BUSY}
+
+	tests := []struct {
+		retry    RetryClass
+		err      error
+		expected bool
+	}{
+		{RetryTemporary, temporary, true},
+		{RetryTemporary, mayHaveSucceeded, false},
+		{RetryTemporary, permanent, false},
+		{RetryTemporary, nominal, false},
+		{RetryTemporary, ErrBusy, false},
+		{RetryMayHaveSucceeded, mayHaveSucceeded, true},
+		{RetryMayHaveSucceeded, temporary, false},
+		{RetryNominal, nominal, true},
+		{RetryNominal, permanent, false},
+		{RetryBusy, ErrBusy, true},
+		{RetryBusy, busyFault, true},
+		{RetryBusy, temporary, false},
+		{DefaultRetryPolicy.Retry, nil, false},
+	}
+	for _, test := range tests {
+		policy := RetryPolicy{Retry: test.retry}
+		if retry := policy.ShouldRetry(test.err, 1); retry != test.expected {
+			t.Errorf("ShouldRetry(%v) with classes %b returned %v", test.err, test.retry, retry)
+		}
+	}
+}
+
+func TestDefaultRetryPolicyMatchesShouldRetry(t *testing.T) {
+	errs := []error{
+		NewError("temporary", false, true),
+		NewError("may have succeeded", true, true),
+		NewError("permanent", false, false),
+		ErrBusy,
+		errors.New("not a protocol error"),
+	}
+	for code := range universal.MessageFault_E_name {
+		errs = append(errs, &RoutableMessageError{Code: universal.MessageFault_E(code)})
+	}
+	for _, err := range errs {
+		if DefaultRetryPolicy.ShouldRetry(err, 100) != ShouldRetry(err) {
+			t.Errorf("DefaultRetryPolicy disagrees with ShouldRetry on %s", err)
+		}
+	}
+}
+
+func TestRetryPolicyMaxAttempts(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, Retry: RetryBusy}
+	if !policy.ShouldRetry(ErrBusy, 2) {
+		t.Error("Expected retry after second attempt")
+	}
+	if policy.ShouldRetry(ErrBusy, 3) {
+		t.Error("Expected no retry after third attempt")
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{Multiplier: 2, MaxInterval: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if d := policy.Delay(i+1, time.Second); d != delay {
+			t.Errorf("Expected delay %s after attempt %d but got %s", delay, i+1, d)
+		}
+	}
+
+	policy = RetryPolicy{InitialInterval: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		if d := policy.Delay(1, time.Hour); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("Jittered delay %s out of range", d)
+		}
+	}
+
+	policy = RetryPolicy{Multiplier: 10}
+	if d := policy.Delay(1000, time.Second); d <= 0 {
+		t.Errorf("Delay overflowed: %s", d)
+	}
+}
+
+func TestRetryPolicyContext(t *testing.T) {
+	if _, ok := RetryPolicyFromContext(context.Background()); ok {
+		t.Error("Expected no policy in background context")
+	}
+	ctx := WithRetryPolicy(context.Background(), RetryPolicy{MaxAttempts: 2})
+	if policy, ok := RetryPolicyFromContext(ctx); !ok || policy.MaxAttempts != 2 {
+		t.Errorf("Unexpected policy: %+v", policy)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	policy := RetryPolicy{InitialInterval: time.Hour}
+	if err := policy.Wait(ctx, 1, time.Second); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected Wait to return when context is canceled, got %v", err)
+	}
+}
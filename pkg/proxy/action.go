@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector/inet"
+	"github.com/teslamotors/vehicle-command/pkg/vehicle"
+
+	carserver "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/carserver"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+// ExtractVehicleAction parses body as the protojson encoding of a message for domain, which may be
+// "infotainment" (a carserver.VehicleAction) or "vcsec" (a vcsec.UnsignedMessage). The returned
+// function sends the message and returns the vehicle's decoded response.
+func ExtractVehicleAction(ctx context.Context, domain string, body []byte) (func(*vehicle.Vehicle) (proto.Message, error), error) {
+	switch domain {
+	case "infotainment":
+		var action carserver.VehicleAction
+		if err := protojson.Unmarshal(body, &action); err != nil {
+			return nil, invalidActionError(err)
+		}
+		return func(v *vehicle.Vehicle) (proto.Message, error) {
+			return v.ExecuteAction(ctx, &carserver.Action_VehicleAction{VehicleAction: &action})
+		}, nil
+	case "vcsec":
+		var message vcsec.UnsignedMessage
+		if err := protojson.Unmarshal(body, &message); err != nil {
+			return nil, invalidActionError(err)
+		}
+		return func(v *vehicle.Vehicle) (proto.Message, error) {
+			return v.ExecuteVCSECAction(ctx, &message)
+		}, nil
+	}
+	return nil, actionError(http.StatusNotFound, "invalid_domain", fmt.Sprintf("expected infotainment or vcsec, not %q", domain))
+}
+
+func invalidActionError(err error) error {
+	return actionError(http.StatusBadRequest, "invalid_action", err.Error())
+}
+
+func actionError(code int, reason, details string) error {
+	message, err := json.Marshal(&Response{Error: reason, ErrDetails: details})
+	if err != nil {
+		message = []byte(reason)
+	}
+	return &inet.HttpError{Code: code, Message: string(message)}
+}
@@ -0,0 +1,41 @@
+package proxy_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector/inet"
+	"github.com/teslamotors/vehicle-command/pkg/proxy"
+)
+
+func TestExtractVehicleAction(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		domain string
+		body   string
+		code   int
+	}{
+		{"infotainment", `{"mediaNextTrack": {}}`, 0},
+		{"infotainment", `{"vehicleControlSunroofOpenCloseAction": {"open": {}}}`, 0},
+		{"vcsec", `{"RKEAction": "RKE_ACTION_UNLOCK"}`, 0},
+		{"infotainment", `{"noSuchAction": {}}`, http.StatusBadRequest},
+		{"infotainment", `not json`, http.StatusBadRequest},
+		{"bms", `{}`, http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		action, err := proxy.ExtractVehicleAction(ctx, test.domain, []byte(test.body))
+		if test.code == 0 {
+			if err != nil || action == nil {
+				t.Errorf("Unexpected error for %s action %s: %s", test.domain, test.body, err)
+			}
+			continue
+		}
+		var httpErr *inet.HttpError
+		if !errors.As(err, &httpErr) || httpErr.Code != test.code {
+			t.Errorf("Expected HTTP %d for %s action %s but got %v", test.code, test.domain, test.body, err)
+		}
+	}
+}
@@ -15,6 +15,8 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/teslamotors/vehicle-command/internal/authentication"
 	"github.com/teslamotors/vehicle-command/internal/log"
@@ -22,6 +24,7 @@ import (
 	"github.com/teslamotors/vehicle-command/pkg/cache"
 	"github.com/teslamotors/vehicle-command/pkg/connector/inet"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/tracing"
 	"github.com/teslamotors/vehicle-command/pkg/vehicle"
 )
 
@@ -43,6 +46,11 @@ func getAccount(req *http.Request) (*account.Account, error) {
 // Proxy exposes an HTTP API for sending vehicle commands.
 type Proxy struct {
 	Timeout time.Duration
+	// VerifyTimeout bounds the time spent checking vehicle state after a command fails in a way
+	// that leaves its outcome uncertain, so that the proxy can report whether the command took
+	// effect instead of returning an error. Zero disables verification. See
+	// [vehicle.Vehicle.SetVerifyTimeout].
+	VerifyTimeout time.Duration
 
 	commandKey  protocol.ECDHPrivateKey
 	sessions    *cache.SessionCache
@@ -100,6 +108,28 @@ func New(ctx context.Context, skey protocol.ECDHPrivateKey, cacheSize int) (*Pro
 	}, nil
 }
 
+// SetSessionStore configures p to share vehicle sessions with other processes through store,
+// for example with other replicas behind a load balancer. This avoids a handshake each time a
+// request for a vehicle is routed to a different replica. SetSessionStore must be called before p
+// starts serving requests.
+func (p *Proxy) SetSessionStore(store cache.SessionStore) {
+	sessions := cache.NewWithStore(p.sessions.MaxEntries, store)
+	sessions.MaxAge = p.sessions.MaxAge
+	p.sessions = sessions
+}
+
+// SetSessionMaxAge configures p to perform a new handshake with a vehicle if its cached sessions
+// haven't been used for longer than maxAge. Zero means sessions don't expire. SetSessionMaxAge
+// must be called before p starts serving requests.
+func (p *Proxy) SetSessionMaxAge(maxAge time.Duration) {
+	p.sessions.MaxAge = maxAge
+}
+
+// SessionStats returns counters that describe the effectiveness of p's session cache.
+func (p *Proxy) SessionStats() cache.Stats {
+	return p.sessions.Stats()
+}
+
 // Response contains a server's response to a client request.
 type Response struct {
 	Response   interface{} `json:"response"`
@@ -240,6 +270,16 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			}
 			return
 		}
+		if len(path) == 7 && path[5] == "action" {
+			domain := path[6]
+			vin := path[4]
+			if len(vin) != vinLength {
+				writeJSONError(w, http.StatusNotFound, errors.New("expected 17-character VIN in path (do not user Fleet API ID)"))
+				return
+			}
+			p.handleVehicleAction(acct, w, req, domain, vin)
+			return
+		}
 		if len(path) == 5 && path[4] == "fleet_telemetry_config" {
 			p.handleFleetTelemetryConfig(acct.Host, w, req)
 			return
@@ -298,9 +338,21 @@ func (p *Proxy) handleFleetTelemetryConfig(host string, w http.ResponseWriter, r
 }
 
 func (p *Proxy) handleVehicleCommand(acct *account.Account, w http.ResponseWriter, req *http.Request, command, vin string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	// Commands aren't canceled if the client disconnects, but they remain part of the request's
+	// trace.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), p.Timeout)
 	defer cancel()
 
+	ctx, span := tracing.Start(ctx, "proxy.handleVehicleCommand")
+	span.SetAttribute(tracing.AttrVIN, vin)
+	span.SetAttribute(tracing.AttrCommand, command)
+	err := p.executeVehicleCommand(ctx, acct, w, req, command, vin)
+	span.End(err)
+	return err
+}
+
+func (p *Proxy) executeVehicleCommand(ctx context.Context, acct *account.Account, w http.ResponseWriter, req *http.Request, command, vin string) error {
+
 	// Serialize commands sent to a specific VIN to avoid some complexities associated with sharing
 	// the vehicle.Vehicle object. VCSEC commands fail if they arrive out of order, anyway.
 	if err := p.lockVIN(ctx, vin); err != nil {
@@ -314,36 +366,122 @@ func (p *Proxy) handleVehicleCommand(acct *account.Account, w http.ResponseWrite
 		return err
 	}
 
+	if unsupported, err := p.runOnVehicle(ctx, w, car, vin, commandToExecuteFunc); unsupported {
+		p.forwardRequest(acct.Host, w, req)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprintln(w, "{\"response\":{\"result\":true,\"reason\":\"\"}}")
+	return nil
+}
+
+// runOnVehicle connects to car, runs commandToExecuteFunc, and writes an error response if the
+// command fails. If the vehicle doesn't support the protocol, runOnVehicle returns unsupported =
+// true without writing a response. The caller is responsible for writing a response if
+// runOnVehicle returns a nil error or unsupported = true.
+func (p *Proxy) runOnVehicle(ctx context.Context, w http.ResponseWriter, car *vehicle.Vehicle, vin string,
+	commandToExecuteFunc func(*vehicle.Vehicle) error) (unsupported bool, err error) {
+
 	if err := car.Connect(ctx); err != nil {
 		writeJSONError(w, http.StatusInternalServerError, err)
-		return err
+		return false, err
 	}
 	defer car.Disconnect()
 
 	if err := car.StartSession(ctx, nil); errors.Is(err, protocol.ErrProtocolNotSupported) {
 		p.markUnsupportedVIN(vin)
-		p.forwardRequest(acct.Host, w, req)
-		return err
+		return true, err
 	} else if err != nil {
 		writeJSONError(w, http.StatusInternalServerError, err)
-		return err
+		return false, err
 	}
 	defer car.UpdateCachedSessions(p.sessions)
 
-	if err = commandToExecuteFunc(car); err == ErrCommandUseRESTAPI {
-		return err
+	car.SetVerifyTimeout(p.VerifyTimeout)
+	err = commandToExecuteFunc(car)
+	if err == ErrCommandUseRESTAPI {
+		return false, err
 	}
 	if protocol.IsNominalError(err) {
 		writeJSONError(w, http.StatusOK, err)
+		return false, err
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return false, err
+	}
+	return false, nil
+}
+
+// handleVehicleAction sends a message that isn't covered by the Fleet API command endpoints and
+// replies with the protojson encoding of the vehicle's response.
+func (p *Proxy) handleVehicleAction(acct *account.Account, w http.ResponseWriter, req *http.Request, domain, vin string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(req.Context()), p.Timeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "proxy.handleVehicleAction")
+	span.SetAttribute(tracing.AttrVIN, vin)
+	span.SetAttribute(tracing.AttrCommand, "action/"+domain)
+	span.End(p.executeVehicleAction(ctx, acct, w, req, domain, vin))
+}
+
+func (p *Proxy) executeVehicleAction(ctx context.Context, acct *account.Account, w http.ResponseWriter, req *http.Request, domain, vin string) error {
+	log.Debug("Executing %s action on %s", domain, vin)
+	if req.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, nil)
+		return fmt.Errorf("wrong http method")
+	}
+	if p.isNotSupported(vin) {
+		writeJSONError(w, http.StatusNotImplemented, protocol.ErrProtocolNotSupported)
+		return protocol.ErrProtocolNotSupported
+	}
+
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		err = &inet.HttpError{Code: http.StatusBadRequest, Message: "could not read request body"}
+		writeJSONError(w, http.StatusBadRequest, err)
 		return err
 	}
+	action, err := ExtractVehicleAction(ctx, domain, body)
 	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return err
+	}
+
+	if err := p.lockVIN(ctx, vin); err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return err
+	}
+	defer p.unlockVIN(vin)
+
+	car, err := acct.GetVehicle(ctx, vin, p.commandKey, p.sessions)
+	if err != nil || car == nil {
 		writeJSONError(w, http.StatusInternalServerError, err)
 		return err
 	}
 
+	var response proto.Message
+	unsupported, err := p.runOnVehicle(ctx, w, car, vin, func(v *vehicle.Vehicle) (err error) {
+		response, err = action(v)
+		return err
+	})
+	if unsupported {
+		writeJSONError(w, http.StatusNotImplemented, err)
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	encodedResponse, err := protojson.Marshal(response)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return err
+	}
 	w.Header().Add("Content-Type", "application/json")
-	fmt.Fprintln(w, "{\"response\":{\"result\":true,\"reason\":\"\"}}")
+	fmt.Fprintf(w, "{\"response\":%s}\n", encodedResponse)
 	return nil
 }
 
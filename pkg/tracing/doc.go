@@ -0,0 +1,35 @@
+// Package tracing records spans for operations that communicate with a vehicle, such as
+// handshakes, Fleet API requests, and commands.
+//
+// Instrumented functions in this module call [Start] with the context they were given, so spans
+// nest according to the call graph. For example, when tesla-http-proxy executes a command, the
+// resulting trace shows how much time was spent performing handshakes, waiting for the Fleet API,
+// and retrying transmissions. Spans carry the attributes defined in this package, including the
+// VIN, vehicle domain, request UUID, authentication method, and outcome.
+//
+// This package does not depend on a tracing library. Applications enable tracing by passing a
+// [Tracer] to [SetTracer] (or to [WithTracer], which scopes it to a single context). Libraries with
+// an OpenTelemetry-style API, which start spans from a context and store them in the child
+// context, can be wrapped with an [Adapter]:
+//
+//	tracer := otel.Tracer("github.com/teslamotors/vehicle-command")
+//	tracing.SetTracer(&tracing.Adapter{
+//		StartSpan: func(ctx context.Context, name string) context.Context {
+//			ctx, _ = tracer.Start(ctx, name)
+//			return ctx
+//		},
+//		SetAttribute: func(ctx context.Context, key, value string) {
+//			trace.SpanFromContext(ctx).SetAttributes(attribute.String(key, value))
+//		},
+//		EndSpan: func(ctx context.Context, err error) {
+//			span := trace.SpanFromContext(ctx)
+//			if err != nil {
+//				span.RecordError(err)
+//				span.SetStatus(codes.Error, err.Error())
+//			}
+//			span.End()
+//		},
+//	})
+//
+// When no Tracer is configured, [Start] returns a Span that discards everything.
+package tracing
@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+// Attribute keys used by spans in this module.
+const (
+	AttrVIN         = "vehicle.vin"
+	AttrDomain      = "vehicle.domain"
+	AttrRequestUUID = "vehicle.request_uuid" // Hex-encoded UUID of the RoutableMessage
+	AttrAuthMethod  = "vehicle.auth_method"
+	AttrCommand     = "vehicle.command"
+	AttrEndpoint    = "fleet_api.endpoint"
+	AttrHTTPStatus  = "http.status_code"
+	AttrOutcome     = "outcome"     // One of the Outcome* constants
+	AttrErrorClass  = "error.class" // See protocol.ErrorClass
+)
+
+// Values of the AttrOutcome attribute.
+const (
+	OutcomeOK = "ok"
+	// OutcomeDeclined indicates the vehicle received and authenticated a command, but didn't
+	// execute it. See protocol.NominalError.
+	OutcomeDeclined = "declined"
+	OutcomeError    = "error"
+)
+
+// Tracer starts spans.
+type Tracer interface {
+	// Start begins a span named name. The returned context should contain the new span, so that
+	// spans started from it become its children.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span represents an in-progress operation.
+type Span interface {
+	SetAttribute(key, value string)
+	// End completes the span. The err parameter is nil if the operation succeeded.
+	End(err error)
+}
+
+type tracerHolder struct {
+	tracer Tracer
+}
+
+var defaultTracer atomic.Pointer[tracerHolder]
+
+// SetTracer configures the Tracer used by contexts that don't have one attached with WithTracer.
+// Passing nil disables tracing.
+func SetTracer(tracer Tracer) {
+	defaultTracer.Store(&tracerHolder{tracer})
+}
+
+type contextKey struct{}
+
+// WithTracer returns a child of ctx that uses tracer instead of the one configured by SetTracer.
+// Passing a nil tracer disables tracing for the child context.
+func WithTracer(ctx context.Context, tracer Tracer) context.Context {
+	return context.WithValue(ctx, contextKey{}, &tracerHolder{tracer})
+}
+
+func tracerFrom(ctx context.Context) Tracer {
+	if holder, ok := ctx.Value(contextKey{}).(*tracerHolder); ok {
+		return holder.tracer
+	}
+	if holder := defaultTracer.Load(); holder != nil {
+		return holder.tracer
+	}
+	return nil
+}
+
+// Start begins a span using the Tracer associated with ctx. When the span ends, its AttrOutcome
+// and (if applicable) AttrErrorClass attributes are set automatically.
+func Start(ctx context.Context, name string) (context.Context, Span) {
+	tracer := tracerFrom(ctx)
+	if tracer == nil {
+		return ctx, nopSpan{}
+	}
+	ctx, span := tracer.Start(ctx, name)
+	return ctx, outcomeSpan{span}
+}
+
+// Outcome returns the AttrOutcome value that corresponds to err.
+func Outcome(err error) string {
+	if err == nil {
+		return OutcomeOK
+	}
+	if protocol.IsNominalError(err) {
+		return OutcomeDeclined
+	}
+	return OutcomeError
+}
+
+type outcomeSpan struct {
+	Span
+}
+
+func (s outcomeSpan) End(err error) {
+	s.SetAttribute(AttrOutcome, Outcome(err))
+	if err != nil {
+		s.SetAttribute(AttrErrorClass, protocol.ErrorClass(err))
+	}
+	s.Span.End(err)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttribute(string, string) {}
+func (nopSpan) End(error)                   {}
+
+// Adapter implements Tracer using callbacks. It's intended for libraries with an
+// OpenTelemetry-style API, in which the current span is stored in a context. See the package
+// documentation for an example.
+type Adapter struct {
+	// StartSpan begins a span and returns a child of ctx that contains it.
+	StartSpan func(ctx context.Context, name string) context.Context
+	// SetAttribute sets an attribute on the span contained in ctx. It may be nil.
+	SetAttribute func(ctx context.Context, key, value string)
+	// EndSpan completes the span contained in ctx.
+	EndSpan func(ctx context.Context, err error)
+}
+
+type adapterSpan struct {
+	ctx     context.Context
+	adapter *Adapter
+}
+
+func (a *Adapter) Start(ctx context.Context, name string) (context.Context, Span) {
+	ctx = a.StartSpan(ctx, name)
+	return ctx, adapterSpan{ctx: ctx, adapter: a}
+}
+
+func (s adapterSpan) SetAttribute(key, value string) {
+	if s.adapter.SetAttribute != nil {
+		s.adapter.SetAttribute(s.ctx, key, value)
+	}
+}
+
+func (s adapterSpan) End(err error) {
+	if s.adapter.EndSpan != nil {
+		s.adapter.EndSpan(s.ctx, err)
+	}
+}
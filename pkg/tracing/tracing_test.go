@@ -0,0 +1,163 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+)
+
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[string]string
+	ended      bool
+	err        error
+}
+
+type recorder struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanKey struct{}
+
+func (r *recorder) Start(ctx context.Context, name string) (context.Context, Span) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attributes: make(map[string]string)}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanKey{}, span), &recorderSpan{r, span}
+}
+
+type recorderSpan struct {
+	r    *recorder
+	span *recordedSpan
+}
+
+func (s *recorderSpan) SetAttribute(key, value string) {
+	s.r.lock.Lock()
+	defer s.r.lock.Unlock()
+	s.span.attributes[key] = value
+}
+
+func (s *recorderSpan) End(err error) {
+	s.r.lock.Lock()
+	defer s.r.lock.Unlock()
+	s.span.ended = true
+	s.span.err = err
+}
+
+func TestNoTracer(t *testing.T) {
+	ctx := context.Background()
+	childCtx, span := Start(ctx, "test")
+	if childCtx != ctx {
+		t.Error("Expected context to be unchanged when tracing is disabled")
+	}
+	span.SetAttribute(AttrVIN, "vin")
+	span.End(nil)
+}
+
+func TestSpansNest(t *testing.T) {
+	r := &recorder{}
+	ctx := WithTracer(context.Background(), r)
+
+	ctx, parent := Start(ctx, "parent")
+	parent.SetAttribute(AttrVIN, "vin")
+	_, child := Start(ctx, "child")
+	child.End(nil)
+	parent.End(nil)
+
+	if len(r.spans) != 2 {
+		t.Fatalf("Expected two spans but got %d", len(r.spans))
+	}
+	if r.spans[1].parent != r.spans[0] {
+		t.Error("Child span doesn't have correct parent")
+	}
+	for _, span := range r.spans {
+		if !span.ended {
+			t.Errorf("Span %s didn't end", span.name)
+		}
+		if span.attributes[AttrOutcome] != OutcomeOK {
+			t.Errorf("Unexpected outcome for span %s: %s", span.name, span.attributes[AttrOutcome])
+		}
+	}
+	if r.spans[0].attributes[AttrVIN] != "vin" {
+		t.Error("Missing VIN attribute")
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	r := &recorder{}
+	ctx := WithTracer(context.Background(), r)
+
+	_, span := Start(ctx, "failed")
+	span.End(context.DeadlineExceeded)
+	_, span = Start(ctx, "declined")
+	span.End(&protocol.NominalError{Details: errors.New("charge limit too low")})
+
+	if outcome := r.spans[0].attributes[AttrOutcome]; outcome != OutcomeError {
+		t.Errorf("Expected %s outcome but got %s", OutcomeError, outcome)
+	}
+	if class := r.spans[0].attributes[AttrErrorClass]; class != protocol.ErrorClass(context.DeadlineExceeded) {
+		t.Errorf("Unexpected error class %s", class)
+	}
+	if r.spans[0].err != context.DeadlineExceeded {
+		t.Error("Span didn't receive error")
+	}
+	if outcome := r.spans[1].attributes[AttrOutcome]; outcome != OutcomeDeclined {
+		t.Errorf("Expected %s outcome but got %s", OutcomeDeclined, outcome)
+	}
+}
+
+func TestDefaultTracer(t *testing.T) {
+	r := &recorder{}
+	SetTracer(r)
+	defer SetTracer(nil)
+
+	_, span := Start(context.Background(), "default")
+	span.End(nil)
+	// WithTracer overrides the default, including disabling tracing.
+	_, span = Start(WithTracer(context.Background(), nil), "disabled")
+	span.End(nil)
+
+	if len(r.spans) != 1 || r.spans[0].name != "default" {
+		t.Errorf("Unexpected spans: %+v", r.spans)
+	}
+}
+
+func TestAdapter(t *testing.T) {
+	r := &recorder{}
+	adapter := &Adapter{
+		StartSpan: func(ctx context.Context, name string) context.Context {
+			ctx, _ = r.Start(ctx, name)
+			return ctx
+		},
+		SetAttribute: func(ctx context.Context, key, value string) {
+			span := ctx.Value(spanKey{}).(*recordedSpan)
+			span.attributes[key] = value
+		},
+		EndSpan: func(ctx context.Context, err error) {
+			ctx.Value(spanKey{}).(*recordedSpan).ended = true
+		},
+	}
+	ctx := WithTracer(context.Background(), adapter)
+	ctx, parent := Start(ctx, "parent")
+	_, child := Start(ctx, "child")
+	child.SetAttribute(AttrDomain, "domain")
+	child.End(nil)
+	parent.End(nil)
+
+	if len(r.spans) != 2 || r.spans[1].parent != r.spans[0] {
+		t.Fatal("Adapter didn't propagate span through context")
+	}
+	if r.spans[1].attributes[AttrDomain] != "domain" || r.spans[1].attributes[AttrOutcome] != OutcomeOK {
+		t.Errorf("Unexpected attributes: %v", r.spans[1].attributes)
+	}
+	if !r.spans[0].ended || !r.spans[1].ended {
+		t.Error("Spans didn't end")
+	}
+}
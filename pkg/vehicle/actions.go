@@ -15,17 +15,23 @@ func (v *Vehicle) ActuateTrunk(ctx context.Context) error {
 
 // OpenTrunk opens the trunk, but note that CloseTrunk is not available on all vehicle types.
 func (v *Vehicle) OpenTrunk(ctx context.Context) error {
-	return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_MOVE, ClosureTrunk)
+	return v.verifiedToggle(ctx, ExpectClosureMoved(ClosureTrunk), func(ctx context.Context) error {
+		return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_MOVE, ClosureTrunk)
+	})
 }
 
 // CloseTrunk is not available on all vehicle types.
 func (v *Vehicle) CloseTrunk(ctx context.Context) error {
-	return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_CLOSE, ClosureTrunk)
+	return v.verified(ctx, ExpectClosure(ClosureTrunk, false), func(ctx context.Context) error {
+		return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_CLOSE, ClosureTrunk)
+	})
 }
 
 // OpenTrunk opens the frunk. There is no remote way to close the frunk!
 func (v *Vehicle) OpenFrunk(ctx context.Context) error {
-	return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_MOVE, ClosureFrunk)
+	return v.verified(ctx, ExpectClosure(ClosureFrunk, true), func(ctx context.Context) error {
+		return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_MOVE, ClosureFrunk)
+	})
 }
 func (v *Vehicle) HonkHorn(ctx context.Context) error {
 	return v.executeCarServerAction(ctx,
@@ -95,35 +101,43 @@ func (v *Vehicle) VentWindows(ctx context.Context) error {
 }
 
 func (v *Vehicle) ChargePortClose(ctx context.Context) error {
-	return v.executeCarServerAction(ctx,
-		&carserver.Action_VehicleAction{
-			VehicleAction: &carserver.VehicleAction{
-				VehicleActionMsg: &carserver.VehicleAction_ChargePortDoorClose{
-					ChargePortDoorClose: &carserver.ChargePortDoorClose{},
+	return v.verified(ctx, ExpectClosure(ClosureChargePort, false), func(ctx context.Context) error {
+		return v.executeCarServerAction(ctx,
+			&carserver.Action_VehicleAction{
+				VehicleAction: &carserver.VehicleAction{
+					VehicleActionMsg: &carserver.VehicleAction_ChargePortDoorClose{
+						ChargePortDoorClose: &carserver.ChargePortDoorClose{},
+					},
 				},
-			},
-		})
+			})
+	})
 }
 
 func (v *Vehicle) ChargePortOpen(ctx context.Context) error {
-	return v.executeCarServerAction(ctx,
-		&carserver.Action_VehicleAction{
-			VehicleAction: &carserver.VehicleAction{
-				VehicleActionMsg: &carserver.VehicleAction_ChargePortDoorOpen{
-					ChargePortDoorOpen: &carserver.ChargePortDoorOpen{},
+	return v.verified(ctx, ExpectClosure(ClosureChargePort, true), func(ctx context.Context) error {
+		return v.executeCarServerAction(ctx,
+			&carserver.Action_VehicleAction{
+				VehicleAction: &carserver.VehicleAction{
+					VehicleActionMsg: &carserver.VehicleAction_ChargePortDoorOpen{
+						ChargePortDoorOpen: &carserver.ChargePortDoorOpen{},
+					},
 				},
-			},
-		})
+			})
+	})
 }
 
 // OpenTonneau opens a Cybetruck's tonneau. Has no effect on other vehicles.
 func (v *Vehicle) OpenTonneau(ctx context.Context) error {
-	return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_OPEN, ClosureTonneau)
+	return v.verified(ctx, ExpectClosure(ClosureTonneau, true), func(ctx context.Context) error {
+		return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_OPEN, ClosureTonneau)
+	})
 }
 
 // CloseTonneau closes a Cybetruck's tonneau. Has no effect on other vehicles.
 func (v *Vehicle) CloseTonneau(ctx context.Context) error {
-	return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_CLOSE, ClosureTonneau)
+	return v.verified(ctx, ExpectClosure(ClosureTonneau, false), func(ctx context.Context) error {
+		return v.executeClosureAction(ctx, vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_CLOSE, ClosureTonneau)
+	})
 }
 
 // StopTonneau tells a Cybetruck to stop moving its tonneau. Has no effect on other vehicles.
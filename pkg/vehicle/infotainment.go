@@ -16,7 +16,12 @@ import (
 	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
 )
 
-func (v *Vehicle) getCarServerResponse(ctx context.Context, action *carserver.Action_VehicleAction) (*carserver.Response, error) {
+// ExecuteAction sends an arbitrary action to the vehicle's infotainment system and returns the
+// response. This allows clients to use actions that don't have a dedicated method yet.
+//
+// If the vehicle reports that it couldn't execute the action, the error is a
+// [protocol.NominalError].
+func (v *Vehicle) ExecuteAction(ctx context.Context, action *carserver.Action_VehicleAction) (*carserver.Response, error) {
 	payload := carserver.Action{
 		ActionMsg: action,
 	}
@@ -45,7 +50,7 @@ func (v *Vehicle) getCarServerResponse(ctx context.Context, action *carserver.Ac
 }
 
 func (v *Vehicle) executeCarServerAction(ctx context.Context, action *carserver.Action_VehicleAction) error {
-	_, err := v.getCarServerResponse(ctx, action)
+	_, err := v.ExecuteAction(ctx, action)
 	return err
 }
 
@@ -0,0 +1,67 @@
+package vehicle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	carserver "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/carserver"
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+func (s *testSender) EnqueueCarServerResponse(t *testing.T, response *carserver.Response) {
+	t.Helper()
+	encodedPayload, err := proto.Marshal(response)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	s.EnqueueResponse(t, &universal.RoutableMessage{
+		Payload: &universal.RoutableMessage_ProtobufMessageAsBytes{
+			ProtobufMessageAsBytes: encodedPayload,
+		},
+	})
+}
+
+func TestExecuteAction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	vehicle, dispatch := newTestVehicle()
+	if err := vehicle.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vehicle.Disconnect()
+
+	action := &carserver.Action_VehicleAction{
+		VehicleAction: &carserver.VehicleAction{
+			VehicleActionMsg: &carserver.VehicleAction_MediaNextTrack{
+				MediaNextTrack: &carserver.MediaNextTrack{},
+			},
+		},
+	}
+
+	dispatch.EnqueueCarServerResponse(t, &carserver.Response{
+		ActionStatus: &carserver.ActionStatus{Result: carserver.OperationStatus_E_OPERATIONSTATUS_OK},
+	})
+	response, err := vehicle.ExecuteAction(ctx, action)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if result := response.GetActionStatus().GetResult(); result != carserver.OperationStatus_E_OPERATIONSTATUS_OK {
+		t.Errorf("Unexpected result: %s", result)
+	}
+
+	dispatch.EnqueueCarServerResponse(t, &carserver.Response{
+		ActionStatus: &carserver.ActionStatus{
+			Result:       carserver.OperationStatus_E_OPERATIONSTATUS_ERROR,
+			ResultReason: &carserver.ResultReason{Reason: &carserver.ResultReason_PlainText{PlainText: "no media"}},
+		},
+	})
+	if _, err := vehicle.ExecuteAction(ctx, action); !protocol.IsNominalError(err) {
+		t.Errorf("Expected nominal error but got %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package vehicle
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+// maxConcurrentKeyQueries limits the number of KeyInfoBySlot requests that ListKeys sends at once.
+const maxConcurrentKeyQueries = 4
+
+// KeyEntry describes a public key enrolled on the vehicle's keychain.
+type KeyEntry struct {
+	Slot uint32 `json:"slot"`
+	// PublicKey is the uncompressed encoding of the NIST P256 public key.
+	PublicKey []byte `json:"public_key"`
+	// Fingerprint identifies the key in the same way as the vehicle does (see KeyFingerprint).
+	Fingerprint string              `json:"fingerprint"`
+	Role        keys.Role           `json:"role"`
+	FormFactor  vcsec.KeyFormFactor `json:"form_factor"`
+	// IsOwnKey is true if the entry is the key that the Vehicle uses to authorize commands.
+	IsOwnKey bool `json:"is_own_key"`
+}
+
+// KeyFingerprint returns the hex-encoded identifier that the vehicle uses for an encoded public
+// key in [vcsec.WhitelistInfo]: the first four bytes of its SHA-1 digest.
+func KeyFingerprint(publicKey []byte) string {
+	digest := sha1.Sum(publicKey)
+	return hex.EncodeToString(digest[:4])
+}
+
+// ListKeys fetches every entry of the vehicle's keychain, sorted by slot. Slots are fetched
+// concurrently. If some slots can't be fetched, ListKeys returns the remaining entries along with
+// an error that describes each failure.
+func (v *Vehicle) ListKeys(ctx context.Context) ([]KeyEntry, error) {
+	summary, err := v.KeySummary(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		lock    sync.Mutex
+		entries []KeyEntry
+		errs    []error
+		wg      sync.WaitGroup
+	)
+	tokens := make(chan struct{}, maxConcurrentKeyQueries)
+	for slot := uint32(0); slot < 32; slot++ {
+		if summary.GetSlotMask()&(1<<slot) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(slot uint32) {
+			defer wg.Done()
+			tokens <- struct{}{}
+			info, err := v.KeyInfoBySlot(ctx, slot)
+			<-tokens
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("slot %d: %w", slot, err))
+				return
+			}
+			entries = append(entries, v.keyEntry(slot, info))
+		}(slot)
+	}
+	wg.Wait()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Slot < entries[j].Slot })
+	return entries, errors.Join(errs...)
+}
+
+func (v *Vehicle) keyEntry(slot uint32, info *vcsec.WhitelistEntryInfo) KeyEntry {
+	publicKey := info.GetPublicKey().GetPublicKeyRaw()
+	return KeyEntry{
+		Slot:        slot,
+		PublicKey:   publicKey,
+		Fingerprint: KeyFingerprint(publicKey),
+		Role:        info.GetKeyRole(),
+		FormFactor:  info.GetMetadataForKey().GetKeyFormFactor(),
+		IsOwnKey:    v.publicKey != nil && bytes.Equal(publicKey, v.publicKey),
+	}
+}
+
+// RemoveKeyInSlot removes the key in a keychain slot. VCSEC only removes keys by value, so
+// RemoveKeyInSlot first fetches the key in slot; if the slot changes between the two requests,
+// the key that was fetched is removed.
+func (v *Vehicle) RemoveKeyInSlot(ctx context.Context, slot uint32) error {
+	info, err := v.KeyInfoBySlot(ctx, slot)
+	if err != nil {
+		return err
+	}
+	publicKey, err := ecdh.P256().NewPublicKey(info.GetPublicKey().GetPublicKeyRaw())
+	if err != nil {
+		return protocol.ErrInvalidPublicKey
+	}
+	return v.RemoveKey(ctx, publicKey)
+}
+
+// KeyIssue identifies a problem found by [AuditKeys].
+type KeyIssue string
+
+const (
+	// KeyUnnamed means the key is enrolled but has no name.
+	KeyUnnamed KeyIssue = "unnamed"
+	// KeyDuplicate means the key is enrolled in more than one slot, or shares its name with another
+	// enrolled key (e.g., because a device was paired again without removing its old key).
+	KeyDuplicate KeyIssue = "duplicate"
+	// KeyStale means the key has a name but is no longer enrolled.
+	KeyStale KeyIssue = "stale"
+)
+
+// KeyAudit describes a key found by [AuditKeys].
+type KeyAudit struct {
+	// Entry is the keychain entry. It's nil for stale keys.
+	Entry *KeyEntry `json:"entry,omitempty"`
+	// PublicKey is the hex-encoded public key.
+	PublicKey string     `json:"public_key"`
+	Name      string     `json:"name,omitempty"`
+	Issues    []KeyIssue `json:"issues,omitempty"`
+}
+
+// AuditKeys cross-references the keychain entries returned by [Vehicle.ListKeys] with key names
+// supplied by the caller (for example, names recorded when keys were registered using
+// account.UpdateKey), which are indexed by hex-encoded public key. It returns one KeyAudit for each
+// enrolled key, in slot order, followed by one for each stale name, in order of public key.
+func AuditKeys(entries []KeyEntry, names map[string]string) []KeyAudit {
+	var results []KeyAudit
+	enrolled := make(map[string]int)
+	nameCount := make(map[string]int)
+	for _, entry := range entries {
+		publicKey := hex.EncodeToString(entry.PublicKey)
+		enrolled[publicKey]++
+		if name, ok := names[publicKey]; ok && enrolled[publicKey] == 1 {
+			nameCount[name]++
+		}
+	}
+	for i := range entries {
+		publicKey := hex.EncodeToString(entries[i].PublicKey)
+		audit := KeyAudit{Entry: &entries[i], PublicKey: publicKey}
+		name, ok := names[publicKey]
+		if ok && name != "" {
+			audit.Name = name
+		} else {
+			audit.Issues = append(audit.Issues, KeyUnnamed)
+		}
+		if enrolled[publicKey] > 1 || (audit.Name != "" && nameCount[name] > 1) {
+			audit.Issues = append(audit.Issues, KeyDuplicate)
+		}
+		results = append(results, audit)
+	}
+	var stale []string
+	for publicKey := range names {
+		if enrolled[publicKey] == 0 {
+			stale = append(stale, publicKey)
+		}
+	}
+	sort.Strings(stale)
+	for _, publicKey := range stale {
+		results = append(results, KeyAudit{PublicKey: publicKey, Name: names[publicKey], Issues: []KeyIssue{KeyStale}})
+	}
+	return results
+}
@@ -0,0 +1,61 @@
+package vehicle
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestAuditKeys(t *testing.T) {
+	phone, oldPhone, card, unnamed := []byte{4, 1}, []byte{4, 2}, []byte{4, 3}, []byte{4, 4}
+	entries := []KeyEntry{
+		{Slot: 0, PublicKey: phone},
+		{Slot: 1, PublicKey: oldPhone},
+		{Slot: 2, PublicKey: card},
+		{Slot: 3, PublicKey: unnamed},
+		{Slot: 5, PublicKey: card},
+	}
+	names := map[string]string{
+		hex.EncodeToString(phone):    "Phone",
+		hex.EncodeToString(oldPhone): "Phone",
+		hex.EncodeToString(card):     "Card",
+		"04ff":                       "Lost phone",
+	}
+	expected := []struct {
+		slot   uint32
+		name   string
+		issues []KeyIssue
+	}{
+		{0, "Phone", []KeyIssue{KeyDuplicate}},
+		{1, "Phone", []KeyIssue{KeyDuplicate}},
+		{2, "Card", []KeyIssue{KeyDuplicate}},
+		{3, "", []KeyIssue{KeyUnnamed}},
+		{5, "Card", []KeyIssue{KeyDuplicate}},
+	}
+
+	results := AuditKeys(entries, names)
+	if len(results) != len(expected)+1 {
+		t.Fatalf("Expected %d results but got %+v", len(expected)+1, results)
+	}
+	for i, want := range expected {
+		got := results[i]
+		if got.Entry == nil || got.Entry.Slot != want.slot || got.Name != want.name || !reflect.DeepEqual(got.Issues, want.issues) {
+			t.Errorf("Result %d: expected %+v but got %+v", i, want, got)
+		}
+	}
+	stale := results[len(expected)]
+	if stale.Entry != nil || stale.PublicKey != "04ff" || !reflect.DeepEqual(stale.Issues, []KeyIssue{KeyStale}) {
+		t.Errorf("Expected stale key but got %+v", stale)
+	}
+
+	if results := AuditKeys(entries[:1], map[string]string{hex.EncodeToString(phone): "Phone"}); len(results[0].Issues) != 0 {
+		t.Errorf("Unexpected issues: %+v", results[0].Issues)
+	}
+}
+
+func TestKeyFingerprint(t *testing.T) {
+	// The first four bytes of SHA1("abc").
+	if fingerprint := KeyFingerprint([]byte("abc")); fingerprint != "a9993e36" {
+		t.Errorf("Unexpected fingerprint %s", fingerprint)
+	}
+}
@@ -0,0 +1,124 @@
+package vehicle
+
+import (
+	"context"
+	"crypto/ecdh"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/keys"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/signatures"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// ErrPairingRejected is returned by [Vehicle.PairKey] when the vehicle reports that it won't
+// enroll the key. The error returned by PairKey also wraps the [protocol.KeychainError] sent by the
+// vehicle.
+var ErrPairingRejected = errors.New("vehicle rejected add-key request")
+
+// PairingStage describes the progress of [Vehicle.PairKey].
+type PairingStage int
+
+const (
+	// PairingRequestSent means the add-key request was transmitted and the user must now tap an NFC
+	// card on the center console and confirm on the vehicle UI.
+	PairingRequestSent PairingStage = iota
+	// PairingWaiting means the key wasn't enrolled yet when the vehicle was last polled.
+	PairingWaiting
+	// PairingEnrolled means the vehicle reports that the key is enrolled.
+	PairingEnrolled
+)
+
+func (s PairingStage) String() string {
+	switch s {
+	case PairingRequestSent:
+		return "request sent"
+	case PairingWaiting:
+		return "waiting for approval"
+	case PairingEnrolled:
+		return "enrolled"
+	}
+	return "unknown"
+}
+
+// PairingProgress is passed to the progress callback of [Vehicle.PairKey].
+type PairingProgress struct {
+	Stage   PairingStage
+	Elapsed time.Duration
+	// Err is set if the vehicle couldn't be polled. PairKey keeps polling after errors.
+	Err error
+}
+
+// PairKey sends an add-key request for publicKey over BLE (see [Vehicle.SendAddKeyRequestWithRole])
+// and then polls the vehicle until the key is enrolled. If progress is not nil, it's called after
+// the request is sent and after each poll.
+//
+// PairKey returns nil once the key is enrolled, an error wrapping [ErrPairingRejected] if the
+// vehicle reports that it won't enroll the key, and ctx.Err() if ctx expires first. The user needs
+// time to reach the vehicle, so ctx should allow at least a minute or two.
+func (v *Vehicle) PairKey(ctx context.Context, publicKey *ecdh.PublicKey, role keys.Role, formFactor vcsec.KeyFormFactor, progress func(PairingProgress)) error {
+	start := time.Now()
+	report := func(stage PairingStage, err error) {
+		if progress != nil {
+			progress(PairingProgress{Stage: stage, Elapsed: time.Since(start), Err: err})
+		}
+	}
+
+	// The vehicle sends the outcome of the request before the client has a session, so it can't be
+	// authenticated. Rejections are only used to stop polling early; success is always confirmed
+	// by polling.
+	rejections := v.dispatcher.SubscribeUnauthenticated(universal.Domain_DOMAIN_VEHICLE_SECURITY, nil)
+	defer rejections.Close()
+
+	if err := v.SendAddKeyRequestWithRole(ctx, publicKey, role, formFactor); err != nil {
+		return err
+	}
+	report(PairingRequestSent, nil)
+
+	for {
+		enrolled, err := v.keyEnrolled(ctx, publicKey)
+		if err == nil && enrolled {
+			report(PairingEnrolled, nil)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		report(PairingWaiting, err)
+		timer := time.NewTimer(v.dispatcher.RetryInterval())
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+				break wait
+			case message := <-rejections.Recv():
+				_, err := unmarshalVCSECResponse(message)
+				var keychainErr *protocol.KeychainError
+				if errors.As(err, &keychainErr) {
+					timer.Stop()
+					return fmt.Errorf("%w: %w", ErrPairingRejected, keychainErr)
+				}
+			}
+		}
+	}
+}
+
+// keyEnrolled asks VCSEC whether publicKey is on the vehicle's keychain. An error is only returned
+// if the vehicle couldn't answer.
+func (v *Vehicle) keyEnrolled(ctx context.Context, publicKey *ecdh.PublicKey) (bool, error) {
+	info, err := v.SessionInfo(ctx, publicKey, universal.Domain_DOMAIN_VEHICLE_SECURITY)
+	if errors.Is(err, protocol.ErrKeyNotPaired) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.GetStatus() == signatures.Session_Info_Status_SESSION_INFO_STATUS_OK, nil
+}
@@ -3,6 +3,7 @@ package vehicle
 import (
 	"context"
 	"crypto/ecdh"
+	"time"
 
 	"google.golang.org/protobuf/proto"
 
@@ -143,7 +144,7 @@ func (v *Vehicle) SetGuestMode(ctx context.Context, enabled bool) error {
 // discards any new PIN provided using this method. To change an existing PIN, first call
 // v.ResetPIN.
 func (v *Vehicle) SetPINToDrive(ctx context.Context, enabled bool, pin string) error {
-	if _, ok := v.conn.(connector.FleetAPIConnector); !ok {
+	if _, ok := v.transport().(connector.FleetAPIConnector); !ok {
 		return protocol.ErrRequiresEncryption
 	}
 
@@ -223,6 +224,122 @@ func (v *Vehicle) RemoveKey(ctx context.Context, publicKey *ecdh.PublicKey) erro
 	return v.executeWhitelistOperation(ctx, encodedPayload)
 }
 
+// AddImpermanentKey adds a public key to the vehicle's whitelist that the vehicle removes
+// automatically, e.g. when [Vehicle.RemoveAllImpermanentKeys] is called.
+func (v *Vehicle) AddImpermanentKey(ctx context.Context, publicKey *ecdh.PublicKey, role keys.Role, formFactor vcsec.KeyFormFactor) error {
+	if publicKey.Curve() != ecdh.P256() {
+		return protocol.ErrInvalidPublicKey
+	}
+	return v.executeWhitelistMessage(ctx, &vcsec.WhitelistOperation{
+		SubMessage: &vcsec.WhitelistOperation_AddImpermanentKey{
+			AddImpermanentKey: permissionChange(publicKey, role, 0),
+		},
+		MetadataForKey: &vcsec.KeyMetadata{KeyFormFactor: formFactor},
+	})
+}
+
+// AddImpermanentKeyAndRemoveExisting removes all impermanent keys from the vehicle's whitelist
+// and adds publicKey as an impermanent key in a single operation.
+func (v *Vehicle) AddImpermanentKeyAndRemoveExisting(ctx context.Context, publicKey *ecdh.PublicKey, role keys.Role, formFactor vcsec.KeyFormFactor) error {
+	if publicKey.Curve() != ecdh.P256() {
+		return protocol.ErrInvalidPublicKey
+	}
+	return v.executeWhitelistMessage(ctx, &vcsec.WhitelistOperation{
+		SubMessage: &vcsec.WhitelistOperation_AddImpermanentKeyAndRemoveExisting{
+			AddImpermanentKeyAndRemoveExisting: permissionChange(publicKey, role, 0),
+		},
+		MetadataForKey: &vcsec.KeyMetadata{KeyFormFactor: formFactor},
+	})
+}
+
+// RemoveAllImpermanentKeys removes every impermanent key from the vehicle's whitelist.
+func (v *Vehicle) RemoveAllImpermanentKeys(ctx context.Context) error {
+	return v.executeWhitelistMessage(ctx, &vcsec.WhitelistOperation{
+		SubMessage: &vcsec.WhitelistOperation_RemoveAllImpermanentKeys{
+			RemoveAllImpermanentKeys: true,
+		},
+	})
+}
+
+// AddKeyPermissions grants the permissions of role to a public key that's already on the
+// vehicle's whitelist.
+func (v *Vehicle) AddKeyPermissions(ctx context.Context, publicKey *ecdh.PublicKey, role keys.Role) error {
+	if publicKey.Curve() != ecdh.P256() {
+		return protocol.ErrInvalidPublicKey
+	}
+	return v.executeWhitelistMessage(ctx, &vcsec.WhitelistOperation{
+		SubMessage: &vcsec.WhitelistOperation_AddPermissionsToPublicKey{
+			AddPermissionsToPublicKey: permissionChange(publicKey, role, 0),
+		},
+	})
+}
+
+// RemoveKeyPermissions revokes the permissions of role from a public key without removing the
+// key from the vehicle's whitelist.
+func (v *Vehicle) RemoveKeyPermissions(ctx context.Context, publicKey *ecdh.PublicKey, role keys.Role) error {
+	if publicKey.Curve() != ecdh.P256() {
+		return protocol.ErrInvalidPublicKey
+	}
+	return v.executeWhitelistMessage(ctx, &vcsec.WhitelistOperation{
+		SubMessage: &vcsec.WhitelistOperation_RemovePermissionsFromPublicKey{
+			RemovePermissionsFromPublicKey: permissionChange(publicKey, role, 0),
+		},
+	})
+}
+
+// UpdateKeyAndPermissions changes the role of a public key on the vehicle's whitelist. If
+// activeFor is non-zero, the vehicle only accepts the key for that long, which allows clients to
+// issue time-boxed keys. The vehicle counts time in whole seconds.
+func (v *Vehicle) UpdateKeyAndPermissions(ctx context.Context, publicKey *ecdh.PublicKey, role keys.Role, activeFor time.Duration) error {
+	if publicKey.Curve() != ecdh.P256() {
+		return protocol.ErrInvalidPublicKey
+	}
+	if activeFor < 0 {
+		return protocol.NewError("key lifetime must not be negative", false, false)
+	}
+	seconds := uint32((activeFor + time.Second - 1) / time.Second)
+	return v.executeWhitelistMessage(ctx, &vcsec.WhitelistOperation{
+		SubMessage: &vcsec.WhitelistOperation_UpdateKeyAndPermissions{
+			UpdateKeyAndPermissions: permissionChange(publicKey, role, seconds),
+		},
+	})
+}
+
+// ReplaceKey atomically replaces oldKey on the vehicle's whitelist with newKey, so that there's no
+// window in which neither key (or both keys) are enrolled. If impermanent is true, newKey is added
+// as an impermanent key (see [Vehicle.AddImpermanentKey]).
+func (v *Vehicle) ReplaceKey(ctx context.Context, oldKey, newKey *ecdh.PublicKey, role keys.Role, impermanent bool) error {
+	if oldKey.Curve() != ecdh.P256() {
+		return protocol.ErrInvalidPublicKey
+	}
+	return v.replaceKey(ctx, &vcsec.ReplaceKey{
+		KeyToReplace: &vcsec.ReplaceKey_PublicKeyToReplace{
+			PublicKeyToReplace: &vcsec.PublicKey{PublicKeyRaw: oldKey.Bytes()},
+		},
+	}, newKey, role, impermanent)
+}
+
+// ReplaceKeyInSlot is like [Vehicle.ReplaceKey] but identifies the key to replace by its keychain
+// slot (see [Vehicle.KeyInfoBySlot]).
+func (v *Vehicle) ReplaceKeyInSlot(ctx context.Context, slot uint32, newKey *ecdh.PublicKey, role keys.Role, impermanent bool) error {
+	return v.replaceKey(ctx, &vcsec.ReplaceKey{
+		KeyToReplace: &vcsec.ReplaceKey_SlotToReplace{SlotToReplace: slot},
+	}, newKey, role, impermanent)
+}
+
+// replaceKey fills in the key to add to replace, which must already identify the key to remove.
+func (v *Vehicle) replaceKey(ctx context.Context, replace *vcsec.ReplaceKey, newKey *ecdh.PublicKey, role keys.Role, impermanent bool) error {
+	if newKey.Curve() != ecdh.P256() {
+		return protocol.ErrInvalidPublicKey
+	}
+	replace.KeyToAdd = &vcsec.PublicKey{PublicKeyRaw: newKey.Bytes()}
+	replace.KeyRole = role
+	replace.Impermanent = impermanent
+	return v.executeWhitelistMessage(ctx, &vcsec.WhitelistOperation{
+		SubMessage: &vcsec.WhitelistOperation_ReplaceKey{ReplaceKey: replace},
+	})
+}
+
 func (v *Vehicle) KeySummary(ctx context.Context) (*vcsec.WhitelistInfo, error) {
 	reply, err := v.getVCSECInfo(ctx, vcsec.InformationRequestType_INFORMATION_REQUEST_TYPE_GET_WHITELIST_INFO, slotNone)
 	if err != nil {
@@ -240,11 +357,15 @@ func (v *Vehicle) KeyInfoBySlot(ctx context.Context, slot uint32) (*vcsec.Whitel
 }
 
 func (v *Vehicle) Lock(ctx context.Context) error {
-	return v.executeRKEAction(ctx, vcsec.RKEAction_E_RKE_ACTION_LOCK)
+	return v.verified(ctx, ExpectLocked(true), func(ctx context.Context) error {
+		return v.executeRKEAction(ctx, vcsec.RKEAction_E_RKE_ACTION_LOCK)
+	})
 }
 
 func (v *Vehicle) Unlock(ctx context.Context) error {
-	return v.executeRKEAction(ctx, vcsec.RKEAction_E_RKE_ACTION_UNLOCK)
+	return v.verified(ctx, ExpectLocked(false), func(ctx context.Context) error {
+		return v.executeRKEAction(ctx, vcsec.RKEAction_E_RKE_ACTION_UNLOCK)
+	})
 }
 
 // SendAddKeyRequest sends an add-key request to the vehicle over BLE. The user must approve the
@@ -275,7 +396,7 @@ func (v *Vehicle) SendAddKeyRequestWithRole(ctx context.Context, publicKey *ecdh
 	if publicKey.Curve() != ecdh.P256() {
 		return protocol.ErrInvalidPublicKey
 	}
-	if _, ok := v.conn.(connector.FleetAPIConnector); ok {
+	if _, ok := v.transport().(connector.FleetAPIConnector); ok {
 		return protocol.ErrRequiresBLE
 	}
 	encodedPayload, err := proto.Marshal(addKeyPayload(publicKey, role, formFactor))
@@ -0,0 +1,118 @@
+package vehicle
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// WakeStage describes the progress of a wake sequence started by
+// [Vehicle.StartSessionSleepAware].
+type WakeStage int
+
+const (
+	// WakeRequested means infotainment was asleep and a wake command was sent.
+	WakeRequested WakeStage = iota
+	// WakeCompleted means the vehicle reports that infotainment is awake.
+	WakeCompleted
+)
+
+func (s WakeStage) String() string {
+	switch s {
+	case WakeRequested:
+		return "wake requested"
+	case WakeCompleted:
+		return "awake"
+	}
+	return "unknown"
+}
+
+// WakeProgress is passed to the callback of [Vehicle.StartSessionSleepAware].
+type WakeProgress struct {
+	Stage   WakeStage
+	Elapsed time.Duration
+}
+
+// StartSessionSleepAware is like [Vehicle.StartSession], but checks whether infotainment is asleep
+// using [Vehicle.BodyControllerState] before performing any handshakes. The check is answered by
+// VCSEC and doesn't wake the vehicle.
+//
+// If domains is nil and infotainment is asleep, only a VCSEC session is started; commands sent to
+// infotainment will fail with [protocol.ErrNoSession]. If domains includes infotainment and
+// infotainment is asleep, the vehicle is woken up (see [Vehicle.Wakeup]) before the handshake, and
+// onWake (if not nil) is called when the wake command is sent and when infotainment is awake.
+// Waking the vehicle over BLE requires a VCSEC session, which is started even if domains doesn't
+// include VCSEC.
+//
+// The check is only performed over BLE. Fleet API connections are equivalent to StartSession. If
+// the vehicle status can't be fetched, StartSessionSleepAware falls back to StartSession.
+func (v *Vehicle) StartSessionSleepAware(ctx context.Context, domains []universal.Domain, onWake func(WakeProgress)) error {
+	if domains != nil && !slices.Contains(domains, protocol.DomainInfotainment) {
+		// Nothing to check; VCSEC doesn't sleep.
+		return v.StartSession(ctx, domains)
+	}
+	if _, ok := v.transport().(connector.FleetAPIConnector); ok {
+		return v.StartSession(ctx, domains)
+	}
+	status, err := v.BodyControllerState(ctx)
+	if err != nil {
+		log.Debug("Couldn't determine if vehicle is asleep: %s", err)
+		return v.StartSession(ctx, domains)
+	}
+	if status.GetVehicleSleepStatus() != vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_ASLEEP {
+		return v.StartSession(ctx, domains)
+	}
+	if domains == nil {
+		log.Debug("Infotainment is asleep; only connecting to VCSEC")
+		return v.StartSession(ctx, []universal.Domain{protocol.DomainVCSEC})
+	}
+
+	if err := v.StartSession(ctx, []universal.Domain{protocol.DomainVCSEC}); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := v.Wakeup(ctx); err != nil {
+		return err
+	}
+	if onWake != nil {
+		onWake(WakeProgress{Stage: WakeRequested, Elapsed: time.Since(start)})
+	}
+	if err := v.waitAwake(ctx); err != nil {
+		return err
+	}
+	if onWake != nil {
+		onWake(WakeProgress{Stage: WakeCompleted, Elapsed: time.Since(start)})
+	}
+	return v.StartSession(ctx, []universal.Domain{protocol.DomainInfotainment})
+}
+
+// waitAwake polls the vehicle until infotainment is awake. The vehicle may not respond while it
+// wakes up, so unlike [Vehicle.WaitFor], waitAwake keeps polling after errors until ctx expires.
+func (v *Vehicle) waitAwake(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lastErr error
+	for update := range v.Watch(ctx, v.dispatcher.RetryInterval()) {
+		if update.Err != nil {
+			log.Debug("Couldn't fetch vehicle status while waking: %s", update.Err)
+			lastErr = update.Err
+			continue
+		}
+		lastErr = nil
+		if InfotainmentAwake(update.Status) {
+			return nil
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("%w (last error: %w)", ctx.Err(), lastErr)
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,119 @@
+package vehicle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/connector"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+type testFleetAPIConnector struct {
+	connector.Connector
+}
+
+func (c *testFleetAPIConnector) Close() {}
+
+func (c *testFleetAPIConnector) SendFleetAPICommand(ctx context.Context, endpoint string, command interface{}) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *testFleetAPIConnector) Wakeup(ctx context.Context) error {
+	return errors.New("not implemented")
+}
+
+// EnqueueCommandCompleted queues the final reply to a VCSEC command.
+func (s *testSender) EnqueueCommandCompleted(t *testing.T) {
+	t.Helper()
+	s.EnqueueResponse(t, &universal.RoutableMessage{
+		Payload: &universal.RoutableMessage_ProtobufMessageAsBytes{},
+	})
+}
+
+func TestStartSessionSleepAwareWakeErrors(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newTestVehicle()
+	if err := vehicle.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vehicle.Disconnect()
+
+	errLinkLost := &protocol.CommandError{Err: errors.New("link lost"), PossibleSuccess: false, PossibleTemporary: false}
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{
+		VehicleSleepStatus: vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_ASLEEP,
+	})
+	dispatch.EnqueueCommandCompleted(t)
+	// The vehicle doesn't respond while it's waking up.
+	dispatch.EnqueueError(nil)
+	dispatch.EnqueueError(nil)
+	dispatch.EnqueueError(errLinkLost)
+	dispatch.EnqueueError(errLinkLost)
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{
+		VehicleSleepStatus: vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_AWAKE,
+	})
+
+	var stages []WakeStage
+	onWake := func(p WakeProgress) { stages = append(stages, p.Stage) }
+	if err := vehicle.StartSessionSleepAware(ctx, []universal.Domain{protocol.DomainInfotainment}, onWake); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	if len(stages) != 2 || stages[1] != WakeCompleted {
+		t.Errorf("Unexpected wake progress: %v", stages)
+	}
+}
+
+func TestStartSessionSleepAwareWakeTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newTestVehicle()
+	if err := vehicle.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vehicle.Disconnect()
+
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{
+		VehicleSleepStatus: vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_ASLEEP,
+	})
+	dispatch.EnqueueCommandCompleted(t)
+	dispatch.EnqueueError(nil)
+	dispatch.EnqueueError(nil)
+	errLinkLost := &protocol.CommandError{Err: errors.New("link lost"), PossibleSuccess: false, PossibleTemporary: false}
+	for i := 0; i < 1000; i++ {
+		dispatch.EnqueueError(errLinkLost)
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err := vehicle.StartSessionSleepAware(shortCtx, []universal.Domain{protocol.DomainInfotainment}, nil)
+	if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, errLinkLost) {
+		t.Errorf("Expected timeout wrapping last error but got %v", err)
+	}
+}
+
+func TestStartSessionSleepAwareFleetAPI(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newTestVehicle()
+	vehicle.conn = &testFleetAPIConnector{}
+	if err := vehicle.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vehicle.Disconnect()
+
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{
+		VehicleSleepStatus: vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_ASLEEP,
+	})
+	onWake := func(p WakeProgress) { t.Errorf("Unexpected wake progress: %s", p.Stage) }
+	if err := vehicle.StartSessionSleepAware(ctx, nil, onWake); err != nil {
+		t.Fatalf("Failed to start session: %s", err)
+	}
+	if len(dispatch.ch) != 1 {
+		t.Error("Vehicle status was fetched over Fleet API")
+	}
+}
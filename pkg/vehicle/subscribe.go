@@ -0,0 +1,63 @@
+package vehicle
+
+import (
+	"context"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+// Subscribe returns a Receiver for messages from domain that the vehicle sends without a
+// corresponding request, such as status updates that VCSEC pushes over BLE. Without a
+// subscription, these messages are discarded. If filter is not nil, only messages for which it
+// returns true are delivered; filter must not block.
+//
+// Messages are only delivered after v has completed a handshake with domain (see
+// [Vehicle.StartSession]). The caller must Close the Receiver when it's no longer needed.
+func (v *Vehicle) Subscribe(domain universal.Domain, filter func(*universal.RoutableMessage) bool) protocol.Receiver {
+	return v.dispatcher.Subscribe(domain, filter)
+}
+
+// SubscribeVCSEC decodes unsolicited messages from the vehicle security controller and sends the
+// ones for which filter returns true (or all of them, if filter is nil) to the returned channel.
+// Messages that can't be decoded or that report an error are discarded. The channel is closed when
+// ctx expires, and the caller must keep receiving from the channel until it's closed.
+func (v *Vehicle) SubscribeVCSEC(ctx context.Context, filter func(*vcsec.FromVCSECMessage) bool) <-chan *vcsec.FromVCSECMessage {
+	recv := v.Subscribe(universal.Domain_DOMAIN_VEHICLE_SECURITY, nil)
+	messages := make(chan *vcsec.FromVCSECMessage)
+	go func() {
+		defer close(messages)
+		defer recv.Close()
+		for {
+			var message *universal.RoutableMessage
+			select {
+			case message = <-recv.Recv():
+			case <-ctx.Done():
+				return
+			}
+			fromVCSEC, err := unmarshalVCSECResponse(message)
+			if err != nil {
+				log.Warning("[%02x] Discarding unsolicited VCSEC message: %s", message.GetUuid(), err)
+				continue
+			}
+			if filter != nil && !filter(fromVCSEC) {
+				continue
+			}
+			select {
+			case messages <- fromVCSEC:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return messages
+}
+
+// IsVehicleStatus returns true if message contains a [vcsec.VehicleStatus]. It can be used as a
+// [Vehicle.SubscribeVCSEC] filter.
+func IsVehicleStatus(message *vcsec.FromVCSECMessage) bool {
+	return message.GetVehicleStatus() != nil
+}
@@ -0,0 +1,57 @@
+package vehicle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+)
+
+func TestSubscribeVCSEC(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newTestVehicle()
+
+	subCtx, stop := context.WithCancel(ctx)
+	messages := vehicle.SubscribeVCSEC(subCtx, IsVehicleStatus)
+
+	dispatch.unsolicited <- &universal.RoutableMessage{
+		Payload: &universal.RoutableMessage_ProtobufMessageAsBytes{
+			ProtobufMessageAsBytes: []byte("not a protobuf"),
+		},
+	}
+	// Messages rejected by the filter aren't delivered.
+	commandStatus, err := proto.Marshal(&vcsec.FromVCSECMessage{
+		SubMessage: &vcsec.FromVCSECMessage_CommandStatus{
+			CommandStatus: &vcsec.CommandStatus{},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatch.unsolicited <- &universal.RoutableMessage{
+		Payload: &universal.RoutableMessage_ProtobufMessageAsBytes{
+			ProtobufMessageAsBytes: commandStatus,
+		},
+	}
+	dispatch.unsolicited <- vehicleStatusMessage(t, &vcsec.VehicleStatus{
+		VehicleLockState: vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED,
+	})
+
+	select {
+	case message := <-messages:
+		if message.GetVehicleStatus().GetVehicleLockState() != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED {
+			t.Errorf("Unexpected message: %+v", message)
+		}
+	case <-ctx.Done():
+		t.Fatal("Vehicle status wasn't delivered")
+	}
+	stop()
+	for range messages {
+	}
+}
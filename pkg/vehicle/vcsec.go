@@ -83,27 +83,65 @@ func readUntil(ctx context.Context, recv protocol.Receiver, done isTerminalTest)
 
 // getVCSECResult sends a payload to VCSEC, retrying as appropriate, and returns nil if the command succeeded.
 func (v *Vehicle) getVCSECResult(ctx context.Context, payload []byte, auth connector.AuthMethod, done isTerminalTest) (*vcsec.FromVCSECMessage, error) {
+	const domain = universal.Domain_DOMAIN_VEHICLE_SECURITY
+	ctx, span := v.startSpan(ctx, domain, auth)
+	start := time.Now()
+	fromVCSEC, err := v.sendVCSEC(ctx, payload, auth, done)
+	v.monitor.CommandCompleted(domain, time.Since(start), err)
+	span.End(err)
+	return fromVCSEC, err
+}
+
+func (v *Vehicle) sendVCSEC(ctx context.Context, payload []byte, auth connector.AuthMethod, done isTerminalTest) (*vcsec.FromVCSECMessage, error) {
+	const domain = universal.Domain_DOMAIN_VEHICLE_SECURITY
 	var fromVCSEC *vcsec.FromVCSECMessage
-	for {
-		recv, err := v.getReceiver(ctx, universal.Domain_DOMAIN_VEHICLE_SECURITY, payload, auth)
+	ctx, policy := v.withRetryPolicy(ctx)
+	for attempts := 1; ; attempts++ {
+		recv, err := v.getReceiver(ctx, domain, payload, auth)
 		if err == nil {
 			fromVCSEC, err = readUntil(ctx, recv, done)
 			recv.Close()
 		}
 
-		if !protocol.ShouldRetry(err) {
+		if !policy.ShouldRetry(err, attempts) {
 			return fromVCSEC, err
 		}
+		v.monitor.Retrying(domain, err)
 
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-time.After(v.dispatcher.RetryInterval()):
-			continue
+		if err := policy.Wait(ctx, attempts, v.dispatcher.RetryInterval()); err != nil {
+			return nil, err
 		}
 	}
 }
 
+// ExecuteVCSECAction sends an arbitrary message to the vehicle's security controller (VCSEC) and
+// returns the final response. This allows clients to use messages that don't have a dedicated
+// method yet.
+//
+// Information requests are sent without authentication, matching the methods that fetch vehicle
+// state. All other messages are authenticated.
+func (v *Vehicle) ExecuteVCSECAction(ctx context.Context, message *vcsec.UnsignedMessage) (*vcsec.FromVCSECMessage, error) {
+	encodedPayload, err := proto.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := v.authMethod
+	var done isTerminalTest
+	switch message.GetSubMessage().(type) {
+	case *vcsec.UnsignedMessage_InformationRequest:
+		auth = connector.AuthMethodNone
+		done = func(*vcsec.FromVCSECMessage) (bool, error) { return true, nil }
+	case *vcsec.UnsignedMessage_WhitelistOperation:
+		done = isWhitelistOperationComplete
+	case nil:
+		return nil, protocol.NewError("VCSEC message is empty", false, false)
+	default:
+		done = isCommandComplete
+	}
+	return v.getVCSECResult(ctx, encodedPayload, auth, done)
+}
+
 const slotNone = 0xFFFFFFFF
 
 func (v *Vehicle) getVCSECInfo(ctx context.Context, requestType vcsec.InformationRequestType, keySlot uint32) (*vcsec.FromVCSECMessage, error) {
@@ -143,11 +181,39 @@ func isWhitelistOperationComplete(fromVCSEC *vcsec.FromVCSECMessage) (bool, erro
 	return false, nil
 }
 
+// isCommandComplete waits for the final reply to a VCSEC command. VCSEC may send intermediate
+// status messages while an actuator is in motion.
+func isCommandComplete(fromVCSEC *vcsec.FromVCSECMessage) (bool, error) {
+	return fromVCSEC.GetCommandStatus() == nil, nil
+}
+
 func (v *Vehicle) executeWhitelistOperation(ctx context.Context, payload []byte) error {
 	_, err := v.getVCSECResult(ctx, payload, v.authMethod, isWhitelistOperationComplete)
 	return err
 }
 
+// executeWhitelistMessage sends a whitelist operation to VCSEC and waits for it to complete.
+func (v *Vehicle) executeWhitelistMessage(ctx context.Context, operation *vcsec.WhitelistOperation) error {
+	payload := vcsec.UnsignedMessage{
+		SubMessage: &vcsec.UnsignedMessage_WhitelistOperation{
+			WhitelistOperation: operation,
+		},
+	}
+	encodedPayload, err := proto.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+	return v.executeWhitelistOperation(ctx, encodedPayload)
+}
+
+func permissionChange(publicKey *ecdh.PublicKey, role keys.Role, secondsToBeActive uint32) *vcsec.PermissionChange {
+	return &vcsec.PermissionChange{
+		Key:               &vcsec.PublicKey{PublicKeyRaw: publicKey.Bytes()},
+		KeyRole:           role,
+		SecondsToBeActive: secondsToBeActive,
+	}
+}
+
 func addKeyPayload(publicKey *ecdh.PublicKey, role keys.Role, formFactor vcsec.KeyFormFactor) *vcsec.UnsignedMessage {
 	return &vcsec.UnsignedMessage{
 		SubMessage: &vcsec.UnsignedMessage_WhitelistOperation{
@@ -172,13 +238,6 @@ func addKeyPayload(publicKey *ecdh.PublicKey, role keys.Role, formFactor vcsec.K
 // referred to "Remote Keyless Entry" but now refers more generally to commands
 // that can be sent by a keyfob).
 func (v *Vehicle) executeRKEAction(ctx context.Context, action vcsec.RKEAction_E) error {
-	done := func(fromVCSEC *vcsec.FromVCSECMessage) (bool, error) {
-		if fromVCSEC.GetCommandStatus() == nil {
-			return true, nil
-		}
-		return false, nil
-	}
-
 	payload := vcsec.UnsignedMessage{
 		SubMessage: &vcsec.UnsignedMessage_RKEAction{
 			RKEAction: action,
@@ -189,7 +248,7 @@ func (v *Vehicle) executeRKEAction(ctx context.Context, action vcsec.RKEAction_E
 		return err
 	}
 
-	_, err = v.getVCSECResult(ctx, encodedPayload, v.authMethod, done)
+	_, err = v.getVCSECResult(ctx, encodedPayload, v.authMethod, isCommandComplete)
 	return err
 }
 
@@ -212,16 +271,12 @@ const (
 	ClosureTrunk   Closure = "trunk"
 	ClosureFrunk   Closure = "frunk"
 	ClosureTonneau Closure = "tonneau"
+	// ClosureChargePort can only be used with ExpectClosure. Use ChargePortOpen and
+	// ChargePortClose to move the charge port door.
+	ClosureChargePort Closure = "charge-port"
 )
 
 func (v *Vehicle) executeClosureAction(ctx context.Context, action vcsec.ClosureMoveType_E, closure Closure) error {
-	done := func(fromVCSEC *vcsec.FromVCSECMessage) (bool, error) {
-		if fromVCSEC.GetCommandStatus() == nil {
-			return true, nil
-		}
-		return false, nil
-	}
-
 	// Not all actions are meaningful for all closures. Exported methods restrict combinations.
 	var request vcsec.ClosureMoveRequest
 	switch closure {
@@ -244,6 +299,6 @@ func (v *Vehicle) executeClosureAction(ctx context.Context, action vcsec.Closure
 		return err
 	}
 
-	_, err = v.getVCSECResult(ctx, encodedPayload, v.authMethod, done)
+	_, err = v.getVCSECResult(ctx, encodedPayload, v.authMethod, isCommandComplete)
 	return err
 }
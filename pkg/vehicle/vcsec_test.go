@@ -230,3 +230,41 @@ func TestWhitelistOperationError(t *testing.T) {
 	dispatch.EnqueueWhitelistOperationStatus(t, errCode)
 	checkWhitelistOperationStatus(t, vehicle.AddKey(ctx, testPublicKey(), true, 0), errCode)
 }
+
+func TestExecuteVCSECAction(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	vehicle, dispatch := newTestVehicle()
+	if err := vehicle.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vehicle.Disconnect()
+
+	if _, err := vehicle.ExecuteVCSECAction(ctx, &vcsec.UnsignedMessage{}); err == nil {
+		t.Error("Expected error for empty message")
+	}
+
+	// The command isn't complete until VCSEC sends a reply without a command status.
+	dispatch.EnqueueAuthenticationSuccessResponse(t)
+	dispatch.EnqueueResponse(t, &universal.RoutableMessage{})
+	message := &vcsec.UnsignedMessage{
+		SubMessage: &vcsec.UnsignedMessage_ClosureMoveRequest{
+			ClosureMoveRequest: &vcsec.ClosureMoveRequest{
+				RearTrunk: vcsec.ClosureMoveType_E_CLOSURE_MOVE_TYPE_MOVE,
+			},
+		},
+	}
+	reply, err := vehicle.ExecuteVCSECAction(ctx, message)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if reply.GetCommandStatus() != nil {
+		t.Errorf("Returned intermediate reply %+v", reply)
+	}
+
+	errCode := vcsec.WhitelistOperationInformation_E_WHITELISTOPERATION_INFORMATION_WHITELIST_FULL
+	dispatch.EnqueueWhitelistOperationStatus(t, errCode)
+	_, err = vehicle.ExecuteVCSECAction(ctx, addKeyPayload(testPublicKey(), 0, 0))
+	checkWhitelistOperationStatus(t, err, errCode)
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"crypto/ecdh"
 	"errors"
+	"sync"
 	"time"
 
 	"google.golang.org/protobuf/proto"
 
 	"github.com/teslamotors/vehicle-command/internal/authentication"
 	"github.com/teslamotors/vehicle-command/internal/dispatcher"
+	"github.com/teslamotors/vehicle-command/internal/log"
 	"github.com/teslamotors/vehicle-command/pkg/cache"
 	"github.com/teslamotors/vehicle-command/pkg/connector"
 	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/tracing"
 
 	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/signatures"
 	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
@@ -55,20 +58,85 @@ type sender interface {
 
 	// Sets the maximum allowed clock error.
 	SetMaxLatency(time.Duration)
+
+	SetRefreshPolicy(dispatcher.RefreshPolicy)
+	SessionHealth(universal.Domain) (dispatcher.SessionHealth, bool)
+
+	// Subscribe returns a Receiver for unsolicited messages from domain that satisfy filter.
+	Subscribe(domain universal.Domain, filter func(*universal.RoutableMessage) bool) protocol.Receiver
+	// SubscribeUnauthenticated is like Subscribe but doesn't wait for a session with domain.
+	SubscribeUnauthenticated(domain universal.Domain, filter func(*universal.RoutableMessage) bool) protocol.Receiver
 }
 
 // A Vehicle represents a Tesla vehicle.
+//
+// A Vehicle is safe for concurrent use by multiple goroutines. Commands sent to the same vehicle
+// domain are authorized and transmitted one at a time, in order, so that the vehicle receives
+// anti-replay counters in increasing order; commands sent to different domains (e.g., VCSEC and
+// infotainment) don't wait for each other. Responses are awaited concurrently, so issuing several
+// commands from separate goroutines takes roughly one round trip per domain rather than one round
+// trip per command.
+//
+// Don't share a vehicle's sessions between Vehicle instances that are used concurrently, since
+// they won't coordinate their anti-replay counters.
 type Vehicle struct {
 	dispatcher sender
-	Flags      uint32
-	vin        string
+	// Flags are included in each message sent to the vehicle. Don't modify Flags while commands are
+	// in progress.
+	Flags uint32
+	vin   string
 
 	conn       connector.Connector
 	authMethod connector.AuthMethod
 
 	keyAvailable bool
+	publicKey    []byte // Encoded public key of the client, or nil if keyAvailable is false
+
+	monitor *dispatcher.Monitor
+
+	policyLock  sync.Mutex
+	retryPolicy *protocol.RetryPolicy // If nil, use protocol.DefaultRetryPolicy
+	// If non-zero, commands that may have succeeded are verified (see SetVerifyTimeout).
+	verifyTimeout time.Duration
 }
 
+// Observer receives notifications about a Vehicle's communication with the vehicle, such as
+// handshakes, retries, and command latencies. See [Vehicle.SetObserver].
+type Observer = dispatcher.Observer
+
+// NopObserver implements Observer by ignoring all notifications. Embed it in Observer
+// implementations that only handle a subset of notifications.
+type NopObserver = dispatcher.NopObserver
+
+// Stats contains counters describing a Vehicle's communication with the vehicle. See
+// [Vehicle.Stats].
+type Stats = dispatcher.Stats
+
+// DomainStats contains counters for a single vehicle domain.
+type DomainStats = dispatcher.DomainStats
+
+// DropReason identifies why a message from the vehicle was discarded.
+type DropReason = dispatcher.DropReason
+
+// RefreshPolicy determines when a Vehicle refreshes a session before using it. See
+// [Vehicle.SetRefreshPolicy].
+type RefreshPolicy = dispatcher.RefreshPolicy
+
+// SessionHealth describes how close a session is to being rejected by the vehicle.
+type SessionHealth = dispatcher.SessionHealth
+
+const (
+	DropUnparseable           = dispatcher.DropUnparseable
+	DropMissingSource         = dispatcher.DropMissingSource
+	DropInvalidUUID           = dispatcher.DropInvalidUUID
+	DropMissingDestination    = dispatcher.DropMissingDestination
+	DropWrongDestination      = dispatcher.DropWrongDestination
+	DropInvalidAddress        = dispatcher.DropInvalidAddress
+	DropNoHandler             = dispatcher.DropNoHandler
+	DropHandlerQueueFull      = dispatcher.DropHandlerQueueFull
+	DropSubscriptionQueueFull = dispatcher.DropSubscriptionQueueFull
+)
+
 // NewVehicle creates a new Vehicle. The privateKey and sessionCache may be nil.
 func NewVehicle(conn connector.Connector, privateKey authentication.ECDHPrivateKey, sessionCache *cache.SessionCache) (*Vehicle, error) {
 	dispatch, err := dispatcher.New(conn, privateKey)
@@ -82,6 +150,13 @@ func NewVehicle(conn connector.Connector, privateKey authentication.ECDHPrivateK
 		conn:         conn,
 		authMethod:   conn.PreferredAuthMethod(),
 		keyAvailable: privateKey != nil,
+		monitor:      dispatch.Monitor(),
+	}
+	if privateKey != nil {
+		vehicle.publicKey = privateKey.PublicBytes()
+	}
+	if authorizer, ok := conn.(connector.AuthorizingConnector); ok && authorizer.Authorizes() {
+		vehicle.keyAvailable = true
 	}
 	if sessionCache != nil {
 		if sessions, ok := sessionCache.GetEntry(vin); ok {
@@ -89,20 +164,61 @@ func NewVehicle(conn connector.Connector, privateKey authentication.ECDHPrivateK
 				return nil, err
 			}
 		}
+		vehicle.invalidateOnFault(sessionCache)
 	}
 	return vehicle, nil
 }
 
+// invalidateOnFault removes sessions from c when the vehicle rejects them.
+func (v *Vehicle) invalidateOnFault(c *cache.SessionCache) {
+	if d, ok := v.dispatcher.(*dispatcher.Dispatcher); ok {
+		d.OnSessionFault(func(domain universal.Domain) {
+			log.Debug("Invalidating cached %s session", domain)
+			// The callback runs on the dispatcher's receive loop, and invalidating a session may
+			// block on I/O (e.g., waiting for another process to release a FileStore lock).
+			go c.Invalidate(v.vin, int(domain))
+		})
+	}
+}
+
+// SetObserver registers an Observer that's notified of v's activity, in addition to the counters
+// returned by [Vehicle.Stats]. Passing nil unregisters the current Observer.
+func (v *Vehicle) SetObserver(observer Observer) {
+	v.monitor.SetObserver(observer)
+}
+
+// Stats returns counters that describe v's communication with the vehicle since v was created.
+func (v *Vehicle) Stats() Stats {
+	return v.monitor.Stats()
+}
+
 // SetMaxLatency sets the threshold used by the client to discard clock-synchronization messages
 // from the vehicle that take too long to arrive.
 func (v *Vehicle) SetMaxLatency(latency time.Duration) {
 	v.dispatcher.SetMaxLatency(latency)
 }
 
+// SetRefreshPolicy controls when v requests updated session info instead of waiting for the
+// vehicle to reject a command that uses an outdated session. By default, sessions are refreshed
+// before use if they're more than an hour old (for example, because they were loaded from a
+// [cache.SessionCache]) or their estimated clock error exceeds two seconds.
+func (v *Vehicle) SetRefreshPolicy(policy RefreshPolicy) {
+	v.dispatcher.SetRefreshPolicy(policy)
+}
+
+// SessionHealth returns the health of v's session with domain. Returns false if there's no
+// established session.
+func (v *Vehicle) SessionHealth(domain universal.Domain) (SessionHealth, bool) {
+	return v.dispatcher.SessionHealth(domain)
+}
+
 func (v *Vehicle) VIN() string {
 	return v.vin
 }
 
+// PrivateKeyAvailable returns true if v can send authenticated commands, either because it has a
+// private key or because its connector authorizes commands on its behalf (see
+// [connector.AuthorizingConnector]).
 func (v *Vehicle) PrivateKeyAvailable() bool {
 	return v.keyAvailable
 }
@@ -118,6 +234,7 @@ func (v *Vehicle) SessionInfo(ctx context.Context, publicKey *ecdh.PublicKey, do
 	if err != nil {
 		return nil, err
 	}
+	defer recv.Close()
 	select {
 	case reply := <-recv.Recv():
 		if err := protocol.GetError(reply); err != nil {
@@ -142,21 +259,19 @@ func (v *Vehicle) SessionInfo(ctx context.Context, publicKey *ecdh.PublicKey, do
 // subsystems. The client may specify a subset of domains if it does not need to connect to all of
 // them; for example, a client that only interacts with VCSEC can avoid waking infotainment.
 func (v *Vehicle) StartSession(ctx context.Context, domains []universal.Domain) error {
-	for {
+	ctx, policy := v.withRetryPolicy(ctx)
+	for attempts := 1; ; attempts++ {
 		err := v.dispatcher.StartSessions(ctx, domains)
 		if err == nil {
 			return nil
 		}
 
-		if !protocol.ShouldRetry(err) {
+		if !policy.ShouldRetry(err, attempts) {
 			return err
 		}
 
-		select {
-		case <-time.After(v.dispatcher.RetryInterval()):
-			continue
-		case <-ctx.Done():
-			return ctx.Err()
+		if err := policy.Wait(ctx, attempts, v.dispatcher.RetryInterval()); err != nil {
+			return err
 		}
 	}
 }
@@ -173,7 +288,23 @@ func (v *Vehicle) Disconnect() {
 	}
 }
 
+// transport returns the Connector that's currently used to reach the vehicle. This differs from
+// v.conn if v.conn is a [connector.MultipathConnector].
+func (v *Vehicle) transport() connector.Connector {
+	if multipath, ok := v.conn.(connector.MultipathConnector); ok {
+		return multipath.Transport()
+	}
+	return v.conn
+}
+
 func (v *Vehicle) getReceiver(ctx context.Context, domain universal.Domain, payload []byte, auth connector.AuthMethod) (protocol.Receiver, error) {
+	if auth != connector.AuthMethodNone {
+		if multipath, ok := v.conn.(connector.MultipathConnector); ok {
+			// The transport may have changed since auth was chosen (possibly by a previous
+			// attempt to send this payload), and not every transport accepts every AuthMethod.
+			auth = multipath.PreferredAuthMethod()
+		}
+	}
 	message := universal.RoutableMessage{
 		ToDestination: &universal.Destination{
 			SubDestination: &universal.Destination_Domain{
@@ -229,30 +360,71 @@ func (v *Vehicle) SendMessage(ctx context.Context, message *universal.RoutableMe
 // The domain controls what vehicle subsystem receives the message, and auth controls how the
 // message is authenticated (if it all).
 func (v *Vehicle) Send(ctx context.Context, domain universal.Domain, payload []byte, auth connector.AuthMethod) ([]byte, error) {
+	ctx, span := v.startSpan(ctx, domain, auth)
+	start := time.Now()
+	response, err := v.send(ctx, domain, payload, auth)
+	v.monitor.CommandCompleted(domain, time.Since(start), err)
+	span.End(err)
+	return response, err
+}
+
+// startSpan begins a span for a command sent to domain, including any retries.
+func (v *Vehicle) startSpan(ctx context.Context, domain universal.Domain, auth connector.AuthMethod) (context.Context, tracing.Span) {
+	ctx, span := tracing.Start(ctx, "vehicle.Send")
+	span.SetAttribute(tracing.AttrVIN, v.vin)
+	span.SetAttribute(tracing.AttrDomain, domain.String())
+	span.SetAttribute(tracing.AttrAuthMethod, auth.String())
+	return ctx, span
+}
+
+func (v *Vehicle) send(ctx context.Context, domain universal.Domain, payload []byte, auth connector.AuthMethod) ([]byte, error) {
 	payloadCopy := make([]byte, len(payload))
 	copy(payloadCopy, payload)
-	for {
+	ctx, policy := v.withRetryPolicy(ctx)
+	for attempts := 1; ; attempts++ {
 		response, err := v.trySend(ctx, domain, payloadCopy, auth)
 
 		if err == nil {
 			return response, nil
 		}
 
-		if !protocol.ShouldRetry(err) {
+		if !policy.ShouldRetry(err, attempts) {
 			return nil, err
 		}
+		v.monitor.Retrying(domain, err)
 
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-time.After(v.dispatcher.RetryInterval()):
-			continue
+		if err := policy.Wait(ctx, attempts, v.dispatcher.RetryInterval()); err != nil {
+			return nil, err
 		}
 	}
 }
 
+// SetRetryPolicy controls how v retries commands that fail, including handshakes started by
+// [Vehicle.StartSession]. Use [protocol.WithRetryPolicy] to override the policy for individual
+// commands. The default is [protocol.DefaultRetryPolicy].
+func (v *Vehicle) SetRetryPolicy(policy protocol.RetryPolicy) {
+	v.policyLock.Lock()
+	defer v.policyLock.Unlock()
+	v.retryPolicy = &policy
+}
+
+// withRetryPolicy returns the RetryPolicy for commands sent using ctx, along with a context that
+// carries the policy to the dispatcher.
+func (v *Vehicle) withRetryPolicy(ctx context.Context) (context.Context, protocol.RetryPolicy) {
+	if policy, ok := protocol.RetryPolicyFromContext(ctx); ok {
+		return ctx, policy
+	}
+	v.policyLock.Lock()
+	policy := protocol.DefaultRetryPolicy
+	if v.retryPolicy != nil {
+		policy = *v.retryPolicy
+	}
+	v.policyLock.Unlock()
+	return protocol.WithRetryPolicy(ctx, policy), policy
+}
+
 func (v *Vehicle) Wakeup(ctx context.Context) error {
-	if oapi, ok := v.conn.(connector.FleetAPIConnector); ok {
+	if oapi, ok := v.transport().(connector.FleetAPIConnector); ok {
 		return oapi.Wakeup(ctx)
 	} else {
 		return v.wakeupRKE(ctx)
@@ -265,6 +437,7 @@ func (v *Vehicle) UpdateCachedSessions(c *cache.SessionCache) error {
 
 func (v *Vehicle) LoadCachedSessions(c *cache.SessionCache) error {
 	if data, ok := c.GetEntry(v.vin); ok {
+		v.invalidateOnFault(c)
 		return v.dispatcher.LoadCache(data)
 	}
 	return errors.New("VIN not in cache")
@@ -39,11 +39,15 @@ type testSender struct {
 	ch            chan *universal.RoutableMessage
 
 	// If SendError is set, Send() returns SendError. Otherwise, Send() returns
-	// the first queued error (or nil if no errors are queued).
+	// the first queued error (or succeeds if no errors are queued or the first
+	// queued error is nil).
 	SendError error
 	errQueue  []error
 
 	ConnectionErrors []error
+
+	// Unsolicited messages are delivered to subscribers.
+	unsolicited chan *universal.RoutableMessage
 }
 
 func (s *testSender) StartSessions(ctx context.Context, domains []universal.Domain) error {
@@ -91,14 +95,39 @@ func (s *testSender) EnqueueResponse(t *testing.T, message *universal.RoutableMe
 
 func (s *testSender) SetMaxLatency(latency time.Duration) {}
 
+func (s *testSender) SetRefreshPolicy(policy dispatcher.RefreshPolicy) {}
+
+func (s *testSender) SessionHealth(domain universal.Domain) (dispatcher.SessionHealth, bool) {
+	return dispatcher.SessionHealth{}, false
+}
+
+type testSubscription struct {
+	ch <-chan *universal.RoutableMessage
+}
+
+func (s *testSubscription) Close() {}
+
+func (s *testSubscription) Recv() <-chan *universal.RoutableMessage {
+	return s.ch
+}
+
+func (s *testSender) Subscribe(domain universal.Domain, filter func(*universal.RoutableMessage) bool) protocol.Receiver {
+	return &testSubscription{ch: s.unsolicited}
+}
+
+func (s *testSender) SubscribeUnauthenticated(domain universal.Domain, filter func(*universal.RoutableMessage) bool) protocol.Receiver {
+	return &testSubscription{ch: s.unsolicited}
+}
+
 func newTestVehicle() (*Vehicle, *testSender) {
 	dispatch := newTestSender()
-	return &Vehicle{dispatcher: dispatch}, dispatch
+	return &Vehicle{dispatcher: dispatch, monitor: &dispatcher.Monitor{}}, dispatch
 }
 
 func newTestSender() *testSender {
 	return &testSender{
-		ch: make(chan *universal.RoutableMessage, 5),
+		ch:          make(chan *universal.RoutableMessage, 5),
+		unsolicited: make(chan *universal.RoutableMessage, 5),
 	}
 }
 
@@ -137,7 +166,9 @@ func (t *testSender) Send(ctx context.Context, message *universal.RoutableMessag
 	if len(t.errQueue) > 0 {
 		err := t.errQueue[0]
 		t.errQueue = t.errQueue[1:]
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &testReceiever{parent: t}, nil
 }
@@ -309,3 +340,35 @@ func TestVehicleRetryFail(t *testing.T) {
 		t.Errorf("Unexpected error: %s", err)
 	}
 }
+
+func TestVehicleRetryPolicy(t *testing.T) {
+	vehicle, dispatch := newTestVehicle()
+	if err := vehicle.Connect(context.Background()); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	defer vehicle.Disconnect()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	busy := &protocol.RoutableMessageError{Code: universal.MessageFault_E_MESSAGEFAULT_ERROR_BUSY}
+	vehicle.SetRetryPolicy(protocol.RetryPolicy{MaxAttempts: 2, Retry: protocol.RetryBusy})
+	for i := 0; i < 3; i++ {
+		dispatch.EnqueueError(busy)
+	}
+	if _, err := vehicle.Send(ctx, universal.Domain_DOMAIN_VEHICLE_SECURITY, nil, connector.AuthMethodNone); err != busy {
+		t.Errorf("Expected busy error after two attempts, got %v", err)
+	}
+	if n := vehicle.Stats().Domains[universal.Domain_DOMAIN_VEHICLE_SECURITY].Retries; n != 1 {
+		t.Errorf("Expected one retry but got %d", n)
+	}
+
+	// A policy attached to the context overrides the vehicle's policy.
+	noRetries := protocol.WithRetryPolicy(ctx, protocol.RetryPolicy{})
+	if _, err := vehicle.Send(noRetries, universal.Domain_DOMAIN_VEHICLE_SECURITY, nil, connector.AuthMethodNone); err != busy {
+		t.Errorf("Expected busy error without retries, got %v", err)
+	}
+	if n := vehicle.Stats().Domains[universal.Domain_DOMAIN_VEHICLE_SECURITY].Retries; n != 1 {
+		t.Errorf("Expected no additional retries but got %d", n-1)
+	}
+}
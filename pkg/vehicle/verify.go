@@ -0,0 +1,289 @@
+package vehicle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/internal/log"
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+// Outcome describes whether a command took effect.
+type Outcome int
+
+const (
+	OutcomeUnknown Outcome = iota
+	OutcomeSucceeded
+	OutcomeFailed
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case OutcomeSucceeded:
+		return "succeeded"
+	case OutcomeFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// An Expectation inspects the body controller state returned by [Vehicle.BodyControllerState]
+// after a command was sent and reports whether it shows that the command took effect. The before
+// argument is the state fetched before the command was sent, or nil if it isn't available.
+//
+// An Expectation returns OutcomeFailed if the state doesn't reflect the command yet. Since the
+// vehicle may not have acted on the command when the state is fetched, [Vehicle.Verify] keeps
+// polling and only reports failure once the state has settled. An Expectation returns
+// OutcomeUnknown if the state doesn't settle the question, for example because a closure is still
+// moving.
+type Expectation func(before, after *vcsec.VehicleStatus) Outcome
+
+// ExpectLocked returns an Expectation that a Lock (if locked is true) or Unlock command succeeded.
+func ExpectLocked(locked bool) Expectation {
+	return func(_, after *vcsec.VehicleStatus) Outcome {
+		isLocked := after.GetVehicleLockState() == vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED ||
+			after.GetVehicleLockState() == vcsec.VehicleLockState_E_VEHICLELOCKSTATE_INTERNAL_LOCKED
+		if isLocked == locked {
+			return OutcomeSucceeded
+		}
+		return OutcomeFailed
+	}
+}
+
+// closureState returns the state of closure in status.
+func closureState(status *vcsec.VehicleStatus, closure Closure) (vcsec.ClosureState_E, bool) {
+	closures := status.GetClosureStatuses()
+	if closures == nil {
+		return vcsec.ClosureState_E_CLOSURESTATE_UNKNOWN, false
+	}
+	switch closure {
+	case ClosureTrunk:
+		return closures.GetRearTrunk(), true
+	case ClosureFrunk:
+		return closures.GetFrontTrunk(), true
+	case ClosureTonneau:
+		return closures.GetTonneau(), true
+	case ClosureChargePort:
+		return closures.GetChargePort(), true
+	}
+	return vcsec.ClosureState_E_CLOSURESTATE_UNKNOWN, false
+}
+
+// ExpectClosure returns an Expectation that a command opened (if open is true) or closed a
+// closure.
+func ExpectClosure(closure Closure, open bool) Expectation {
+	return func(_, after *vcsec.VehicleStatus) Outcome {
+		state, ok := closureState(after, closure)
+		if !ok {
+			return OutcomeUnknown
+		}
+		switch state {
+		case vcsec.ClosureState_E_CLOSURESTATE_OPEN, vcsec.ClosureState_E_CLOSURESTATE_AJAR:
+			if open {
+				return OutcomeSucceeded
+			}
+			return OutcomeFailed
+		case vcsec.ClosureState_E_CLOSURESTATE_CLOSED:
+			if open {
+				return OutcomeFailed
+			}
+			return OutcomeSucceeded
+		case vcsec.ClosureState_E_CLOSURESTATE_OPENING:
+			if open {
+				return OutcomeSucceeded
+			}
+		case vcsec.ClosureState_E_CLOSURESTATE_CLOSING:
+			if !open {
+				return OutcomeSucceeded
+			}
+		}
+		return OutcomeUnknown
+	}
+}
+
+// ExpectClosureMoved returns an Expectation that a command toggled a closure. OpenTrunk sends a
+// MOVE command, which closes the trunk on some vehicles if it's already open, so the outcome
+// depends on the closure's state before the command. If that state is unknown, the Expectation is
+// the same as ExpectClosure(closure, true).
+func ExpectClosureMoved(closure Closure) Expectation {
+	return func(before, after *vcsec.VehicleStatus) Outcome {
+		open := true
+		switch state, _ := closureState(before, closure); state {
+		case vcsec.ClosureState_E_CLOSURESTATE_OPEN, vcsec.ClosureState_E_CLOSURESTATE_AJAR, vcsec.ClosureState_E_CLOSURESTATE_OPENING:
+			open = false
+		}
+		return ExpectClosure(closure, open)(before, after)
+	}
+}
+
+// Verification is the result of checking whether a command that may have succeeded took effect.
+type Verification struct {
+	Outcome Outcome
+	// Status is the last body controller state fetched from the vehicle, which is the evidence for
+	// Outcome. Status is nil if the state couldn't be fetched.
+	Status *vcsec.VehicleStatus
+	// Cause is the error that made the command's outcome uncertain.
+	Cause error
+	// Err is the error that prevented verification, if any.
+	Err error
+}
+
+// VerificationError is returned by commands that are verified automatically (see
+// [Vehicle.SetVerifyTimeout]) when verification doesn't show that the command succeeded. If
+// verification shows that the command failed, the VerificationError is wrapped in a
+// [protocol.NominalError].
+type VerificationError struct {
+	Verification
+}
+
+func (e *VerificationError) Error() string {
+	if e.Outcome == OutcomeFailed {
+		return fmt.Sprintf("vehicle state shows command did not take effect (after error: %s)", e.Cause)
+	}
+	if e.Status == nil && e.Err != nil {
+		return fmt.Sprintf("couldn't fetch vehicle state (%s) after error: %s", e.Err, e.Cause)
+	}
+	return fmt.Sprintf("vehicle state doesn't show whether command took effect (after error: %s)", e.Cause)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Cause
+}
+
+// MayHaveSucceeded returns true unless verification showed that the command failed.
+func (e *VerificationError) MayHaveSucceeded() bool {
+	return e.Outcome == OutcomeUnknown
+}
+
+func (e *VerificationError) Temporary() bool {
+	return protocol.Temporary(e.Cause)
+}
+
+// verifySettlePeriod is how long the vehicle state must consistently show that a command didn't
+// take effect before Verify reports failure.
+var verifySettlePeriod = 2 * time.Second
+
+// Verify determines whether a command that returned cause took effect by polling
+// [Vehicle.BodyControllerState] until expect returns a definitive Outcome or ctx expires. The
+// before argument is passed to expect and should be the state fetched before the command was sent,
+// or nil if it isn't available. If cause is nil, the command succeeded, and if
+// protocol.MayHaveSucceeded(cause) is false, the command failed; in either case Verify doesn't
+// contact the vehicle.
+//
+// The vehicle may not have acted on the command by the time its state is fetched, so Verify only
+// returns OutcomeFailed once expect has reported failure for verifySettlePeriod. If ctx expires
+// before then, Verify returns OutcomeUnknown.
+//
+// Callers typically use a context that's independent of the one used to send the command, since
+// the command's context may have expired.
+func (v *Vehicle) Verify(ctx context.Context, before *vcsec.VehicleStatus, cause error, expect Expectation) *Verification {
+	result := &Verification{Cause: cause}
+	if cause == nil {
+		result.Outcome = OutcomeSucceeded
+		return result
+	}
+	if !protocol.MayHaveSucceeded(cause) {
+		result.Outcome = OutcomeFailed
+		return result
+	}
+	var failingSince time.Time
+	for {
+		status, err := v.BodyControllerState(ctx)
+		if err == nil {
+			result.Status = status
+			result.Err = nil
+			result.Outcome = expect(before, status)
+			switch result.Outcome {
+			case OutcomeSucceeded:
+				return result
+			case OutcomeFailed:
+				if failingSince.IsZero() {
+					failingSince = time.Now()
+				} else if time.Since(failingSince) >= verifySettlePeriod {
+					return result
+				}
+			default:
+				failingSince = time.Time{}
+			}
+		} else {
+			result.Err = err
+		}
+		select {
+		case <-ctx.Done():
+			if result.Outcome == OutcomeFailed && time.Since(failingSince) >= verifySettlePeriod {
+				result.Err = nil
+				return result
+			}
+			result.Outcome = OutcomeUnknown
+			if result.Err == nil {
+				result.Err = ctx.Err()
+			}
+			return result
+		case <-time.After(v.dispatcher.RetryInterval()):
+		}
+	}
+}
+
+// SetVerifyTimeout enables automatic verification of commands whose effect is reported by
+// [Vehicle.BodyControllerState]: Lock, Unlock, OpenTrunk, CloseTrunk, OpenFrunk, OpenTonneau,
+// CloseTonneau, ChargePortOpen, and ChargePortClose. (Window positions aren't included in the
+// body controller state, so window commands can't be verified.)
+//
+// When a command fails with an error for which protocol.MayHaveSucceeded is true, the method spends
+// up to timeout calling [Vehicle.Verify]. It returns nil if the command succeeded, and otherwise a
+// [VerificationError]. Since Verify waits for the vehicle state to settle before reporting
+// failure, timeouts shorter than a few seconds can only show that a command succeeded. While
+// verification is enabled, OpenTrunk fetches the body controller state before sending the command,
+// since its effect depends on whether the trunk is already open. A timeout of zero, the default,
+// disables verification.
+func (v *Vehicle) SetVerifyTimeout(timeout time.Duration) {
+	v.policyLock.Lock()
+	defer v.policyLock.Unlock()
+	v.verifyTimeout = timeout
+}
+
+// verified sends a command by calling send and returns the error that the command should return
+// after verification.
+func (v *Vehicle) verified(ctx context.Context, expect Expectation, send func(context.Context) error) error {
+	return v.sendVerified(ctx, false, expect, send)
+}
+
+// verifiedToggle is like verified, but for commands whose effect depends on the vehicle's state
+// before the command was sent (see [ExpectClosureMoved]). It fetches that state before calling
+// send.
+func (v *Vehicle) verifiedToggle(ctx context.Context, expect Expectation, send func(context.Context) error) error {
+	return v.sendVerified(ctx, true, expect, send)
+}
+
+func (v *Vehicle) sendVerified(ctx context.Context, fetchBefore bool, expect Expectation, send func(context.Context) error) error {
+	v.policyLock.Lock()
+	timeout := v.verifyTimeout
+	v.policyLock.Unlock()
+	if timeout == 0 {
+		return send(ctx)
+	}
+	var before *vcsec.VehicleStatus
+	if fetchBefore {
+		var err error
+		if before, err = v.BodyControllerState(ctx); err != nil {
+			log.Debug("Couldn't fetch vehicle state before sending command: %s", err)
+		}
+	}
+	cause := send(ctx)
+	if !protocol.MayHaveSucceeded(cause) {
+		return cause
+	}
+	// The command's context may have expired, which is often why the outcome is uncertain.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	result := v.Verify(ctx, before, cause, expect)
+	switch result.Outcome {
+	case OutcomeSucceeded:
+		return nil
+	case OutcomeFailed:
+		return &protocol.NominalError{Details: &VerificationError{*result}}
+	}
+	return &VerificationError{*result}
+}
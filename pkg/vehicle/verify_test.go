@@ -0,0 +1,231 @@
+package vehicle
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol"
+
+	universal "github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/universalmessage"
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+var errLostResponse = &protocol.CommandError{Err: errors.New("response lost"), PossibleSuccess: true, PossibleTemporary: true}
+
+func vehicleStatusMessage(t *testing.T, status *vcsec.VehicleStatus) *universal.RoutableMessage {
+	t.Helper()
+	payload := vcsec.FromVCSECMessage{
+		SubMessage: &vcsec.FromVCSECMessage_VehicleStatus{
+			VehicleStatus: status,
+		},
+	}
+	encodedPayload, err := proto.Marshal(&payload)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	return &universal.RoutableMessage{
+		Payload: &universal.RoutableMessage_ProtobufMessageAsBytes{
+			ProtobufMessageAsBytes: encodedPayload,
+		},
+	}
+}
+
+func (s *testSender) EnqueueVehicleStatus(t *testing.T, status *vcsec.VehicleStatus) {
+	t.Helper()
+	s.EnqueueResponse(t, vehicleStatusMessage(t, status))
+}
+
+func newVerifyingVehicle(t *testing.T, ctx context.Context) (*Vehicle, *testSender) {
+	t.Helper()
+	vehicle, dispatch := newTestVehicle()
+	if err := vehicle.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(vehicle.Disconnect)
+	vehicle.SetVerifyTimeout(time.Second)
+	return vehicle, dispatch
+}
+
+func TestExpectClosure(t *testing.T) {
+	status := &vcsec.VehicleStatus{
+		ClosureStatuses: &vcsec.ClosureStatuses{
+			RearTrunk:  vcsec.ClosureState_E_CLOSURESTATE_OPENING,
+			FrontTrunk: vcsec.ClosureState_E_CLOSURESTATE_CLOSED,
+			ChargePort: vcsec.ClosureState_E_CLOSURESTATE_OPEN,
+			Tonneau:    vcsec.ClosureState_E_CLOSURESTATE_UNKNOWN,
+		},
+	}
+	tests := []struct {
+		closure Closure
+		open    bool
+		outcome Outcome
+	}{
+		{ClosureTrunk, true, OutcomeSucceeded},
+		{ClosureTrunk, false, OutcomeUnknown},
+		{ClosureFrunk, true, OutcomeFailed},
+		{ClosureFrunk, false, OutcomeSucceeded},
+		{ClosureChargePort, true, OutcomeSucceeded},
+		{ClosureChargePort, false, OutcomeFailed},
+		{ClosureTonneau, true, OutcomeUnknown},
+	}
+	for _, test := range tests {
+		if outcome := ExpectClosure(test.closure, test.open)(nil, status); outcome != test.outcome {
+			t.Errorf("ExpectClosure(%s, %v) = %s, expected %s", test.closure, test.open, outcome, test.outcome)
+		}
+	}
+	if outcome := ExpectClosure(ClosureTrunk, true)(nil, &vcsec.VehicleStatus{}); outcome != OutcomeUnknown {
+		t.Errorf("Expected unknown outcome without closure statuses, got %s", outcome)
+	}
+}
+
+func trunkStatus(state vcsec.ClosureState_E) *vcsec.VehicleStatus {
+	return &vcsec.VehicleStatus{
+		ClosureStatuses: &vcsec.ClosureStatuses{RearTrunk: state},
+	}
+}
+
+func TestExpectClosureMoved(t *testing.T) {
+	open := trunkStatus(vcsec.ClosureState_E_CLOSURESTATE_OPEN)
+	closed := trunkStatus(vcsec.ClosureState_E_CLOSURESTATE_CLOSED)
+	tests := []struct {
+		before  *vcsec.VehicleStatus
+		after   *vcsec.VehicleStatus
+		outcome Outcome
+	}{
+		{closed, open, OutcomeSucceeded},
+		{closed, closed, OutcomeFailed},
+		{open, closed, OutcomeSucceeded},
+		{open, open, OutcomeFailed},
+		{nil, open, OutcomeSucceeded},
+		{nil, closed, OutcomeFailed},
+	}
+	for _, test := range tests {
+		if outcome := ExpectClosureMoved(ClosureTrunk)(test.before, test.after); outcome != test.outcome {
+			t.Errorf("ExpectClosureMoved(%s -> %s) = %s, expected %s", test.before.GetClosureStatuses().GetRearTrunk(),
+				test.after.GetClosureStatuses().GetRearTrunk(), outcome, test.outcome)
+		}
+	}
+}
+
+func TestVerifySucceeded(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newVerifyingVehicle(t, ctx)
+
+	unlocked := &vcsec.VehicleStatus{VehicleLockState: vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED}
+	dispatch.EnqueueError(errLostResponse)
+	// The vehicle hasn't acted on the command yet when it's first polled.
+	dispatch.EnqueueVehicleStatus(t, unlocked)
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{
+		VehicleLockState: vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED,
+	})
+	if err := vehicle.Lock(ctx); err != nil {
+		t.Errorf("Expected verified success, got %s", err)
+	}
+}
+
+func TestVerifyToggle(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newVerifyingVehicle(t, ctx)
+
+	// OpenTrunk closes a trunk that's already open.
+	dispatch.EnqueueError(nil)
+	dispatch.EnqueueVehicleStatus(t, trunkStatus(vcsec.ClosureState_E_CLOSURESTATE_OPEN))
+	dispatch.EnqueueError(errLostResponse)
+	dispatch.EnqueueVehicleStatus(t, trunkStatus(vcsec.ClosureState_E_CLOSURESTATE_OPEN))
+	dispatch.EnqueueVehicleStatus(t, trunkStatus(vcsec.ClosureState_E_CLOSURESTATE_CLOSING))
+	if err := vehicle.OpenTrunk(ctx); err != nil {
+		t.Errorf("Expected verified success, got %s", err)
+	}
+}
+
+func setVerifySettlePeriod(t *testing.T, period time.Duration) {
+	prev := verifySettlePeriod
+	verifySettlePeriod = period
+	t.Cleanup(func() { verifySettlePeriod = prev })
+}
+
+func TestVerifyFailed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newVerifyingVehicle(t, ctx)
+	setVerifySettlePeriod(t, 20*time.Millisecond)
+
+	dispatch.EnqueueError(errLostResponse)
+	dispatch.fixedResponse = vehicleStatusMessage(t, &vcsec.VehicleStatus{
+		VehicleLockState: vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED,
+	})
+	err := vehicle.Unlock(ctx)
+	if !protocol.IsNominalError(err) || protocol.MayHaveSucceeded(err) {
+		t.Fatalf("Expected definitive failure, got %v", err)
+	}
+	var verifyErr *VerificationError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("Expected VerificationError, got %T", err)
+	}
+	if verifyErr.Outcome != OutcomeFailed || verifyErr.Status.GetVehicleLockState() != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED {
+		t.Errorf("Unexpected verification result: %+v", verifyErr.Verification)
+	}
+	if !errors.Is(err, errLostResponse) {
+		t.Errorf("Expected error to wrap cause")
+	}
+}
+
+func TestVerifyFailureNotSettled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newVerifyingVehicle(t, ctx)
+	vehicle.SetVerifyTimeout(100 * time.Millisecond)
+
+	dispatch.EnqueueError(errLostResponse)
+	// The vehicle may still act on the command after verification times out.
+	dispatch.fixedResponse = vehicleStatusMessage(t, &vcsec.VehicleStatus{
+		VehicleLockState: vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED,
+	})
+	err := vehicle.Unlock(ctx)
+	var verifyErr *VerificationError
+	if !errors.As(err, &verifyErr) || !protocol.MayHaveSucceeded(err) || protocol.IsNominalError(err) {
+		t.Fatalf("Expected unknown outcome, got %v", err)
+	}
+	if verifyErr.Outcome != OutcomeUnknown || verifyErr.Status == nil {
+		t.Errorf("Unexpected verification result: %+v", verifyErr.Verification)
+	}
+}
+
+func TestVerifyUnknown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newVerifyingVehicle(t, ctx)
+	vehicle.SetVerifyTimeout(50 * time.Millisecond)
+
+	dispatch.EnqueueError(nil)
+	dispatch.EnqueueError(errLostResponse)
+	// The trunk keeps moving until verification times out.
+	dispatch.fixedResponse = vehicleStatusMessage(t, trunkStatus(vcsec.ClosureState_E_CLOSURESTATE_CLOSING))
+
+	err := vehicle.OpenTrunk(ctx)
+	var verifyErr *VerificationError
+	if !errors.As(err, &verifyErr) || !protocol.MayHaveSucceeded(err) {
+		t.Fatalf("Expected unknown outcome, got %v", err)
+	}
+	if verifyErr.Status == nil {
+		t.Error("Expected verification to include last vehicle status")
+	}
+}
+
+func TestVerifyDisabled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newVerifyingVehicle(t, ctx)
+	vehicle.SetVerifyTimeout(0)
+
+	dispatch.EnqueueError(errLostResponse)
+	if err := vehicle.Lock(ctx); err != errLostResponse {
+		t.Errorf("Expected unmodified error, got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package vehicle
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+// FieldChange describes a change to a field of a vcsec.VehicleStatus. Field is the field's
+// protojson name, with nested fields separated by periods (e.g., "closureStatuses.rearTrunk").
+// Enum values are identified by name.
+type FieldChange struct {
+	Field string `json:"field"`
+	From  string `json:"from,omitempty"`
+	To    string `json:"to"`
+}
+
+// StatusChange is sent by [Vehicle.Watch] when the vehicle status changes.
+type StatusChange struct {
+	Time time.Time `json:"time"`
+	// Status is the current vehicle status. It's nil if Err is set.
+	Status *vcsec.VehicleStatus `json:"-"`
+	// Changes lists the fields that differ from the previous status. The first StatusChange sent
+	// by Watch lists every field.
+	Changes []FieldChange `json:"changes,omitempty"`
+	// Err is set if the vehicle status couldn't be fetched. Watch continues polling after errors.
+	Err error `json:"-"`
+}
+
+// DiffStatus returns the fields that differ between previous and current. If previous is nil,
+// DiffStatus returns every field of current.
+func DiffStatus(previous, current *vcsec.VehicleStatus) []FieldChange {
+	var changes []FieldChange
+	diffMessage("", previous.ProtoReflect(), current.ProtoReflect(), previous == nil, &changes)
+	return changes
+}
+
+func diffMessage(prefix string, previous, current protoreflect.Message, all bool, changes *[]FieldChange) {
+	fields := current.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		name := prefix + field.JSONName()
+		if field.Message() != nil && field.Cardinality() != protoreflect.Repeated {
+			diffMessage(name+".", previous.Get(field).Message(), current.Get(field).Message(), all, changes)
+			continue
+		}
+		to := formatField(field, current.Get(field))
+		if all {
+			*changes = append(*changes, FieldChange{Field: name, To: to})
+		} else if from := formatField(field, previous.Get(field)); from != to {
+			*changes = append(*changes, FieldChange{Field: name, From: from, To: to})
+		}
+	}
+}
+
+func formatField(field protoreflect.FieldDescriptor, value protoreflect.Value) string {
+	if field.Enum() != nil && field.Cardinality() != protoreflect.Repeated {
+		if enumValue := field.Enum().Values().ByNumber(value.Enum()); enumValue != nil {
+			return string(enumValue.Name())
+		}
+		return fmt.Sprintf("%d", value.Enum())
+	}
+	return value.String()
+}
+
+// Watch polls [Vehicle.BodyControllerState] every interval and sends a StatusChange each time the
+// status differs from the previous one, starting with the initial status. The channel is closed
+// when ctx expires. The caller must keep receiving from the channel until it's closed.
+func (v *Vehicle) Watch(ctx context.Context, interval time.Duration) <-chan StatusChange {
+	updates := make(chan StatusChange)
+	go func() {
+		defer close(updates)
+		var previous *vcsec.VehicleStatus
+		for {
+			status, err := v.BodyControllerState(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			update := StatusChange{Time: time.Now(), Status: status, Err: err}
+			if err == nil {
+				update.Changes = DiffStatus(previous, status)
+			}
+			if err != nil || previous == nil || len(update.Changes) > 0 {
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err == nil {
+				previous = status
+			}
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return updates
+}
+
+// WaitFor polls [Vehicle.BodyControllerState] until predicate returns true, and then returns the
+// vehicle status that satisfied predicate. Returns an error if the status can't be fetched or ctx
+// expires first.
+func (v *Vehicle) WaitFor(ctx context.Context, predicate func(*vcsec.VehicleStatus) bool) (*vcsec.VehicleStatus, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	for update := range v.Watch(ctx, v.dispatcher.RetryInterval()) {
+		if update.Err != nil {
+			return nil, update.Err
+		}
+		if predicate(update.Status) {
+			return update.Status, nil
+		}
+	}
+	return nil, ctx.Err()
+}
+
+// AllClosuresClosed returns true if status shows that every door, trunk, and the charge port are
+// closed. It can be used as a [Vehicle.WaitFor] predicate.
+func AllClosuresClosed(status *vcsec.VehicleStatus) bool {
+	closures := status.GetClosureStatuses()
+	if closures == nil {
+		return false
+	}
+	for _, state := range []vcsec.ClosureState_E{
+		closures.GetFrontDriverDoor(),
+		closures.GetFrontPassengerDoor(),
+		closures.GetRearDriverDoor(),
+		closures.GetRearPassengerDoor(),
+		closures.GetRearTrunk(),
+		closures.GetFrontTrunk(),
+		closures.GetChargePort(),
+		closures.GetTonneau(),
+	} {
+		if state != vcsec.ClosureState_E_CLOSURESTATE_CLOSED {
+			return false
+		}
+	}
+	return true
+}
+
+// InfotainmentAwake returns true if status shows that the infotainment system is awake. It can be
+// used as a [Vehicle.WaitFor] predicate.
+func InfotainmentAwake(status *vcsec.VehicleStatus) bool {
+	return status.GetVehicleSleepStatus() == vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_AWAKE
+}
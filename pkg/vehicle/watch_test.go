@@ -0,0 +1,103 @@
+package vehicle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/teslamotors/vehicle-command/pkg/protocol/protobuf/vcsec"
+)
+
+func TestDiffStatus(t *testing.T) {
+	previous := &vcsec.VehicleStatus{
+		VehicleLockState: vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED,
+	}
+	current := &vcsec.VehicleStatus{
+		VehicleLockState: vcsec.VehicleLockState_E_VEHICLELOCKSTATE_UNLOCKED,
+		ClosureStatuses: &vcsec.ClosureStatuses{
+			RearTrunk: vcsec.ClosureState_E_CLOSURESTATE_OPEN,
+		},
+		DetailedClosureStatus: &vcsec.DetailedClosureStatus{TonneauPercentOpen: 40},
+	}
+	expected := []FieldChange{
+		{Field: "closureStatuses.rearTrunk", From: "CLOSURESTATE_CLOSED", To: "CLOSURESTATE_OPEN"},
+		{Field: "vehicleLockState", From: "VEHICLELOCKSTATE_LOCKED", To: "VEHICLELOCKSTATE_UNLOCKED"},
+		{Field: "detailedClosureStatus.tonneauPercentOpen", From: "0", To: "40"},
+	}
+	changes := DiffStatus(previous, current)
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes but got %+v", len(expected), changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Errorf("Expected %+v but got %+v", expected[i], changes[i])
+		}
+	}
+	if changes := DiffStatus(current, current); len(changes) != 0 {
+		t.Errorf("Unexpected changes: %+v", changes)
+	}
+	if changes := DiffStatus(nil, previous); len(changes) != 12 {
+		t.Errorf("Expected initial status to include every field, got %+v", changes)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newTestVehicle()
+	if err := vehicle.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vehicle.Disconnect()
+
+	locked := &vcsec.VehicleStatus{VehicleLockState: vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED}
+	dispatch.EnqueueVehicleStatus(t, locked)
+	dispatch.EnqueueVehicleStatus(t, locked)
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{})
+
+	watchCtx, stop := context.WithCancel(ctx)
+	updates := vehicle.Watch(watchCtx, time.Millisecond)
+	initial := <-updates
+	if initial.Err != nil || initial.Status.GetVehicleLockState() != vcsec.VehicleLockState_E_VEHICLELOCKSTATE_LOCKED {
+		t.Fatalf("Unexpected initial update: %+v", initial)
+	}
+	update := <-updates
+	if update.Err != nil || len(update.Changes) != 1 || update.Changes[0].Field != "vehicleLockState" {
+		t.Fatalf("Unexpected update: %+v", update)
+	}
+	stop()
+	for range updates {
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	vehicle, dispatch := newTestVehicle()
+	if err := vehicle.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer vehicle.Disconnect()
+
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{
+		VehicleSleepStatus: vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_ASLEEP,
+	})
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{
+		VehicleSleepStatus: vcsec.VehicleSleepStatus_E_VEHICLE_SLEEP_STATUS_AWAKE,
+		UserPresence:       vcsec.UserPresence_E_VEHICLE_USER_PRESENCE_PRESENT,
+	})
+	status, err := vehicle.WaitFor(ctx, InfotainmentAwake)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.GetUserPresence() != vcsec.UserPresence_E_VEHICLE_USER_PRESENCE_PRESENT {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	shortCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	dispatch.EnqueueVehicleStatus(t, &vcsec.VehicleStatus{})
+	if _, err := vehicle.WaitFor(shortCtx, AllClosuresClosed); err == nil {
+		t.Error("Expected error when condition isn't met")
+	}
+}